@@ -11,7 +11,17 @@ type httpHandler struct {
 	svc *service.Service
 }
 
-func (h *httpHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		h.svc.MetricsHandler().ServeHTTP(w, r)
+		return
+	}
+
+	if r.URL.Path == "/drain" {
+		h.svc.DrainHandler().ServeHTTP(w, r)
+		return
+	}
+
 	info, err := h.svc.Status()
 	if err != nil {
 		logger.Errorw("failed to read status", err)
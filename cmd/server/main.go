@@ -14,12 +14,12 @@ import (
 
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
 	"github.com/livekit/egress/pkg/service"
+	"github.com/livekit/egress/pkg/tracing"
 	"github.com/livekit/egress/version"
-	"github.com/livekit/protocol/egress"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
-	"github.com/livekit/protocol/redis"
 	"github.com/livekit/protocol/tracer"
 )
 
@@ -73,14 +73,23 @@ func runService(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if err = conf.Validate(); err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Init(conf, "egress")
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
 
-	rc, err := redis.GetRedisClient(conf.Redis)
+	transport, err := service.NewServiceTransport(conf)
 	if err != nil {
 		return err
 	}
+	defer transport.Close()
 
-	rpcServer := egress.NewRedisRPCServer(rc)
-	svc := service.NewService(conf, rpcServer)
+	svc := service.NewService(conf, transport.RPCServer, transport.State)
 
 	if conf.HealthPort != 0 {
 		go func() {
@@ -89,16 +98,31 @@ func runService(c *cli.Context) error {
 	}
 
 	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(stopChan, syscall.SIGTERM)
+
+	drainChan := make(chan os.Signal, 1)
+	signal.Notify(drainChan, syscall.SIGQUIT)
 
 	killChan := make(chan os.Signal, 1)
 	signal.Notify(killChan, syscall.SIGINT)
 
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	go func() {
+		for range reloadChan {
+			reloadConfig(c, conf, svc)
+		}
+	}()
+
 	go func() {
 		select {
 		case sig := <-stopChan:
 			logger.Infow("exit requested, finishing recording then shutting down", "signal", sig)
 			svc.Stop(false)
+		case sig := <-drainChan:
+			logger.Infow("drain requested, finishing recordings then shutting down", "signal", sig)
+			svc.Drain()
 		case sig := <-killChan:
 			logger.Infow("exit requested, stopping recording and shutting down", "signal", sig)
 			svc.Stop(true)
@@ -114,10 +138,28 @@ func runHandler(c *cli.Context) error {
 		return err
 	}
 
-	ctx, span := tracer.Start(context.Background(), "Handler.New")
+	shutdownTracing, err := tracing.Init(conf, "egress-handler")
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	ctx, span := tracer.Start(tracing.FromEnviron(context.Background()), "Handler.New")
 	defer span.End()
 
-	logger.Debugw("handler launched")
+	req := &livekit.StartEgressRequest{}
+	reqString := c.String("request")
+	err = protojson.Unmarshal([]byte(reqString), req)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if err = conf.InitHandlerLogger(req.EgressId, params.LogValues(req)...); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	logger.Debugw("handler launched", params.LogValues(req)...)
 
 	tmpPath := c.String("temp-path")
 	if tmpPath != "" {
@@ -130,22 +172,14 @@ func runHandler(c *cli.Context) error {
 		_ = os.Setenv("TMPDIR", tmpPath)
 	}
 
-	rc, err := redis.GetRedisClient(conf.Redis)
-	if err != nil {
-		span.RecordError(err)
-		return err
-	}
-
-	req := &livekit.StartEgressRequest{}
-	reqString := c.String("request")
-	err = protojson.Unmarshal([]byte(reqString), req)
+	transport, err := service.NewHandlerTransport(conf)
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
+	defer transport.Close()
 
-	rpcHandler := egress.NewRedisRPCServer(rc)
-	handler := service.NewHandler(conf, rpcHandler)
+	handler := service.NewHandler(conf, transport.RPCServer)
 
 	killChan := make(chan os.Signal, 1)
 	signal.Notify(killChan, syscall.SIGINT)
@@ -160,6 +194,31 @@ func runHandler(c *cli.Context) error {
 	return nil
 }
 
+// reloadConfig re-reads the config file, and applies the fields which can change while the service is running
+func reloadConfig(c *cli.Context, conf *config.Config, svc *service.Service) {
+	configFile := c.String("config")
+	if configFile == "" {
+		logger.Warnw("config can only be reloaded from a config file", nil)
+		return
+	}
+
+	logger.Infow("reloading config", "path", configFile)
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		logger.Errorw("failed to read config", err)
+		return
+	}
+	reloaded, err := conf.Reload(string(content))
+	if err == nil {
+		err = reloaded.Validate()
+	}
+	if err != nil {
+		logger.Errorw("invalid config, not reloaded", err)
+		return
+	}
+	svc.Reload(reloaded)
+}
+
 func getConfig(c *cli.Context) (*config.Config, error) {
 	configFile := c.String("config")
 	configBody := c.String("config-body")
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"time"
 
 	"github.com/go-logr/zapr"
@@ -13,30 +16,178 @@ import (
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
-	"github.com/livekit/protocol/redis"
 	"github.com/livekit/protocol/utils"
 	lksdk "github.com/livekit/server-sdk-go"
 )
 
 const (
-	roomCompositeCpuCost  = 3
-	webCpuCost            = 3
-	trackCompositeCpuCost = 2
-	trackCpuCost          = 1
+	roomCompositeCpuCost    = 3
+	webCpuCost              = 3
+	trackCompositeCpuCost   = 2
+	trackCpuCost            = 1
+	cpuHoldDuration         = time.Second * 30
+	audioOnlyCpuCostRatio   = 0.5
+	passthroughCpuCostRatio = 0.25
+	hardwareCpuCostRatio    = 1
+
+	webhookRetries = 3
+
+	uploadAttempts           = 5
+	uploadMultipartThreshold = 100 // MB
+	uploadPartSize           = 16  // MB
+	minUploadPartSize        = 5   // MB, the s3 minimum
+	uploadProgressInterval   = time.Second * 5
+	uploadProgressStep       = 5 // percent
+
+	retentionTTL = time.Hour * 24
+
+	statusFileInterval = time.Second * 10
+
+	orphanTempFileAge = time.Hour * 24
+
+	sourceRetryAttempts = 3
+	sourceRetryBackoff  = time.Second
+
+	pipelineRestartDelay = time.Second
+
+	dedupeWindow = time.Minute * 10
+
+	segmentHookTimeout = time.Second * 30
+
+	watchdogInterval     = time.Second * 5
+	watchdogStallTimeout = time.Minute
+
+	avSyncLogThreshold = time.Millisecond * 100
+	avSyncMaxStep      = time.Millisecond * 10
+
+	llhlsPartDuration    = time.Second
+	minLLHLSPartDuration = time.Millisecond * 200
+
+	thumbnailWidth   = 320
+	thumbnailQuality = 85
+
+	roomEventsMaxEvents = 1000
+
+	dataCaptureMaxRate     = 20
+	dataCaptureCueDuration = time.Second * 3
+
+	loudnessTarget  = -23 // LUFS, as in EBU R128
+	loudnessMaxGain = 20  // dB
+
+	streamReconnectAttempts = 3
+	streamReconnectWindow   = time.Minute
+
+	websocketReconnectTimeout  = time.Second * 30
+	websocketMaxBufferSize     = 4 << 20 // bytes
+	websocketMaxBufferDuration = time.Second * 5
+	websocketSampleRate        = 48000
+	websocketChannels          = 2
+
+	minRoomEmptyTimeout = time.Second * 10
+
+	chromeLogMaxLines = 1000
+
+	eosTimeout = time.Second * 30
+
+	debugBusMessages = 100
+
+	pliRetryInterval = time.Second
+	pliMinInterval   = time.Second
+
+	maxQuantizer = 51
+
+	clockOverlayFormat   = "%Y-%m-%d %H:%M:%S UTC"
+	clockOverlayFontSize = 24
+
+	roomCompositeMemoryCost  = 1
+	webMemoryCost            = 1
+	trackCompositeMemoryCost = 0.5
+	trackMemoryCost          = 0.25
+	memoryHeadroom           = 0.5
+
+	roomCompositeEncoderSessions  = 1
+	webEncoderSessions            = 1
+	trackCompositeEncoderSessions = 1
+)
+
+// video encoders
+const (
+	EncoderSoftware = "software"
+	EncoderVAAPI    = "vaapi"
+	EncoderNVENC    = "nvenc"
+	EncoderAuto     = "auto" // a hardware encoder if one can be created, otherwise software
+)
+
+// overlay positions
+const (
+	OverlayTopLeft     = "top_left"
+	OverlayTopRight    = "top_right"
+	OverlayBottomLeft  = "bottom_left"
+	OverlayBottomRight = "bottom_right"
+)
+
+// data capture formats
+const (
+	DataCaptureJSONL = "jsonl"
+	DataCaptureVTT   = "vtt"
+)
+
+// chrome log levels
+const (
+	ChromeLogDebug = "debug"
+	ChromeLogInfo  = "info"
+	ChromeLogWarn  = "warn"
+	ChromeLogError = "error"
+)
+
+// start signal timeout behavior
+const (
+	StartSignalStart = "start"
+	StartSignalFail  = "fail"
 )
 
 type Config struct {
-	Redis     *redis.RedisConfig `yaml:"redis"`      // required
-	ApiKey    string             `yaml:"api_key"`    // required (env LIVEKIT_API_KEY)
-	ApiSecret string             `yaml:"api_secret"` // required (env LIVEKIT_API_SECRET)
-	WsUrl     string             `yaml:"ws_url"`     // required (env LIVEKIT_WS_URL)
-
-	HealthPort           int    `yaml:"health_port"`
-	PrometheusPort       int    `yaml:"prometheus_port"`
-	LogLevel             string `yaml:"log_level"`
-	TemplateBase         string `yaml:"template_base"`
-	Insecure             bool   `yaml:"insecure"`
-	LocalOutputDirectory string `yaml:"local_directory"` // used for temporary storage before upload
+	Redis     *RedisConfig `yaml:"redis"`      // required, unless grpc is used
+	ApiKey    string       `yaml:"api_key"`    // required (env LIVEKIT_API_KEY)
+	ApiSecret string       `yaml:"api_secret"` // required (env LIVEKIT_API_SECRET)
+	WsUrl     string       `yaml:"ws_url"`     // required (env LIVEKIT_WS_URL)
+
+	HealthPort           int      `yaml:"health_port"`
+	PrometheusPort       int      `yaml:"prometheus_port"`
+	LogLevel             string   `yaml:"log_level"`
+	TemplateBase         string   `yaml:"template_base"`
+	TemplateAllowlist    []string `yaml:"template_allowlist"` // origins custom_base_url may use, any if empty
+	Insecure             bool     `yaml:"insecure"`
+	LocalOutputDirectory string   `yaml:"local_directory"` // used for temporary storage before upload
+	MinFreeDisk          float64  `yaml:"min_free_disk"`   // GB of free disk required to accept file egress, 0 disables
+	DisableFaststart     bool     `yaml:"disable_faststart"`
+
+	// Optional auth for prometheus metrics, served on the prometheus port and at /metrics on the health port
+	MetricsAuth *MetricsAuthConfig `yaml:"metrics_auth"`
+
+	// Optional grpc transport, used instead of redis for requests and updates
+	GRPC *GRPCConfig `yaml:"grpc"`
+
+	// Optional JSON file with the node's status, for inspecting it from a shell when redis is unreachable
+	StatusFile *StatusFileConfig `yaml:"status_file"`
+
+	// Optional OpenTelemetry export of egress traces
+	Tracing *TracingConfig `yaml:"tracing"`
+
+	// Optional limits which split composite file outputs into numbered files, each uploaded once it is written
+	FileSplit FileSplitConfig `yaml:"file_split"`
+
+	// Optional webhook, notified of egress status changes
+	Webhook *WebhookConfig `yaml:"webhook"`
+
+	// Retries and multipart settings for file uploads
+	Upload UploadConfig `yaml:"upload"`
+
+	// Optional local copies of uploaded files, deleted once they expire
+	Retention *RetentionConfig `yaml:"retention"`
+
+	// Cleanup on startup after handlers and services which were killed
+	OrphanCleanup OrphanCleanupConfig `yaml:"orphan_cleanup"`
 
 	S3     *S3Config    `yaml:"s3"`
 	Azure  *AzureConfig `yaml:"azure"`
@@ -46,11 +197,171 @@ type Config struct {
 	// CPU costs for various egress types
 	CPUCost CPUCostConfig `yaml:"cpu_cost"`
 
+	// Memory costs (in GB) for various egress types
+	MemoryCost MemoryCostConfig `yaml:"memory_cost"`
+
+	// GPU encoder session costs for various egress types, only checked when a GPU is present
+	GPUCost GPUCostConfig `yaml:"gpu_cost"`
+
 	SessionLimits `yaml:"session_limits"`
 
+	// Stream urls contain keys, which are always redacted in logs
+	StreamKeyPattern string `yaml:"stream_key_pattern"` // regexp matching the part of a stream url to redact
+	RedactStreamUrls bool   `yaml:"redact_stream_urls"` // also redact stream urls in egress info
+
+	// h264 encoder, one of software, vaapi, nvenc, or auto (default software)
+	Encoder string `yaml:"encoder"`
+
+	// Optional image overlaid on all encoded video
+	Watermark *WatermarkConfig `yaml:"watermark"`
+
+	// Optional region of the page captured by room composite and web egress
+	Crop *CropConfig `yaml:"crop"`
+
+	// Optional utc wall clock time overlaid on all encoded video
+	ClockOverlay *ClockOverlayConfig `yaml:"clock_overlay"`
+
+	// Optional loudness normalization of all encoded audio
+	Loudness *LoudnessConfig `yaml:"loudness"`
+
+	// Optional filters for all decoded audio
+	AudioFilter *AudioFilterConfig `yaml:"audio_filter"`
+
+	// Video encoder tuning which is not part of the request encoding options
+	VideoEncoding VideoEncodingConfig `yaml:"video_encoding"`
+
+	// simulcast layer subscribed to for track and track composite egress: low, medium, or high (default high)
+	VideoQuality string `yaml:"video_quality"`
+
+	// Reordering of packets received by track and track composite egress
+	JitterBuffer JitterBufferConfig `yaml:"jitter_buffer"`
+
+	// Key frame requests sent to video publishers by track and track composite egress
+	PLI PLIConfig `yaml:"pli"`
+
+	// Reconnection of stream outputs which disconnect
+	StreamReconnect StreamReconnectConfig `yaml:"stream_reconnect"`
+
+	// Reconnection and buffering of websocket outputs
+	Websocket WebsocketConfig `yaml:"websocket"`
+
+	// Waiting for tracks of track composite egress to be republished
+	Republish RepublishConfig `yaml:"republish"`
+
+	// Ending room composite egress once everyone has left the room
+	RoomEmpty RoomEmptyConfig `yaml:"room_empty"`
+
+	// Chrome launch options for room composite and web egress
+	Chrome ChromeConfig `yaml:"chrome"`
+
+	// Forwarding of chrome console messages, page errors and failed requests to the egress logs
+	ChromeLogs ChromeLogsConfig `yaml:"chrome_logs"`
+
+	// Retrying of room joins, track subscriptions and page loads which fail when an egress starts
+	SourceRetry SourceRetryConfig `yaml:"source_retry"`
+
+	// Rebuilding pipelines which fail once the egress is active
+	PipelineRestart PipelineRestartConfig `yaml:"pipeline_restart"`
+
+	// Answering retried start requests with the egress already started, instead of starting it again
+	Dedupe DedupeConfig `yaml:"dedupe"`
+
+	// Notifying external tooling of each finished segment
+	SegmentHooks SegmentHooksConfig `yaml:"segment_hooks"`
+
+	// Failing room composite and web egress whose output stops growing
+	Watchdog WatchdogConfig `yaml:"watchdog"`
+
+	// Measuring and correcting audio/video drift at the muxer
+	AVSync AVSyncConfig `yaml:"av_sync"`
+
+	// Low-latency HLS output for segmented file egress
+	LowLatencyHLS LowLatencyHLSConfig `yaml:"low_latency_hls"`
+
+	// writes an MPEG-DASH manifest next to the playlist of every segmented file egress
+	DASHManifest bool `yaml:"dash_manifest"`
+
+	// jpeg thumbnails of room composite and web egress to files and segments
+	Thumbnails ThumbnailsConfig `yaml:"thumbnails"`
+
+	// room events recorded next to room composite files, and as chapters of mp4s
+	RoomEvents RoomEventsConfig `yaml:"room_events"`
+
+	// data messages of room composite and track composite egress written to a sidecar
+	DataCapture DataCaptureConfig `yaml:"data_capture"`
+
+	// Waiting for room composite templates to signal that they are ready
+	StartSignal StartSignalConfig `yaml:"start_signal"`
+
+	// Limits on the number of egresses running at once, checked alongside resource costs
+	ConcurrencyLimits ConcurrencyLimits `yaml:"concurrency_limits"`
+
+	// how long to wait for active egresses to finish when draining before stopping them, 0 waits indefinitely
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+
+	// how long to wait for a stopped pipeline to flush before forcing it to stop
+	EOSTimeout time.Duration `yaml:"eos_timeout"`
+
+	// Output kept for debugging each egress
+	Debug DebugConfig `yaml:"debug"`
+
+	// stable across restarts, so that egresses lost in a crash can be reported. Defaults to a random ID
+	NodeID string `yaml:"node_id"`
+
 	// internal
-	NodeID     string      `yaml:"-"`
-	FileUpload interface{} `yaml:"-"` // one of S3, Azure, or GCP
+	FileUpload interface{} `yaml:"-"` // one of S3, Azure, GCP, or AliOSS
+}
+
+// MetricsAuthConfig accepts either basic auth or the bearer token, if both are set
+type MetricsAuthConfig struct {
+	Username    string `yaml:"username"` // basic auth, set with password
+	Password    string `yaml:"password"`
+	BearerToken string `yaml:"bearer_token"`
+}
+
+type StatusFileConfig struct {
+	Path     string        `yaml:"path"`     // required, replaced atomically on each write
+	Interval time.Duration `yaml:"interval"` // how often the file is written (default 10s)
+}
+
+// TracingConfig exports spans over OTLP/HTTP. Handlers follow the service's sampling decision
+type TracingConfig struct {
+	Endpoint   string            `yaml:"endpoint"`    // e.g. http://localhost:4318, /v1/traces if there is no path
+	Headers    map[string]string `yaml:"headers"`     // sent with each export, e.g. for auth
+	SampleRate float64           `yaml:"sample_rate"` // fraction of egresses traced, defaults to 1
+}
+
+type WebhookConfig struct {
+	URL       string `yaml:"url"`
+	ApiKey    string `yaml:"api_key"`    // used to sign payloads, defaults to api_key
+	ApiSecret string `yaml:"api_secret"` // defaults to api_secret
+	Retries   int    `yaml:"retries"`    // attempts after the first for 5xx responses and connection errors
+}
+
+// UploadConfig applies to all uploads. If an upload still fails after MaxAttempts, the egress fails and
+// the local file is kept for recovery
+type UploadConfig struct {
+	MaxAttempts        int   `yaml:"max_attempts"`        // attempts for each request, including each part of a multipart upload
+	MultipartThreshold int64 `yaml:"multipart_threshold"` // MB, larger files are uploaded to s3 in parts
+	PartSize           int64 `yaml:"part_size"`           // MB, at least 5
+	Progressive        bool  `yaml:"progressive"`         // upload ogg, webm, mkv and ts files to s3 while they are recorded
+
+	// file egress sends an update while its output is uploaded each time the interval passes,
+	// or the step (percent) more of the file has been uploaded
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+	ProgressStep     float64       `yaml:"progress_step"`
+
+	// bytes per second for the node, shared by all of its uploads. 0 is unlimited
+	BandwidthLimit int64 `yaml:"bandwidth_limit"`
+}
+
+type RetentionConfig struct {
+	Directory string        `yaml:"directory"` // required, each egress is kept in a subdirectory named by its egress ID
+	TTL       time.Duration `yaml:"ttl"`       // how long to keep files after upload (default 24h)
+}
+
+type OrphanCleanupConfig struct {
+	TempFileAge time.Duration `yaml:"temp_file_age"` // egress temp files not owned by a running service are removed after this (default 24h)
 }
 
 type S3Config struct {
@@ -60,11 +371,22 @@ type S3Config struct {
 	Endpoint       string `yaml:"endpoint"`
 	Bucket         string `yaml:"bucket"`
 	ForcePathStyle bool   `yaml:"force_path_style"`
+
+	// defaults for uploads using this config, which requests can override
+	Metadata map[string]string `yaml:"metadata"`
+	Tagging  string            `yaml:"tagging"` // url encoded, e.g. room_id=RM_123&team=a
+
+	// applied to all s3 uploads, since requests can't set them
+	SSE          string `yaml:"sse"`            // AES256 or aws:kms
+	SSEKMSKeyID  string `yaml:"sse_kms_key_id"` // kms key for aws:kms, defaults to the aws managed key
+	ACL          string `yaml:"acl"`            // canned acl, e.g. bucket-owner-full-control
+	StorageClass string `yaml:"storage_class"`  // e.g. STANDARD_IA
 }
 
 type AzureConfig struct {
 	AccountName   string `yaml:"account_name"` // (env AZURE_STORAGE_ACCOUNT)
 	AccountKey    string `yaml:"account_key"`  // (env AZURE_STORAGE_KEY)
+	SASToken      string `yaml:"sas_token"`    // used instead of the account key when none is given
 	ContainerName string `yaml:"container_name"`
 }
 
@@ -74,29 +396,313 @@ type GCPConfig struct {
 }
 
 type SessionLimits struct {
+	// applies to every egress, unless a shorter output specific limit is set
+	MaxDuration time.Duration `yaml:"max_duration"`
+
 	FileOutputMaxDuration    time.Duration `yaml:"file_output_max_duration"`
 	StreamOutputMaxDuration  time.Duration `yaml:"stream_output_max_duration"`
 	SegmentOutputMaxDuration time.Duration `yaml:"segment_output_max_duration"`
 }
 
+type FileSplitConfig struct {
+	MaxSize     float64       `yaml:"max_size"`     // GB, 0 disables
+	MaxDuration time.Duration `yaml:"max_duration"` // 0 disables
+}
+
+// DebugConfig applies to every egress. Each egress writes to a subdirectory of Directory named by its egress ID
+type DebugConfig struct {
+	Directory  string `yaml:"directory"`   // defaults to debug in the local directory
+	EgressLogs bool   `yaml:"egress_logs"` // also write each egress' logs to egress.log
+
+	// write the pipeline graph and the last BusMessages bus messages when a pipeline fails to link,
+	// fails, or doesn't flush within the eos timeout
+	DotDumps    bool `yaml:"dot_dumps"`
+	BusMessages int  `yaml:"bus_messages"`
+	UploadDumps bool `yaml:"upload_dumps"` // also upload dumps next to the output
+}
+
+// SourceRetryConfig applies to starting the source of every egress. The egress only fails once all attempts
+// have failed, with the attempt count in its error
+type SourceRetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"` // including the first, 1 to disable retries
+	Backoff     time.Duration `yaml:"backoff"`      // before the second attempt, doubling after each attempt
+}
+
+// PipelineRestartConfig applies to stream, file and segmented file egress whose pipeline fails with an element error
+// once active. The pipeline is rebuilt with the same source, and carries on with the same outputs
+type PipelineRestartConfig struct {
+	MaxRestarts int           `yaml:"max_restarts"` // per egress, 0 to fail instead
+	Delay       time.Duration `yaml:"delay"`        // before the pipeline is rebuilt
+}
+
+// DedupeConfig applies to start requests for an egress ID which was accepted within the window. With redis, a node
+// only starts an egress if no other node has started it within the window
+type DedupeConfig struct {
+	Window time.Duration `yaml:"window"` // how long accepted requests are remembered
+	Redis  bool          `yaml:"redis"`  // also dedupe across nodes
+}
+
+// SegmentHooksConfig applies to each finished segment of segmented file egress, and each chunk of split files.
+// Hooks run in the order segments were written, after the segment has been uploaded
+type SegmentHooksConfig struct {
+	Command []string      `yaml:"command"` // run with the segment's local path as its last argument
+	Publish bool          `yaml:"publish"` // publish each segment to redis
+	Timeout time.Duration `yaml:"timeout"` // for each command or publish
+}
+
+// WatchdogConfig samples the bytes written by every egress. Room composite and web egress fail once nothing
+// has been written for StallTimeout while active and not paused
+type WatchdogConfig struct {
+	Interval     time.Duration `yaml:"interval"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+}
+
+// AVSyncConfig applies to muxed outputs with both audio and video. Drift is how far audio timestamps are
+// ahead of video at the muxer, and is logged once it passes LogThreshold. Once it passes MaxDrift, audio is shifted
+// by up to MaxStep each second until the drift is back under half of MaxDrift
+type AVSyncConfig struct {
+	LogThreshold time.Duration `yaml:"log_threshold"`
+	MaxDrift     time.Duration `yaml:"max_drift"` // 0 only measures drift
+	MaxStep      time.Duration `yaml:"max_step"`
+}
+
+// LowLatencyHLSConfig applies to every segmented file egress on the node. Segments are written as parts of
+// PartDuration, which are uploaded and listed in the playlist as they are written, and joined into the segment
+// once it's complete
+type LowLatencyHLSConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	PartDuration time.Duration `yaml:"part_duration"` // must divide the segment duration
+}
+
+// ThumbnailsConfig takes a thumbnail of the first frame, then one every Interval. They are uploaded next to
+// the output, and never fail the egress
+type ThumbnailsConfig struct {
+	Interval time.Duration `yaml:"interval"` // whole seconds, 0 to disable
+	Width    int32         `yaml:"width"`    // keeping the aspect ratio, never larger than the output
+	Quality  int           `yaml:"quality"`  // jpeg quality, 1-100
+}
+
+// RoomEventsConfig applies to room composite egress to files. A hidden participant collects track, speaker and
+// chapter events from the room, which are uploaded next to the file and written as chapters of mp4s
+type RoomEventsConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	MaxEvents int  `yaml:"max_events"` // later events are counted and dropped
+}
+
+// DataCaptureConfig applies to room composite and track composite egress to files and segments. Data messages are
+// appended to a sidecar as they arrive, which is uploaded next to the output once it's complete
+type DataCaptureConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Topic       string        `yaml:"topic"`        // only json messages with a matching "topic" field, empty for all
+	Format      string        `yaml:"format"`       // jsonl or vtt
+	MaxRate     int           `yaml:"max_rate"`     // messages per second, later ones are dropped
+	CueDuration time.Duration `yaml:"cue_duration"` // longest a vtt cue is shown
+}
+
+// StreamReconnectConfig bounds reconnection of rtmp outputs. A url is marked as failed once
+// MaxAttempts reconnects have been made within Window
+type StreamReconnectConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	Window      time.Duration `yaml:"window"`
+}
+
+// WebsocketConfig applies to track egress to websockets. While the consumer is disconnected or falls behind,
+// audio is buffered up to MaxBufferSize and MaxBufferDuration, after which the oldest audio is dropped
+type WebsocketConfig struct {
+	ReconnectTimeout  time.Duration `yaml:"reconnect_timeout"` // how long to keep reconnecting before the egress fails
+	MaxBufferSize     int           `yaml:"max_buffer_size"`   // bytes
+	MaxBufferDuration time.Duration `yaml:"max_buffer_duration"`
+
+	// s16le pcm format sent to the consumer. With Timestamps, each message starts with a header
+	// containing its capture time and a sequence number
+	SampleRate int  `yaml:"sample_rate"`
+	Channels   int  `yaml:"channels"`
+	Timestamps bool `yaml:"timestamps"`
+}
+
+// RepublishConfig applies to track composite egress. When a track is unpublished or its participant disconnects,
+// the egress waits up to Timeout for the participant to publish a track from the same source, then continues with it
+type RepublishConfig struct {
+	Timeout     time.Duration `yaml:"timeout"`     // 0 ends the egress when a track is unpublished
+	Placeholder bool          `yaml:"placeholder"` // write blank video while waiting, instead of holding the last frame
+}
+
+// ChromeConfig applies to room composite and web egress. The proxy can only be set per node
+type ChromeConfig struct {
+	Flags           []string `yaml:"flags"`             // extra launch flags like --name or --name=value, --name=false removes a default flag
+	ProxyServer     string   `yaml:"proxy_server"`      // passed to --proxy-server
+	ProxyBypassList string   `yaml:"proxy_bypass_list"` // passed to --proxy-bypass-list
+}
+
+// ChromeLogsConfig applies to room composite and web egress
+type ChromeLogsConfig struct {
+	Level    string `yaml:"level"`     // the lowest severity forwarded
+	MaxLines int    `yaml:"max_lines"` // per egress, after which messages are dropped
+}
+
+// StartSignalConfig applies to room composite egress. Capture starts when the template logs START_RECORDING,
+// or once Timeout has passed without it
+type StartSignalConfig struct {
+	Timeout   time.Duration `yaml:"timeout"`    // 0 waits indefinitely
+	OnTimeout string        `yaml:"on_timeout"` // StartSignalStart or StartSignalFail
+}
+
+// RoomEmptyConfig applies to room composite egress. Once the room has had no participants for Timeout, the egress
+// ends as if it had been stopped. Egress recorders and hidden participants are not counted
+type RoomEmptyConfig struct {
+	Timeout time.Duration `yaml:"timeout"` // 0 keeps recording empty rooms
+	Tracks  bool          `yaml:"tracks"`  // also treat the room as empty when no participant has published a track
+}
+
+// JitterBufferConfig applies to track and track composite egress. Packets are reordered, and missing packets are
+// waited for up to Latency before being treated as lost
+type JitterBufferConfig struct {
+	Latency time.Duration `yaml:"latency"` // 0 waits up to 2s for video and 4s for audio
+}
+
+// PLIConfig applies to video track and track composite egress. A key frame is requested as soon as the track is
+// subscribed, and every RetryInterval until one arrives. Later requests, after packet loss, are sent at most once
+// per MinInterval
+type PLIConfig struct {
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	MinInterval   time.Duration `yaml:"min_interval"`
+}
+
+// VideoEncodingConfig applies to every encoded video output. Zero values keep the current encoder behavior
+type VideoEncodingConfig struct {
+	KeyFrameInterval time.Duration `yaml:"key_frame_interval"` // maximum time between key frames
+	RateControl      string        `yaml:"rate_control"`       // cbr, vbr, or cqp (default cbr)
+	Quantizer        uint          `yaml:"quantizer"`          // h264 quantizer for cqp, or quality for vbr, 0-51
+
+	// frames per second for requests which don't set one, e.g. 25 or 29.97 (default 30)
+	Framerate float64 `yaml:"framerate"`
+	// encode 24, 30 and 60 fps as 23.976, 29.97 and 59.94, since requests can only set whole rates
+	NTSC bool `yaml:"ntsc"`
+}
+
+type WatermarkConfig struct {
+	Image    string  `yaml:"image"`    // url or local path of a png or jpeg, required
+	Position string  `yaml:"position"` // corner to place the image in (default bottom_right)
+	OffsetX  int     `yaml:"offset_x"` // pixels between the image and the left or right edge
+	OffsetY  int     `yaml:"offset_y"` // pixels between the image and the top or bottom edge
+	Opacity  float64 `yaml:"opacity"`  // 0-1 (default 1)
+}
+
+// CropConfig is a region of the page in display pixels, which is scaled to the output resolution
+type CropConfig struct {
+	Left   int32 `yaml:"left"`
+	Top    int32 `yaml:"top"`
+	Width  int32 `yaml:"width"`
+	Height int32 `yaml:"height"`
+}
+
+// LoudnessConfig moves the gain of mixed audio toward Target, by at most MaxGain either way
+type LoudnessConfig struct {
+	Target  float64 `yaml:"target"`   // LUFS
+	MaxGain float64 `yaml:"max_gain"` // dB
+}
+
+// AudioFilterConfig cleans up voice recordings
+type AudioFilterConfig struct {
+	HighpassCutoff   float64 `yaml:"highpass_cutoff"` // Hz, 0 to disable
+	NoiseSuppression bool    `yaml:"noise_suppression"`
+}
+
+type ClockOverlayConfig struct {
+	Format   string `yaml:"format"`    // strftime format (default %Y-%m-%d %H:%M:%S UTC)
+	FontSize int    `yaml:"font_size"` // (default 24)
+	Position string `yaml:"position"`  // corner to place the time in (default top_left)
+}
+
+// ConcurrencyLimits caps the number of running egresses. Zero means unlimited.
+type ConcurrencyLimits struct {
+	MaxConcurrent     int `yaml:"max_concurrent"`
+	MaxRoomComposite  int `yaml:"max_room_composite"`
+	MaxWeb            int `yaml:"max_web"`
+	MaxTrackComposite int `yaml:"max_track_composite"`
+	MaxTrack          int `yaml:"max_track"`
+}
+
 type CPUCostConfig struct {
 	RoomCompositeCpuCost  float64 `yaml:"room_composite_cpu_cost"`
 	TrackCompositeCpuCost float64 `yaml:"track_composite_cpu_cost"`
 	TrackCpuCost          float64 `yaml:"track_cpu_cost"`
 	WebCpuCost            float64 `yaml:"web_cpu_cost"`
+
+	// composite requests without video are charged this fraction of their cpu cost
+	AudioOnlyCpuCostRatio float64 `yaml:"audio_only_cpu_cost_ratio"`
+	// track composite requests remuxed to mkv without transcoding are charged this fraction of their cpu cost
+	PassthroughCpuCostRatio float64 `yaml:"passthrough_cpu_cost_ratio"`
+	// requests encoded with vaapi or nvenc are charged this fraction of their cpu cost
+	HardwareCpuCostRatio float64 `yaml:"hardware_cpu_cost_ratio"`
+
+	// CPU is held from acceptance until the egress starts, or until this duration has passed
+	CPUHoldDuration time.Duration `yaml:"cpu_hold_duration"`
+
+	// Optional cost of composite requests from their encoding options, in place of the costs above.
+	// Requests without encoding options are charged the costs above
+	Formula *CPUCostFormula `yaml:"formula"`
+}
+
+// CPUCostFormula charges a composite request base_cpu_cost, plus chrome_cpu_cost for room composite and web,
+// megapixel_cpu_cost for each million pixels encoded each second (1080p30 is 62.2) unless it is audio only,
+// and audio_cpu_cost unless it is video only
+type CPUCostFormula struct {
+	BaseCpuCost      float64 `yaml:"base_cpu_cost"`
+	ChromeCpuCost    float64 `yaml:"chrome_cpu_cost"`
+	MegapixelCpuCost float64 `yaml:"megapixel_cpu_cost"`
+	AudioCpuCost     float64 `yaml:"audio_cpu_cost"`
+}
+
+type MemoryCostConfig struct {
+	RoomCompositeMemoryCost  float64 `yaml:"room_composite_memory_cost"`
+	TrackCompositeMemoryCost float64 `yaml:"track_composite_memory_cost"`
+	TrackMemoryCost          float64 `yaml:"track_memory_cost"`
+	WebMemoryCost            float64 `yaml:"web_memory_cost"`
+	MemoryHeadroom           float64 `yaml:"memory_headroom"` // memory to keep free on the node
+}
+
+type GPUCostConfig struct {
+	MaxEncoderSessions            int64 `yaml:"max_encoder_sessions"` // 0 disables encoder session checks
+	RoomCompositeEncoderSessions  int64 `yaml:"room_composite_encoder_sessions"`
+	TrackCompositeEncoderSessions int64 `yaml:"track_composite_encoder_sessions"`
+	TrackEncoderSessions          int64 `yaml:"track_encoder_sessions"`
+	WebEncoderSessions            int64 `yaml:"web_encoder_sessions"`
 }
 
 func NewConfig(confString string) (*Config, error) {
+	conf, err := parseConfig(confString, utils.NewGuid("NE_"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conf.initLogger(nil, nil); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// Reload parses a changed config body, without initializing the logger. The node ID is kept if it isn't set.
+func (c *Config) Reload(confString string) (*Config, error) {
+	return parseConfig(confString, c.NodeID)
+}
+
+func parseConfig(confString, nodeID string) (*Config, error) {
 	conf := &Config{
 		LogLevel:     "info",
 		TemplateBase: "https://egress-composite.livekit.io",
 		ApiKey:       os.Getenv("LIVEKIT_API_KEY"),
 		ApiSecret:    os.Getenv("LIVEKIT_API_SECRET"),
 		WsUrl:        os.Getenv("LIVEKIT_WS_URL"),
-		NodeID:       utils.NewGuid("NE_"),
+		NodeID:       nodeID,
 	}
 	if confString != "" {
-		if err := yaml.Unmarshal([]byte(confString), conf); err != nil {
+		expanded, err := expandEnv(confString)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal([]byte(expanded), conf); err != nil {
 			return nil, errors.ErrCouldNotParseConfig(err)
 		}
 	}
@@ -109,6 +715,8 @@ func NewConfig(confString string) (*Config, error) {
 			Endpoint:       conf.S3.Endpoint,
 			Bucket:         conf.S3.Bucket,
 			ForcePathStyle: conf.S3.ForcePathStyle,
+			Metadata:       conf.S3.Metadata,
+			Tagging:        conf.S3.Tagging,
 		}
 	} else if conf.GCP != nil {
 		var credentials []byte
@@ -135,6 +743,356 @@ func NewConfig(confString string) (*Config, error) {
 		}
 	}
 
+	if conf.Redis != nil {
+		conf.Redis.updateDefaults()
+	}
+
+	if conf.Webhook != nil {
+		if conf.Webhook.ApiKey == "" {
+			conf.Webhook.ApiKey = conf.ApiKey
+		}
+		if conf.Webhook.ApiSecret == "" {
+			conf.Webhook.ApiSecret = conf.ApiSecret
+		}
+		if conf.Webhook.Retries <= 0 {
+			conf.Webhook.Retries = webhookRetries
+		}
+	}
+
+	if auth := conf.MetricsAuth; auth != nil {
+		if (auth.Username == "") != (auth.Password == "") {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("metrics_auth username and password must be set together"))
+		}
+		if auth.Username == "" && auth.BearerToken == "" {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("metrics_auth requires a username and password, or a bearer_token"))
+		}
+	}
+
+	if conf.StatusFile != nil {
+		if conf.StatusFile.Path == "" {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("status_file path is required"))
+		}
+		if conf.StatusFile.Interval <= 0 {
+			conf.StatusFile.Interval = statusFileInterval
+		}
+	}
+
+	if conf.Tracing != nil {
+		u, err := url.Parse(conf.Tracing.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("tracing endpoint must be an http or https url"))
+		}
+		if conf.Tracing.SampleRate == 0 {
+			conf.Tracing.SampleRate = 1
+		} else if conf.Tracing.SampleRate < 0 || conf.Tracing.SampleRate > 1 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("tracing sample_rate must be between 0 and 1"))
+		}
+	}
+
+	if conf.StreamKeyPattern != "" {
+		if _, err := regexp.Compile(conf.StreamKeyPattern); err != nil {
+			return nil, errors.ErrCouldNotParseConfig(err)
+		}
+	}
+
+	switch conf.Encoder {
+	case "":
+		conf.Encoder = EncoderSoftware
+	case EncoderSoftware, EncoderVAAPI, EncoderNVENC, EncoderAuto:
+	default:
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid encoder %s", conf.Encoder))
+	}
+
+	if conf.Crop != nil {
+		if conf.Crop.Left < 0 || conf.Crop.Top < 0 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("crop left and top cannot be negative"))
+		}
+		if conf.Crop.Width <= 0 || conf.Crop.Height <= 0 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("crop width and height are required"))
+		}
+	}
+
+	if conf.Watermark != nil {
+		if conf.Watermark.Image == "" {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("watermark image is required"))
+		}
+		switch conf.Watermark.Position {
+		case "":
+			conf.Watermark.Position = OverlayBottomRight
+		case OverlayTopLeft, OverlayTopRight, OverlayBottomLeft, OverlayBottomRight:
+		default:
+			return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid watermark position %s", conf.Watermark.Position))
+		}
+		if conf.Watermark.Opacity < 0 || conf.Watermark.Opacity > 1 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("watermark opacity must be between 0 and 1"))
+		}
+		if conf.Watermark.Opacity == 0 {
+			conf.Watermark.Opacity = 1
+		}
+	}
+
+	if conf.ClockOverlay != nil {
+		if conf.ClockOverlay.Format == "" {
+			conf.ClockOverlay.Format = clockOverlayFormat
+		}
+		if conf.ClockOverlay.FontSize <= 0 {
+			conf.ClockOverlay.FontSize = clockOverlayFontSize
+		}
+		switch conf.ClockOverlay.Position {
+		case "":
+			conf.ClockOverlay.Position = OverlayTopLeft
+		case OverlayTopLeft, OverlayTopRight, OverlayBottomLeft, OverlayBottomRight:
+		default:
+			return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid clock overlay position %s", conf.ClockOverlay.Position))
+		}
+	}
+
+	switch conf.VideoEncoding.RateControl {
+	case "", "cbr", "vbr", "cqp":
+	default:
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid rate_control %s", conf.VideoEncoding.RateControl))
+	}
+	if conf.VideoEncoding.Quantizer > maxQuantizer {
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("quantizer must be at most %d", maxQuantizer))
+	}
+	if conf.VideoEncoding.KeyFrameInterval < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("key_frame_interval cannot be negative"))
+	}
+	if conf.VideoEncoding.Framerate != 0 && (conf.VideoEncoding.Framerate < 1 || conf.VideoEncoding.Framerate > 60) {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("framerate must be between 1 and 60"))
+	}
+
+	switch conf.VideoQuality {
+	case "", "low", "medium", "high":
+	default:
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid video_quality %s", conf.VideoQuality))
+	}
+
+	for _, origin := range conf.TemplateAllowlist {
+		if u, err := url.Parse(origin); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid template_allowlist entry %s, expected an origin like https://example.com", origin))
+		}
+	}
+
+	switch conf.ChromeLogs.Level {
+	case "":
+		conf.ChromeLogs.Level = ChromeLogDebug
+	case ChromeLogDebug, ChromeLogInfo, ChromeLogWarn, ChromeLogError:
+	default:
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid chrome_logs level %s", conf.ChromeLogs.Level))
+	}
+	if conf.ChromeLogs.MaxLines < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("chrome_logs max_lines cannot be negative"))
+	} else if conf.ChromeLogs.MaxLines == 0 {
+		conf.ChromeLogs.MaxLines = chromeLogMaxLines
+	}
+
+	if conf.StartSignal.Timeout < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("start_signal timeout cannot be negative"))
+	}
+	switch conf.StartSignal.OnTimeout {
+	case "":
+		conf.StartSignal.OnTimeout = StartSignalStart
+	case StartSignalStart, StartSignalFail:
+	default:
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid start_signal on_timeout %s", conf.StartSignal.OnTimeout))
+	}
+
+	if conf.RoomEmpty.Timeout < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("room_empty timeout cannot be negative"))
+	} else if conf.RoomEmpty.Timeout > 0 && conf.RoomEmpty.Timeout < minRoomEmptyTimeout {
+		// short enough to end the egress during a participant reconnect
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("room_empty timeout must be at least %v", minRoomEmptyTimeout))
+	}
+
+	if conf.JitterBuffer.Latency < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("jitter_buffer latency cannot be negative"))
+	}
+
+	if conf.Republish.Timeout < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("republish timeout cannot be negative"))
+	}
+
+	if conf.FileSplit.MaxSize < 0 || conf.FileSplit.MaxDuration < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("file_split limits cannot be negative"))
+	}
+
+	if conf.Upload.MaxAttempts <= 0 {
+		conf.Upload.MaxAttempts = uploadAttempts
+	}
+	if conf.Upload.MultipartThreshold <= 0 {
+		conf.Upload.MultipartThreshold = uploadMultipartThreshold
+	}
+	if conf.Upload.PartSize <= 0 {
+		conf.Upload.PartSize = uploadPartSize
+	} else if conf.Upload.PartSize < minUploadPartSize {
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("upload part_size must be at least %d MB", minUploadPartSize))
+	}
+	if conf.Upload.ProgressInterval <= 0 {
+		conf.Upload.ProgressInterval = uploadProgressInterval
+	}
+	if conf.Upload.ProgressStep <= 0 {
+		conf.Upload.ProgressStep = uploadProgressStep
+	}
+	if conf.Upload.BandwidthLimit < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("upload bandwidth_limit cannot be negative"))
+	}
+
+	if conf.Retention != nil {
+		if conf.Retention.Directory == "" {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("retention directory is required"))
+		}
+		conf.Retention.Directory = path.Clean(conf.Retention.Directory)
+		if conf.Retention.TTL <= 0 {
+			conf.Retention.TTL = retentionTTL
+		}
+	}
+
+	if conf.OrphanCleanup.TempFileAge <= 0 {
+		conf.OrphanCleanup.TempFileAge = orphanTempFileAge
+	}
+
+	if conf.SourceRetry.MaxAttempts <= 0 {
+		conf.SourceRetry.MaxAttempts = sourceRetryAttempts
+	}
+	if conf.SourceRetry.Backoff <= 0 {
+		conf.SourceRetry.Backoff = sourceRetryBackoff
+	}
+	if conf.PipelineRestart.MaxRestarts < 0 || conf.PipelineRestart.Delay < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("pipeline_restart values cannot be negative"))
+	} else if conf.PipelineRestart.Delay == 0 {
+		conf.PipelineRestart.Delay = pipelineRestartDelay
+	}
+	if conf.Dedupe.Window < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("dedupe window cannot be negative"))
+	} else if conf.Dedupe.Window == 0 {
+		conf.Dedupe.Window = dedupeWindow
+	}
+	if conf.SegmentHooks.Timeout < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("segment_hooks timeout cannot be negative"))
+	} else if conf.SegmentHooks.Timeout == 0 {
+		conf.SegmentHooks.Timeout = segmentHookTimeout
+	}
+	if conf.Watchdog.Interval < 0 || conf.Watchdog.StallTimeout < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("watchdog durations cannot be negative"))
+	}
+	if conf.Watchdog.Interval == 0 {
+		conf.Watchdog.Interval = watchdogInterval
+	}
+	if conf.Watchdog.StallTimeout == 0 {
+		conf.Watchdog.StallTimeout = watchdogStallTimeout
+	}
+	if conf.Watchdog.StallTimeout < conf.Watchdog.Interval {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("watchdog stall_timeout must be at least its interval"))
+	}
+	if conf.AVSync.LogThreshold < 0 || conf.AVSync.MaxDrift < 0 || conf.AVSync.MaxStep < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("av_sync durations cannot be negative"))
+	}
+	if conf.AVSync.LogThreshold == 0 {
+		conf.AVSync.LogThreshold = avSyncLogThreshold
+	}
+	if conf.AVSync.MaxStep == 0 {
+		conf.AVSync.MaxStep = avSyncMaxStep
+	}
+	if conf.Loudness != nil {
+		if conf.Loudness.Target == 0 {
+			conf.Loudness.Target = loudnessTarget
+		} else if conf.Loudness.Target > 0 || conf.Loudness.Target < -50 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("loudness target must be between -50 and 0 LUFS"))
+		}
+		if conf.Loudness.MaxGain < 0 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("loudness max_gain cannot be negative"))
+		} else if conf.Loudness.MaxGain == 0 {
+			conf.Loudness.MaxGain = loudnessMaxGain
+		}
+	}
+	if conf.AudioFilter != nil && (conf.AudioFilter.HighpassCutoff < 0 || conf.AudioFilter.HighpassCutoff > 1000) {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("audio_filter highpass_cutoff must be between 0 and 1000 Hz"))
+	}
+	if conf.Thumbnails.Interval < 0 || conf.Thumbnails.Interval%time.Second != 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("thumbnails interval must be whole seconds"))
+	}
+	if conf.Thumbnails.Width < 0 || conf.Thumbnails.Width%2 != 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("thumbnails width must be even"))
+	} else if conf.Thumbnails.Width == 0 {
+		conf.Thumbnails.Width = thumbnailWidth
+	}
+	if conf.Thumbnails.Quality < 0 || conf.Thumbnails.Quality > 100 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("thumbnails quality must be between 1 and 100"))
+	} else if conf.Thumbnails.Quality == 0 {
+		conf.Thumbnails.Quality = thumbnailQuality
+	}
+	if conf.RoomEvents.Enabled && (conf.ApiKey == "" || conf.ApiSecret == "") {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("room_events requires api_key and api_secret"))
+	}
+	if conf.RoomEvents.MaxEvents < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("room_events max_events cannot be negative"))
+	} else if conf.RoomEvents.MaxEvents == 0 {
+		conf.RoomEvents.MaxEvents = roomEventsMaxEvents
+	}
+	switch conf.DataCapture.Format {
+	case "":
+		conf.DataCapture.Format = DataCaptureJSONL
+	case DataCaptureJSONL, DataCaptureVTT:
+	default:
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("invalid data_capture format %s", conf.DataCapture.Format))
+	}
+	if conf.DataCapture.MaxRate < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("data_capture max_rate cannot be negative"))
+	} else if conf.DataCapture.MaxRate == 0 {
+		conf.DataCapture.MaxRate = dataCaptureMaxRate
+	}
+	if conf.DataCapture.CueDuration < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("data_capture cue_duration cannot be negative"))
+	} else if conf.DataCapture.CueDuration == 0 {
+		conf.DataCapture.CueDuration = dataCaptureCueDuration
+	}
+	if conf.DataCapture.Enabled && (conf.ApiKey == "" || conf.ApiSecret == "") {
+		// room composite egress joins with a token of its own
+		return nil, errors.ErrCouldNotParseConfig(errors.New("data_capture requires api_key and api_secret"))
+	}
+	if conf.LowLatencyHLS.PartDuration == 0 {
+		conf.LowLatencyHLS.PartDuration = llhlsPartDuration
+	} else if conf.LowLatencyHLS.PartDuration < minLLHLSPartDuration {
+		return nil, errors.ErrCouldNotParseConfig(fmt.Errorf("low_latency_hls part_duration must be at least %v", minLLHLSPartDuration))
+	}
+	if conf.StreamReconnect.MaxAttempts <= 0 {
+		conf.StreamReconnect.MaxAttempts = streamReconnectAttempts
+	}
+	if conf.StreamReconnect.Window <= 0 {
+		conf.StreamReconnect.Window = streamReconnectWindow
+	}
+	if conf.EOSTimeout < 0 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("eos_timeout cannot be negative"))
+	} else if conf.EOSTimeout == 0 {
+		conf.EOSTimeout = eosTimeout
+	}
+	if conf.PLI.RetryInterval <= 0 {
+		conf.PLI.RetryInterval = pliRetryInterval
+	}
+	if conf.PLI.MinInterval <= 0 {
+		conf.PLI.MinInterval = pliMinInterval
+	}
+	if conf.Websocket.ReconnectTimeout <= 0 {
+		conf.Websocket.ReconnectTimeout = websocketReconnectTimeout
+	}
+	if conf.Websocket.MaxBufferSize <= 0 {
+		conf.Websocket.MaxBufferSize = websocketMaxBufferSize
+	}
+	if conf.Websocket.MaxBufferDuration <= 0 {
+		conf.Websocket.MaxBufferDuration = websocketMaxBufferDuration
+	}
+	if conf.Websocket.SampleRate <= 0 {
+		conf.Websocket.SampleRate = websocketSampleRate
+	} else if conf.Websocket.SampleRate < 8000 || conf.Websocket.SampleRate > 96000 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("websocket sample_rate must be between 8000 and 96000"))
+	}
+	if conf.Websocket.Channels <= 0 {
+		conf.Websocket.Channels = websocketChannels
+	} else if conf.Websocket.Channels > 2 {
+		return nil, errors.ErrCouldNotParseConfig(errors.New("websocket channels must be 1 or 2"))
+	}
+
 	// Setting CPU costs from config. Ensure that CPU costs are positive
 	if conf.CPUCost.RoomCompositeCpuCost <= 0 {
 		conf.CPUCost.RoomCompositeCpuCost = roomCompositeCpuCost
@@ -148,31 +1106,110 @@ func NewConfig(confString string) (*Config, error) {
 	if conf.CPUCost.TrackCpuCost <= 0 {
 		conf.CPUCost.TrackCpuCost = trackCpuCost
 	}
+	if conf.CPUCost.AudioOnlyCpuCostRatio <= 0 || conf.CPUCost.AudioOnlyCpuCostRatio > 1 {
+		conf.CPUCost.AudioOnlyCpuCostRatio = audioOnlyCpuCostRatio
+	}
+	if conf.CPUCost.PassthroughCpuCostRatio <= 0 || conf.CPUCost.PassthroughCpuCostRatio > 1 {
+		conf.CPUCost.PassthroughCpuCostRatio = passthroughCpuCostRatio
+	}
+	if conf.CPUCost.HardwareCpuCostRatio <= 0 || conf.CPUCost.HardwareCpuCostRatio > 1 {
+		conf.CPUCost.HardwareCpuCostRatio = hardwareCpuCostRatio
+	}
+	if conf.CPUCost.CPUHoldDuration <= 0 {
+		conf.CPUCost.CPUHoldDuration = cpuHoldDuration
+	}
+	if formula := conf.CPUCost.Formula; formula != nil {
+		if formula.BaseCpuCost < 0 || formula.ChromeCpuCost < 0 || formula.MegapixelCpuCost < 0 || formula.AudioCpuCost < 0 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("cpu_cost formula costs cannot be negative"))
+		}
+		if formula.BaseCpuCost+formula.ChromeCpuCost+formula.MegapixelCpuCost+formula.AudioCpuCost == 0 {
+			return nil, errors.ErrCouldNotParseConfig(errors.New("cpu_cost formula requires at least one cost"))
+		}
+	}
+
+	// Setting memory costs from config. Ensure that memory costs are positive
+	if conf.MemoryCost.RoomCompositeMemoryCost <= 0 {
+		conf.MemoryCost.RoomCompositeMemoryCost = roomCompositeMemoryCost
+	}
+	if conf.MemoryCost.WebMemoryCost <= 0 {
+		conf.MemoryCost.WebMemoryCost = webMemoryCost
+	}
+	if conf.MemoryCost.TrackCompositeMemoryCost <= 0 {
+		conf.MemoryCost.TrackCompositeMemoryCost = trackCompositeMemoryCost
+	}
+	if conf.MemoryCost.TrackMemoryCost <= 0 {
+		conf.MemoryCost.TrackMemoryCost = trackMemoryCost
+	}
+	if conf.MemoryCost.MemoryHeadroom <= 0 {
+		conf.MemoryCost.MemoryHeadroom = memoryHeadroom
+	}
+
+	// Setting gpu costs from config. Track egress does not transcode, so it has no default cost
+	if conf.GPUCost.RoomCompositeEncoderSessions <= 0 {
+		conf.GPUCost.RoomCompositeEncoderSessions = roomCompositeEncoderSessions
+	}
+	if conf.GPUCost.WebEncoderSessions <= 0 {
+		conf.GPUCost.WebEncoderSessions = webEncoderSessions
+	}
+	if conf.GPUCost.TrackCompositeEncoderSessions <= 0 {
+		conf.GPUCost.TrackCompositeEncoderSessions = trackCompositeEncoderSessions
+	}
 
 	conf.LocalOutputDirectory = path.Clean(conf.LocalOutputDirectory)
 	if conf.LocalOutputDirectory == "." {
 		conf.LocalOutputDirectory = os.TempDir()
 	}
 
-	if err := conf.initLogger(); err != nil {
-		return nil, err
+	if conf.Debug.Directory == "" {
+		conf.Debug.Directory = path.Join(conf.LocalOutputDirectory, "debug")
+	}
+	if conf.Debug.BusMessages <= 0 {
+		conf.Debug.BusMessages = debugBusMessages
 	}
 
 	return conf, nil
 }
 
-func (c *Config) initLogger() error {
+// InitHandlerLogger is called by the handler of an egress. Room connection logs are tagged with keysAndValues,
+// and if egress logs are enabled, everything the handler logs is also written to its debug directory
+func (c *Config) InitHandlerLogger(egressID string, keysAndValues ...interface{}) error {
+	var outputs []string
+	if c.Debug.EgressLogs {
+		dir := path.Join(c.Debug.Directory, egressID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		outputs = append(outputs, path.Join(dir, "egress.log"))
+	}
+
+	return c.initLogger(outputs, keysAndValues)
+}
+
+// the level of every logger built from a config, so that it can be changed while running
+var logLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+// SetLogLevel changes the level of the logger, if level is valid
+func SetLogLevel(level string) {
+	lvl := zapcore.Level(0)
+	if err := lvl.UnmarshalText([]byte(level)); err == nil {
+		logLevel.SetLevel(lvl)
+	}
+}
+
+func (c *Config) initLogger(outputs []string, sdkValues []interface{}) error {
 	conf := zap.NewProductionConfig()
 	if c.LogLevel != "" {
-		lvl := zapcore.Level(0)
-		if err := lvl.UnmarshalText([]byte(c.LogLevel)); err == nil {
-			conf.Level = zap.NewAtomicLevelAt(lvl)
-		}
+		SetLogLevel(c.LogLevel)
 	}
+	conf.Level = logLevel
+	conf.OutputPaths = append(conf.OutputPaths, outputs...)
 
-	l, _ := conf.Build()
+	l, err := conf.Build()
+	if err != nil {
+		return err
+	}
 
 	logger.SetLogger(zapr.NewLogger(l).WithValues("nodeID", c.NodeID), "egress")
-	lksdk.SetLogger(logger.GetLogger())
+	lksdk.SetLogger(logger.GetLogger().WithValues(sdkValues...))
 	return nil
 }
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/livekit/egress/pkg/errors"
+)
+
+// expandEnv replaces ${VAR} with the value of VAR, and ${VAR:-default} with default if VAR is unset or empty.
+// Defaults may contain variables themselves, and $$ is a literal $. Every unset variable without a default is
+// listed in the error.
+func expandEnv(s string) (string, error) {
+	e := &envExpander{s: s}
+	expanded, err := e.text(false)
+	if err != nil {
+		return "", errors.ErrCouldNotParseConfig(err)
+	}
+	if len(e.missing) > 0 {
+		return "", errors.ErrCouldNotParseConfig(
+			fmt.Errorf("environment variables not set: %s", strings.Join(e.missing, ", ")),
+		)
+	}
+	return expanded, nil
+}
+
+// EscapeEnv escapes s so that it is unchanged by expansion, e.g. for a config which has already been expanded
+func EscapeEnv(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+type envExpander struct {
+	s       string
+	pos     int
+	missing []string
+}
+
+// text expands until the end of s, or the brace closing a default
+func (e *envExpander) text(inDefault bool) (string, error) {
+	var b strings.Builder
+	for e.pos < len(e.s) {
+		switch {
+		case strings.HasPrefix(e.s[e.pos:], "$$"):
+			b.WriteByte('$')
+			e.pos += 2
+		case strings.HasPrefix(e.s[e.pos:], "${"):
+			e.pos += 2
+			value, err := e.variable()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+		case inDefault && e.s[e.pos] == '}':
+			return b.String(), nil
+		default:
+			b.WriteByte(e.s[e.pos])
+			e.pos++
+		}
+	}
+
+	if inDefault {
+		return "", errors.New("unterminated ${ in config")
+	}
+	return b.String(), nil
+}
+
+// variable expands a variable, after its ${
+func (e *envExpander) variable() (string, error) {
+	start := e.pos
+	for e.pos < len(e.s) && isEnvNameChar(e.s[e.pos], e.pos == start) {
+		e.pos++
+	}
+	name := e.s[start:e.pos]
+
+	switch {
+	case name == "":
+		return "", fmt.Errorf("invalid environment variable in config at %q", excerpt(e.s[start-2:]))
+
+	case strings.HasPrefix(e.s[e.pos:], "}"):
+		e.pos++
+		if value, ok := os.LookupEnv(name); ok {
+			return value, nil
+		}
+		e.addMissing(name)
+		return "", nil
+
+	case strings.HasPrefix(e.s[e.pos:], ":-"):
+		e.pos += 2
+		// variables in the default are only required if it is used
+		missing := len(e.missing)
+		def, err := e.text(true)
+		if err != nil {
+			return "", err
+		}
+		e.pos++
+		if value := os.Getenv(name); value != "" {
+			e.missing = e.missing[:missing]
+			return value, nil
+		}
+		return def, nil
+
+	default:
+		return "", fmt.Errorf("invalid environment variable in config at %q", excerpt(e.s[start-2:]))
+	}
+}
+
+func (e *envExpander) addMissing(name string) {
+	for _, m := range e.missing {
+		if m == name {
+			return
+		}
+	}
+	e.missing = append(e.missing, name)
+}
+
+func isEnvNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// excerpt keeps error messages short
+func excerpt(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 20 {
+		s = s[:20]
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("S3_SECRET", "secret")
+	t.Setenv("REDIS_HOST", "redis")
+	t.Setenv("EMPTY", "")
+
+	for _, c := range []struct {
+		in       string
+		expected string
+	}{
+		{"secret: ${S3_SECRET}", "secret: secret"},
+		{"address: ${REDIS_HOST}:6379", "address: redis:6379"},
+		{"address: ${REDIS_ADDRESS:-localhost:6379}", "address: localhost:6379"},
+		{"address: ${REDIS_HOST:-localhost}", "address: redis"},
+		// an empty variable is set, but uses the default
+		{"key: ${EMPTY}", "key: "},
+		{"key: ${EMPTY:-default}", "key: default"},
+		{"key: ${EMPTY:-}", "key: "},
+		// nested defaults
+		{"address: ${REDIS_ADDRESS:-${REDIS_HOST}:6379}", "address: redis:6379"},
+		{"address: ${REDIS_ADDRESS:-${REDIS_PORT:-${REDIS_HOST}}}", "address: redis"},
+		// unused defaults are not required
+		{"address: ${REDIS_HOST:-${REDIS_ADDRESS}}", "address: redis"},
+		// escaped and unexpanded dollar signs
+		{"password: pa$$${S3_SECRET}", "password: pa$secret"},
+		{"password: $$${S3_SECRET}$$", "password: $secret$"},
+		{"password: pa$word", "password: pa$word"},
+		{"filename: {room_name}-{time}.mp4", "filename: {room_name}-{time}.mp4"},
+	} {
+		expanded, err := expandEnv(c.in)
+		require.NoError(t, err, c.in)
+		require.Equal(t, c.expected, expanded, c.in)
+	}
+
+	// config already expanded by the service is unchanged by the handler
+	expanded, err := expandEnv(EscapeEnv("password: pa$$word${S3_SECRET}"))
+	require.NoError(t, err)
+	require.Equal(t, "password: pa$$word${S3_SECRET}", expanded)
+}
+
+func TestExpandEnvErrors(t *testing.T) {
+	t.Setenv("REDIS_HOST", "redis")
+
+	// every missing variable is listed, once
+	_, err := expandEnv("key: ${API_KEY}\nsecret: ${API_SECRET}\nother: ${API_KEY}\naddress: ${REDIS_HOST}")
+	require.EqualError(t, err, "could not parse config: environment variables not set: API_KEY, API_SECRET")
+
+	// including those in defaults which are used
+	_, err = expandEnv("address: ${REDIS_ADDRESS:-${REDIS_PORT}}")
+	require.EqualError(t, err, "could not parse config: environment variables not set: REDIS_PORT")
+
+	for _, in := range []string{
+		"key: ${API_KEY",
+		"key: ${API_KEY:-default",
+		"key: ${}",
+		"key: ${1KEY}",
+		"key: ${API-KEY}",
+	} {
+		_, err = expandEnv(in)
+		require.Error(t, err, in)
+	}
+}
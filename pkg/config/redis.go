@@ -0,0 +1,85 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/livekit/protocol/redis"
+)
+
+const (
+	// enough to ride out a sentinel failover
+	redisMaxRetries      = 10
+	redisMinRetryBackoff = time.Millisecond * 100
+	redisMaxRetryBackoff = time.Second * 3
+)
+
+// RedisConfig adds tls and retry options to the protocol's config. Sentinel is used if sentinel_addresses is set.
+type RedisConfig struct {
+	redis.RedisConfig `yaml:",inline"`
+
+	TLS *RedisTLSConfig `yaml:"tls"` // implies use_tls
+
+	// commands are retried with exponential backoff, e.g. while sentinel promotes a new master
+	MaxRetries      int           `yaml:"max_retries"`       // default 10, -1 disables retries
+	MinRetryBackoff time.Duration `yaml:"min_retry_backoff"` // default 100ms
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"` // default 3s
+}
+
+type RedisTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`   // defaults to the system roots
+	CertFile           string `yaml:"cert_file"` // client certificate, set with key_file
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"` // defaults to the host connected to
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (c *RedisConfig) updateDefaults() {
+	if c.MaxRetries == 0 {
+		c.MaxRetries = redisMaxRetries
+	}
+	if c.MinRetryBackoff <= 0 {
+		c.MinRetryBackoff = redisMinRetryBackoff
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = redisMaxRetryBackoff
+	}
+}
+
+// GetTLSConfig returns nil if tls is not used
+func (c *RedisConfig) GetTLSConfig() (*tls.Config, error) {
+	if !c.UseTLS && c.TLS == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.TLS == nil {
+		return tlsConfig, nil
+	}
+
+	tlsConfig.ServerName = c.TLS.ServerName
+	tlsConfig.InsecureSkipVerify = c.TLS.InsecureSkipVerify
+	if c.TLS.CAFile != "" {
+		pem, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLS.CAFile)
+		}
+	}
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRedisConfig(t *testing.T) {
+	conf, err := parseConfig(`
+redis:
+  sentinel_master_name: egress
+  sentinel_addresses: [sentinel-1:26379, sentinel-2:26379]
+  password: secret
+  max_retries: 5
+  tls:
+    server_name: redis.internal
+`, "NE_test")
+	require.NoError(t, err)
+	require.Equal(t, "egress", conf.Redis.MasterName)
+	require.Equal(t, []string{"sentinel-1:26379", "sentinel-2:26379"}, conf.Redis.SentinelAddresses)
+	require.Equal(t, "secret", conf.Redis.Password)
+	require.Equal(t, 5, conf.Redis.MaxRetries)
+	require.Equal(t, redisMinRetryBackoff, conf.Redis.MinRetryBackoff)
+	require.Equal(t, time.Second*3, conf.Redis.MaxRetryBackoff)
+
+	tlsConfig, err := conf.Redis.GetTLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, "redis.internal", tlsConfig.ServerName)
+
+	// handlers get the same config from the service
+	b, err := yaml.Marshal(conf)
+	require.NoError(t, err)
+	handlerConf, err := parseConfig(string(b), "NE_handler")
+	require.NoError(t, err)
+	require.Equal(t, conf.Redis, handlerConf.Redis)
+
+	conf.Redis.TLS = nil
+	tlsConfig, err = conf.Redis.GetTLSConfig()
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+
+	conf.Redis.TLS = &RedisTLSConfig{CAFile: "/missing/ca.pem"}
+	_, err = conf.Redis.GetTLSConfig()
+	require.Error(t, err)
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+)
+
+// minimum and recommended cpu costs of each egress type
+var cpuCostMinimums = []struct {
+	field       string
+	minimum     float64
+	recommended float64
+	cost        func(CPUCostConfig) float64
+}{
+	{"room_composite_cpu_cost", 2.5, 3, func(c CPUCostConfig) float64 { return c.RoomCompositeCpuCost }},
+	{"web_cpu_cost", 2.5, 3, func(c CPUCostConfig) float64 { return c.WebCpuCost }},
+	{"track_composite_cpu_cost", 1, 2, func(c CPUCostConfig) float64 { return c.TrackCompositeCpuCost }},
+	{"track_cpu_cost", 0.5, 1, func(c CPUCostConfig) float64 { return c.TrackCpuCost }},
+}
+
+// Validate checks that the service can run with the config on this node, before any egress depends on it.
+// Every problem is returned in one error, and anything which only limits the service is logged as a warning.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if c.ApiKey == "" {
+		v.problem("api_key is required")
+	}
+	if c.ApiSecret == "" {
+		v.problem("api_secret is required")
+	}
+	if c.WsUrl == "" {
+		v.problem("ws_url is required")
+	} else {
+		v.checkUrl("ws_url", c.WsUrl, "ws", "wss", "http", "https")
+	}
+	switch {
+	case c.Redis == nil && c.GRPC != nil:
+		// redis is optional with the grpc transport
+	case c.Redis == nil || (c.Redis.Address == "" && len(c.Redis.SentinelAddresses) == 0):
+		v.problem("redis address or sentinel_addresses is required")
+	case len(c.Redis.SentinelAddresses) > 0 && c.Redis.MasterName == "":
+		v.problem("redis sentinel_master_name is required with sentinel_addresses")
+	}
+	if c.Redis != nil {
+		if _, err := c.Redis.GetTLSConfig(); err != nil {
+			v.problem("redis tls: %v", err)
+		}
+	}
+	if c.GRPC != nil {
+		if c.GRPC.Address == "" {
+			v.problem("grpc address is required")
+		} else if _, _, err := net.SplitHostPort(c.GRPC.Address); err != nil {
+			v.problem("invalid grpc address %s: %v", c.GRPC.Address, err)
+		}
+		if _, err := c.GRPC.GetTLSConfig(); err != nil {
+			v.problem("grpc tls: %v", err)
+		}
+	}
+	if c.Dedupe.Redis && c.Redis == nil {
+		v.problem("dedupe redis requires redis")
+	}
+	v.checkUrl("template_base", c.TemplateBase, "http", "https")
+	if c.Webhook != nil {
+		v.checkUrl("webhook url", c.Webhook.URL, "http", "https")
+	}
+
+	ports := make(map[int]string)
+	for _, port := range []struct {
+		field string
+		port  int
+	}{
+		{"health_port", c.HealthPort},
+		{"prometheus_port", c.PrometheusPort},
+	} {
+		switch {
+		case port.port == 0:
+		case port.port < 0 || port.port > 65535:
+			v.problem("%s %d is not a valid port", port.field, port.port)
+		case ports[port.port] != "":
+			v.problem("%s and %s are both %d", ports[port.port], port.field, port.port)
+		default:
+			ports[port.port] = port.field
+		}
+	}
+
+	v.checkUploads(c)
+
+	v.checkDirectory("local_directory", c.LocalOutputDirectory)
+	if c.Retention != nil {
+		v.checkDirectory("retention directory", c.Retention.Directory)
+	}
+	if c.Debug.EgressLogs || c.Debug.DotDumps {
+		v.checkDirectory("debug directory", c.Debug.Directory)
+	}
+	if c.StatusFile != nil {
+		v.checkDirectory("status_file path", path.Dir(c.StatusFile.Path))
+	}
+
+	v.checkCPUCost(c.CPUCost, float64(runtime.NumCPU()))
+
+	if len(v.warnings) > 0 {
+		logger.Warnw("config warnings", nil, "warnings", v.warnings)
+	}
+	if len(v.problems) > 0 {
+		return errors.ErrInvalidConfig(v.problems)
+	}
+	return nil
+}
+
+type validator struct {
+	problems []string
+	warnings []string
+}
+
+func (v *validator) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) warning(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) checkUrl(field, rawUrl string, schemes ...string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		v.problem("%s %q is not a valid url", field, rawUrl)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	v.problem("%s %q must be a %s url", field, rawUrl, strings.Join(schemes, ", "))
+}
+
+// checkUploads checks the fields each uploader requires
+func (v *validator) checkUploads(c *Config) {
+	if s3 := c.S3; s3 != nil {
+		if s3.Bucket == "" {
+			v.problem("s3 bucket is required")
+		}
+		if (s3.AccessKey == "") != (s3.Secret == "") {
+			v.problem("s3 access_key and secret must be set together")
+		}
+		region := s3.Region
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		switch {
+		case s3.Endpoint != "":
+			// s3 compatible services use their own regions
+			v.checkUrl("s3 endpoint", s3.Endpoint, "http", "https")
+		case region == "":
+			v.problem("s3 region is required without an endpoint")
+		case !isAWSRegion(region):
+			v.problem("s3 region %q is not an aws region", region)
+		}
+	}
+
+	if azure := c.Azure; azure != nil {
+		if azure.AccountName == "" {
+			v.problem("azure account_name is required")
+		}
+		if azure.AccountKey == "" && azure.SASToken == "" {
+			v.problem("azure account_key or sas_token is required")
+		}
+		if azure.ContainerName == "" {
+			v.problem("azure container_name is required")
+		}
+	}
+
+	if gcp := c.GCP; gcp != nil && gcp.Bucket == "" {
+		v.problem("gcp bucket is required")
+	}
+
+	if oss := c.AliOSS; oss != nil {
+		if oss.Bucket == "" {
+			v.problem("alioss bucket is required")
+		}
+		if oss.AccessKey == "" || oss.Secret == "" {
+			v.problem("alioss access_key and secret are required")
+		}
+		if oss.Endpoint == "" && oss.Region == "" {
+			v.problem("alioss endpoint or region is required")
+		}
+	}
+}
+
+func isAWSRegion(region string) bool {
+	for _, p := range endpoints.DefaultPartitions() {
+		if _, ok := p.Regions()[region]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDirectory checks that dir, or the closest parent which exists if it will be created, can be written to
+func (v *validator) checkDirectory(field, dir string) {
+	for existing := dir; ; existing = path.Dir(existing) {
+		info, err := os.Stat(existing)
+		missing := os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+		if missing && existing != path.Dir(existing) {
+			continue
+		}
+		switch {
+		case err != nil:
+			v.problem("%s %s: %v", field, dir, err)
+		case !info.IsDir():
+			v.problem("%s %s: %s is not a directory", field, dir, existing)
+		case syscall.Access(existing, 0x2) != nil: // W_OK
+			v.problem("%s %s: %s is not writable", field, dir, existing)
+		}
+		return
+	}
+}
+
+// checkCPUCost reports costs below the recommended minimums, and egress types which cost more than the node has.
+// The service can't start if it has too few cpus for any type
+func (v *validator) checkCPUCost(costs CPUCostConfig, numCPUs float64) {
+	requirements := make([]float64, 0, len(cpuCostMinimums))
+	for _, m := range cpuCostMinimums {
+		cost := m.cost(costs)
+		if cost < m.minimum {
+			v.warning("cpu_cost %s %v is below the minimum of %v (recommended %v)", m.field, cost, m.minimum, m.recommended)
+		}
+		requirements = append(requirements, cost)
+	}
+	sort.Float64s(requirements)
+
+	recommendedMinimum := requirements[2]
+	if recommendedMinimum < 3 {
+		recommendedMinimum = 3
+	}
+
+	switch {
+	case numCPUs < requirements[0]:
+		v.problem("not enough cpu: %v available, at least %v required (recommended %v)",
+			numCPUs, requirements[0], recommendedMinimum)
+	case numCPUs < requirements[3]:
+		var disabled []string
+		for _, m := range cpuCostMinimums {
+			if numCPUs < m.cost(costs) {
+				disabled = append(disabled, strings.TrimSuffix(m.field, "_cpu_cost"))
+			}
+		}
+		v.warning("not enough cpu for %s egress, which will be disabled: %v available, %v required (recommended %v)",
+			strings.Join(disabled, ", "), numCPUs, requirements[3], recommendedMinimum)
+	}
+}
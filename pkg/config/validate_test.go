@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/redis"
+)
+
+func newValidConfig(t *testing.T) *Config {
+	conf, err := parseConfig("", "NE_test")
+	require.NoError(t, err)
+	conf.ApiKey = "key"
+	conf.ApiSecret = "secret"
+	conf.WsUrl = "wss://livekit.example.com"
+	conf.Redis = &RedisConfig{RedisConfig: redis.RedisConfig{Address: "localhost:6379"}}
+	conf.LocalOutputDirectory = t.TempDir()
+	conf.CPUCost = CPUCostConfig{
+		RoomCompositeCpuCost:  0.5,
+		WebCpuCost:            0.5,
+		TrackCompositeCpuCost: 0.5,
+		TrackCpuCost:          0.5,
+	}
+	return conf
+}
+
+func TestValidate(t *testing.T) {
+	conf := newValidConfig(t)
+	require.NoError(t, conf.Validate())
+
+	conf.S3 = &S3Config{Bucket: "bucket"}
+	t.Setenv("AWS_DEFAULT_REGION", "us-west-2")
+	require.NoError(t, conf.Validate())
+
+	// s3 compatible services have their own regions
+	conf.S3.Region = "minio-1"
+	conf.S3.Endpoint = "http://localhost:9000"
+	require.NoError(t, conf.Validate())
+
+	// directories are created if they don't exist
+	conf.Retention = &RetentionConfig{Directory: path.Join(t.TempDir(), "retention", "egress")}
+	require.NoError(t, conf.Validate())
+
+	// redis is optional with the grpc transport
+	conf.Redis = nil
+	conf.GRPC = &GRPCConfig{Address: ":9090"}
+	require.NoError(t, conf.Validate())
+
+	// but not with cross-node dedupe
+	conf.Dedupe.Redis = true
+	require.Error(t, conf.Validate())
+}
+
+func TestValidateProblems(t *testing.T) {
+	conf := newValidConfig(t)
+	conf.ApiKey = ""
+	conf.WsUrl = "livekit.example.com"
+	conf.HealthPort = 8080
+	conf.PrometheusPort = 8080
+	conf.S3 = &S3Config{Bucket: "bucket", Region: "us-wset-2"}
+	conf.Azure = &AzureConfig{AccountName: "account"}
+	conf.GRPC = &GRPCConfig{Address: "9090", TLS: &GRPCTLSConfig{CertFile: "cert.pem"}}
+
+	file := path.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	conf.LocalOutputDirectory = path.Join(file, "egress")
+
+	// every problem is returned at once
+	err := conf.Validate()
+	var invalid *errors.InvalidConfigError
+	require.True(t, errors.As(err, &invalid))
+	require.Equal(t, []string{
+		"api_key is required",
+		`ws_url "livekit.example.com" is not a valid url`,
+		"invalid grpc address 9090: address 9090: missing port in address",
+		"grpc tls: cert_file and key_file are required",
+		"health_port and prometheus_port are both 8080",
+		`s3 region "us-wset-2" is not an aws region`,
+		"azure account_key or sas_token is required",
+		"azure container_name is required",
+		"local_directory " + conf.LocalOutputDirectory + ": " + file + " is not a directory",
+	}, invalid.Problems)
+}
+
+func TestValidateCPUCost(t *testing.T) {
+	v := &validator{}
+	v.checkCPUCost(CPUCostConfig{
+		RoomCompositeCpuCost:  3,
+		WebCpuCost:            2,
+		TrackCompositeCpuCost: 2,
+		TrackCpuCost:          1,
+	}, 2)
+	require.Empty(t, v.problems)
+	require.Equal(t, []string{
+		"cpu_cost web_cpu_cost 2 is below the minimum of 2.5 (recommended 3)",
+		"not enough cpu for room_composite egress, which will be disabled: 2 available, 3 required (recommended 3)",
+	}, v.warnings)
+
+	v = &validator{}
+	v.checkCPUCost(CPUCostConfig{
+		RoomCompositeCpuCost:  3,
+		WebCpuCost:            3,
+		TrackCompositeCpuCost: 2,
+		TrackCpuCost:          1,
+	}, 0.5)
+	require.Equal(t, []string{"not enough cpu: 0.5 available, at least 1 required (recommended 3)"}, v.problems)
+}
@@ -3,16 +3,133 @@ package errors
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 var (
 	ErrNoConfig            = errors.New("missing config")
-	ErrInvalidRPC          = errors.New("invalid request")
-	ErrGhostPadFailed      = errors.New("failed to add ghost pad to bin")
-	ErrStreamAlreadyExists = errors.New("stream already exists")
-	ErrStreamNotFound      = errors.New("stream not found")
+	ErrInvalidRPC          = WithCode(CategoryValidation, CodeInvalidRequest, errors.New("invalid request"))
+	ErrGhostPadFailed      = WithCode(CategoryInternal, CodeInternal, errors.New("failed to add ghost pad to bin"))
+	ErrStreamAlreadyExists = WithCode(CategoryValidation, CodeInvalidRequest, errors.New("stream already exists"))
+	ErrStreamNotFound      = WithCode(CategoryValidation, CodeInvalidRequest, errors.New("stream not found"))
+	ErrDiskFull            = WithCode(CategoryOutput, CodeDiskFull, errors.New("not enough disk space"))
+	ErrPipelineStalled     = WithCode(CategoryPipeline, CodePipelineStalled, errors.New("pipeline stalled"))
+	ErrEgressNotActive     = WithCode(CategoryValidation, CodeInvalidRequest, errors.New("egress not active"))
+	ErrSourceDisconnected  = WithCode(CategorySource, CodeSourceFailed, errors.New("source disconnected, cannot resume"))
+	ErrBitrateWithCQP      = WithCode(CategoryValidation, CodeInvalidRequest, errors.New("video bitrate cannot be set with cqp rate control"))
+	ErrStartSignalTimeout  = WithCode(CategorySource, CodeStartTimeout, errors.New("template did not signal START_RECORDING in time"))
 )
 
+// error categories, which say which part of an egress failed
+const (
+	CategoryValidation = "validation" // the request is invalid, and can't succeed on any node
+	CategorySource     = "source"     // the room, track or page being recorded
+	CategoryPipeline   = "pipeline"   // media processing
+	CategoryOutput     = "output"     // local files, streams and websockets
+	CategoryUpload     = "upload"     // cloud storage
+	CategoryInternal   = "internal"   // egress or node misconfiguration
+	CategoryAborted    = "aborted"    // stopped before it started
+)
+
+// error codes are stable identifiers, reported with failed egresses so that clients can handle errors without
+// parsing messages
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeInvalidUrl          = "invalid_url"
+	CodeNotSupported        = "not_supported"
+	CodeTemplateNotAllowed  = "template_not_allowed"
+	CodeTrackNotFound       = "track_not_found"
+	CodeParticipantNotFound = "participant_not_found"
+	CodeSourceFailed        = "source_failed"
+	CodePageException       = "page_exception"
+	CodeStartTimeout        = "start_timeout"
+	CodePipelineFailed      = "pipeline_failed"
+	CodePipelineStalled     = "pipeline_stalled"
+	CodeStreamFailed        = "stream_failed"
+	CodeWebsocketFailed     = "websocket_failed"
+	CodeDiskFull            = "disk_full"
+	CodeUploadFailed        = "upload_failed"
+	CodeInternal            = "internal"
+	CodeAborted             = "aborted"
+)
+
+// codes used for errors which only have a category
+var defaultCodes = map[string]string{
+	CategoryValidation: CodeInvalidRequest,
+	CategorySource:     CodeSourceFailed,
+	CategoryPipeline:   CodePipelineFailed,
+	CategoryOutput:     CodeStreamFailed,
+	CategoryUpload:     CodeUploadFailed,
+	CategoryInternal:   CodeInternal,
+	CategoryAborted:    CodeAborted,
+}
+
+// CategorizedError attaches a category and code to an error so that failures can be grouped
+type CategorizedError struct {
+	Category string
+	Code     string
+	err      error
+}
+
+func (e *CategorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *CategorizedError) Unwrap() error {
+	return e.err
+}
+
+// WithCategory categorizes err, unless it already has a category
+func WithCategory(category string, err error) error {
+	return WithCode(category, defaultCodes[category], err)
+}
+
+// WithCode categorizes err with a specific code, unless it already has a category
+func WithCode(category, code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *CategorizedError
+	if errors.As(err, &e) {
+		return err
+	}
+	return &CategorizedError{Category: category, Code: code, err: err}
+}
+
+// Category returns the category of err, defaulting to CategoryPipeline
+func Category(err error) string {
+	var e *CategorizedError
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return CategoryPipeline
+}
+
+// Code returns the code of err, defaulting to CodePipelineFailed
+func Code(err error) string {
+	var e *CategorizedError
+	if errors.As(err, &e) && e.Code != "" {
+		return e.Code
+	}
+	return CodePipelineFailed
+}
+
+// IsValidation returns true if err means the request itself is invalid
+func IsValidation(err error) bool {
+	return Category(err) == CategoryValidation
+}
+
+// Format returns the text reported in EgressInfo.Error, the error code in brackets followed by the message
+func Format(err error) string {
+	return FormatMessage(Code(err), err.Error())
+}
+
+// FormatMessage prefixes msg with an error code, as in EgressInfo.Error
+func FormatMessage(code, msg string) string {
+	return fmt.Sprintf("[%s] %s", code, msg)
+}
+
 func New(err string) error {
 	return errors.New(err)
 }
@@ -21,42 +138,100 @@ func Is(err, target error) bool {
 	return errors.Is(err, target)
 }
 
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
 func ErrCouldNotParseConfig(err error) error {
 	return fmt.Errorf("could not parse config: %v", err)
 }
 
+// InvalidConfigError lists every problem found in a config
+type InvalidConfigError struct {
+	Problems []string
+}
+
+func (e *InvalidConfigError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
+}
+
+func ErrInvalidConfig(problems []string) error {
+	return &InvalidConfigError{Problems: problems}
+}
+
 func ErrNotSupported(feature string) error {
-	return fmt.Errorf("%s is not yet supported", feature)
+	return WithCode(CategoryValidation, CodeNotSupported, fmt.Errorf("%s is not yet supported", feature))
 }
 
 func ErrIncompatible(format, codec interface{}) error {
-	return fmt.Errorf("format %v incompatible with codec %v", format, codec)
+	return WithCode(CategoryValidation, CodeInvalidRequest, fmt.Errorf("format %v incompatible with codec %v", format, codec))
+}
+
+func ErrExtensionIncompatible(extension, format interface{}) error {
+	return WithCode(CategoryValidation, CodeInvalidRequest, fmt.Errorf("file extension %v incompatible with format %v", extension, format))
+}
+
+func ErrPassthroughIncompatible(requested, codec interface{}) error {
+	return WithCode(CategoryValidation, CodeInvalidRequest, fmt.Errorf("passthrough requires %v, but track is %v", requested, codec))
 }
 
 func ErrInvalidInput(field string) error {
-	return fmt.Errorf("request has missing or invalid field: %s", field)
+	return WithCode(CategoryValidation, CodeInvalidRequest, fmt.Errorf("request has missing or invalid field: %s", field))
 }
 
 func ErrInvalidUrl(url, protocol string) error {
-	return fmt.Errorf("invalid %s url: %s", protocol, url)
+	return WithCode(CategoryValidation, CodeInvalidUrl, fmt.Errorf("invalid %s url: %s", protocol, url))
+}
+
+func ErrTemplateNotAllowed(url string) error {
+	return WithCode(CategoryValidation, CodeTemplateNotAllowed, fmt.Errorf("custom template %s is not in the template allowlist", url))
+}
+
+// PageError is returned when the page being recorded throws an uncaught exception before recording starts
+type PageError struct {
+	Message string
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("page threw an uncaught exception: %s", e.Message)
+}
+
+func ErrPageException(message string) error {
+	return WithCode(CategorySource, CodePageException, &PageError{Message: message})
 }
 
 func ErrTrackNotFound(trackID string) error {
-	return fmt.Errorf("track %s not found", trackID)
+	return WithCode(CategorySource, CodeTrackNotFound, fmt.Errorf("track %s not found", trackID))
 }
 
 func ErrParticipantNotFound(identity string) error {
-	return fmt.Errorf("participant %s not found", identity)
+	return WithCode(CategorySource, CodeParticipantNotFound, fmt.Errorf("participant %s not found", identity))
+}
+
+func ErrTrackNotRepublished(identity string, source string, timeout time.Duration) error {
+	return WithCategory(CategorySource, fmt.Errorf("%s track from %s was not republished within %v", source, identity, timeout))
 }
 
 func ErrPadLinkFailed(src, sink, status string) error {
-	return fmt.Errorf("failed to link %s to %s: %s", src, sink, status)
+	return WithCode(CategoryInternal, CodeInternal, fmt.Errorf("failed to link %s to %s: %s", src, sink, status))
 }
 
 func ErrUploadFailed(location string, err error) error {
-	return fmt.Errorf("%s upload failed: %v", location, err)
+	return WithCategory(CategoryUpload, fmt.Errorf("%s upload failed: %v", location, err))
+}
+
+func ErrInvalidUploadConfig(location, reason string) error {
+	return WithCode(CategoryValidation, CodeInvalidRequest, fmt.Errorf("invalid %s upload config: %s", location, reason))
+}
+
+func ErrWatermarkFailed(image string, err error) error {
+	return WithCode(CategoryInternal, CodeInternal, fmt.Errorf("could not load watermark %s: %v", image, err))
 }
 
 func ErrWebSocketClosed(addr string) error {
-	return errors.New(fmt.Sprintf("websocket already closed: %s", addr))
+	return WithCode(CategoryOutput, CodeWebsocketFailed, fmt.Errorf("websocket already closed: %s", addr))
+}
+
+func ErrWebSocketReconnectFailed(addr string, err error) error {
+	return WithCode(CategoryOutput, CodeWebsocketFailed, fmt.Errorf("could not reconnect websocket %s: %v", addr, err))
 }
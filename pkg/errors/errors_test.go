@@ -0,0 +1,36 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodes(t *testing.T) {
+	err := ErrInvalidInput("url")
+	require.Equal(t, CategoryValidation, Category(err))
+	require.Equal(t, CodeInvalidRequest, Code(err))
+	require.True(t, IsValidation(err))
+	require.Equal(t, "[invalid_request] request has missing or invalid field: url", Format(err))
+
+	// the most specific category is kept
+	wrapped := WithCategory(CategorySource, ErrTrackNotFound("TR_1"))
+	require.Equal(t, CategorySource, Category(wrapped))
+	require.Equal(t, CodeTrackNotFound, Code(wrapped))
+	wrapped = WithCategory(CategorySource, fmt.Errorf("could not build input: %w", ErrIncompatible("mp4", "vp8")))
+	require.Equal(t, CategoryValidation, Category(wrapped))
+
+	// categories without a code
+	require.Equal(t, CodeUploadFailed, Code(ErrUploadFailed("s3", New("timeout"))))
+
+	// uncategorized errors come from the pipeline
+	err = New("internal data stream error")
+	require.Equal(t, CategoryPipeline, Category(err))
+	require.Equal(t, CodePipelineFailed, Code(err))
+	require.False(t, IsValidation(err))
+
+	var pageErr *PageError
+	require.True(t, As(ErrPageException("ReferenceError: x is not defined"), &pageErr))
+	require.Equal(t, "ReferenceError: x is not defined", pageErr.Message)
+}
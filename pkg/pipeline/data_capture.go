@@ -0,0 +1,25 @@
+package pipeline
+
+import (
+	"context"
+)
+
+// closeDataCapture stops taking data messages, once the input has closed
+func (p *Pipeline) closeDataCapture() {
+	written, dropped, err := p.dataWriter.Close()
+	if err != nil {
+		p.Logger.Errorw("could not write data messages", err)
+	}
+	// restarted pipelines add to the count of the one before
+	p.DataMessageCount += written
+	if dropped > 0 {
+		p.Logger.Infow("data messages dropped", "written", written, "dropped", dropped)
+	}
+}
+
+// storeDataCapture uploads the data capture sidecar next to the output. A missing sidecar doesn't fail the egress
+func (p *Pipeline) storeDataCapture(ctx context.Context) {
+	if _, _, err := p.storeFile(ctx, p.GetDataCaptureFilepath(), p.GetDataCaptureStorageFilepath(), p.GetDataCaptureType(), nil); err != nil {
+		p.Logger.Errorw("could not store data messages", err)
+	}
+}
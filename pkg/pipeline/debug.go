@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// reasons for a debug dump, used in its filenames
+const (
+	dumpLinkFailed = "link_failed"
+	dumpError      = "error"
+	dumpEOSTimeout = "eos_timeout"
+	dumpStalled    = "stalled"
+)
+
+// busHistory keeps the most recent bus messages, which are written with the pipeline graph in debug dumps
+type busHistory struct {
+	mu       sync.Mutex
+	max      int
+	messages []string
+}
+
+func newBusHistory(max int) *busHistory {
+	return &busHistory{max: max}
+}
+
+func (h *busHistory) add(msg *gst.Message) {
+	line := fmt.Sprintf("%s %s %s: %s", time.Now().UTC().Format(time.RFC3339Nano), msg.TypeName(), msg.Source(), msg.String())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.messages) >= h.max {
+		h.messages = h.messages[1:]
+	}
+	h.messages = append(h.messages, line)
+}
+
+func (h *busHistory) list() []string {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string(nil), h.messages...)
+}
+
+// writeDebugDump writes the pipeline graph and recent bus messages to the egress' debug directory,
+// returning the paths of the files written
+func writeDebugDump(conf config.DebugConfig, p *params.Params, bin *gst.Bin, messages []string, reason string) ([]string, error) {
+	dir := path.Join(conf.Directory, p.Info.EgressId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	dotPath := path.Join(dir, fmt.Sprintf("%s_%s.dot", p.Info.EgressId, reason))
+	if err := os.WriteFile(dotPath, []byte(bin.DebugBinToDotData(gst.DebugGraphShowAll)), 0644); err != nil {
+		return nil, err
+	}
+
+	// bus messages can include stream urls
+	busPath := path.Join(dir, fmt.Sprintf("%s_%s_bus.log", p.Info.EgressId, reason))
+	log := p.RedactUrls(strings.Join(messages, "\n"))
+	if err := os.WriteFile(busPath, []byte(log), 0644); err != nil {
+		return nil, err
+	}
+
+	return []string{dotPath, busPath}, nil
+}
+
+// debugDump writes a debug dump if they are enabled, and uploads it next to the output if configured.
+// It returns the local directory of the dump, to be logged with the failure
+func (p *Pipeline) debugDump(reason string) string {
+	if !p.debugConf.DotDumps {
+		return ""
+	}
+
+	files, err := writeDebugDump(p.debugConf, p.Params, p.pipeline.Bin, p.busHistory.list(), reason)
+	if err != nil {
+		p.Logger.Warnw("could not write debug dump", err)
+		return ""
+	}
+
+	if p.debugConf.UploadDumps && p.uploader != nil {
+		for _, localPath := range files {
+			_, filename := path.Split(localPath)
+			if _, err = p.uploader.Upload(localPath, p.debugStoragePath(filename), "text/plain", nil); err != nil {
+				p.Logger.Warnw("could not upload debug dump", err, "location", p.uploader.Location())
+			}
+		}
+	}
+
+	return path.Dir(files[0])
+}
+
+// debugStoragePath puts debug files where the output is stored
+func (p *Pipeline) debugStoragePath(filename string) string {
+	if p.EgressType == params.EgressTypeSegmentedFile {
+		return p.GetStorageFilepath(filename)
+	}
+	dir, _ := path.Split(p.StorageFilepath)
+	return path.Join(dir, filename)
+}
@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	// hooks which can't keep up are skipped once this many segments are waiting
+	maxPendingHooks = 100
+	// the end of a failed command's output is logged
+	maxHookOutput = 1024
+)
+
+// SegmentEvent describes a finished segment of segmented file egress, or chunk of a split file
+type SegmentEvent struct {
+	EgressID  string
+	Sequence  int    // from 0, in the order segments were written
+	LocalPath string // removed after the hooks have run if it was uploaded, unless retained
+	Location  string // where it was uploaded, if it was
+	StartTime int64  // running time, in ns
+	Duration  int64  // ns
+}
+
+type segmentHook struct {
+	event  *SegmentEvent
+	remove bool
+}
+
+// segmentHooks runs the configured command and publishes a message for each segment, one segment at a time
+// so that hooks see segments in order. Failures are logged and counted, but don't fail the egress
+type segmentHooks struct {
+	conf    config.SegmentHooksConfig
+	logger  logger.Logger
+	publish func(context.Context, *SegmentEvent) error
+
+	sequence int
+	pending  chan *segmentHook
+	done     chan struct{}
+	failures atomic.Int32
+}
+
+func newSegmentHooks(conf config.SegmentHooksConfig, l logger.Logger) *segmentHooks {
+	return &segmentHooks{
+		conf:    conf,
+		logger:  l,
+		pending: make(chan *segmentHook, maxPendingHooks),
+		done:    make(chan struct{}),
+	}
+}
+
+func (h *segmentHooks) start() {
+	go func() {
+		defer close(h.done)
+		for hook := range h.pending {
+			h.run(hook.event)
+			if hook.remove {
+				_ = os.Remove(hook.event.LocalPath)
+			}
+		}
+	}()
+}
+
+// enqueue is called from the segment worker, in segment order
+func (h *segmentHooks) enqueue(event *SegmentEvent, remove bool) {
+	event.Sequence = h.sequence
+	h.sequence++
+
+	select {
+	case h.pending <- &segmentHook{event: event, remove: remove}:
+	default:
+		h.failures.Inc()
+		h.logger.Warnw("segment hooks falling behind, skipping segment", nil, "path", event.LocalPath, "sequence", event.Sequence)
+		if remove {
+			_ = os.Remove(event.LocalPath)
+		}
+	}
+}
+
+// close waits for the remaining hooks to run
+func (h *segmentHooks) close() {
+	close(h.pending)
+	<-h.done
+}
+
+func (h *segmentHooks) run(event *SegmentEvent) {
+	if len(h.conf.Command) > 0 {
+		if err := h.exec(event); err != nil {
+			h.failures.Inc()
+			h.logger.Warnw("segment hook failed", err, "path", event.LocalPath, "sequence", event.Sequence)
+		}
+	}
+
+	if h.conf.Publish && h.publish != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), h.conf.Timeout)
+		err := h.publish(ctx, event)
+		cancel()
+		if err != nil {
+			h.failures.Inc()
+			h.logger.Warnw("failed to publish segment", err, "path", event.LocalPath, "sequence", event.Sequence)
+		}
+	}
+}
+
+// exec runs the command with the segment path as its last argument, and the rest of the event in its environment
+func (h *segmentHooks) exec(event *SegmentEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.conf.Timeout)
+	defer cancel()
+
+	args := append(append([]string{}, h.conf.Command[1:]...), event.LocalPath)
+	cmd := exec.CommandContext(ctx, h.conf.Command[0], args...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("EGRESS_ID=%s", event.EgressID),
+		fmt.Sprintf("SEGMENT_SEQUENCE=%d", event.Sequence),
+		fmt.Sprintf("SEGMENT_PATH=%s", event.LocalPath),
+		fmt.Sprintf("SEGMENT_LOCATION=%s", event.Location),
+		fmt.Sprintf("SEGMENT_START=%d", event.StartTime),
+		fmt.Sprintf("SEGMENT_DURATION=%d", event.Duration),
+	)
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %v", h.conf.Timeout)
+		}
+		if len(out) > maxHookOutput {
+			out = out[len(out)-maxHookOutput:]
+		}
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	h.logger.Debugw("segment hook finished", "path", event.LocalPath, "sequence", event.Sequence, "took", time.Since(start))
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build integration
+
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+func TestSegmentHooks(t *testing.T) {
+	dir := t.TempDir()
+	out := path.Join(dir, "hooks.log")
+
+	hooks := newSegmentHooks(config.SegmentHooksConfig{
+		Command: []string{"sh", "-c", `echo "$SEGMENT_SEQUENCE $1" >> ` + out + `; [ "$SEGMENT_SEQUENCE" != 1 ]`, "hook"},
+		Publish: true,
+		Timeout: time.Second * 5,
+	}, logger.GetDefaultLogger())
+
+	var published []int
+	hooks.publish = func(_ context.Context, event *SegmentEvent) error {
+		published = append(published, event.Sequence)
+		return nil
+	}
+	hooks.start()
+
+	removed := path.Join(dir, "chunk_2.mp4")
+	require.NoError(t, os.WriteFile(removed, nil, 0644))
+	hooks.enqueue(&SegmentEvent{LocalPath: "segment_0.ts"}, false)
+	hooks.enqueue(&SegmentEvent{LocalPath: "segment_1.ts"}, false)
+	hooks.enqueue(&SegmentEvent{LocalPath: removed}, true)
+	hooks.close()
+
+	// hooks run in order, and a failed command doesn't stop the rest
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0 segment_0.ts", "1 segment_1.ts", "2 " + removed}, strings.Split(strings.TrimSpace(string(b)), "\n"))
+	require.Equal(t, []int{0, 1, 2}, published)
+	require.Equal(t, int32(1), hooks.failures.Load())
+
+	_, err = os.Stat(removed)
+	require.True(t, os.IsNotExist(err))
+}
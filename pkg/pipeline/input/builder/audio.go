@@ -10,14 +10,15 @@ import (
 
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/pipeline/params"
-	"github.com/livekit/protocol/logger"
 )
 
 type AudioInput struct {
-	decoder []*gst.Element
-	testSrc []*gst.Element
-	mixer   []*gst.Element
-	encoder *gst.Element
+	decoder    []*gst.Element
+	testSrc    []*gst.Element
+	mixer      []*gst.Element
+	processing []*gst.Element // filters and loudness normalization, between the mixer or decoder and the encoder
+	valve      *gst.Element
+	encoder    *gst.Element
 }
 
 func NewWebAudioInput(p *params.Params) (*AudioInput, error) {
@@ -39,10 +40,18 @@ func NewSDKAudioInput(p *params.Params, src *app.Source, codec webrtc.RTPCodecPa
 	if err := a.buildSDKDecoder(p, src, codec); err != nil {
 		return nil, err
 	}
+	if p.Passthrough {
+		return a, nil
+	}
 	if err := a.buildMixer(p); err != nil {
 		return nil, err
 	}
 	if p.OutputType == params.OutputTypeRaw {
+		if p.AudioFilter != nil {
+			if err := a.buildAudioFilter(p); err != nil {
+				return nil, err
+			}
+		}
 		return a, nil
 	}
 	if err := a.buildEncoder(p); err != nil {
@@ -68,6 +77,16 @@ func (a *AudioInput) AddToBin(bin *gst.Bin) error {
 			return err
 		}
 	}
+	if a.processing != nil {
+		if err := bin.AddMany(a.processing...); err != nil {
+			return err
+		}
+	}
+	if a.valve != nil {
+		if err := bin.Add(a.valve); err != nil {
+			return err
+		}
+	}
 	if a.encoder != nil {
 		if err := bin.Add(a.encoder); err != nil {
 			return err
@@ -99,25 +118,48 @@ func (a *AudioInput) Link() error {
 			return err
 		}
 	}
+
+	srcName, srcPad := "audio decoder", getSrcPad(a.decoder)
+	if a.mixer != nil {
+		srcName, srcPad = "audio mixer", getSrcPad(a.mixer)
+	}
+	if a.processing != nil {
+		if link := srcPad.Link(a.processing[0].GetStaticPad("sink")); link != gst.PadLinkOK {
+			return errors.ErrPadLinkFailed(srcName, "audio processing", link.String())
+		}
+		if err := gst.ElementLinkMany(a.processing...); err != nil {
+			return err
+		}
+		srcName, srcPad = "audio processing", getSrcPad(a.processing)
+	}
+
 	if a.encoder != nil {
-		if a.mixer != nil {
-			if link := getSrcPad(a.mixer).Link(a.encoder.GetStaticPad("sink")); link != gst.PadLinkOK {
-				return errors.ErrPadLinkFailed("audio mixer", "audio encoder", link.String())
-			}
-		} else {
-			if link := getSrcPad(a.decoder).Link(a.encoder.GetStaticPad("sink")); link != gst.PadLinkOK {
-				return errors.ErrPadLinkFailed("audio decoder", "audio encoder", link.String())
+		if a.valve != nil {
+			if link := srcPad.Link(a.valve.GetStaticPad("sink")); link != gst.PadLinkOK {
+				return errors.ErrPadLinkFailed(srcName, "audio valve", link.String())
 			}
+			srcName, srcPad = "audio valve", a.valve.GetStaticPad("src")
+		}
+
+		if link := srcPad.Link(a.encoder.GetStaticPad("sink")); link != gst.PadLinkOK {
+			return errors.ErrPadLinkFailed(srcName, "audio encoder", link.String())
 		}
 	}
 
 	return nil
 }
 
+func (a *AudioInput) GetValve() *gst.Element {
+	return a.valve
+}
+
 func (a *AudioInput) GetSrcPad() *gst.Pad {
 	if a.encoder != nil {
 		return a.encoder.GetStaticPad("src")
 	}
+	if a.processing != nil {
+		return getSrcPad(a.processing)
+	}
 	if a.mixer != nil {
 		return getSrcPad(a.mixer)
 	}
@@ -160,6 +202,11 @@ func (a *AudioInput) buildSDKDecoder(p *params.Params, src *app.Source, codec we
 			return err
 		}
 
+		if p.Passthrough {
+			a.decoder = append(a.decoder, rtpOpusDepay)
+			return nil
+		}
+
 		opusDec, err := gst.NewElement("opusdec")
 		if err != nil {
 			return err
@@ -167,6 +214,10 @@ func (a *AudioInput) buildSDKDecoder(p *params.Params, src *app.Source, codec we
 		if err = opusDec.SetProperty("use-inband-fec", true); err != nil {
 			return err
 		}
+		// conceal packets reported lost by the app writer
+		if err = opusDec.SetProperty("plc", true); err != nil {
+			return err
+		}
 
 		a.decoder = append(a.decoder, rtpOpusDepay, opusDec)
 
@@ -229,7 +280,7 @@ func (a *AudioInput) buildMixer(p *params.Params) error {
 	}
 	// set latency slightly higher than max audio appsrc latency
 	if err = audioMixer.SetProperty("latency", latency); err != nil {
-		logger.Errorw("latency", err)
+		p.Logger.Errorw("could not set audio mixer latency", err)
 		return err
 	}
 	mixedCaps, err := getCapsFilter(p)
@@ -242,6 +293,24 @@ func (a *AudioInput) buildMixer(p *params.Params) error {
 }
 
 func (a *AudioInput) buildEncoder(p *params.Params) error {
+	if p.AudioFilter != nil {
+		if err := a.buildAudioFilter(p); err != nil {
+			return err
+		}
+	}
+	if p.Loudness != nil {
+		if err := a.buildLoudness(p); err != nil {
+			return err
+		}
+	}
+	if p.EgressType == params.EgressTypeFile {
+		valve, err := buildValve()
+		if err != nil {
+			return err
+		}
+		a.valve = valve
+	}
+
 	switch p.AudioCodec {
 	case params.MimeTypeOpus:
 		encoder, err := gst.NewElement("opusenc")
@@ -256,7 +325,10 @@ func (a *AudioInput) buildEncoder(p *params.Params) error {
 	case params.MimeTypeAAC:
 		encoder, err := gst.NewElement("faac")
 		if err != nil {
-			return err
+			// faac is not always packaged
+			if encoder, err = gst.NewElement("voaacenc"); err != nil {
+				return err
+			}
 		}
 		if err = encoder.SetProperty("bitrate", int(p.AudioBitrate*1000)); err != nil {
 			return err
@@ -273,10 +345,17 @@ func (a *AudioInput) buildEncoder(p *params.Params) error {
 func getCapsFilter(p *params.Params) (*gst.Element, error) {
 	var caps *gst.Caps
 	switch p.AudioCodec {
-	case params.MimeTypeOpus, params.MimeTypeRaw:
+	case params.MimeTypeOpus:
 		caps = gst.NewCapsFromString(
 			"audio/x-raw,format=S16LE,layout=interleaved,rate=48000,channels=2",
 		)
+	case params.MimeTypeRaw:
+		// decoded and resampled to the format expected by the websocket consumer
+		caps = gst.NewCapsFromString(
+			fmt.Sprintf("audio/x-raw,format=S16LE,layout=interleaved,rate=%d,channels=%d",
+				p.WebsocketConf.SampleRate, p.WebsocketConf.Channels,
+			),
+		)
 	case params.MimeTypeAAC:
 		caps = gst.NewCapsFromString(
 			fmt.Sprintf("audio/x-raw,format=S16LE,layout=interleaved,rate=%d,channels=2", p.AudioFrequency),
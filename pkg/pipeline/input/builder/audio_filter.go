@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// noise suppression runs at 48kHz, one of the rates supported by webrtcdsp
+const noiseSuppressionCaps = "audio/x-raw,format=S16LE,layout=interleaved,rate=48000"
+
+// buildAudioFilter cleans up decoded or mixed audio with a high pass filter and webrtc noise suppression. Audio leaves
+// in the format it came in, so the rest of the pipeline, and its sample rate, is unchanged
+func (a *AudioInput) buildAudioFilter(p *params.Params) error {
+	var elements []*gst.Element
+
+	if p.AudioFilter.HighpassCutoff > 0 {
+		audioConvert, err := gst.NewElement("audioconvert")
+		if err != nil {
+			return err
+		}
+
+		highpass, err := gst.NewElement("audiocheblimit")
+		if err != nil {
+			return err
+		}
+		highpass.SetArg("mode", "high-pass")
+		if err = highpass.SetProperty("cutoff", float32(p.AudioFilter.HighpassCutoff)); err != nil {
+			return err
+		}
+		if err = highpass.SetProperty("poles", 4); err != nil {
+			return err
+		}
+
+		elements = append(elements, audioConvert, highpass)
+	}
+
+	if p.AudioFilter.NoiseSuppression {
+		audioConvert, err := gst.NewElement("audioconvert")
+		if err != nil {
+			return err
+		}
+
+		audioResample, err := gst.NewElement("audioresample")
+		if err != nil {
+			return err
+		}
+
+		caps, err := gst.NewElement("capsfilter")
+		if err != nil {
+			return err
+		}
+		if err = caps.SetProperty("caps", gst.NewCapsFromString(noiseSuppressionCaps)); err != nil {
+			return err
+		}
+
+		// without echo cancellation, webrtcdsp doesn't need a webrtcechoprobe
+		dsp, err := gst.NewElement("webrtcdsp")
+		if err != nil {
+			return err
+		}
+		for property, value := range map[string]bool{
+			"echo-cancel":       false,
+			"gain-control":      false,
+			"high-pass-filter":  false,
+			"noise-suppression": true,
+		} {
+			if err = dsp.SetProperty(property, value); err != nil {
+				return err
+			}
+		}
+
+		elements = append(elements, audioConvert, audioResample, caps, dsp)
+	}
+
+	if len(elements) == 0 {
+		return nil
+	}
+
+	audioConvert, err := gst.NewElement("audioconvert")
+	if err != nil {
+		return err
+	}
+
+	audioResample, err := gst.NewElement("audioresample")
+	if err != nil {
+		return err
+	}
+
+	outCaps, err := getCapsFilter(p)
+	if err != nil {
+		return err
+	}
+
+	a.processing = append(a.processing, elements...)
+	a.processing = append(a.processing, audioConvert, audioResample, outCaps)
+	return nil
+}
@@ -0,0 +1,88 @@
+//go:build integration
+
+package builder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+func TestAudioFilterDuration(t *testing.T) {
+	gst.Init(nil)
+
+	for _, audioParams := range []params.AudioParams{
+		{AudioCodec: params.MimeTypeOpus},
+		{AudioCodec: params.MimeTypeAAC, AudioFrequency: 44100},
+	} {
+		rate := 48000
+		if audioParams.AudioCodec == params.MimeTypeAAC {
+			rate = int(audioParams.AudioFrequency)
+		}
+
+		unfiltered := &params.Params{AudioParams: audioParams}
+		frames, end := runAudioFilter(t, unfiltered)
+		require.InDelta(t, float64(end), float64(time.Duration(frames)*time.Second/time.Duration(rate)), float64(time.Millisecond))
+
+		filtered := &params.Params{AudioParams: audioParams}
+		filtered.AudioFilter = &config.AudioFilterConfig{HighpassCutoff: 80, NoiseSuppression: true}
+		filteredFrames, filteredEnd := runAudioFilter(t, filtered)
+
+		// the sample rate is unchanged, and noise suppression may only hold back its last 10ms
+		require.InDelta(t, float64(filteredEnd), float64(time.Duration(filteredFrames)*time.Second/time.Duration(rate)), float64(time.Millisecond))
+		require.InDelta(t, float64(end), float64(filteredEnd), float64(time.Millisecond*20), audioParams.AudioCodec)
+	}
+}
+
+// runAudioFilter filters 480,000 frames of noise, returning the frames written and the end time of the last buffer
+func runAudioFilter(t *testing.T, p *params.Params) (int64, time.Duration) {
+	pipeline, err := gst.NewPipeline("")
+	require.NoError(t, err)
+
+	src, err := gst.NewElement("audiotestsrc")
+	require.NoError(t, err)
+	src.SetArg("wave", "pink-noise")
+	require.NoError(t, src.SetProperty("samplesperbuffer", 480))
+	require.NoError(t, src.SetProperty("num-buffers", 1000))
+
+	caps, err := getCapsFilter(p)
+	require.NoError(t, err)
+
+	a := &AudioInput{}
+	if p.AudioFilter != nil {
+		require.NoError(t, a.buildAudioFilter(p))
+	}
+
+	sink, err := gst.NewElement("fakesink")
+	require.NoError(t, err)
+	require.NoError(t, sink.SetProperty("sync", false))
+
+	var frames int64
+	var end time.Duration
+	sink.GetStaticPad("sink").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		buffer := info.GetBuffer()
+		frames += buffer.GetSize() / 4 // S16LE stereo
+		end = buffer.PresentationTimestamp() + buffer.Duration()
+		return gst.PadProbeOK
+	})
+
+	elements := append([]*gst.Element{src, caps}, a.processing...)
+	elements = append(elements, sink)
+	require.NoError(t, pipeline.AddMany(elements...))
+	require.NoError(t, gst.ElementLinkMany(elements...))
+
+	require.NoError(t, pipeline.SetState(gst.StatePlaying))
+	msg := pipeline.GetPipelineBus().TimedPopFiltered(time.Second*30, gst.MessageEOS|gst.MessageError)
+	require.NotNil(t, msg)
+	if msg.Type() == gst.MessageError {
+		t.Fatal(msg.ParseError())
+	}
+	require.NoError(t, pipeline.SetState(gst.StateNull))
+
+	return frames, end
+}
@@ -3,11 +3,13 @@ package builder
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pion/webrtc/v3"
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
+	"go.uber.org/atomic"
 
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/pipeline/params"
@@ -26,7 +28,11 @@ type InputBin struct {
 	videoPad *gst.Pad
 
 	multiQueue *gst.Element
+	tags       *gst.Element
 	mux        *gst.Element
+
+	bytesOut atomic.Int64 // encoded bytes passed to the muxer, or to the output when there is none
+	drift    *driftCorrector
 }
 
 func NewWebInput(ctx context.Context, p *params.Params) (*InputBin, error) {
@@ -122,10 +128,23 @@ func (b *InputBin) build(ctx context.Context, p *params.Params) error {
 		if err = b.bin.Add(b.mux); err != nil {
 			return err
 		}
+
+		if b.audio != nil && b.video != nil {
+			b.drift = newDriftCorrector(p.AVSync, p.Logger)
+		}
+
+		if p.EgressType == params.EgressTypeFile {
+			if b.tags, err = buildTagInject(p); err != nil {
+				return err
+			}
+			if err = b.bin.Add(b.tags); err != nil {
+				return err
+			}
+		}
 	}
 
-	// HLS has no output bin
-	if p.OutputType == params.OutputTypeHLS {
+	// HLS and split files have no output bin
+	if p.OutputType == params.OutputTypeHLS || p.SplitFile() {
 		return nil
 	}
 
@@ -157,6 +176,7 @@ func (b *InputBin) Element() *gst.Element {
 
 func (b *InputBin) Link() error {
 	mqPad := 0
+	var audioOut, videoOut *gst.Pad
 
 	// link audio elements
 	if b.audio != nil {
@@ -169,7 +189,14 @@ func (b *InputBin) Link() error {
 			queuePad = b.multiQueue.GetRequestPad("sink_%u")
 		}
 
-		if linkReturn := b.audio.GetSrcPad().Link(queuePad); linkReturn != gst.PadLinkOK {
+		srcPad := b.audio.GetSrcPad()
+		if b.tags != nil {
+			if linkReturn := srcPad.Link(b.tags.GetStaticPad("sink")); linkReturn != gst.PadLinkOK {
+				return errors.ErrPadLinkFailed("audio", "taginject", linkReturn.String())
+			}
+			srcPad = b.tags.GetStaticPad("src")
+		}
+		if linkReturn := srcPad.Link(queuePad); linkReturn != gst.PadLinkOK {
 			return errors.ErrPadLinkFailed("audio", "multiQueue", linkReturn.String())
 		}
 
@@ -188,6 +215,8 @@ func (b *InputBin) Link() error {
 			}
 		}
 
+		audioOut = b.multiQueue.GetStaticPad(fmt.Sprintf("src_%d", mqPad))
+		b.countBytes(audioOut)
 		mqPad++
 	}
 
@@ -202,7 +231,15 @@ func (b *InputBin) Link() error {
 			queuePad = b.multiQueue.GetRequestPad("sink_%u")
 		}
 
-		if linkReturn := b.video.GetSrcPad().Link(queuePad); linkReturn != gst.PadLinkOK {
+		srcPad := b.video.GetSrcPad()
+		if b.tags != nil && b.audio == nil {
+			// tags are only needed on one of the mux inputs
+			if linkReturn := srcPad.Link(b.tags.GetStaticPad("sink")); linkReturn != gst.PadLinkOK {
+				return errors.ErrPadLinkFailed("video", "taginject", linkReturn.String())
+			}
+			srcPad = b.tags.GetStaticPad("src")
+		}
+		if linkReturn := srcPad.Link(queuePad); linkReturn != gst.PadLinkOK {
 			return errors.ErrPadLinkFailed("video", "multiQueue", linkReturn.String())
 		}
 
@@ -220,11 +257,159 @@ func (b *InputBin) Link() error {
 				return errors.ErrPadLinkFailed("video", "mux", linkReturn.String())
 			}
 		}
+
+		videoOut = b.multiQueue.GetStaticPad(fmt.Sprintf("src_%d", mqPad))
+		b.countBytes(videoOut)
+	}
+
+	if b.drift != nil && audioOut != nil && videoOut != nil {
+		b.drift.attach(audioOut, videoOut)
+	}
+
+	return nil
+}
+
+// countBytes adds the size of each buffer leaving the multiqueue to bytesOut
+func (b *InputBin) countBytes(pad *gst.Pad) {
+	if pad == nil {
+		return
+	}
+	pad.AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil {
+			b.bytesOut.Add(buffer.GetSize())
+		}
+		return gst.PadProbeOK
+	})
+}
+
+// BytesOut returns the number of encoded bytes written so far
+func (b *InputBin) BytesOut() int64 {
+	return b.bytesOut.Load()
+}
+
+// Pause drops buffers before they reach the encoders
+func (b *InputBin) Pause() error {
+	valves := b.getValves()
+	if len(valves) == 0 {
+		return errors.ErrNotSupported("pausing this output")
+	}
+
+	for _, valve := range valves {
+		if err := valve.SetProperty("drop", true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume lets buffers through again, shifting timestamps back by the paused duration so the output has no gap
+func (b *InputBin) Resume(pausedFor time.Duration) error {
+	valves := b.getValves()
+	if len(valves) == 0 {
+		return errors.ErrNotSupported("pausing this output")
 	}
 
+	for _, valve := range valves {
+		pad := valve.GetStaticPad("src")
+		pad.SetOffset(pad.GetOffset() - int64(pausedFor))
+		if err := valve.SetProperty("drop", false); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// SendMuxEOS sends EOS straight to the muxer, so that it can finalize its output when EOS is stuck upstream.
+// It returns false if there is no muxer
+func (b *InputBin) SendMuxEOS() bool {
+	if b.mux == nil {
+		return false
+	}
+
+	pads, err := b.mux.GetSinkPads()
+	if err != nil || len(pads) == 0 {
+		return false
+	}
+	for _, pad := range pads {
+		pad.SendEvent(gst.NewEOSEvent())
+	}
+	return true
+}
+
+// FrameStats returns the number of video frames dropped so far, and the number waiting to be encoded
+func (b *InputBin) FrameStats() (dropped int64, queued int) {
+	if b.video == nil {
+		return 0, 0
+	}
+	return b.video.FrameStats()
+}
+
+// DriftStats returns the largest a/v drift measured at the muxer, and the correction applied to audio
+func (b *InputBin) DriftStats() (maxDrift, correction time.Duration) {
+	return b.drift.stats()
+}
+
+// OnVideoDimensions calls f whenever the dimensions of the subscribed video track change
+func (b *InputBin) OnVideoDimensions(f func(width, height int)) {
+	if b.video != nil {
+		b.video.OnSourceDimensions(f)
+	}
+}
+
+// OnThumbnail calls f with each I420 thumbnail frame, when thumbnails are enabled
+func (b *InputBin) OnThumbnail(f func(frame []byte)) {
+	if b.video != nil {
+		b.video.OnThumbnail(f)
+	}
+}
+
+func (b *InputBin) getValves() []*gst.Element {
+	var valves []*gst.Element
+	if b.audio != nil && b.audio.GetValve() != nil {
+		valves = append(valves, b.audio.GetValve())
+	}
+	if b.video != nil && b.video.GetValve() != nil {
+		valves = append(valves, b.video.GetValve())
+	}
+	return valves
+}
+
+// buildTagInject identifies the recording in the container metadata, for muxers which write tags
+func buildTagInject(p *params.Params) (*gst.Element, error) {
+	tagInject, err := gst.NewElement("taginject")
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{
+		fmt.Sprintf("comment=%s", quoteTag(p.Info.EgressId)),
+		fmt.Sprintf("datetime=(datetime)%s", time.Now().UTC().Format(time.RFC3339)),
+	}
+	if p.Info.RoomName != "" {
+		tags = append(tags, fmt.Sprintf("title=%s", quoteTag(p.Info.RoomName)))
+	}
+	if err = tagInject.SetProperty("tags", strings.Join(tags, ",")); err != nil {
+		return nil, err
+	}
+
+	return tagInject, nil
+}
+
+func quoteTag(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func buildValve() (*gst.Element, error) {
+	valve, err := gst.NewElement("valve")
+	if err != nil {
+		return nil, err
+	}
+	if err = valve.SetProperty("drop", false); err != nil {
+		return nil, err
+	}
+	return valve, nil
+}
+
 func buildQueue() (*gst.Element, error) {
 	queue, err := gst.NewElement("queue")
 	if err != nil {
@@ -243,6 +428,10 @@ func buildQueue() (*gst.Element, error) {
 }
 
 func buildMux(p *params.Params) (*gst.Element, error) {
+	if p.SplitFile() {
+		return buildSplitMux(p)
+	}
+
 	switch p.OutputType {
 	case params.OutputTypeRaw:
 		return nil, nil
@@ -254,13 +443,44 @@ func buildMux(p *params.Params) (*gst.Element, error) {
 		return gst.NewElement("avmux_ivf")
 
 	case params.OutputTypeMP4:
-		return gst.NewElement("mp4mux")
+		mux, err := gst.NewElement("mp4mux")
+		if err != nil {
+			return nil, err
+		}
+		if p.EgressType == params.EgressTypeFile && p.Faststart {
+			// media is kept in faststart-file until EOS, when the moov is written first and the media copied after it.
+			// The file is complete once the pipeline has finished, so it is uploaded as usual
+			if err = mux.SetProperty("faststart", true); err != nil {
+				return nil, err
+			}
+			if p.LocalFilepath != "" {
+				// on the same disk as the output, which was checked for space when the request was accepted
+				if err = mux.SetProperty("faststart-file", p.LocalFilepath+".faststart"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return mux, nil
 
 	case params.OutputTypeTS:
 		return gst.NewElement("mpegtsmux")
 
-	case params.OutputTypeWebM:
-		return gst.NewElement("webmmux")
+	case params.OutputTypeWebM, params.OutputTypeMKV:
+		factory := "matroskamux"
+		if p.OutputType == params.OutputTypeWebM {
+			factory = "webmmux"
+		}
+		mux, err := gst.NewElement(factory)
+		if err != nil {
+			return nil, err
+		}
+		if p.ProgressiveUpload {
+			// without seeking back to write the duration and cues, so that uploaded parts stay valid
+			if err = mux.SetProperty("streamable", true); err != nil {
+				return nil, err
+			}
+		}
+		return mux, nil
 
 	case params.OutputTypeRTMP:
 		mux, err := gst.NewElement("flvmux")
@@ -272,12 +492,23 @@ func buildMux(p *params.Params) (*gst.Element, error) {
 		}
 		return mux, nil
 
+	case params.OutputTypeSRT:
+		mux, err := gst.NewElement("mpegtsmux")
+		if err != nil {
+			return nil, err
+		}
+		// 7 ts packets fill a udp datagram
+		if err = mux.SetProperty("alignment", 7); err != nil {
+			return nil, err
+		}
+		return mux, nil
+
 	case params.OutputTypeHLS:
 		mux, err := gst.NewElement("splitmuxsink")
 		if err != nil {
 			return nil, err
 		}
-		if err = mux.SetProperty("max-size-time", uint64(time.Duration(p.SegmentDuration)*time.Second)); err != nil {
+		if err = mux.SetProperty("max-size-time", uint64(p.GetSplitDuration())); err != nil {
 			return nil, err
 		}
 		if err = mux.SetProperty("async-finalize", true); err != nil {
@@ -286,7 +517,11 @@ func buildMux(p *params.Params) (*gst.Element, error) {
 		if err = mux.SetProperty("muxer-factory", "mpegtsmux"); err != nil {
 			return nil, err
 		}
-		if err = mux.SetProperty("location", fmt.Sprintf("%s_%%05d.ts", p.LocalFilePrefix)); err != nil {
+		if err = mux.SetProperty("location", p.GetMuxLocation()); err != nil {
+			return nil, err
+		}
+		// a restarted pipeline numbers its segments after the last one's
+		if err = mux.SetProperty("start-index", p.SegmentIndex); err != nil {
 			return nil, err
 		}
 		return mux, nil
@@ -296,6 +531,48 @@ func buildMux(p *params.Params) (*gst.Element, error) {
 	}
 }
 
+var splitMuxers = map[params.OutputType]string{
+	params.OutputTypeMP4:  "mp4mux",
+	params.OutputTypeWebM: "webmmux",
+	params.OutputTypeMKV:  "matroskamux",
+	params.OutputTypeOGG:  "oggmux",
+}
+
+// buildSplitMux writes numbered files, starting a new one on the first key frame after a limit is reached
+func buildSplitMux(p *params.Params) (*gst.Element, error) {
+	mux, err := gst.NewElement("splitmuxsink")
+	if err != nil {
+		return nil, err
+	}
+	if p.SplitDuration > 0 {
+		if err = mux.SetProperty("max-size-time", uint64(p.SplitDuration)); err != nil {
+			return nil, err
+		}
+		// ask the encoder for a key frame at the limit, rather than waiting for the next one
+		if err = mux.SetProperty("send-keyframe-requests", true); err != nil {
+			return nil, err
+		}
+	}
+	if p.SplitSize > 0 {
+		if err = mux.SetProperty("max-size-bytes", p.SplitSize); err != nil {
+			return nil, err
+		}
+	}
+	if err = mux.SetProperty("async-finalize", true); err != nil {
+		return nil, err
+	}
+	if err = mux.SetProperty("muxer-factory", splitMuxers[p.OutputType]); err != nil {
+		return nil, err
+	}
+	if err = mux.SetProperty("location", p.GetChunkLocation()); err != nil {
+		return nil, err
+	}
+	if err = mux.SetProperty("start-index", p.SegmentIndex); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
 func getSrcPad(elements []*gst.Element) *gst.Pad {
 	return elements[len(elements)-1].GetStaticPad("src")
 }
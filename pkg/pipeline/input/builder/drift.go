@@ -0,0 +1,158 @@
+package builder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	driftSmoothing     = 20 // buffers of each stream arrive in bursts, so drift is averaged over this many samples
+	driftCheckInterval = time.Second
+)
+
+// driftCorrector tracks how far audio is ahead of video at the muxer, from the timestamps of the latest buffer of each.
+// Corrections are applied to audio as a pad offset, and intervals are measured in video time
+type driftCorrector struct {
+	conf   config.AVSyncConfig
+	logger logger.Logger
+
+	mu          sync.Mutex
+	audio       time.Duration
+	video       time.Duration
+	hasAudio    bool
+	hasVideo    bool
+	drift       time.Duration // smoothed, including the correction
+	maxDrift    time.Duration
+	correction  time.Duration // applied to audio so far
+	correcting  bool
+	checkedAt   time.Duration
+	loggedDrift bool
+}
+
+func newDriftCorrector(conf config.AVSyncConfig, l logger.Logger) *driftCorrector {
+	return &driftCorrector{
+		conf:   conf,
+		logger: l,
+	}
+}
+
+// attach measures the buffers leaving the audio and video pads, shifting audio when it needs correcting
+func (d *driftCorrector) attach(audioPad, videoPad *gst.Pad) {
+	audioPad.AddProbe(gst.PadProbeTypeBuffer, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil && buffer.PresentationTimestamp() >= 0 {
+			if shift := d.observeAudio(buffer.PresentationTimestamp()); shift != 0 {
+				pad.SetOffset(pad.GetOffset() + int64(shift))
+			}
+		}
+		return gst.PadProbeOK
+	})
+	videoPad.AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil && buffer.PresentationTimestamp() >= 0 {
+			d.observeVideo(buffer.PresentationTimestamp())
+		}
+		return gst.PadProbeOK
+	})
+}
+
+// observeAudio records an audio timestamp, and returns the change to make to the audio offset
+func (d *driftCorrector) observeAudio(pts time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.audio, d.hasAudio = pts, true
+	if !d.measure() {
+		return 0
+	}
+	// corrections are only made here, where they can be applied to the audio pad
+	return d.check()
+}
+
+func (d *driftCorrector) observeVideo(pts time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.video, d.hasVideo = pts, true
+	d.measure()
+}
+
+// measure updates the smoothed drift, returning false until both streams have started
+func (d *driftCorrector) measure() bool {
+	if !d.hasAudio || !d.hasVideo {
+		return false
+	}
+
+	sample := d.audio + d.correction - d.video
+	d.drift += (sample - d.drift) / driftSmoothing
+	if abs := absDuration(d.drift); abs > d.maxDrift {
+		d.maxDrift = abs
+	}
+	return true
+}
+
+// check logs the drift and returns the next correction, once each interval
+func (d *driftCorrector) check() time.Duration {
+	if d.video-d.checkedAt < driftCheckInterval {
+		return 0
+	}
+	d.checkedAt = d.video
+
+	abs := absDuration(d.drift)
+	if abs > d.conf.LogThreshold {
+		if !d.loggedDrift {
+			d.logger.Warnw("a/v drift", nil, "drift", d.drift, "correction", d.correction)
+			d.loggedDrift = true
+		}
+	} else if d.loggedDrift {
+		d.logger.Infow("a/v drift recovered", "drift", d.drift, "correction", d.correction)
+		d.loggedDrift = false
+	}
+
+	if d.conf.MaxDrift == 0 {
+		return 0
+	}
+	switch {
+	case abs > d.conf.MaxDrift:
+		d.correcting = true
+	case abs <= d.conf.MaxDrift/2:
+		d.correcting = false
+	}
+	if !d.correcting {
+		return 0
+	}
+
+	step := abs
+	if step > d.conf.MaxStep {
+		step = d.conf.MaxStep
+	}
+	if d.drift > 0 {
+		// audio is ahead
+		step = -step
+	}
+	d.correction += step
+	d.drift += step
+	return step
+}
+
+// stats returns the largest drift measured, and the correction applied to audio
+func (d *driftCorrector) stats() (maxDrift, correction time.Duration) {
+	if d == nil {
+		return 0, 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.maxDrift, d.correction
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
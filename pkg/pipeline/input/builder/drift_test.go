@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+func TestDriftCorrection(t *testing.T) {
+	// audio timestamps run 0.1% fast, putting audio 600ms ahead after 10 minutes
+	measured := simulateDrift(config.AVSyncConfig{LogThreshold: time.Millisecond * 100}, 0.001, time.Minute*10)
+	maxDrift, correction := measured.stats()
+	require.InDelta(t, float64(time.Millisecond*600), float64(measured.drift), float64(time.Millisecond*30))
+	require.InDelta(t, float64(time.Millisecond*600), float64(maxDrift), float64(time.Millisecond*30))
+	require.Zero(t, correction)
+
+	corrected := simulateDrift(config.AVSyncConfig{
+		LogThreshold: time.Millisecond * 100,
+		MaxDrift:     time.Millisecond * 100,
+		MaxStep:      time.Millisecond * 10,
+	}, 0.001, time.Minute*10)
+	maxDrift, correction = corrected.stats()
+	require.Less(t, absDuration(corrected.drift), time.Millisecond*100)
+	require.LessOrEqual(t, maxDrift, time.Millisecond*110)
+	require.InDelta(t, float64(-time.Millisecond*600), float64(correction), float64(time.Millisecond*60))
+
+	// corrections are bounded, so a sudden jump is worked off a step at a time
+	jumped := newDriftCorrector(config.AVSyncConfig{MaxDrift: time.Millisecond * 100, MaxStep: time.Millisecond * 10}, logger.GetDefaultLogger())
+	var largest time.Duration
+	for ts := time.Duration(0); ts < time.Second*10; ts += time.Millisecond * 20 {
+		jumped.observeVideo(ts)
+		if shift := jumped.observeAudio(ts + time.Millisecond*400); absDuration(shift) > largest {
+			largest = absDuration(shift)
+		}
+	}
+	require.Equal(t, time.Millisecond*10, largest)
+}
+
+// simulateDrift feeds a corrector buffer timestamps in the order they would reach the muxer,
+// with 20ms audio buffers whose timestamps are skewed, and 30fps video
+func simulateDrift(conf config.AVSyncConfig, skew float64, duration time.Duration) *driftCorrector {
+	d := newDriftCorrector(conf, logger.GetDefaultLogger())
+
+	audioInterval := time.Millisecond * 20
+	videoInterval := time.Second / 30
+	var audioAt, videoAt time.Duration
+	for videoAt < duration {
+		if audioAt <= videoAt {
+			d.observeAudio(audioAt + time.Duration(float64(audioAt)*skew))
+			audioAt += audioInterval
+		} else {
+			d.observeVideo(videoAt)
+			videoAt += videoInterval
+		}
+	}
+	return d
+}
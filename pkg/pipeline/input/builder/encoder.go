@@ -0,0 +1,183 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/logger"
+)
+
+var hardwareEncoders = map[string]string{
+	config.EncoderVAAPI: "vaapih264enc",
+	config.EncoderNVENC: "nvh264enc",
+}
+
+// ResolveEncoder checks that the configured h264 encoder can be created. Auto resolves to the first
+// hardware encoder available, or to software if there are none.
+func ResolveEncoder(encoder string) (string, error) {
+	gst.Init(nil)
+
+	switch encoder {
+	case config.EncoderVAAPI, config.EncoderNVENC:
+		if !canCreate(hardwareEncoders[encoder]) {
+			return "", errors.ErrNotSupported(fmt.Sprintf("%s encoding on this node", encoder))
+		}
+		return encoder, nil
+
+	case config.EncoderAuto:
+		for _, e := range []string{config.EncoderNVENC, config.EncoderVAAPI} {
+			if canCreate(hardwareEncoders[e]) {
+				logger.Infow("using hardware encoder", "encoder", e)
+				return e, nil
+			}
+		}
+		logger.Infow("no hardware encoder available, using software")
+		return config.EncoderSoftware, nil
+
+	default:
+		return config.EncoderSoftware, nil
+	}
+}
+
+func canCreate(name string) bool {
+	_, err := gst.NewElement(name)
+	return err == nil
+}
+
+func buildH264Encoder(p *params.Params) (*gst.Element, error) {
+	switch p.VideoEncoder {
+	case config.EncoderVAAPI:
+		return buildVAAPIEncoder(p)
+	case config.EncoderNVENC:
+		return buildNVENCEncoder(p)
+	default:
+		return buildX264Encoder(p)
+	}
+}
+
+func buildX264Encoder(p *params.Params) (*gst.Element, error) {
+	x264Enc, err := gst.NewElement("x264enc")
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.RateControl {
+	case params.RateControlCQP:
+		x264Enc.SetArg("pass", "quant")
+
+	case params.RateControlVBR:
+		// constant quality, with the bitrate as a maximum
+		x264Enc.SetArg("pass", "qual")
+		if err = x264Enc.SetProperty("bitrate", uint(p.VideoBitrate)); err != nil {
+			return nil, err
+		}
+
+	default:
+		if err = x264Enc.SetProperty("bitrate", uint(p.VideoBitrate)); err != nil {
+			return nil, err
+		}
+	}
+	if p.Quantizer > 0 && p.RateControl != params.RateControlCBR {
+		if err = x264Enc.SetProperty("quantizer", p.Quantizer); err != nil {
+			return nil, err
+		}
+	}
+
+	x264Enc.SetArg("speed-preset", "veryfast")
+	if dist := keyFrameDist(p); dist > 0 {
+		if err = x264Enc.SetProperty("key-int-max", dist); err != nil {
+			return nil, err
+		}
+		// Avoid key frames other than at fixed intervals, as splitmuxsink can become inconsistent otherwise
+		if err = x264Enc.SetProperty("option-string", "scenecut=0"); err != nil {
+			return nil, err
+		}
+	}
+
+	return x264Enc, nil
+}
+
+func buildVAAPIEncoder(p *params.Params) (*gst.Element, error) {
+	vaapiEnc, err := gst.NewElement("vaapih264enc")
+	if err != nil {
+		return nil, err
+	}
+
+	vaapiEnc.SetArg("rate-control", string(p.RateControl))
+	if p.RateControl == params.RateControlCQP {
+		if p.Quantizer > 0 {
+			if err = vaapiEnc.SetProperty("init-qp", p.Quantizer); err != nil {
+				return nil, err
+			}
+		}
+	} else if err = vaapiEnc.SetProperty("bitrate", uint(p.VideoBitrate)); err != nil {
+		return nil, err
+	}
+
+	if dist := keyFrameDist(p); dist > 0 {
+		if err = vaapiEnc.SetProperty("keyframe-period", dist); err != nil {
+			return nil, err
+		}
+	}
+
+	return vaapiEnc, nil
+}
+
+func buildNVENCEncoder(p *params.Params) (*gst.Element, error) {
+	nvEnc, err := gst.NewElement("nvh264enc")
+	if err != nil {
+		return nil, err
+	}
+
+	nvEnc.SetArg("preset", "low-latency-hq")
+	switch p.RateControl {
+	case params.RateControlCQP:
+		nvEnc.SetArg("rc-mode", "constqp")
+		if p.Quantizer > 0 {
+			if err = nvEnc.SetProperty("qp-const", int(p.Quantizer)); err != nil {
+				return nil, err
+			}
+		}
+	case params.RateControlVBR:
+		nvEnc.SetArg("rc-mode", "vbr")
+	default:
+		nvEnc.SetArg("rc-mode", "cbr")
+	}
+	if p.RateControl != params.RateControlCQP {
+		if err = nvEnc.SetProperty("bitrate", uint(p.VideoBitrate)); err != nil {
+			return nil, err
+		}
+	}
+
+	if dist := keyFrameDist(p); dist > 0 {
+		if err = nvEnc.SetProperty("gop-size", int(dist)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nvEnc, nil
+}
+
+// keyFrameDist returns the maximum number of frames between key frames, or 0 for the encoder default
+func keyFrameDist(p *params.Params) uint {
+	if p.KeyFrameInterval > 0 {
+		return uint(p.Framerate.Frames(p.KeyFrameInterval))
+	}
+	if p.OutputType == params.OutputTypeHLS {
+		// key frames at segment boundaries, or part boundaries for low-latency HLS
+		return uint(p.Framerate.Frames(p.GetSplitDuration().Seconds()))
+	}
+	return 0
+}
+
+// capsProfile returns the profile to request from the encoder. Hardware encoders only produce constrained baseline.
+func capsProfile(p *params.Params) params.Profile {
+	if _, ok := hardwareEncoders[p.VideoEncoder]; ok && p.VideoProfile == params.ProfileBaseline {
+		return "constrained-baseline"
+	}
+	return p.VideoProfile
+}
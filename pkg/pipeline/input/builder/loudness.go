@@ -0,0 +1,214 @@
+package builder
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+const (
+	loudnessBlock    = 0.1 // seconds of audio in each loudness measurement
+	loudnessWindow   = 30  // blocks in the short-term loudness window, as in EBU R128
+	loudnessGate     = -70 // LUFS, below which blocks are treated as silence, holding the gain
+	loudnessGainStep = 0.1 // dB the gain can change each block, so that it's not heard pumping
+)
+
+// buildLoudness normalizes mixed audio toward the target loudness. Short-term loudness is measured before the volume
+// element as in EBU R128, and the gain follows it slowly, so no audio is held back. rglimiter catches the peaks of
+// a gain which is still too high
+func (a *AudioInput) buildLoudness(p *params.Params) error {
+	audioConvert, err := gst.NewElement("audioconvert")
+	if err != nil {
+		return err
+	}
+
+	floatCaps, err := gst.NewElement("capsfilter")
+	if err != nil {
+		return err
+	}
+	if err = floatCaps.SetProperty("caps", gst.NewCapsFromString(
+		"audio/x-raw,format=F32LE,layout=interleaved",
+	)); err != nil {
+		return err
+	}
+
+	volume, err := gst.NewElement("volume")
+	if err != nil {
+		return err
+	}
+
+	limiter, err := gst.NewElement("rglimiter")
+	if err != nil {
+		return err
+	}
+
+	outConvert, err := gst.NewElement("audioconvert")
+	if err != nil {
+		return err
+	}
+
+	// back to the mixed audio format, so that the encoder is unchanged
+	outCaps, err := getCapsFilter(p)
+	if err != nil {
+		return err
+	}
+
+	// the rate of the mixed audio
+	rate := 48000
+	if p.AudioCodec == params.MimeTypeAAC {
+		rate = int(p.AudioFrequency)
+	}
+
+	normalizer := newLoudnessNormalizer(*p.Loudness, rate, 2)
+	volume.GetStaticPad("sink").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		buffer := info.GetBuffer()
+		if buffer == nil {
+			return gst.PadProbeOK
+		}
+
+		mapInfo := buffer.Map(gst.MapRead)
+		gain, changed := normalizer.process(mapInfo.Bytes())
+		buffer.Unmap()
+		if changed {
+			if err := volume.SetProperty("volume", math.Pow(10, gain/20)); err != nil {
+				p.Logger.Debugw("could not set volume", "error", err)
+			}
+		}
+		return gst.PadProbeOK
+	})
+
+	a.processing = append(a.processing, audioConvert, floatCaps, volume, limiter, outConvert, outCaps)
+	return nil
+}
+
+// loudnessNormalizer measures the short-term loudness of interleaved F32LE audio, and moves the gain toward the
+// difference from the target
+type loudnessNormalizer struct {
+	conf     config.LoudnessConfig
+	channels int
+
+	filters     [][2]biquad // k-weighting, for each channel
+	blockSize   int         // frames in each block
+	blockFrames int
+	blockSum    float64
+	blocks      []float64 // mean square of each block in the window
+	gain        float64   // dB
+}
+
+func newLoudnessNormalizer(conf config.LoudnessConfig, rate, channels int) *loudnessNormalizer {
+	n := &loudnessNormalizer{
+		conf:      conf,
+		channels:  channels,
+		blockSize: int(float64(rate) * loudnessBlock),
+	}
+	shelf, highPass := kWeighting(float64(rate))
+	for i := 0; i < channels; i++ {
+		n.filters = append(n.filters, [2]biquad{shelf, highPass})
+	}
+	return n
+}
+
+// process measures a buffer, and returns the gain to apply and whether it has changed
+func (n *loudnessNormalizer) process(data []byte) (float64, bool) {
+	gain := n.gain
+	frameSize := 4 * n.channels
+	for offset := 0; offset+frameSize <= len(data); offset += frameSize {
+		for c := 0; c < n.channels; c++ {
+			sample := float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset+4*c:])))
+			weighted := n.filters[c][1].process(n.filters[c][0].process(sample))
+			// left and right channels are weighted equally
+			n.blockSum += weighted * weighted
+		}
+
+		n.blockFrames++
+		if n.blockFrames == n.blockSize {
+			n.endBlock()
+		}
+	}
+	return n.gain, n.gain != gain
+}
+
+func (n *loudnessNormalizer) endBlock() {
+	power := n.blockSum / float64(n.blockFrames)
+	n.blockSum, n.blockFrames = 0, 0
+	if powerToLoudness(power) < loudnessGate {
+		return
+	}
+
+	n.blocks = append(n.blocks, power)
+	if len(n.blocks) > loudnessWindow {
+		n.blocks = n.blocks[1:]
+	}
+	loudness := n.loudness()
+
+	target := math.Max(-n.conf.MaxGain, math.Min(n.conf.MaxGain, n.conf.Target-loudness))
+	switch {
+	case target > n.gain+loudnessGainStep:
+		n.gain += loudnessGainStep
+	case target < n.gain-loudnessGainStep:
+		n.gain -= loudnessGainStep
+	default:
+		n.gain = target
+	}
+}
+
+// loudness returns the short-term loudness of the last blocks above the gate, in LUFS
+func (n *loudnessNormalizer) loudness() float64 {
+	if len(n.blocks) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, power := range n.blocks {
+		sum += power
+	}
+	return powerToLoudness(sum / float64(len(n.blocks)))
+}
+
+func powerToLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the ITU-R BS.1770 pre-filter and high pass at the sample rate
+func kWeighting(rate float64) (biquad, biquad) {
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return shelf, highPass
+}
@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+// stereoSine returns interleaved F32LE audio with a 1 kHz sine of the given amplitude on each channel
+func stereoSine(rate int, seconds float64, left, right float64, offset int) []byte {
+	frames := int(float64(rate) * seconds)
+	data := make([]byte, frames*8)
+	for i := 0; i < frames; i++ {
+		s := math.Sin(2 * math.Pi * 1000 * float64(offset+i) / float64(rate))
+		binary.LittleEndian.PutUint32(data[i*8:], math.Float32bits(float32(left*s)))
+		binary.LittleEndian.PutUint32(data[i*8+4:], math.Float32bits(float32(right*s)))
+	}
+	return data
+}
+
+func TestLoudnessMeasurement(t *testing.T) {
+	for _, rate := range []int{44100, 48000} {
+		n := newLoudnessNormalizer(config.LoudnessConfig{Target: -23, MaxGain: 20}, rate, 2)
+
+		// a full scale 1 kHz sine on one channel measures -3.01 LUFS, as in BS.1770
+		n.process(stereoSine(rate, 4, 1, 0, 0))
+		require.InDelta(t, -3.01, n.loudness(), 0.05, "rate %d", rate)
+	}
+}
+
+func TestLoudnessNormalization(t *testing.T) {
+	const rate = 48000
+	conf := config.LoudnessConfig{Target: -23, MaxGain: 20}
+
+	// one second at a time, with the gain applied as the volume element would
+	run := func(n *loudnessNormalizer, amplitude float64, seconds int) {
+		for i := 0; i < seconds; i++ {
+			n.process(stereoSine(rate, 1, amplitude, amplitude, i*rate))
+		}
+	}
+
+	// -38.06 LUFS, which is brought up to the target
+	n := newLoudnessNormalizer(conf, rate, 2)
+	run(n, 0.0125, 5)
+	require.Greater(t, n.gain, 0.0)
+	require.Less(t, n.gain, 5.0, "gain changes gradually")
+	run(n, 0.0125, 30)
+	require.InDelta(t, conf.Target-n.loudness(), n.gain, 0.01)
+	require.InDelta(t, 15.06, n.gain, 0.05)
+
+	// silence holds the gain
+	gain := n.gain
+	run(n, 0, 10)
+	require.Equal(t, gain, n.gain)
+
+	// very quiet audio is only raised by max_gain
+	n = newLoudnessNormalizer(conf, rate, 2)
+	run(n, 0.0005, 60)
+	require.Equal(t, conf.MaxGain, n.gain)
+
+	// loud audio is brought down
+	n = newLoudnessNormalizer(conf, rate, 2)
+	run(n, 0.25, 60)
+	require.InDelta(t, conf.Target-n.loudness(), n.gain, 0.01)
+	require.Less(t, n.gain, -5.0)
+
+	// and never by more than max_gain
+	n = newLoudnessNormalizer(conf, rate, 2)
+	run(n, 0.9, 60)
+	require.Equal(t, -conf.MaxGain, n.gain)
+}
@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// buildOverlays adds overlays after the video has been scaled to the output size, so that they are
+// positioned in output pixels
+func (v *VideoInput) buildOverlays(p *params.Params) error {
+	if p.Watermark != nil {
+		overlay, err := buildWatermark(p.Watermark)
+		if err != nil {
+			return err
+		}
+		v.elements = append(v.elements, overlay)
+	}
+
+	if p.ClockOverlay != nil {
+		overlay, err := buildClockOverlay(p.ClockOverlay)
+		if err != nil {
+			return err
+		}
+		v.elements = append(v.elements, overlay)
+	}
+
+	return nil
+}
+
+func buildWatermark(w *params.WatermarkParams) (*gst.Element, error) {
+	overlay, err := gst.NewElement("gdkpixbufoverlay")
+	if err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("location", w.Location); err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("offset-x", w.OffsetX); err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("offset-y", w.OffsetY); err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("alpha", w.Alpha); err != nil {
+		return nil, err
+	}
+	return overlay, nil
+}
+
+// buildClockOverlay renders the wall clock time, which is utc since handlers are launched with TZ=UTC.
+// clockoverlay blends onto I420 and BGRx directly, so no conversion is added for it.
+func buildClockOverlay(c *config.ClockOverlayConfig) (*gst.Element, error) {
+	overlay, err := gst.NewElement("clockoverlay")
+	if err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("time-format", c.Format); err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("font-desc", fmt.Sprintf("Sans %d", c.FontSize)); err != nil {
+		return nil, err
+	}
+	if err = overlay.SetProperty("shaded-background", true); err != nil {
+		return nil, err
+	}
+
+	switch c.Position {
+	case config.OverlayTopLeft, config.OverlayBottomLeft:
+		overlay.SetArg("halignment", "left")
+	default:
+		overlay.SetArg("halignment", "right")
+	}
+	switch c.Position {
+	case config.OverlayTopLeft, config.OverlayTopRight:
+		overlay.SetArg("valignment", "top")
+	default:
+		overlay.SetArg("valignment", "bottom")
+	}
+
+	return overlay, nil
+}
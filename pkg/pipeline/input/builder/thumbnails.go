@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/tinyzimmer/go-gst/gst"
+	"github.com/tinyzimmer/go-gst/gst/app"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// ThumbnailElementPrefix names the elements of the thumbnail branch, whose errors don't fail the egress
+const ThumbnailElementPrefix = "thumbnail_"
+
+// buildThumbnails tees raw video before the encoder into a branch which takes the first frame and one every
+// ThumbnailInterval after it, scaled down to I420 frames of the thumbnail size. The branch's queue is leaky, so it
+// never holds up the recording, and frames are encoded to jpeg outside of the pipeline, so that an encoding failure
+// can't stop the stream
+func (v *VideoInput) buildThumbnails(p *params.Params) error {
+	tee, err := gst.NewElement("tee")
+	if err != nil {
+		return err
+	}
+
+	queue, err := gst.NewElementWithName("queue", ThumbnailElementPrefix+"queue")
+	if err != nil {
+		return err
+	}
+	if err = queue.SetProperty("max-size-buffers", uint(1)); err != nil {
+		return err
+	}
+	if err = queue.SetProperty("max-size-bytes", uint(0)); err != nil {
+		return err
+	}
+	if err = queue.SetProperty("max-size-time", uint64(0)); err != nil {
+		return err
+	}
+	queue.SetArg("leaky", "downstream")
+
+	videoRate, err := gst.NewElementWithName("videorate", ThumbnailElementPrefix+"rate")
+	if err != nil {
+		return err
+	}
+	if err = videoRate.SetProperty("drop-only", true); err != nil {
+		return err
+	}
+
+	videoScale, err := gst.NewElementWithName("videoscale", ThumbnailElementPrefix+"scale")
+	if err != nil {
+		return err
+	}
+
+	videoConvert, err := gst.NewElementWithName("videoconvert", ThumbnailElementPrefix+"convert")
+	if err != nil {
+		return err
+	}
+
+	caps, err := gst.NewElementWithName("capsfilter", ThumbnailElementPrefix+"caps")
+	if err != nil {
+		return err
+	}
+	if err = caps.SetProperty("caps", gst.NewCapsFromString(fmt.Sprintf(
+		"video/x-raw,format=I420,framerate=1000/%d,width=%d,height=%d,pixel-aspect-ratio=1/1",
+		p.ThumbnailInterval.Milliseconds(), p.ThumbnailWidth, p.ThumbnailHeight,
+	))); err != nil {
+		return err
+	}
+
+	sinkElement, err := gst.NewElementWithName("appsink", ThumbnailElementPrefix+"sink")
+	if err != nil {
+		return err
+	}
+	sink := app.SinkFromElement(sinkElement)
+	sink.SetDrop(true)
+	sink.SetMaxBuffers(1)
+	if err = sink.SetProperty("sync", false); err != nil {
+		return err
+	}
+	sink.SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(appSink *app.Sink) gst.FlowReturn {
+			sample := appSink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+			buffer := sample.GetBuffer()
+			if buffer == nil || v.onThumbnail == nil {
+				return gst.FlowOK
+			}
+
+			// the mapped memory is only valid until the buffer is released
+			frame := append([]byte(nil), buffer.Map(gst.MapRead).Bytes()...)
+			buffer.Unmap()
+			v.onThumbnail(frame)
+			return gst.FlowOK
+		},
+	})
+
+	v.elements = append(v.elements, tee)
+	v.tee = tee
+	v.thumbnails = []*gst.Element{queue, videoRate, videoScale, videoConvert, caps, sinkElement}
+	return nil
+}
+
+// OnThumbnail calls f with each I420 thumbnail frame, from a streaming thread. f must not block
+func (v *VideoInput) OnThumbnail(f func(frame []byte)) {
+	v.onThumbnail = f
+}
@@ -14,6 +14,19 @@ import (
 
 type VideoInput struct {
 	elements []*gst.Element
+	valve    *gst.Element
+
+	// the depayloader, parser or decoder whose caps have the dimensions of the subscribed track
+	source *gst.Element
+
+	// raw video waits in queue while the encoder falls behind, and videorate drops frames to keep the framerate
+	queue *gst.Element
+	rate  *gst.Element
+
+	// thumbnails branch off before the encoder
+	tee         *gst.Element
+	thumbnails  []*gst.Element
+	onThumbnail func(frame []byte)
 }
 
 func NewWebVideoInput(p *params.Params) (*VideoInput, error) {
@@ -22,6 +35,9 @@ func NewWebVideoInput(p *params.Params) (*VideoInput, error) {
 	if err := v.buildWebDecoder(p); err != nil {
 		return nil, err
 	}
+	if err := v.buildOverlays(p); err != nil {
+		return nil, err
+	}
 	if err := v.buildEncoder(p); err != nil {
 		return nil, err
 	}
@@ -34,9 +50,12 @@ func NewSDKVideoInput(p *params.Params, src *app.Source, codec webrtc.RTPCodecPa
 	if err := v.buildSDKDecoder(p, src, codec); err != nil {
 		return nil, err
 	}
-	if p.OutputType == params.OutputTypeIVF || p.OutputType == params.OutputTypeWebM {
+	if skipTranscode(p, codec) {
 		return v, nil
 	}
+	if err := v.buildOverlays(p); err != nil {
+		return nil, err
+	}
 	if err := v.buildEncoder(p); err != nil {
 		return nil, err
 	}
@@ -45,17 +64,81 @@ func NewSDKVideoInput(p *params.Params, src *app.Source, codec webrtc.RTPCodecPa
 }
 
 func (v *VideoInput) AddToBin(bin *gst.Bin) error {
-	return bin.AddMany(v.elements...)
+	if err := bin.AddMany(v.elements...); err != nil {
+		return err
+	}
+	if len(v.thumbnails) > 0 {
+		return bin.AddMany(v.thumbnails...)
+	}
+	return nil
 }
 
 func (v *VideoInput) Link() error {
-	return gst.ElementLinkMany(v.elements...)
+	if err := gst.ElementLinkMany(v.elements...); err != nil {
+		return err
+	}
+	if v.tee != nil {
+		return gst.ElementLinkMany(append([]*gst.Element{v.tee}, v.thumbnails...)...)
+	}
+	return nil
 }
 
 func (v *VideoInput) GetSrcPad() *gst.Pad {
 	return getSrcPad(v.elements)
 }
 
+func (v *VideoInput) GetValve() *gst.Element {
+	return v.valve
+}
+
+// OnSourceDimensions calls f with the dimensions of the subscribed track each time its caps change,
+// such as when a different simulcast layer is forwarded
+func (v *VideoInput) OnSourceDimensions(f func(width, height int)) {
+	if v.source == nil {
+		return
+	}
+
+	v.source.GetStaticPad("src").AddProbe(gst.PadProbeTypeEventDownstream, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		event := info.GetEvent()
+		if event == nil || event.Type() != gst.EventTypeCaps {
+			return gst.PadProbeOK
+		}
+
+		s := event.ParseCaps().GetStructureAt(0)
+		if s == nil {
+			return gst.PadProbeOK
+		}
+		width, _ := s.GetValue("width")
+		height, _ := s.GetValue("height")
+		if w, ok := width.(int); ok {
+			if h, ok := height.(int); ok {
+				f(w, h)
+			}
+		}
+		return gst.PadProbeOK
+	})
+}
+
+// FrameStats returns the number of frames dropped so far, and the number of frames waiting to be encoded.
+// Both are zero for passthrough video, which isn't decoded
+func (v *VideoInput) FrameStats() (dropped int64, queued int) {
+	if v.rate != nil {
+		if value, err := v.rate.GetProperty("drop"); err == nil {
+			if d, ok := value.(uint64); ok {
+				dropped = int64(d)
+			}
+		}
+	}
+	if v.queue != nil {
+		if value, err := v.queue.GetProperty("current-level-buffers"); err == nil {
+			if q, ok := value.(uint); ok {
+				queued = int(q)
+			}
+		}
+	}
+	return
+}
+
 func (v *VideoInput) buildWebDecoder(p *params.Params) error {
 	xImageSrc, err := gst.NewElement("ximagesrc")
 	if err != nil {
@@ -75,11 +158,43 @@ func (v *VideoInput) buildWebDecoder(p *params.Params) error {
 	if err != nil {
 		return err
 	}
+	v.elements = []*gst.Element{xImageSrc, videoQueue}
+
+	capsStr := fmt.Sprintf("video/x-raw,framerate=%s", p.Framerate)
+	if p.Crop != nil {
+		videoCrop, err := gst.NewElement("videocrop")
+		if err != nil {
+			return err
+		}
+		for name, value := range map[string]int32{
+			"left":   p.Crop.Left,
+			"right":  p.Crop.Right,
+			"top":    p.Crop.Top,
+			"bottom": p.Crop.Bottom,
+		} {
+			if err = videoCrop.SetProperty(name, int(value)); err != nil {
+				return err
+			}
+		}
+		v.elements = append(v.elements, videoCrop)
+
+		// the cropped region is scaled back up, so that the encoder caps don't depend on the crop
+		capsStr += fmt.Sprintf(",width=%d,height=%d,pixel-aspect-ratio=1/1", p.Width, p.Height)
+	}
 
 	videoConvert, err := gst.NewElement("videoconvert")
 	if err != nil {
 		return err
 	}
+	v.elements = append(v.elements, videoConvert)
+
+	if p.Crop != nil {
+		videoScale, err := gst.NewElement("videoscale")
+		if err != nil {
+			return err
+		}
+		v.elements = append(v.elements, videoScale)
+	}
 
 	videoRate, err := gst.NewElement("videorate")
 	if err != nil {
@@ -90,13 +205,12 @@ func (v *VideoInput) buildWebDecoder(p *params.Params) error {
 	if err != nil {
 		return err
 	}
-	if err = caps.SetProperty("caps", gst.NewCapsFromString(
-		fmt.Sprintf("video/x-raw,framerate=%d/1", p.Framerate),
-	)); err != nil {
+	if err = caps.SetProperty("caps", gst.NewCapsFromString(capsStr)); err != nil {
 		return err
 	}
 
-	v.elements = []*gst.Element{xImageSrc, videoQueue, videoConvert, videoRate, caps}
+	v.elements = append(v.elements, videoRate, caps)
+	v.queue, v.rate = videoQueue, videoRate
 	return nil
 }
 
@@ -123,12 +237,24 @@ func (v *VideoInput) buildSDKDecoder(p *params.Params, src *app.Source, codec we
 			return err
 		}
 
+		if p.Passthrough {
+			h264Parse, err := gst.NewElement("h264parse")
+			if err != nil {
+				return err
+			}
+
+			v.elements = append(v.elements, rtpH264Depay, h264Parse)
+			v.source = h264Parse
+			return nil
+		}
+
 		avDecH264, err := gst.NewElement("avdec_h264")
 		if err != nil {
 			return err
 		}
 
 		v.elements = append(v.elements, rtpH264Depay, avDecH264)
+		v.source = avDecH264
 
 	case strings.EqualFold(codec.MimeType, string(params.MimeTypeVP8)):
 		if err := src.Element.SetProperty("caps", gst.NewCapsFromString(
@@ -145,8 +271,9 @@ func (v *VideoInput) buildSDKDecoder(p *params.Params, src *app.Source, codec we
 			return err
 		}
 
-		if p.OutputType == params.OutputTypeIVF || p.OutputType == params.OutputTypeWebM {
+		if skipTranscode(p, codec) {
 			v.elements = append(v.elements, rtpVP8Depay)
+			v.source = rtpVP8Depay
 			return nil
 		}
 
@@ -156,6 +283,7 @@ func (v *VideoInput) buildSDKDecoder(p *params.Params, src *app.Source, codec we
 		}
 
 		v.elements = append(v.elements, rtpVP8Depay, vp8Dec)
+		v.source = vp8Dec
 
 	default:
 		return errors.ErrNotSupported(codec.MimeType)
@@ -186,35 +314,46 @@ func (v *VideoInput) buildSDKDecoder(p *params.Params, src *app.Source, codec we
 		return err
 	}
 	if err = caps.SetProperty("caps", gst.NewCapsFromString(
-		fmt.Sprintf("video/x-raw,format=I420,width=%d,height=%d,framerate=%d/1,colorimetry=bt709,chroma-site=mpeg2,pixel-aspect-ratio=1/1", p.Width, p.Height, p.Framerate)),
+		fmt.Sprintf("video/x-raw,format=I420,width=%d,height=%d,framerate=%s,colorimetry=bt709,chroma-site=mpeg2,pixel-aspect-ratio=1/1", p.Width, p.Height, p.Framerate)),
 	); err != nil {
 		return err
 	}
 
 	v.elements = append(v.elements, videoQueue, videoConvert, videoScale, videoRate, caps)
+	v.queue, v.rate = videoQueue, videoRate
 	return nil
 }
 
+// skipTranscode returns true if the track payload can be muxed without decoding
+func skipTranscode(p *params.Params, codec webrtc.RTPCodecParameters) bool {
+	if p.Passthrough {
+		return true
+	}
+	return (p.OutputType == params.OutputTypeIVF || p.OutputType == params.OutputTypeWebM) &&
+		p.VideoCodec == params.MimeTypeVP8 &&
+		strings.EqualFold(codec.MimeType, string(params.MimeTypeVP8))
+}
+
 func (v *VideoInput) buildEncoder(p *params.Params) error {
-	switch p.VideoCodec {
-	// we only encode h264, the rest are too slow
-	case params.MimeTypeH264:
-		x264Enc, err := gst.NewElement("x264enc")
+	if p.EgressType == params.EgressTypeFile {
+		valve, err := buildValve()
 		if err != nil {
 			return err
 		}
-		if err = x264Enc.SetProperty("bitrate", uint(p.VideoBitrate)); err != nil {
+		v.valve = valve
+		v.elements = append(v.elements, valve)
+	}
+	if p.ThumbnailInterval > 0 {
+		if err := v.buildThumbnails(p); err != nil {
 			return err
 		}
-		x264Enc.SetArg("speed-preset", "veryfast")
-		if p.OutputType == params.OutputTypeHLS {
-			if err = x264Enc.SetProperty("key-int-max", uint(int32(p.SegmentDuration)*p.Framerate)); err != nil {
-				return err
-			}
-			// Avoid key frames other than at segments boundaries as splitmuxsink can become inconsistent otherwise
-			if err = x264Enc.SetProperty("option-string", "scenecut=0"); err != nil {
-				return err
-			}
+	}
+
+	switch p.VideoCodec {
+	case params.MimeTypeH264:
+		h264Enc, err := buildH264Encoder(p)
+		if err != nil {
+			return err
 		}
 
 		if p.VideoProfile == "" {
@@ -227,15 +366,72 @@ func (v *VideoInput) buildEncoder(p *params.Params) error {
 		}
 
 		if err = caps.SetProperty("caps", gst.NewCapsFromString(
-			fmt.Sprintf("video/x-h264,profile=%s,framerate=%d/1", p.VideoProfile, p.Framerate),
+			fmt.Sprintf("video/x-h264,profile=%s,framerate=%s", capsProfile(p), p.Framerate),
 		)); err != nil {
 			return err
 		}
 
-		v.elements = append(v.elements, x264Enc, caps)
+		v.elements = append(v.elements, h264Enc, caps)
+		return nil
+
+	case params.MimeTypeVP8, params.MimeTypeVP9:
+		vpxEnc, err := buildVPXEncoder(p)
+		if err != nil {
+			return err
+		}
+
+		v.elements = append(v.elements, vpxEnc)
 		return nil
 
 	default:
 		return errors.ErrNotSupported(fmt.Sprintf("%s encoding", p.VideoCodec))
 	}
 }
+
+// buildVPXEncoder creates a vp8 or vp9 encoder tuned for realtime encoding, which is much slower than x264 otherwise
+func buildVPXEncoder(p *params.Params) (*gst.Element, error) {
+	name := "vp8enc"
+	cpuUsed := 4
+	if p.VideoCodec == params.MimeTypeVP9 {
+		name = "vp9enc"
+		cpuUsed = 6
+	}
+
+	vpxEnc, err := gst.NewElement(name)
+	if err != nil {
+		return nil, err
+	}
+	// VideoBitrate is in kbps
+	if err = vpxEnc.SetProperty("target-bitrate", int(p.VideoBitrate*1000)); err != nil {
+		return nil, err
+	}
+	if err = vpxEnc.SetProperty("deadline", int64(1)); err != nil {
+		return nil, err
+	}
+	if err = vpxEnc.SetProperty("cpu-used", cpuUsed); err != nil {
+		return nil, err
+	}
+	if err = vpxEnc.SetProperty("threads", 4); err != nil {
+		return nil, err
+	}
+	keyFrameDist := p.Framerate.Frames(2)
+	if p.KeyFrameInterval > 0 {
+		keyFrameDist = p.Framerate.Frames(p.KeyFrameInterval)
+	}
+	if err = vpxEnc.SetProperty("keyframe-max-dist", keyFrameDist); err != nil {
+		return nil, err
+	}
+	if p.RateControl == params.RateControlVBR {
+		vpxEnc.SetArg("end-usage", "vbr")
+	} else {
+		vpxEnc.SetArg("end-usage", "cbr")
+	}
+
+	if p.VideoCodec == params.MimeTypeVP9 {
+		if err = vpxEnc.SetProperty("row-mt", true); err != nil {
+			return nil, err
+		}
+	}
+
+	return vpxEnc, nil
+}
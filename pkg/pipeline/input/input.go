@@ -2,6 +2,7 @@ package input
 
 import (
 	"context"
+	"time"
 
 	"github.com/tinyzimmer/go-gst/gst"
 
@@ -19,6 +20,14 @@ type Input interface {
 	Link() error
 	StartRecording() chan struct{}
 	EndRecording() chan struct{}
+	Pause() error
+	Resume(pausedFor time.Duration) error
+	SendMuxEOS() bool
+	BytesOut() int64
+	FrameStats() (dropped int64, queued int)
+	DriftStats() (maxDrift, correction time.Duration)
+	OnThumbnail(f func(frame []byte))
+	OnData(f func(data []byte, participant string))
 	Close()
 }
 
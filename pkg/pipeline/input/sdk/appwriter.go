@@ -3,7 +3,7 @@ package sdk
 import (
 	"encoding/binary"
 	"io"
-	"net"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -38,12 +38,17 @@ var (
 	H264KeyFrame2x2IDR = []byte{0x65, 0x88, 0x84, 0x0a, 0xf2, 0x62, 0x80, 0x00, 0xa7, 0xbe}
 
 	H264KeyFrame2x2 = [][]byte{H264KeyFrame2x2SPS, H264KeyFrame2x2PPS, H264KeyFrame2x2IDR}
+
+	// 20ms of silence
+	OpusSilenceFrame = []byte{0xf8, 0xff, 0xfe}
 )
 
 type appWriter struct {
 	logger      logger.Logger
 	sb          *samplebuilder.SampleBuilder
+	trackMu     sync.Mutex // guards track and rp, which are replaced when the track is republished
 	track       *webrtc.TrackRemote
+	rp          *lksdk.RemoteParticipant
 	codec       params.MimeType
 	src         *app.Source
 	startTime   time.Time
@@ -64,8 +69,22 @@ type appWriter struct {
 	tsStep      uint32
 	maxRTP      atomic.Int64
 
+	// jitter
+	jitter         *jitterParams
+	heldSince      time.Time // when the sample builder started holding back packets
+	highestSN      uint16
+	highestSNValid bool
+	lastSNValid    bool
+
+	// key frame requests
+	pli              *pliParams
+	keyFrameReceived atomic.Bool
+	lastPLI          atomic.Int64 // unix nanoseconds
+	firstKeyFrame    sync.Once
+
 	// state
 	muted        atomic.Bool
+	unpublished  atomic.Bool
 	playing      chan struct{}
 	drain        chan struct{}
 	drainTimeout time.Duration
@@ -75,6 +94,10 @@ type appWriter struct {
 	// vp8
 	firstPktPushed bool
 	vp8Munger      *sfu.VP8Munger
+
+	// track composite
+	republish *republishParams
+	replaced  chan trackReplacement
 }
 
 func newAppWriter(
@@ -86,11 +109,15 @@ func newAppWriter(
 	cs *synchronizer,
 	playing chan struct{},
 	writeBlanks bool,
+	jitter *jitterParams,
+	pli *pliParams,
+	republish *republishParams,
 ) (*appWriter, error) {
 
 	w := &appWriter{
 		logger:      logger.Logger(logr.Logger(l).WithValues("trackID", track.ID(), "kind", track.Kind().String())),
 		track:       track,
+		rp:          rp,
 		codec:       codec,
 		src:         src,
 		writeBlanks: writeBlanks,
@@ -100,6 +127,10 @@ func newAppWriter(
 		drain:       make(chan struct{}),
 		force:       make(chan struct{}),
 		finished:    make(chan struct{}),
+		jitter:      jitter,
+		pli:         pli,
+		republish:   republish,
+		replaced:    make(chan trackReplacement, 1),
 	}
 
 	var depacketizer rtp.Depacketizer
@@ -109,14 +140,14 @@ func newAppWriter(
 		depacketizer = &codecs.VP8Packet{}
 		maxLate = maxVideoLate
 		w.drainTimeout = videoTimeout
-		w.writePLI = func() { rp.WritePLI(track.SSRC()) }
+		w.writePLI = w.throttledPLI
 		w.vp8Munger = sfu.NewVP8Munger(w.logger)
 
 	case params.MimeTypeH264:
 		depacketizer = &codecs.H264Packet{}
 		maxLate = maxVideoLate
 		w.drainTimeout = videoTimeout
-		w.writePLI = func() { rp.WritePLI(track.SSRC()) }
+		w.writePLI = w.throttledPLI
 
 	case params.MimeTypeOpus:
 		depacketizer = &codecs.OpusPacket{}
@@ -136,6 +167,9 @@ func newAppWriter(
 	w.sb = w.newSampleBuilder()
 
 	go w.start()
+	if w.writePLI != nil {
+		go w.requestKeyFrames()
+	}
 	return w, nil
 }
 
@@ -169,6 +203,14 @@ func (w *appWriter) start() {
 					return
 				}
 
+				if w.republish != nil && (w.unpublished.Load() || !isTimeout(err)) {
+					// wait for the track to be republished
+					if err = w.awaitReplacement(); err == nil {
+						continue
+					}
+					return
+				}
+
 				if w.muted.Load() {
 					// switch to writing blank frames
 					err = w.pushBlankFrames()
@@ -177,7 +219,7 @@ func (w *appWriter) start() {
 					}
 				}
 
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if isTimeout(err) {
 					continue
 				}
 
@@ -191,6 +233,10 @@ func (w *appWriter) start() {
 				return
 			}
 
+			if w.writePLI != nil && !w.keyFrameReceived.Load() {
+				w.checkKeyFrame(pkt)
+			}
+
 			// sync offsets after first packet read
 			// see comment in writeRTP below
 			if !w.clockSynced {
@@ -202,10 +248,11 @@ func (w *appWriter) start() {
 			}
 
 			// push packet to sample builder
+			w.recordArrival(pkt)
 			w.sb.Push(pkt)
 
 			// push completed packets to appsrc
-			if err = w.pushPackets(false); err != nil {
+			if err = w.pushAvailable(); err != nil {
 				if !errors.Is(err, io.EOF) {
 					w.logger.Errorw("could not push buffers", err)
 				}
@@ -235,7 +282,7 @@ func (w *appWriter) pushBlankFrames() error {
 	//   recreated it to work now, will remove this when bug fixed
 	w.sb = w.newSampleBuilder()
 
-	if !w.writeBlanks {
+	if !w.writeBlanks || !w.clockSynced {
 		// wait until unmuted or closed
 		ticker := time.NewTicker(time.Millisecond * 100)
 		defer ticker.Stop()
@@ -248,15 +295,7 @@ func (w *appWriter) pushBlankFrames() error {
 		}
 	}
 
-	// expected difference between packet timestamps
-	tsStep := w.tsStep
-	if tsStep == 0 {
-		w.logger.Debugw("no timestamp step, guessing")
-		tsStep = w.track.Codec().ClockRate / (24000 / 1001)
-	}
-
-	// expected packet duration in nanoseconds
-	frameDuration := time.Duration(float64(tsStep) * 1e9 / float64(w.track.Codec().ClockRate))
+	tsStep, frameDuration := w.blankFrameStep()
 	ticker := time.NewTicker(frameDuration)
 	defer ticker.Stop()
 
@@ -272,7 +311,7 @@ func (w *appWriter) pushBlankFrames() error {
 			pkt, _, err := w.track.ReadRTP()
 			if err != nil {
 				// continue if read timeout
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if isTimeout(err) {
 					continue
 				}
 
@@ -305,6 +344,20 @@ func (w *appWriter) pushBlankFrames() error {
 	}
 }
 
+// blankFrameStep returns the expected difference between packet timestamps, and the packet duration
+func (w *appWriter) blankFrameStep() (uint32, time.Duration) {
+	tsStep := w.tsStep
+	if w.codec == params.MimeTypeOpus {
+		// silence frames are always 20ms, regardless of the packet duration used by the publisher
+		tsStep = w.track.Codec().ClockRate / 50
+	} else if tsStep == 0 {
+		w.logger.Debugw("no timestamp step, guessing")
+		tsStep = w.track.Codec().ClockRate / (24000 / 1001)
+	}
+
+	return tsStep, time.Duration(float64(tsStep) * 1e9 / float64(w.track.Codec().ClockRate))
+}
+
 func (w *appWriter) pushBlankFrame(timestamp uint32) error {
 	pkt := &rtp.Packet{
 		Header: rtp.Header{
@@ -351,6 +404,9 @@ func (w *appWriter) pushBlankFrame(timestamp uint32) error {
 		}
 
 		pkt.Payload = buf[:offset]
+
+	case params.MimeTypeOpus:
+		pkt.Payload = OpusSilenceFrame
 	}
 
 	if err := w.push([]*rtp.Packet{pkt}, true); err != nil {
@@ -371,6 +427,12 @@ func (w *appWriter) push(packets []*rtp.Packet, blankFrame bool) error {
 			w.tsStep = pkt.Timestamp - w.lastTS
 		}
 
+		if blankFrame {
+			w.lastSNValid = false
+		} else {
+			w.recordLoss(pkt)
+		}
+
 		// record SN and TS
 		w.lastSN = pkt.SequenceNumber
 		w.lastTS = pkt.Timestamp
@@ -396,9 +458,7 @@ func (w *appWriter) push(packets []*rtp.Packet, blankFrame bool) error {
 		// Since the audio and video track might start pushing to their buffers at different times, we then add a
 		// synced clock offset (w.ptsOffset), which is always 0 for the first track, and fixes the video starting to play too
 		// early if it's waiting for a key frame
-		cyclesElapsed := int64(pkt.Timestamp) - w.rtpOffset
-		nanoSecondsElapsed := int64(float64(cyclesElapsed) * w.conversion)
-		b.SetPresentationTimestamp(time.Duration(nanoSecondsElapsed + w.ptsOffset))
+		b.SetPresentationTimestamp(w.getPTS(pkt.Timestamp))
 
 		w.src.PushBuffer(b)
 	}
@@ -406,6 +466,12 @@ func (w *appWriter) push(packets []*rtp.Packet, blankFrame bool) error {
 	return nil
 }
 
+func (w *appWriter) getPTS(timestamp uint32) time.Duration {
+	cyclesElapsed := int64(timestamp) - w.rtpOffset
+	nanoSecondsElapsed := int64(float64(cyclesElapsed) * w.conversion)
+	return time.Duration(nanoSecondsElapsed + w.ptsOffset)
+}
+
 func (w *appWriter) translatePacket(pkt *rtp.Packet) {
 	switch w.codec {
 	case params.MimeTypeVP8:
@@ -484,6 +550,14 @@ func (w *appWriter) isDraining() bool {
 	}
 }
 
+func (w *appWriter) sendPLI() {
+	w.trackMu.Lock()
+	rp, ssrc := w.rp, w.track.SSRC()
+	w.trackMu.Unlock()
+
+	rp.WritePLI(ssrc)
+}
+
 func (w *appWriter) trackMuted() {
 	w.logger.Debugw("track muted", "timestamp", time.Since(w.startTime).Seconds())
 	w.muted.Store(true)
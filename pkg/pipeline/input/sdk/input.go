@@ -54,6 +54,27 @@ type SDKInput struct {
 	videoPlaying     chan struct{}
 	videoParticipant string
 
+	// simulcast
+	preferredWidth  uint32
+	preferredHeight uint32
+	videoWidth      int
+	videoHeight     int
+	layerSwitches   atomic.Int32
+
+	packetStats   packetStats
+	firstKeyFrame atomic.Duration
+
+	// track composite tracks which are replaced when republished
+	mu               sync.Mutex
+	republishTimeout time.Duration
+	published        map[string]*publishedTrack // by app source name
+	replacing        map[string]string          // app source names by replacement track ID
+
+	failure chan error
+
+	dataMu sync.Mutex
+	onData func(data []byte, participant string)
+
 	active       atomic.Int32
 	mutedChan    chan bool
 	endRecording chan struct{}
@@ -64,21 +85,28 @@ func NewSDKInput(ctx context.Context, p *params.Params) (*SDKInput, error) {
 	defer span.End()
 
 	s := &SDKInput{
-		logger:       p.Logger,
-		cs:           &synchronizer{},
-		mutedChan:    p.MutedChan,
-		endRecording: make(chan struct{}),
+		logger:           p.Logger,
+		cs:               &synchronizer{},
+		republishTimeout: p.RepublishTimeout,
+		published:        make(map[string]*publishedTrack),
+		replacing:        make(map[string]string),
+		failure:          make(chan error, 1),
+		mutedChan:        p.MutedChan,
+		endRecording:     make(chan struct{}),
 	}
 
 	if err := s.joinRoom(p); err != nil {
+		s.disconnect()
 		return nil, err
 	}
 
 	input, err := builder.NewSDKInput(ctx, p, s.audioSrc, s.videoSrc, s.audioCodec, s.videoCodec)
 	if err != nil {
+		s.disconnect()
 		return nil, err
 	}
 	s.InputBin = input
+	input.OnVideoDimensions(s.onVideoDimensions)
 
 	return s, nil
 }
@@ -158,3 +186,10 @@ func (s *SDKInput) SendAppSrcEOS(name string) {
 func (s *SDKInput) Close() {
 	s.room.Disconnect()
 }
+
+// disconnect leaves the room after a failed start, which may have failed before the room was created
+func (s *SDKInput) disconnect() {
+	if s.room != nil {
+		s.room.Disconnect()
+	}
+}
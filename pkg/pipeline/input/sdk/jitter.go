@@ -0,0 +1,132 @@
+package sdk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/tinyzimmer/go-gst/gst"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+type jitterParams struct {
+	latency time.Duration // max time to wait for missing packets, 0 to wait until the sample builder is full
+	conceal bool          // ask the audio decoder to conceal lost packets
+	stats   *packetStats
+}
+
+// packetStats counts late and missing packets across all tracks of an egress
+type packetStats struct {
+	lost      atomic.Int64
+	reordered atomic.Int64
+	concealed atomic.Int64
+}
+
+// PacketStats returns the number of packets which were lost, arrived out of order, and were concealed by the decoder
+func (s *SDKInput) PacketStats() (lost, reordered, concealed int64) {
+	return s.packetStats.lost.Load(), s.packetStats.reordered.Load(), s.packetStats.concealed.Load()
+}
+
+// pushAvailable pushes completed frames to the appsrc. Once frames have been held back for longer than the
+// jitter latency, any packets still missing are treated as lost
+func (w *appWriter) pushAvailable() error {
+	if !w.isPlaying() {
+		return nil
+	}
+
+	force := w.jitter.latency > 0 && !w.heldSince.IsZero() && time.Since(w.heldSince) > w.jitter.latency
+	popped := false
+	for {
+		var packets []*rtp.Packet
+		if force {
+			packets = w.sb.ForcePopPackets()
+		} else {
+			packets = w.sb.PopPackets()
+		}
+		if len(packets) == 0 {
+			break
+		}
+
+		popped = true
+		if err := w.push(packets, false); err != nil {
+			return err
+		}
+	}
+
+	if popped || force {
+		w.heldSince = time.Time{}
+	} else if w.heldSince.IsZero() {
+		w.heldSince = time.Now()
+	}
+	return nil
+}
+
+// recordArrival counts packets received after a later packet
+func (w *appWriter) recordArrival(pkt *rtp.Packet) {
+	if !w.highestSNValid {
+		w.highestSN = pkt.SequenceNumber
+		w.highestSNValid = true
+		return
+	}
+
+	switch diff := pkt.SequenceNumber - w.highestSN; {
+	case diff == 0:
+		// duplicate
+	case diff < 0x8000:
+		w.highestSN = pkt.SequenceNumber
+	default:
+		w.jitter.stats.reordered.Inc()
+	}
+}
+
+// recordLoss counts packets missing between the last packet pushed and pkt, which is about to be pushed.
+// Lost audio is concealed by the decoder, and lost video requests a key frame
+func (w *appWriter) recordLoss(pkt *rtp.Packet) {
+	if !w.lastSNValid {
+		w.lastSNValid = true
+		return
+	}
+
+	lost := pkt.SequenceNumber - w.lastSN - 1
+	if lost == 0 || lost >= 0x8000 {
+		return
+	}
+
+	w.jitter.stats.lost.Add(int64(lost))
+	w.logger.Debugw("packets lost", "count", lost)
+
+	switch {
+	case w.codec == params.MimeTypeOpus && w.jitter.conceal:
+		w.concealLoss(pkt, lost)
+	case w.writePLI != nil:
+		w.writePLI()
+	}
+}
+
+// concealLoss sends a packet lost event covering the audio missing before pkt. The depayloader turns it into a gap,
+// which the decoder fills using packet loss concealment
+func (w *appWriter) concealLoss(pkt *rtp.Packet, lost uint16) {
+	tsStep := w.tsStep
+	if tsStep == 0 {
+		tsStep = w.track.Codec().ClockRate / 50
+	}
+
+	start := w.getPTS(w.lastTS + tsStep)
+	end := w.getPTS(pkt.Timestamp)
+	if end <= start {
+		return
+	}
+
+	s := gst.NewStructureFromString(fmt.Sprintf(
+		"GstRTPPacketLost, seqnum=(uint)%d, timestamp=(guint64)%d, duration=(guint64)%d, retry=(uint)0",
+		w.lastSN+1+w.snOffset, uint64(start), uint64(end-start),
+	))
+	if s == nil || !w.src.SendEvent(gst.NewCustomEvent(gst.EventTypeCustomDownstream, s)) {
+		w.logger.Debugw("could not send packet lost event")
+		return
+	}
+
+	w.jitter.stats.concealed.Add(int64(lost))
+}
@@ -0,0 +1,73 @@
+package sdk
+
+import (
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go"
+)
+
+// selectVideoLayer subscribes to the simulcast layer for quality. The sfu forwards the best layer available up to
+// the one requested, so a lower layer is received while the preferred one is paused
+func (s *SDKInput) selectVideoLayer(pub *lksdk.RemoteTrackPublication, quality livekit.VideoQuality) {
+	info := pub.TrackInfo()
+	if info == nil || !info.Simulcast {
+		return
+	}
+
+	layer := getVideoLayer(info.Layers, quality)
+	if layer == nil {
+		return
+	}
+	s.preferredWidth, s.preferredHeight = layer.Width, layer.Height
+
+	if quality != livekit.VideoQuality_HIGH {
+		// the sdk always asks for the high layer, but the sfu uses dimensions when they are given
+		pub.SetVideoDimensions(layer.Width, layer.Height)
+	}
+	s.logger.Debugw("selected video layer", "quality", layer.Quality, "width", layer.Width, "height", layer.Height)
+}
+
+// getVideoLayer returns the highest layer at or below quality, or the lowest layer if there is none
+func getVideoLayer(layers []*livekit.VideoLayer, quality livekit.VideoQuality) *livekit.VideoLayer {
+	var best, lowest *livekit.VideoLayer
+	for _, layer := range layers {
+		if lowest == nil || layer.Quality < lowest.Quality {
+			lowest = layer
+		}
+		if layer.Quality <= quality && (best == nil || layer.Quality > best.Quality) {
+			best = layer
+		}
+	}
+	if best == nil {
+		return lowest
+	}
+	return best
+}
+
+// onVideoDimensions counts changes in the dimensions of the subscribed video, which are layer switches for
+// simulcast tracks. The encoder caps don't change, since the video is scaled to the output size
+func (s *SDKInput) onVideoDimensions(width, height int) {
+	if s.videoWidth == width && s.videoHeight == height {
+		return
+	}
+
+	prevWidth, prevHeight := s.videoWidth, s.videoHeight
+	s.videoWidth, s.videoHeight = width, height
+	if prevWidth == 0 && prevHeight == 0 {
+		return
+	}
+
+	s.layerSwitches.Inc()
+	degraded := s.preferredWidth > 0 && width*height < int(s.preferredWidth*s.preferredHeight)
+	s.logger.Infow("video layer changed",
+		"width", width,
+		"height", height,
+		"previousWidth", prevWidth,
+		"previousHeight", prevHeight,
+		"degraded", degraded,
+	)
+}
+
+// LayerSwitches returns the number of times the dimensions of the subscribed video have changed
+func (s *SDKInput) LayerSwitches() int {
+	return int(s.layerSwitches.Load())
+}
@@ -0,0 +1,86 @@
+package sdk
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/livekit-server/pkg/sfu/buffer"
+)
+
+type pliParams struct {
+	retryInterval time.Duration             // between requests until a key frame arrives
+	minInterval   time.Duration             // between later requests
+	onKeyFrame    func(delay time.Duration) // called once, with the time taken to receive the first key frame
+}
+
+// FirstKeyFrameDelay returns the time taken for the first video key frame to arrive after subscribing,
+// or 0 if none has arrived
+func (s *SDKInput) FirstKeyFrameDelay() time.Duration {
+	return s.firstKeyFrame.Load()
+}
+
+// requestKeyFrames requests a key frame as soon as the track is subscribed, and retries until one arrives.
+// It runs until the writer finishes
+func (w *appWriter) requestKeyFrames() {
+	w.sendPLI()
+	if w.pli.retryInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.pli.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.finished:
+			return
+		case <-ticker.C:
+			if !w.keyFrameReceived.Load() {
+				w.logger.Debugw("no key frame received, requesting again")
+				w.sendPLI()
+			}
+		}
+	}
+}
+
+// throttledPLI requests a key frame after packet loss, at most once per min interval.
+// Until the first key frame arrives, requests are left to requestKeyFrames
+func (w *appWriter) throttledPLI() {
+	if !w.keyFrameReceived.Load() {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := w.lastPLI.Load()
+	if now-last < int64(w.pli.minInterval) || !w.lastPLI.CAS(last, now) {
+		return
+	}
+	w.sendPLI()
+}
+
+// checkKeyFrame records the arrival of the first key frame
+func (w *appWriter) checkKeyFrame(pkt *rtp.Packet) {
+	var keyFrame bool
+	switch w.codec {
+	case params.MimeTypeH264:
+		keyFrame = buffer.IsH264Keyframe(pkt.Payload)
+	case params.MimeTypeVP8:
+		vp8 := buffer.VP8{}
+		keyFrame = vp8.Unmarshal(pkt.Payload) == nil && vp8.IsKeyFrame
+	}
+	if !keyFrame {
+		return
+	}
+
+	w.keyFrameReceived.Store(true)
+	w.lastPLI.Store(time.Now().UnixNano())
+	w.firstKeyFrame.Do(func() {
+		delay := time.Since(w.startTime)
+		w.logger.Infow("first key frame received", "delay", delay)
+		if w.pli.onKeyFrame != nil {
+			w.pli.onKeyFrame(delay)
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package sdk
+
+import (
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go"
+)
+
+// republishParams are set for track composite writers, which wait for their track to be replaced when it is unpublished
+type republishParams struct {
+	timeout     time.Duration
+	placeholder bool   // write blank frames while waiting
+	onTimeout   func() // called if the track is not replaced in time
+}
+
+type trackReplacement struct {
+	track *webrtc.TrackRemote
+	rp    *lksdk.RemoteParticipant
+}
+
+// publishedTrack identifies a track of a track composite egress, so that a republished track can replace it
+type publishedTrack struct {
+	writer   *appWriter
+	identity string
+	source   livekit.TrackSource
+	kind     webrtc.RTPCodecType
+	mimeType string
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// trackUnpublished makes the writer wait for a replacement once it has read the remaining packets
+func (w *appWriter) trackUnpublished() {
+	w.logger.Debugw("track unpublished", "timestamp", time.Since(w.startTime).Seconds())
+	w.unpublished.Store(true)
+}
+
+// replaceTrack continues writing from a republished track
+func (w *appWriter) replaceTrack(track *webrtc.TrackRemote, rp *lksdk.RemoteParticipant) {
+	select {
+	case w.replaced <- trackReplacement{track: track, rp: rp}:
+	default:
+		w.logger.Warnw("track already replaced", nil, "trackID", track.ID())
+	}
+}
+
+// awaitReplacement waits for the track to be republished, writing blank frames in the meantime if enabled.
+// It returns an error if the writer is drained, or the track isn't replaced within the timeout
+func (w *appWriter) awaitReplacement() error {
+	_ = w.pushPackets(true)
+	w.unpublished.Store(true)
+	w.logger.Infow("waiting for track to be republished", "timeout", w.republish.timeout)
+
+	timeout := time.NewTimer(w.republish.timeout)
+	defer timeout.Stop()
+
+	var tsStep uint32
+	var placeholder <-chan time.Time
+	if w.republish.placeholder && w.track.Kind() == webrtc.RTPCodecTypeVideo && w.isPlaying() {
+		var frameDuration time.Duration
+		tsStep, frameDuration = w.blankFrameStep()
+		ticker := time.NewTicker(frameDuration)
+		defer ticker.Stop()
+		placeholder = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.drain:
+			return io.EOF
+
+		case <-timeout.C:
+			w.republish.onTimeout()
+			return io.EOF
+
+		case r := <-w.replaced:
+			w.setTrack(r)
+			return nil
+
+		case <-placeholder:
+			if err := w.pushBlankFrame(w.lastTS + tsStep); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (w *appWriter) setTrack(r trackReplacement) {
+	w.trackMu.Lock()
+	w.track, w.rp = r.track, r.rp
+	w.trackMu.Unlock()
+	w.unpublished.Store(false)
+
+	// the new track starts at a random rtp timestamp, so its first packet is mapped to the current time
+	w.sb = w.newSampleBuilder()
+	w.clockSynced = false
+	w.firstPktPushed = false
+	w.tsStep = 0
+	w.heldSince = time.Time{}
+	w.highestSNValid = false
+	w.lastSNValid = false
+
+	w.logger.Infow("track republished", "newTrackID", r.track.ID())
+	if w.writePLI != nil {
+		// wait for a key frame from the new track
+		w.keyFrameReceived.Store(false)
+		w.sendPLI()
+	}
+}
+
+// awaitRepublish marks a track as unpublished, so that its writer waits for a replacement
+func (s *SDKInput) awaitRepublish(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t := s.published[name]; t != nil {
+		t.writer.trackUnpublished()
+	}
+}
+
+// onRepublishTimeout fails the egress when a track was not republished in time
+func (s *SDKInput) onRepublishTimeout(name string) {
+	s.mu.Lock()
+	t := s.published[name]
+	s.mu.Unlock()
+
+	if t != nil {
+		s.fail(errors.ErrTrackNotRepublished(t.identity, strings.ToLower(t.source.String()), s.republishTimeout))
+	}
+}
+
+// findReplacement subscribes to a newly published track if it can replace one which was unpublished
+func (s *SDKInput) findReplacement(pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) bool {
+	s.mu.Lock()
+	var found bool
+	for name, t := range s.published {
+		if !t.writer.unpublished.Load() || t.identity != rp.Identity() || t.source != pub.Source() {
+			continue
+		}
+		if (t.kind == webrtc.RTPCodecTypeAudio) != (pub.Kind() == lksdk.TrackKindAudio) {
+			continue
+		}
+
+		s.replacing[pub.SID()] = name
+		found = true
+		break
+	}
+	s.mu.Unlock()
+
+	if !found {
+		return false
+	}
+
+	s.logger.Infow("replacement track published", "identity", rp.Identity(), "trackID", pub.SID())
+	if err := pub.SetSubscribed(true); err != nil {
+		s.logger.Errorw("could not subscribe to replacement track", err, "trackID", pub.SID())
+		s.mu.Lock()
+		delete(s.replacing, pub.SID())
+		s.mu.Unlock()
+	}
+	return true
+}
+
+// onReplacementSubscribed switches a writer to a republished track, returning false if the track is not a replacement
+func (s *SDKInput) onReplacementSubscribed(track *webrtc.TrackRemote, pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, ok := s.replacing[pub.SID()]
+	if !ok {
+		return false
+	}
+	delete(s.replacing, pub.SID())
+
+	t := s.published[name]
+	if !strings.EqualFold(track.Codec().MimeType, t.mimeType) {
+		// the pipeline was built for the codec of the original track
+		s.logger.Warnw("replacement track has a different codec", nil, "trackID", pub.SID(), "mime", track.Codec().MimeType)
+		_ = pub.SetSubscribed(false)
+		return true
+	}
+
+	switch name {
+	case AudioAppSource:
+		s.audioTrackID = pub.SID()
+		s.audioParticipant = rp.Identity()
+	case VideoAppSource:
+		s.videoTrackID = pub.SID()
+		s.videoParticipant = rp.Identity()
+	}
+	t.writer.replaceTrack(track, rp)
+	return true
+}
+
+// Failure returns a channel which receives an error if the source fails after it has started
+func (s *SDKInput) Failure() <-chan error {
+	return s.failure
+}
+
+func (s *SDKInput) fail(err error) {
+	select {
+	case s.failure <- err:
+	default:
+	}
+}
@@ -24,6 +24,7 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 			OnTrackUnmuted:     s.onTrackUnmuted,
 			OnTrackPublished:   s.onTrackPublished,
 			OnTrackUnpublished: s.onTrackUnpublished,
+			OnDataReceived:     s.onDataReceived,
 		},
 	}
 
@@ -33,6 +34,10 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 	var onSubscribeErr error
 	var wg sync.WaitGroup
 	cb.OnTrackSubscribed = func(track *webrtc.TrackRemote, pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
+		if s.onReplacementSubscribed(track, pub, rp) {
+			return
+		}
+
 		defer wg.Done()
 		s.logger.Debugw("track subscribed", "trackID", track.ID(), "mime", track.Codec().MimeType)
 		s.active.Inc()
@@ -61,12 +66,22 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 		}
 		mu.Unlock()
 
+		// websocket egress only carries pcm audio
+		if p.EgressType == params.EgressTypeWebsocket && track.Kind() == webrtc.RTPCodecTypeVideo {
+			onSubscribeErr = errors.ErrIncompatible(params.OutputTypeRaw, track.Codec().MimeType)
+			return
+		}
+
 		switch {
 		case strings.EqualFold(track.Codec().MimeType, string(params.MimeTypeOpus)):
 			codec = params.MimeTypeOpus
 			appSrcName = AudioAppSource
 			p.AudioEnabled = true
-			if p.AudioCodec == "" {
+			if p.Passthrough && p.AudioCodec != "" && p.AudioCodec != codec {
+				onSubscribeErr = errors.ErrPassthroughIncompatible(p.AudioCodec, codec)
+				return
+			}
+			if p.AudioCodec == "" || p.Passthrough {
 				p.AudioCodec = codec
 			}
 
@@ -75,25 +90,36 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 			appSrcName = VideoAppSource
 			p.VideoEnabled = true
 
-			if p.VideoCodec == "" {
-				if p.AudioEnabled {
+			if p.Passthrough {
+				if onSubscribeErr = setPassthroughVideoCodec(p, codec); onSubscribeErr != nil {
+					return
+				}
+			} else if p.VideoCodec == "" {
+				if p.OutputType == params.OutputTypeWebM {
+					p.VideoCodec = params.DefaultVideoCodecs[params.OutputTypeWebM]
+				} else if p.AudioEnabled {
 					// transcode to h264 for composite requests
 					p.VideoCodec = params.MimeTypeH264
 				} else {
 					p.VideoCodec = params.MimeTypeVP8
 				}
 			}
-			if p.TrackID != "" {
-				p.OutputType = params.OutputTypeWebM
-			}
 
 		case strings.EqualFold(track.Codec().MimeType, string(params.MimeTypeH264)):
 			codec = params.MimeTypeH264
 			appSrcName = VideoAppSource
 			p.VideoEnabled = true
 
-			if p.VideoCodec == "" {
-				p.VideoCodec = params.MimeTypeH264
+			if p.Passthrough {
+				if onSubscribeErr = setPassthroughVideoCodec(p, codec); onSubscribeErr != nil {
+					return
+				}
+			} else if p.VideoCodec == "" {
+				if p.OutputType == params.OutputTypeWebM {
+					p.VideoCodec = params.DefaultVideoCodecs[params.OutputTypeWebM]
+				} else {
+					p.VideoCodec = params.MimeTypeH264
+				}
 			}
 
 		default:
@@ -101,6 +127,12 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 			return
 		}
 
+		if p.TrackID != "" && p.EgressType == params.EgressTypeFile {
+			if onSubscribeErr = p.UpdateTrackOutputType(codec); onSubscribeErr != nil {
+				return
+			}
+		}
+
 		<-p.GstReady
 		src, err := gst.NewElementWithName("appsrc", appSrcName)
 		if err != nil {
@@ -109,15 +141,38 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 			return
 		}
 
-		// write blank frames only when writing to mp4
-		writeBlanks := p.VideoCodec == params.MimeTypeH264
+		// fill muted periods with silence and blank frames so that the output stays continuous and in sync.
+		// Remuxed video can't be padded with frames in a different format, so timestamps jump over the muted
+		// period instead
+		writeBlanks := track.Kind() == webrtc.RTPCodecTypeAudio || !p.Passthrough
+
+		jitter := &jitterParams{
+			latency: p.JitterLatency,
+			conceal: track.Kind() == webrtc.RTPCodecTypeAudio && !p.Passthrough,
+			stats:   &s.packetStats,
+		}
+
+		pli := &pliParams{
+			retryInterval: p.PLIRetryInterval,
+			minInterval:   p.PLIMinInterval,
+			onKeyFrame:    s.firstKeyFrame.Store,
+		}
+
+		var republish *republishParams
+		if p.RepublishTimeout > 0 {
+			republish = &republishParams{
+				timeout:     p.RepublishTimeout,
+				placeholder: p.RepublishPlaceholder,
+				onTimeout:   func() { s.onRepublishTimeout(appSrcName) },
+			}
+		}
 
 		switch track.Kind() {
 		case webrtc.RTPCodecTypeAudio:
 			s.audioSrc = app.SrcFromElement(src)
 			s.audioPlaying = make(chan struct{})
 			s.audioCodec = track.Codec()
-			s.audioWriter, err = newAppWriter(track, codec, rp, s.logger, s.audioSrc, s.cs, s.audioPlaying, writeBlanks)
+			s.audioWriter, err = newAppWriter(track, codec, rp, s.logger, s.audioSrc, s.cs, s.audioPlaying, writeBlanks, jitter, pli, republish)
 			s.audioParticipant = rp.Identity()
 			if err != nil {
 				s.logger.Errorw("could not create app writer", err)
@@ -126,10 +181,11 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 			}
 
 		case webrtc.RTPCodecTypeVideo:
+			s.selectVideoLayer(pub, p.VideoQuality)
 			s.videoSrc = app.SrcFromElement(src)
 			s.videoPlaying = make(chan struct{})
 			s.videoCodec = track.Codec()
-			s.videoWriter, err = newAppWriter(track, codec, rp, s.logger, s.videoSrc, s.cs, s.videoPlaying, writeBlanks)
+			s.videoWriter, err = newAppWriter(track, codec, rp, s.logger, s.videoSrc, s.cs, s.videoPlaying, writeBlanks, jitter, pli, republish)
 			s.videoParticipant = rp.Identity()
 			if err != nil {
 				s.logger.Errorw("could not create app writer", err)
@@ -137,6 +193,23 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 				return
 			}
 		}
+
+		if republish != nil {
+			writer := s.audioWriter
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				writer = s.videoWriter
+			}
+
+			s.mu.Lock()
+			s.published[appSrcName] = &publishedTrack{
+				writer:   writer,
+				identity: rp.Identity(),
+				source:   pub.Source(),
+				kind:     track.Kind(),
+				mimeType: track.Codec().MimeType,
+			}
+			s.mu.Unlock()
+		}
 	}
 
 	s.room = lksdk.CreateRoom(cb)
@@ -190,17 +263,38 @@ func (s *SDKInput) joinRoom(p *params.Params) error {
 	return nil
 }
 
+// setPassthroughVideoCodec uses the track codec for output, failing if a different codec was requested
+func setPassthroughVideoCodec(p *params.Params, codec params.MimeType) error {
+	if p.VideoCodec != "" && p.VideoCodec != codec {
+		return errors.ErrPassthroughIncompatible(p.VideoCodec, codec)
+	}
+	p.VideoCodec = codec
+	return nil
+}
+
 func (s *SDKInput) onParticipantDisconnected(p *lksdk.RemoteParticipant) {
 	identity := p.Identity()
 	if identity == s.audioParticipant {
-		go s.SendAppSrcEOS(AudioAppSource)
+		if s.republishTimeout > 0 {
+			s.awaitRepublish(AudioAppSource)
+		} else {
+			go s.SendAppSrcEOS(AudioAppSource)
+		}
 	}
 	if identity == s.videoParticipant {
-		go s.SendAppSrcEOS(VideoAppSource)
+		if s.republishTimeout > 0 {
+			s.awaitRepublish(VideoAppSource)
+		} else {
+			go s.SendAppSrcEOS(VideoAppSource)
+		}
 	}
 }
 
 func (s *SDKInput) onTrackPublished(pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
+	if s.republishTimeout > 0 && s.findReplacement(pub, rp) {
+		return
+	}
+
 	if rp.Identity() != s.participantIdentity {
 		return
 	}
@@ -208,6 +302,27 @@ func (s *SDKInput) onTrackPublished(pub *lksdk.RemoteTrackPublication, rp *lksdk
 	s.logger.Errorw("participant published new track", nil, "identity", s.participantIdentity, "trackID", pub.SID())
 }
 
+func (s *SDKInput) onDataReceived(data []byte, rp *lksdk.RemoteParticipant) {
+	s.dataMu.Lock()
+	onData := s.onData
+	s.dataMu.Unlock()
+
+	if onData != nil {
+		var identity string
+		if rp != nil {
+			identity = rp.Identity()
+		}
+		onData(data, identity)
+	}
+}
+
+// OnData calls f with each data message received, which must not block
+func (s *SDKInput) OnData(f func(data []byte, participant string)) {
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+	s.onData = f
+}
+
 func (s *SDKInput) onTrackMuted(pub lksdk.TrackPublication, _ lksdk.Participant) {
 	track := pub.Track()
 	if track == nil {
@@ -242,7 +357,11 @@ func (s *SDKInput) onTrackUnmuted(pub lksdk.TrackPublication, _ lksdk.Participan
 
 func (s *SDKInput) onTrackUnpublished(track *lksdk.RemoteTrackPublication, _ *lksdk.RemoteParticipant) {
 	if w := s.getWriterForTrack(track.SID()); w != nil {
-		w.sendEOS()
+		if s.republishTimeout > 0 {
+			w.trackUnpublished()
+		} else {
+			w.sendEOS()
+		}
 	}
 }
 
@@ -310,6 +429,10 @@ func (s *SDKInput) subscribeToTracks(expecting map[string]struct{}) error {
 }
 
 func (s *SDKInput) getWriterForTrack(trackID string) *appWriter {
+	// track IDs change when tracks are republished
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	switch trackID {
 	case s.trackID:
 		if s.audioWriter != nil {
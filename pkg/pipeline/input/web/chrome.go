@@ -0,0 +1,139 @@
+package web
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// flags egress relies on for capture, which can't be changed from the config
+var reservedChromeFlags = map[string]bool{
+	"display":               true,
+	"window-size":           true,
+	"window-position":       true,
+	"kiosk":                 true,
+	"remote-debugging-port": true,
+	"remote-debugging-pipe": true,
+	"user-data-dir":         true,
+}
+
+// chromeFlags returns the flags chrome is launched with. A value of false removes a flag
+func (s *WebInput) chromeFlags(p *params.Params, conf *config.Config) map[string]interface{} {
+	width, height := displaySize(p)
+
+	flags := map[string]interface{}{
+		"no-first-run":             true,
+		"no-default-browser-check": true,
+		"disable-gpu":              true,
+		"no-sandbox":               true,
+
+		// puppeteer default behavior
+		"disable-infobars":                       true,
+		"excludeSwitches":                        "enable-automation",
+		"disable-background-networking":          true,
+		"enable-features":                        "NetworkService,NetworkServiceInProcess",
+		"disable-background-timer-throttling":    true,
+		"disable-backgrounding-occluded-windows": true,
+		"disable-breakpad":                       true,
+		"disable-client-side-phishing-detection": true,
+		"disable-default-apps":                   true,
+		"disable-dev-shm-usage":                  true,
+		"disable-extensions":                     true,
+		"disable-features":                       "site-per-process,TranslateUI,BlinkGenPropertyTrees",
+		"disable-hang-monitor":                   true,
+		"disable-ipc-flooding-protection":        true,
+		"disable-popup-blocking":                 true,
+		"disable-prompt-on-repost":               true,
+		"disable-renderer-backgrounding":         true,
+		"disable-sync":                           true,
+		"force-color-profile":                    "srgb",
+		"metrics-recording-only":                 true,
+		"safebrowsing-disable-auto-update":       true,
+		"password-store":                         "basic",
+		"use-mock-keychain":                      true,
+
+		// custom args
+		"kiosk":             true,
+		"enable-automation": false,
+		"autoplay-policy":   "no-user-gesture-required",
+		"window-position":   "0,0",
+		"window-size":       fmt.Sprintf("%d,%d", width, height),
+
+		// output
+		"display": p.Display,
+	}
+
+	if conf.Insecure {
+		flags["disable-web-security"] = true
+		flags["allow-running-insecure-content"] = true
+	}
+
+	// the proxy is only configurable per node, so that requests can't route traffic elsewhere
+	if conf.Chrome.ProxyServer != "" {
+		flags["proxy-server"] = conf.Chrome.ProxyServer
+		if conf.Chrome.ProxyBypassList != "" {
+			flags["proxy-bypass-list"] = conf.Chrome.ProxyBypassList
+		}
+	}
+
+	for _, flag := range conf.Chrome.Flags {
+		name, value, err := parseChromeFlag(flag)
+		if err != nil {
+			s.logger.Warnw("skipping chrome flag", err, "flag", flag)
+			continue
+		}
+		flags[name] = value
+	}
+
+	return flags
+}
+
+// parseChromeFlag parses a flag like --name or --name=value. true and false values are booleans
+func parseChromeFlag(flag string) (string, interface{}, error) {
+	if !strings.HasPrefix(flag, "--") {
+		return "", nil, errors.New("flag must start with --")
+	}
+
+	name, value, hasValue := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", nil, errors.New("invalid flag name")
+	}
+	if reservedChromeFlags[name] {
+		return "", nil, errors.New("flag is set by egress")
+	}
+
+	switch {
+	case !hasValue, value == "true":
+		return name, true, nil
+	case value == "false":
+		return name, false, nil
+	default:
+		return name, value, nil
+	}
+}
+
+// formatChromeFlags lists flags as they are passed to chrome, sorted by name
+func formatChromeFlags(flags map[string]interface{}) []string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	formatted := make([]string, 0, len(names))
+	for _, name := range names {
+		switch value := flags[name].(type) {
+		case bool:
+			if value {
+				formatted = append(formatted, "--"+name)
+			}
+		default:
+			formatted = append(formatted, fmt.Sprintf("--%s=%v", name, value))
+		}
+	}
+	return formatted
+}
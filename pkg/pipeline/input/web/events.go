@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go"
+)
+
+// the template joins with the egress id as its identity, which a second connection would replace
+const roomEventsIdentitySuffix = "_events"
+
+// roomEvents collects room events and data messages as a hidden participant. Events past the limit are counted
+// and dropped
+type roomEvents struct {
+	mu      sync.Mutex
+	room    *lksdk.Room
+	closed  bool
+	collect bool // room events are kept, rather than only data messages passed on
+	events  []*params.RoomEvent
+	max     int
+	dropped int
+	speaker string
+	onData  func(data []byte, participant string)
+
+	logger logger.Logger
+}
+
+// collectRoomEvents joins the room. Room events are extras, so an egress which can't join carries on without them
+func (s *WebInput) collectRoomEvents(conf *config.Config, p *params.Params) {
+	e := s.events
+	f := false
+	token, err := auth.NewAccessToken(conf.ApiKey, conf.ApiSecret).
+		AddGrant(&auth.VideoGrant{
+			RoomJoin:       true,
+			Room:           p.Info.RoomName,
+			CanSubscribe:   &f,
+			CanPublish:     &f,
+			CanPublishData: &f,
+			Hidden:         true,
+			Recorder:       true,
+		}).
+		SetIdentity(p.Info.EgressId + roomEventsIdentitySuffix).
+		SetValidFor(24 * time.Hour).
+		ToJWT()
+	if err != nil {
+		e.logger.Warnw("could not build room events token", err)
+		return
+	}
+
+	cb := &lksdk.RoomCallback{
+		ParticipantCallback: lksdk.ParticipantCallback{
+			OnTrackPublished: func(pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
+				e.add(&params.RoomEvent{
+					Type:        params.RoomEventTrackPublished,
+					Participant: rp.Identity(),
+					TrackID:     pub.SID(),
+					TrackSource: pub.Source().String(),
+				})
+			},
+			OnTrackUnpublished: func(pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
+				e.add(&params.RoomEvent{
+					Type:        params.RoomEventTrackUnpublished,
+					Participant: rp.Identity(),
+					TrackID:     pub.SID(),
+					TrackSource: pub.Source().String(),
+				})
+			},
+			OnDataReceived: e.onDataReceived,
+		},
+		OnActiveSpeakersChanged: e.onActiveSpeakersChanged,
+	}
+
+	room, err := lksdk.ConnectToRoomWithToken(p.LKUrl, token, cb, lksdk.WithAutoSubscribe(false))
+	if err != nil {
+		e.logger.Warnw("could not join room to collect events", err)
+		return
+	}
+
+	e.mu.Lock()
+	closed := e.closed
+	if !closed {
+		e.room = room
+	}
+	e.mu.Unlock()
+
+	if closed {
+		room.Disconnect()
+	}
+}
+
+// onDataReceived passes data messages on, and adds chapters, sent as {"chapter": "<title>"}
+func (e *roomEvents) onDataReceived(data []byte, rp *lksdk.RemoteParticipant) {
+	var identity string
+	if rp != nil {
+		identity = rp.Identity()
+	}
+	e.mu.Lock()
+	onData := e.onData
+	e.mu.Unlock()
+	if onData != nil {
+		onData(data, identity)
+	}
+
+	var msg struct {
+		Chapter string `json:"chapter"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Chapter == "" {
+		return
+	}
+
+	e.add(&params.RoomEvent{
+		Type:        params.RoomEventChapter,
+		Participant: identity,
+		Title:       msg.Chapter,
+	})
+}
+
+// onActiveSpeakersChanged adds an event when the loudest speaker changes
+func (e *roomEvents) onActiveSpeakersChanged(speakers []lksdk.Participant) {
+	if len(speakers) == 0 {
+		return
+	}
+
+	identities := make([]string, 0, len(speakers))
+	for _, speaker := range speakers {
+		identities = append(identities, speaker.Identity())
+	}
+
+	e.mu.Lock()
+	changed := identities[0] != e.speaker
+	e.speaker = identities[0]
+	e.mu.Unlock()
+
+	if changed {
+		e.add(&params.RoomEvent{
+			Type:        params.RoomEventSpeakerChanged,
+			Participant: identities[0],
+			Speakers:    identities,
+		})
+	}
+}
+
+func (e *roomEvents) add(event *params.RoomEvent) {
+	event.Time = time.Now().UnixNano()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed || !e.collect {
+		return
+	}
+	if len(e.events) >= e.max {
+		if e.dropped == 0 {
+			e.logger.Infow("room event limit reached", "maxEvents", e.max)
+		}
+		e.dropped++
+		return
+	}
+	e.events = append(e.events, event)
+}
+
+// close disconnects outside of the lock, since callbacks may be waiting on it
+func (e *roomEvents) close() {
+	e.mu.Lock()
+	e.closed = true
+	room := e.room
+	e.room = nil
+	e.mu.Unlock()
+
+	if room != nil {
+		room.Disconnect()
+	}
+}
+
+// OnData calls f with each data message received, which must not block
+func (s *WebInput) OnData(f func(data []byte, participant string)) {
+	if s.events == nil {
+		return
+	}
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	s.events.onData = f
+}
+
+// RoomEvents returns the events collected, and the number dropped after the limit was reached
+func (s *WebInput) RoomEvents() ([]*params.RoomEvent, int) {
+	if s.events == nil {
+		return nil, 0
+	}
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	return s.events.events, s.events.dropped
+}
@@ -5,8 +5,11 @@ import (
 	"math/rand"
 	"os"
 	"os/exec"
+	"sync"
 	"time"
 
+	"go.uber.org/atomic"
+
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/pipeline/input/builder"
 	"github.com/livekit/egress/pkg/pipeline/params"
@@ -28,6 +31,12 @@ type WebInput struct {
 
 	startRecording chan struct{}
 	endRecording   chan struct{}
+	endOnce        sync.Once
+	closed         chan struct{}
+	closeOnce      sync.Once
+	started        atomic.Bool // recording has started, so page errors no longer fail the egress
+	failure        chan error
+	events         *roomEvents
 
 	logger logger.Logger
 }
@@ -41,7 +50,9 @@ func NewWebInput(ctx context.Context, conf *config.Config, p *params.Params) (*W
 	defer span.End()
 
 	s := &WebInput{
-		logger: p.Logger,
+		closed:  make(chan struct{}),
+		failure: make(chan error, 1),
+		logger:  p.Logger,
 	}
 
 	if err := s.createPulseSink(ctx, p); err != nil {
@@ -56,7 +67,7 @@ func NewWebInput(ctx context.Context, conf *config.Config, p *params.Params) (*W
 		return nil, err
 	}
 
-	if err := s.launchChrome(ctx, p, conf.Insecure); err != nil {
+	if err := s.launchChrome(ctx, p, conf); err != nil {
 		s.logger.Errorw("failed to launch chrome", err, "display", p.Display)
 		s.Close()
 		return nil, err
@@ -71,6 +82,14 @@ func NewWebInput(ctx context.Context, conf *config.Config, p *params.Params) (*W
 	}
 	s.InputBin = input
 
+	if p.RoomEmptyTimeout > 0 && s.endRecording != nil {
+		go s.monitorRoom(conf, p)
+	}
+	if p.RoomEventsFilepath != "" || p.DataCapture {
+		s.events = &roomEvents{collect: p.RoomEventsFilepath != "", max: p.MaxRoomEvents, logger: s.logger}
+		go s.collectRoomEvents(conf, p)
+	}
+
 	return s, nil
 }
 
@@ -82,7 +101,20 @@ func (s *WebInput) EndRecording() chan struct{} {
 	return s.endRecording
 }
 
+// end closes the end recording channel, recording the reason the egress ended
+func (s *WebInput) end(p *params.Params, reason string) {
+	s.endOnce.Do(func() {
+		p.EndReason = reason
+		close(s.endRecording)
+	})
+}
+
 func (s *WebInput) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	if s.events != nil {
+		s.events.close()
+	}
+
 	if s.chromeCancel != nil {
 		s.chromeCancel()
 		s.chromeCancel = nil
@@ -92,14 +124,12 @@ func (s *WebInput) Close() {
 		err := s.xvfb.Process.Signal(os.Interrupt)
 		if err != nil {
 			s.logger.Errorw("failed to kill xvfb", err)
+		} else {
+			// release the display, which is reused if the egress is retried
+			_ = s.xvfb.Wait()
 		}
 		s.xvfb = nil
 	}
 
-	if s.pulseSink != "" {
-		err := exec.Command("pactl", "unload-module", s.pulseSink).Run()
-		if err != nil {
-			s.logger.Errorw("failed to unload pulse sink", err)
-		}
-	}
+	s.removePulseSink()
 }
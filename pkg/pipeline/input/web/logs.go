@@ -0,0 +1,159 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/logger"
+)
+
+// chrome message severities, in increasing order
+const (
+	severityDebug = iota
+	severityInfo
+	severityWarn
+	severityError
+)
+
+var severities = map[string]int{
+	config.ChromeLogDebug: severityDebug,
+	config.ChromeLogInfo:  severityInfo,
+	config.ChromeLogWarn:  severityWarn,
+	config.ChromeLogError: severityError,
+}
+
+// chromeLogger forwards console messages, page errors and failed requests from chrome to the egress logger.
+// Chrome events are delivered to listeners one at a time, so it needs no locking
+type chromeLogger struct {
+	logger   logger.Logger
+	level    int
+	maxLines int
+	lines    int
+	requests map[network.RequestID]string // urls of requests in flight
+}
+
+func newChromeLogger(p *params.Params) *chromeLogger {
+	return &chromeLogger{
+		logger:   p.Logger,
+		level:    severities[p.ChromeLogLevel],
+		maxLines: p.ChromeLogMaxLines,
+		requests: make(map[network.RequestID]string),
+	}
+}
+
+func (l *chromeLogger) handle(ev interface{}) {
+	switch ev := ev.(type) {
+	case *runtime.EventConsoleAPICalled:
+		l.log(consoleSeverity(ev.Type), fmt.Sprintf("chrome %s: %s", ev.Type.String(), strings.Join(consoleArgs(ev), " ")))
+
+	case *runtime.EventExceptionThrown:
+		l.log(severityError, "chrome exception", "error", exceptionMessage(ev))
+
+	case *network.EventRequestWillBeSent:
+		l.requests[ev.RequestID] = stripQuery(ev.Request.URL)
+
+	case *network.EventResponseReceived:
+		if ev.Response.Status >= 400 {
+			l.log(severityWarn, "chrome request failed", "url", stripQuery(ev.Response.URL), "status", ev.Response.Status)
+		}
+
+	case *network.EventLoadingFinished:
+		delete(l.requests, ev.RequestID)
+
+	case *network.EventLoadingFailed:
+		url := l.requests[ev.RequestID]
+		delete(l.requests, ev.RequestID)
+		if !ev.Canceled {
+			l.log(severityWarn, "chrome request failed", "url", url, "error", ev.ErrorText)
+		}
+	}
+}
+
+func (l *chromeLogger) log(severity int, msg string, keysAndValues ...interface{}) {
+	if severity < l.level || l.lines > l.maxLines {
+		return
+	}
+
+	l.lines++
+	if l.lines > l.maxLines {
+		l.logger.Infow("chrome log limit reached, dropping further messages", "maxLines", l.maxLines)
+		return
+	}
+
+	switch severity {
+	case severityDebug:
+		l.logger.Debugw(msg, keysAndValues...)
+	case severityInfo:
+		l.logger.Infow(msg, keysAndValues...)
+	default:
+		l.logger.Warnw(msg, nil, keysAndValues...)
+	}
+}
+
+func consoleSeverity(t runtime.APIType) int {
+	switch t {
+	case runtime.APITypeError, runtime.APITypeAssert:
+		return severityError
+	case runtime.APITypeWarning:
+		return severityWarn
+	case runtime.APITypeInfo:
+		return severityInfo
+	default:
+		return severityDebug
+	}
+}
+
+// stripQuery removes query params, which can hold access tokens, from logged urls
+func stripQuery(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
+
+// consoleArgs returns the values logged by a console call
+func consoleArgs(ev *runtime.EventConsoleAPICalled) []string {
+	args := make([]string, 0, len(ev.Args))
+	for _, arg := range ev.Args {
+		var val interface{}
+		if err := json.Unmarshal(arg.Value, &val); err != nil {
+			continue
+		}
+		args = append(args, fmt.Sprint(val))
+	}
+	return args
+}
+
+func exceptionMessage(ev *runtime.EventExceptionThrown) string {
+	if d := ev.ExceptionDetails; d != nil {
+		if d.Exception != nil && d.Exception.Description != "" {
+			return d.Exception.Description
+		}
+		return d.Text
+	}
+	return "unknown exception"
+}
+
+// Failure returns a channel which receives an error if the page fails before recording starts
+func (s *WebInput) Failure() <-chan error {
+	return s.failure
+}
+
+// onException fails the egress if the page throws before recording starts, instead of recording a broken page
+func (s *WebInput) onException(ev *runtime.EventExceptionThrown) {
+	if s.started.Load() {
+		return
+	}
+
+	select {
+	case s.failure <- errors.ErrPageException(exceptionMessage(ev)):
+	default:
+	}
+}
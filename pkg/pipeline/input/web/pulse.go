@@ -0,0 +1,98 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/tracer"
+	"github.com/livekit/protocol/utils"
+)
+
+// Each egress gets a null sink named after its egress id. Chrome plays audio into it, and the pipeline captures
+// its monitor source, so pages recorded on the same node can't hear each other.
+
+var sinkNameRegexp = regexp.MustCompile(`sink_name="?([^"\s]+)"?`)
+
+// creates a new pulse audio sink
+func (s *WebInput) createPulseSink(ctx context.Context, p *params.Params) error {
+	ctx, span := tracer.Start(ctx, "WebInput.createPulseSink")
+	defer span.End()
+
+	cmd := exec.Command("pactl",
+		"load-module", "module-null-sink",
+		fmt.Sprintf("sink_name=\"%s\"", p.Info.EgressId),
+		fmt.Sprintf("sink_properties=device.description=\"%s\"", p.Info.EgressId),
+	)
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return err
+	}
+
+	// pactl prints the module index
+	s.pulseSink = strings.TrimSpace(b.String())
+	return nil
+}
+
+func (s *WebInput) removePulseSink() {
+	if s.pulseSink == "" {
+		return
+	}
+
+	if err := exec.Command("pactl", "unload-module", s.pulseSink).Run(); err != nil {
+		s.logger.Errorw("failed to unload pulse sink", err)
+	}
+	s.pulseSink = ""
+}
+
+// RemovePulseSinks unloads egress sinks which are not in use. Sinks are normally removed when the egress ends,
+// but are left behind if its handler crashes
+func RemovePulseSinks(inUse func(egressID string) bool) {
+	modules, err := listPulseSinks()
+	if err != nil {
+		logger.Debugw("could not list pulse sinks", "error", err)
+		return
+	}
+
+	for egressID, module := range modules {
+		if inUse(egressID) {
+			continue
+		}
+
+		logger.Infow("removing stale pulse sink", "egressID", egressID)
+		if err = exec.Command("pactl", "unload-module", module).Run(); err != nil {
+			logger.Errorw("failed to unload pulse sink", err, "egressID", egressID)
+		}
+	}
+}
+
+// listPulseSinks returns the module index of each egress sink, by egress id
+func listPulseSinks() (map[string]string, error) {
+	out, err := exec.Command("pactl", "list", "short", "modules").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 || fields[1] != "module-null-sink" {
+			continue
+		}
+		match := sinkNameRegexp.FindStringSubmatch(fields[2])
+		if match == nil || !strings.HasPrefix(match[1], utils.EgressPrefix) {
+			continue
+		}
+		modules[match[1]] = fields[0]
+	}
+	return modules, nil
+}
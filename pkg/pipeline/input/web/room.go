@@ -0,0 +1,84 @@
+package web
+
+import (
+	"context"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go"
+)
+
+const (
+	roomEmptyPollInterval = time.Second * 5
+	roomEndedReason       = "room ended"
+)
+
+// monitorRoom ends the recording once the room has been empty for the room empty timeout
+func (s *WebInput) monitorRoom(conf *config.Config, p *params.Params) {
+	select {
+	case <-s.closed:
+		return
+	case <-s.startRecording:
+	}
+
+	client := lksdk.NewRoomServiceClient(p.LKUrl, conf.ApiKey, conf.ApiSecret)
+	ticker := time.NewTicker(roomEmptyPollInterval)
+	defer ticker.Stop()
+
+	var emptySince time.Time
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-s.endRecording:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), roomEmptyPollInterval)
+		res, err := client.ListParticipants(ctx, &livekit.ListParticipantsRequest{Room: p.Info.RoomName})
+		cancel()
+		if err != nil {
+			s.logger.Warnw("could not list participants", err)
+			continue
+		}
+
+		if countParticipants(res.Participants, p.RoomEmptyTracks) > 0 {
+			if !emptySince.IsZero() {
+				s.logger.Debugw("room no longer empty")
+				emptySince = time.Time{}
+			}
+			continue
+		}
+
+		if emptySince.IsZero() {
+			s.logger.Infow("room is empty", "timeout", p.RoomEmptyTimeout)
+			emptySince = time.Now()
+		} else if time.Since(emptySince) >= p.RoomEmptyTimeout {
+			s.logger.Infow("room has been empty, ending recording", "emptyFor", time.Since(emptySince))
+			s.end(p, roomEndedReason)
+			return
+		}
+	}
+}
+
+// countParticipants returns the number of participants being recorded, ignoring recorders and hidden participants.
+// If withTracks is set, participants without published tracks are also ignored
+func countParticipants(participants []*livekit.ParticipantInfo, withTracks bool) int {
+	count := 0
+	for _, pi := range participants {
+		if pi.State == livekit.ParticipantInfo_DISCONNECTED {
+			continue
+		}
+		if pi.Permission != nil && (pi.Permission.Hidden || pi.Permission.Recorder) {
+			continue
+		}
+		if withTracks && len(pi.Tracks) == 0 {
+			continue
+		}
+		count++
+	}
+	return count
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Low-latency HLS segments are written by the muxer as parts, which are uploaded and listed as they are closed,
+// and joined into their segment once it has all of its parts. The playlist is uploaded after each part, so it
+// never lists an object which hasn't been uploaded yet. Parts are only handled by the segment worker.
+
+// storePart uploads a finished part and lists it in the playlist, ending the segment once all of its parts are written
+func (p *Pipeline) storePart(update segmentUpdate) {
+	duration := time.Duration(update.endTime - p.chunkEndTime)
+	p.chunkEndTime = update.endTime
+	p.partIndex++
+	p.segmentParts = append(p.segmentParts, update.localPath)
+
+	_, _, err := p.storeSegment(update.localPath, p.GetStorageFilepath(update.localPath))
+	if err != nil {
+		p.failSegment(err)
+	} else if err = p.llPlaylist.AddPart(update.localPath, duration, p.GetPartFilepath(p.partIndex)); err != nil {
+		p.Logger.Errorw("failed to add part", err, "path", update.localPath)
+	}
+
+	if len(p.segmentParts) >= p.GetPartsPerSegment() {
+		p.endLLSegment()
+	}
+	p.storePlaylist(context.Background())
+}
+
+// endLLSegment joins the parts written since the last segment, then uploads and lists the segment
+func (p *Pipeline) endLLSegment() {
+	if len(p.segmentParts) == 0 {
+		return
+	}
+
+	localPath := p.GetSegmentFilepath(int(p.SegmentsInfo.SegmentCount))
+	parts := p.segmentParts
+	p.segmentParts = nil
+
+	var location string
+	var size int64
+	err := joinParts(localPath, parts)
+	if err == nil {
+		location, size, err = p.storeSegment(localPath, p.GetStorageFilepath(localPath))
+	}
+	p.addSegment(size)
+	if err != nil {
+		p.failSegment(err)
+	}
+
+	if p.hooks != nil {
+		event := p.segmentEvent(segmentUpdate{localPath: localPath, endTime: p.chunkEndTime}, location, err)
+		event.StartTime = p.segmentStartTime
+		event.Duration = p.chunkEndTime - p.segmentStartTime
+		p.hooks.enqueue(event, false)
+	}
+	p.segmentStartTime = p.chunkEndTime
+
+	if err == nil {
+		if err = p.llPlaylist.EndSegment(localPath); err != nil {
+			p.Logger.Errorw("failed to end segment", err, "path", localPath)
+		}
+	}
+}
+
+// failSegment fails the egress, since a missing part or segment breaks the playlist
+func (p *Pipeline) failSegment(err error) {
+	if p.setFirstError(err) {
+		p.SendEOS(context.Background())
+	}
+}
+
+// joinParts writes a segment from its parts. MPEG-TS parts can be concatenated
+func joinParts(segmentPath string, parts []string) error {
+	f, err := os.Create(segmentPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, part := range parts {
+		if err = appendFile(f, part); err != nil {
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+func appendFile(w io.Writer, filepath string) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
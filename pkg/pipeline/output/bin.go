@@ -21,6 +21,9 @@ type OutputBin struct {
 	sinks    map[string]*streamSink
 	lock     sync.Mutex
 
+	// websocket
+	websocket *websocketSink
+
 	logger logger.Logger
 }
 
@@ -36,6 +39,10 @@ func New(ctx context.Context, p *params.Params) (*OutputBin, error) {
 
 	switch p.EgressType {
 	case params.EgressTypeFile:
+		if p.SplitFile() {
+			// split files are written by the muxer
+			return nil, nil
+		}
 		return buildFileOutputBin(p)
 	case params.EgressTypeStream:
 		return buildStreamOutputBin(p)
@@ -197,6 +204,9 @@ func (o *OutputBin) RemoveSink(url string) error {
 }
 
 func (o *OutputBin) GetUrlFromName(name string) (string, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
 	for url, sink := range o.sinks {
 		if sink.queue.GetName() == name || sink.sink.GetName() == name {
 			return url, nil
@@ -74,6 +74,15 @@ func buildStreamSink(protocol params.OutputType, url string) (*streamSink, error
 		if err = sink.Set("location", url); err != nil {
 			return nil, err
 		}
+
+	case params.OutputTypeSRT:
+		sink, err = buildSRTSink(id, url)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.ErrInvalidInput("stream protocol")
 	}
 
 	return &streamSink{
@@ -81,3 +90,42 @@ func buildStreamSink(protocol params.OutputType, url string) (*streamSink, error
 		sink:  sink,
 	}, nil
 }
+
+func buildSRTSink(id, url string) (*gst.Element, error) {
+	opts, err := params.ParseSRTOptions(url)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := gst.NewElementWithName("srtsink", fmt.Sprintf("sink_%s", id))
+	if err != nil {
+		return nil, err
+	}
+	if err = sink.SetProperty("sync", false); err != nil {
+		return nil, err
+	}
+	// drop data instead of blocking the tee while disconnected
+	if err = sink.SetProperty("wait-for-connection", false); err != nil {
+		return nil, err
+	}
+	if err = sink.SetProperty("uri", url); err != nil {
+		return nil, err
+	}
+
+	// also set explicitly, since older versions of srtsink ignore some uri params
+	if opts.Mode != "" {
+		sink.SetArg("mode", opts.Mode)
+	}
+	if opts.Latency > 0 {
+		if err = sink.SetProperty("latency", opts.Latency); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Passphrase != "" {
+		if err = sink.SetProperty("passphrase", opts.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
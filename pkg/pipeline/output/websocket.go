@@ -4,18 +4,30 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
+	"go.uber.org/atomic"
 
+	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/pipeline/params"
+	pcmsink "github.com/livekit/egress/pkg/pipeline/sink"
 	"github.com/livekit/protocol/logger"
 )
 
+const (
+	websocketWriteTimeout = time.Second * 10
+	websocketCloseTimeout = time.Second * 5
+	websocketMinReconnect = time.Millisecond * 100
+	websocketMaxReconnect = time.Second * 5
+)
+
 func buildWebsocketOutputBin(p *params.Params) (*OutputBin, error) {
-	writer, err := newWebSocketSink(p.WebsocketUrl, params.MimeTypeRaw, p.Logger, p.MutedChan)
+	writer, err := newWebSocketSink(p.WebsocketUrl, params.MimeTypeRaw, p.WebsocketConf, p.Logger, p.MutedChan)
 	if err != nil {
 		return nil, err
 	}
@@ -25,6 +37,11 @@ func buildWebsocketOutputBin(p *params.Params) (*OutputBin, error) {
 		return nil, err
 	}
 
+	var framer *pcmsink.PCMFramer
+	if p.WebsocketConf.Timestamps {
+		framer = pcmsink.NewPCMFramer(p.WebsocketConf.SampleRate, p.WebsocketConf.Channels, writer.getStartTime)
+	}
+
 	sink.SetCallbacks(&app.SinkCallbacks{
 		EOSFunc: func(appSink *app.Sink) {
 			// Close writer on EOS
@@ -47,6 +64,9 @@ func buildWebsocketOutputBin(p *params.Params) (*OutputBin, error) {
 
 			// Map the buffer to READ operation
 			samples := buffer.Map(gst.MapRead).Bytes()
+			if framer != nil {
+				samples = framer.Frame(buffer.PresentationTimestamp(), samples)
+			}
 
 			// From the extracted bytes, send to writer
 			_, err = writer.Write(samples)
@@ -69,27 +89,63 @@ func buildWebsocketOutputBin(p *params.Params) (*OutputBin, error) {
 	}
 
 	return &OutputBin{
-		bin:    bin,
-		logger: p.Logger,
+		bin:       bin,
+		websocket: writer,
+		logger:    p.Logger,
 	}, nil
 }
 
-type websocketState string
+// SetStartTime sets the function returning the unix time of pts 0, used to timestamp websocket frames
+func (o *OutputBin) SetStartTime(startTime func() int64) {
+	if o != nil && o.websocket != nil {
+		o.websocket.startTime.Store(startTime)
+	}
+}
 
-const (
-	WebSocketActive websocketState = "active"
-	WebSocketClosed websocketState = "closed"
-)
+// WebsocketStats returns the number of times the websocket output has reconnected, and the number of bytes it dropped
+func (o *OutputBin) WebsocketStats() (reconnects int, droppedBytes int64) {
+	if o == nil || o.websocket == nil {
+		return 0, 0
+	}
+	return int(o.websocket.reconnects.Load()), o.websocket.droppedBytes.Load()
+}
 
+type websocketMessage struct {
+	messageType int
+	data        []byte
+	queuedAt    time.Time
+}
+
+// websocketSink queues messages and writes them from a separate goroutine, so that a slow or disconnected
+// consumer never blocks the pipeline. Queued audio is limited by the websocket config, dropping the oldest first,
+// and a gap message is sent in its place. If the connection fails, it is reconnected with exponential backoff.
 type websocketSink struct {
-	conn   *websocket.Conn
+	url    string
+	header http.Header
+	conf   config.WebsocketConfig
 	logger logger.Logger
 	muted  chan bool
-	closed chan struct{}
-	state  websocketState
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	conn        *websocket.Conn
+	addr        string
+	queue       []*websocketMessage
+	queuedBytes int
+	gapBytes    int64 // dropped since the last gap message
+	reconnected bool  // since the last gap message
+	err         error
+	closed      bool
+
+	reconnects   atomic.Int32
+	droppedBytes atomic.Int64
+	startTime    atomic.Value // func() int64
+
+	closing chan struct{}
+	done    chan struct{}
 }
 
-func newWebSocketSink(url string, mimeType params.MimeType, logger logger.Logger, muted chan bool) (io.WriteCloser, error) {
+func newWebSocketSink(url string, mimeType params.MimeType, conf config.WebsocketConfig, logger logger.Logger, muted chan bool) (*websocketSink, error) {
 	// set Content-Type header
 	header := http.Header{}
 	header.Set("Content-Type", string(mimeType))
@@ -100,53 +156,278 @@ func newWebSocketSink(url string, mimeType params.MimeType, logger logger.Logger
 	}
 
 	s := &websocketSink{
-		conn:   conn,
-		logger: logger,
-		muted:  muted,
-		closed: make(chan struct{}),
-		state:  WebSocketActive,
+		url:     url,
+		header:  header,
+		conf:    conf,
+		logger:  logger,
+		muted:   muted,
+		conn:    conn,
+		addr:    conn.RemoteAddr().String(),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
 	}
+	s.cond = sync.NewCond(&s.mu)
+
+	go s.run()
 	go s.listenToMutedChan()
 
 	return s, nil
 }
 
+// getStartTime returns the unix time of pts 0, or 0 if it is not known
+func (s *websocketSink) getStartTime() int64 {
+	if startTime, ok := s.startTime.Load().(func() int64); ok {
+		return startTime()
+	}
+	return 0
+}
+
 func (s *websocketSink) Write(p []byte) (n int, err error) {
-	if s.state == WebSocketClosed {
-		return 0, errors.ErrWebSocketClosed(s.conn.RemoteAddr().String())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.closed {
+		return 0, errors.ErrWebSocketClosed(s.addr)
 	}
 
-	return len(p), s.conn.WriteMessage(websocket.BinaryMessage, p)
+	// the buffer is unmapped once the sample has been handled
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	now := time.Now()
+	s.queue = append(s.queue, &websocketMessage{
+		messageType: websocket.BinaryMessage,
+		data:        data,
+		queuedAt:    now,
+	})
+	s.queuedBytes += len(data)
+	s.dropOldest(now)
+	s.cond.Signal()
+
+	return len(p), nil
 }
 
-func (s *websocketSink) Close() error {
-	if s.state == WebSocketClosed {
+// dropOldest removes the oldest audio until the queue is within the configured limits. Control messages are kept.
+func (s *websocketSink) dropOldest(now time.Time) {
+	for i := 0; i < len(s.queue); {
+		msg := s.queue[i]
+		if msg.messageType != websocket.BinaryMessage {
+			i++
+			continue
+		}
+		if s.queuedBytes <= s.conf.MaxBufferSize && now.Sub(msg.queuedAt) <= s.conf.MaxBufferDuration {
+			return
+		}
+
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.queuedBytes -= len(msg.data)
+		s.gapBytes += int64(len(msg.data))
+		s.droppedBytes.Add(int64(len(msg.data)))
+	}
+}
+
+// queueText queues a control message, which is never dropped
+func (s *websocketSink) queueText(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return errors.ErrWebSocketClosed(s.addr)
+	}
+
+	s.queue = append(s.queue, &websocketMessage{
+		messageType: websocket.TextMessage,
+		data:        data,
+		queuedAt:    time.Now(),
+	})
+	s.cond.Signal()
+	return nil
+}
+
+// next waits for the next message, returning nil once the sink is closed and its queue is empty.
+// After audio has been dropped or the connection restored, a gap message is returned first.
+func (s *websocketSink) next() *websocketMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
 		return nil
 	}
 
+	if s.gapBytes > 0 || s.reconnected {
+		data, err := json.Marshal(&gapMessagePayload{
+			Gap:          true,
+			DroppedBytes: s.gapBytes,
+			Reconnected:  s.reconnected,
+		})
+		if err == nil {
+			s.gapBytes = 0
+			s.reconnected = false
+			return &websocketMessage{messageType: websocket.TextMessage, data: data}
+		}
+	}
+
+	msg := s.queue[0]
+	s.queue = s.queue[1:]
+	if msg.messageType == websocket.BinaryMessage {
+		s.queuedBytes -= len(msg.data)
+	}
+	return msg
+}
+
+// requeue returns a message which could not be sent to the front of the queue
+func (s *websocketSink) requeue(msg *websocketMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.queuedAt.IsZero() {
+		// gap messages are rebuilt from the counts
+		gap := &gapMessagePayload{}
+		if err := json.Unmarshal(msg.data, gap); err == nil {
+			s.gapBytes += gap.DroppedBytes
+		}
+		return
+	}
+	s.queue = append([]*websocketMessage{msg}, s.queue...)
+	if msg.messageType == websocket.BinaryMessage {
+		s.queuedBytes += len(msg.data)
+	}
+}
+
+func (s *websocketSink) run() {
+	defer close(s.done)
+
+	for {
+		msg := s.next()
+		if msg == nil {
+			s.closeConn()
+			return
+		}
+
+		s.mu.Lock()
+		conn := s.conn
+		s.mu.Unlock()
+
+		_ = conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+		if err := conn.WriteMessage(msg.messageType, msg.data); err != nil {
+			select {
+			case <-s.closing:
+				// not reconnecting to flush the queue
+				_ = conn.Close()
+				return
+			default:
+			}
+
+			s.logger.Warnw("websocket disconnected", err, "addr", s.addr)
+			s.requeue(msg)
+			if err = s.reconnect(); err != nil {
+				s.logger.Errorw("could not reconnect websocket", err)
+				s.mu.Lock()
+				s.err = errors.ErrWebSocketReconnectFailed(s.addr, err)
+				s.queue = nil
+				s.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// reconnect dials with exponential backoff until a connection is made, or the reconnect timeout has passed
+func (s *websocketSink) reconnect() error {
+	s.mu.Lock()
+	_ = s.conn.Close()
+	s.mu.Unlock()
+
+	deadline := time.Now().Add(s.conf.ReconnectTimeout)
+	delay := websocketMinReconnect
+	for {
+		select {
+		case <-time.After(delay):
+		case <-s.closing:
+			return errors.ErrWebSocketClosed(s.addr)
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(s.url, s.header)
+		if err == nil {
+			s.mu.Lock()
+			s.conn = conn
+			s.addr = conn.RemoteAddr().String()
+			s.reconnected = true
+			s.mu.Unlock()
+
+			s.reconnects.Inc()
+			s.logger.Infow("websocket reconnected", "addr", s.addr)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		s.logger.Debugw("retrying websocket", "error", err, "delay", delay)
+		if delay *= 2; delay > websocketMaxReconnect {
+			delay = websocketMaxReconnect
+		}
+	}
+}
+
+func (s *websocketSink) closeConn() {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
 	// write close message for graceful disconnection
-	err := s.conn.WriteMessage(websocket.CloseMessage, nil)
+	_ = conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	err := conn.WriteMessage(websocket.CloseMessage, nil)
 	if err != nil && !errors.Is(err, io.EOF) {
 		s.logger.Errorw("cannot write WS close message", err)
 	}
+	_ = conn.Close()
+}
+
+// Close sends any queued messages, then closes the connection
+func (s *websocketSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.closing)
+	s.cond.Broadcast()
+	s.mu.Unlock()
 
-	// terminate connection and close the `closed` channel
-	err = s.conn.Close()
-	close(s.closed)
-	s.state = WebSocketClosed
-	return err
+	select {
+	case <-s.done:
+	case <-time.After(websocketCloseTimeout):
+		s.logger.Warnw("websocket did not flush before closing", nil, "addr", s.addr)
+		s.mu.Lock()
+		_ = s.conn.Close()
+		s.mu.Unlock()
+	}
+	return nil
 }
 
 type textMessagePayload struct {
 	Muted bool `json:"muted"`
 }
 
-func (s *websocketSink) writeMutedMessage(muted bool) error {
-	// If the socket is closed, return error
-	if s.state == WebSocketClosed {
-		return errors.ErrWebSocketClosed(s.conn.RemoteAddr().String())
-	}
+// gapMessagePayload is sent where audio is missing from the stream, so that consumers can detect gaps
+type gapMessagePayload struct {
+	Gap          bool  `json:"gap"`
+	DroppedBytes int64 `json:"dropped_bytes"`
+	Reconnected  bool  `json:"reconnected,omitempty"`
+}
 
+func (s *websocketSink) writeMutedMessage(muted bool) error {
 	// Marshal `muted` payload
 	data, err := json.Marshal(&textMessagePayload{
 		Muted: muted,
@@ -155,14 +436,13 @@ func (s *websocketSink) writeMutedMessage(muted bool) error {
 		return err
 	}
 
-	// Write message
-	return s.conn.WriteMessage(websocket.TextMessage, data)
+	// Queue message, in order with the audio
+	return s.queueText(data)
 }
 
 func (s *websocketSink) listenToMutedChan() {
-	// If the `muted` channel is nil or socket is closed,
-	// cannot send message. Just return
-	if s.muted == nil || s.state == WebSocketClosed {
+	// If the `muted` channel is nil, cannot send message. Just return
+	if s.muted == nil {
 		return
 	}
 	var err error
@@ -173,7 +453,7 @@ func (s *websocketSink) listenToMutedChan() {
 			if err != nil && !errors.Is(err, io.EOF) {
 				s.logger.Errorw("error writing muted message: ", err)
 			}
-		case <-s.closed:
+		case <-s.closing:
 			return
 		}
 	}
@@ -0,0 +1,32 @@
+package params
+
+import (
+	"fmt"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+)
+
+// CropParams are the pixels removed from each edge of the display before it is scaled to the output resolution
+type CropParams struct {
+	Left   int32
+	Right  int32
+	Top    int32
+	Bottom int32
+}
+
+// updateCrop checks that the crop region fits within the display, which has the output resolution
+func (p *Params) updateCrop(conf *config.CropConfig) error {
+	if conf.Left+conf.Width > p.Width || conf.Top+conf.Height > p.Height {
+		return errors.ErrInvalidInput(fmt.Sprintf("crop (%dx%d at %d,%d does not fit within %dx%d)",
+			conf.Width, conf.Height, conf.Left, conf.Top, p.Width, p.Height))
+	}
+
+	p.Crop = &CropParams{
+		Left:   conf.Left,
+		Right:  p.Width - conf.Left - conf.Width,
+		Top:    conf.Top,
+		Bottom: p.Height - conf.Top - conf.Height,
+	}
+	return nil
+}
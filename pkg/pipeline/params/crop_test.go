@@ -0,0 +1,22 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+func TestUpdateCrop(t *testing.T) {
+	p := &Params{VideoParams: VideoParams{Width: 1920, Height: 1080}}
+
+	require.NoError(t, p.updateCrop(&config.CropConfig{Left: 0, Top: 120, Width: 1920, Height: 960}))
+	require.Equal(t, &CropParams{Left: 0, Right: 0, Top: 120, Bottom: 0}, p.Crop)
+
+	require.NoError(t, p.updateCrop(&config.CropConfig{Left: 100, Top: 50, Width: 1280, Height: 720}))
+	require.Equal(t, &CropParams{Left: 100, Right: 540, Top: 50, Bottom: 310}, p.Crop)
+
+	require.Error(t, p.updateCrop(&config.CropConfig{Left: 100, Top: 0, Width: 1920, Height: 1080}))
+	require.Error(t, p.updateCrop(&config.CropConfig{Left: 0, Top: 0, Width: 1280, Height: 1440}))
+}
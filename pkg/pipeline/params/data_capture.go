@@ -0,0 +1,66 @@
+package params
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+// DataCaptureParams describe the sidecar of data messages written during composite egress
+type DataCaptureParams struct {
+	DataCapture     bool
+	DataTopic       string
+	DataFormat      string // jsonl or vtt
+	DataMaxRate     int
+	DataCueDuration time.Duration
+	DataFilepath    string // set when a restarted pipeline appends to the sidecar of the one before
+
+	DataMessageCount int // written, recorded in the manifest
+}
+
+func (p *Params) updateDataCapture(conf config.DataCaptureConfig) {
+	switch p.EgressType {
+	case EgressTypeFile, EgressTypeSegmentedFile:
+	default:
+		return
+	}
+
+	p.DataCapture = true
+	p.DataTopic = conf.Topic
+	p.DataFormat = conf.Format
+	p.DataMaxRate = conf.MaxRate
+	p.DataCueDuration = conf.CueDuration
+}
+
+// GetDataCaptureFilepath names the sidecar after the file, or the playlist of segments. Track composite outputs
+// are only named once the room is joined, so this is only final once the input has been created
+func (p *Params) GetDataCaptureFilepath() string {
+	if p.DataFilepath != "" {
+		return p.DataFilepath
+	}
+	prefix := strings.TrimSuffix(p.LocalFilepath, path.Ext(p.LocalFilepath))
+	if p.EgressType == EgressTypeSegmentedFile {
+		prefix = strings.TrimSuffix(p.PlaylistFilename, path.Ext(p.PlaylistFilename))
+	}
+	return prefix + "_data." + p.DataFormat
+}
+
+// GetDataCaptureStorageFilepath stores the sidecar next to the output
+func (p *Params) GetDataCaptureStorageFilepath() string {
+	_, filename := path.Split(p.GetDataCaptureFilepath())
+	if p.EgressType == EgressTypeSegmentedFile {
+		return p.GetStorageFilepath(filename)
+	}
+	dir, _ := path.Split(p.StorageFilepath)
+	return path.Join(dir, filename)
+}
+
+// GetDataCaptureType is the content type of the sidecar
+func (p *Params) GetDataCaptureType() OutputType {
+	if p.DataFormat == config.DataCaptureVTT {
+		return OutputTypeVTT
+	}
+	return OutputTypeJSONL
+}
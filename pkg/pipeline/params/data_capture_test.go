@@ -0,0 +1,46 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+func TestDataCapture(t *testing.T) {
+	conf := config.DataCaptureConfig{Enabled: true, Topic: "captions", Format: config.DataCaptureVTT, MaxRate: 20, CueDuration: time.Second * 3}
+
+	p := &Params{
+		EgressType: EgressTypeFile,
+		FileParams: FileParams{
+			LocalFilepath:   "/tmp/EG_123/recording.mp4",
+			StorageFilepath: "recordings/recording.mp4",
+		},
+	}
+	p.updateDataCapture(conf)
+	require.True(t, p.DataCapture)
+	require.Equal(t, "/tmp/EG_123/recording_data.vtt", p.GetDataCaptureFilepath())
+	require.Equal(t, "recordings/recording_data.vtt", p.GetDataCaptureStorageFilepath())
+	require.Equal(t, OutputTypeVTT, p.GetDataCaptureType())
+
+	// named after the playlist
+	conf.Format = config.DataCaptureJSONL
+	p = &Params{
+		EgressType: EgressTypeSegmentedFile,
+		SegmentedFileParams: SegmentedFileParams{
+			PlaylistFilename:  "/tmp/EG_123/playlist.m3u8",
+			StoragePathPrefix: "segments/",
+		},
+	}
+	p.updateDataCapture(conf)
+	require.Equal(t, "/tmp/EG_123/playlist_data.jsonl", p.GetDataCaptureFilepath())
+	require.Equal(t, "segments/playlist_data.jsonl", p.GetDataCaptureStorageFilepath())
+	require.Equal(t, OutputTypeJSONL, p.GetDataCaptureType())
+
+	// streams have nowhere to store it
+	p = &Params{EgressType: EgressTypeStream}
+	p.updateDataCapture(conf)
+	require.False(t, p.DataCapture)
+}
@@ -0,0 +1,114 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+func TestUpdateFileOutputType(t *testing.T) {
+	for _, test := range []struct {
+		fileType livekit.EncodedFileType
+		filepath string
+		expected OutputType
+		err      bool
+	}{
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording", OutputTypeMP4, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.mp4", OutputTypeMP4, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.ogg", OutputTypeOGG, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.webm", OutputTypeWebM, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.mkv", OutputTypeMKV, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.MP4", OutputTypeMP4, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.OGG", OutputTypeOGG, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.WebM", OutputTypeWebM, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.MKV", OutputTypeMKV, false},
+		{livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.v2", OutputTypeMP4, false},
+		{livekit.EncodedFileType_MP4, "recording", OutputTypeMP4, false},
+		{livekit.EncodedFileType_MP4, "recording.mp4", OutputTypeMP4, false},
+		{livekit.EncodedFileType_MP4, "recording.MP4", OutputTypeMP4, false},
+		{livekit.EncodedFileType_MP4, "recording.ogg", OutputTypeMP4, true},
+		{livekit.EncodedFileType_MP4, "recording.webm", OutputTypeMP4, true},
+		{livekit.EncodedFileType_MP4, "recording.MKV", OutputTypeMP4, true},
+		{livekit.EncodedFileType_OGG, "recording", OutputTypeOGG, false},
+		{livekit.EncodedFileType_OGG, "recording.ogg", OutputTypeOGG, false},
+		{livekit.EncodedFileType_OGG, "recording.Ogg", OutputTypeOGG, false},
+		{livekit.EncodedFileType_OGG, "recording.mp4", OutputTypeOGG, true},
+		{livekit.EncodedFileType_OGG, "recording.webm", OutputTypeOGG, true},
+		{livekit.EncodedFileType_OGG, "recording.mkv", OutputTypeOGG, true},
+	} {
+		p := &Params{AudioParams: AudioParams{AudioEnabled: true}, VideoParams: VideoParams{VideoEnabled: true}}
+		err := p.updateFileOutputType(test.fileType, test.filepath)
+		if test.err {
+			require.Error(t, err, test.filepath)
+		} else {
+			require.NoError(t, err, test.filepath)
+		}
+		require.Equal(t, test.expected, p.OutputType, test.filepath)
+	}
+}
+
+func TestUpdateFilepathExtension(t *testing.T) {
+	for _, test := range []struct {
+		outputType OutputType
+		filepath   string
+		expected   string
+	}{
+		{OutputTypeMP4, "recording", "recording.mp4"},
+		{OutputTypeMP4, "recording.mp4", "recording.mp4"},
+		{OutputTypeMP4, "recording.MP4", "recording.MP4"},
+		{OutputTypeMP4, "recording.v2", "recording.v2.mp4"},
+		{OutputTypeOGG, "recording", "recording.ogg"},
+		{OutputTypeWebM, "recording.WEBM", "recording.WEBM"},
+		{OutputTypeMKV, "recording", "recording.mkv"},
+		// track egress output types depend on the track codec
+		{OutputTypeIVF, "recording.ogg", "recording.ivf"},
+		{OutputTypeOGG, "recording.IVF", "recording.ogg"},
+	} {
+		p := &Params{
+			Logger:     logger.Logger(logger.GetLogger()),
+			OutputType: test.outputType,
+			FileParams: FileParams{
+				StorageFilepath: test.filepath,
+				FileInfo:        &livekit.FileInfo{},
+			},
+		}
+		require.NoError(t, p.updateFilepath("", nil))
+		require.Equal(t, test.expected, p.StorageFilepath)
+		require.Equal(t, test.expected, p.FileInfo.Filename)
+	}
+}
+
+func TestUpdateTrackOutputType(t *testing.T) {
+	for _, test := range []struct {
+		codec    MimeType
+		filepath string
+		expected OutputType
+		err      bool
+	}{
+		{MimeTypeOpus, "track", OutputTypeOGG, false},
+		{MimeTypeOpus, "track.webm", OutputTypeWebM, false},
+		{MimeTypeVP8, "track", OutputTypeWebM, false},
+		{MimeTypeVP8, "track.ivf", OutputTypeIVF, false},
+		{MimeTypeVP8, "track.IVF", OutputTypeIVF, false},
+		{MimeTypeVP8, "track.mkv", OutputTypeMKV, false},
+		{MimeTypeH264, "track", OutputTypeMP4, false},
+		{MimeTypeH264, "track.mp4", OutputTypeMP4, false},
+		{MimeTypeVP9, "track", "", true},
+	} {
+		p := &Params{
+			conf:       &config.Config{},
+			FileParams: FileParams{StorageFilepath: test.filepath},
+		}
+		err := p.UpdateTrackOutputType(test.codec)
+		if test.err {
+			require.Error(t, err, test.filepath)
+		} else {
+			require.NoError(t, err, test.filepath)
+		}
+		require.Equal(t, test.expected, p.OutputType, test.filepath)
+	}
+}
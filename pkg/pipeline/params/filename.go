@@ -0,0 +1,84 @@
+package params
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+const (
+	filenameTimeFormat = "2006-01-02T150405"
+	filenameDateFormat = "2006-01-02"
+)
+
+var (
+	templateRegexp = regexp.MustCompile(`\{[^{}/]*\}`)
+
+	// characters which are unsafe in s3 keys or local paths. Slashes are included so that a value can't add directories
+	invalidFilenameChars = regexp.MustCompile("[/\\\\:*?\"<>|#%{}^~\\[\\]`\\x00-\\x1f\\x7f]")
+
+	// templates filled in once the egress has subscribed to its tracks
+	trackCompositeTemplates = []string{"{publisher_identity}"}
+	trackTemplates          = []string{"{publisher_identity}", "{track_id}", "{track_type}", "{track_source}"}
+)
+
+// getFilenameReplacements returns the values of templates known when the request is received
+func (p *Params) getFilenameReplacements() map[string]string {
+	now := time.Now()
+	return map[string]string{
+		"{room_name}": p.Info.RoomName,
+		"{room_id}":   p.Info.RoomId,
+		"{egress_id}": p.Info.EgressId,
+		"{time}":      now.Format(filenameTimeFormat),
+		"{date}":      now.Format(filenameDateFormat),
+	}
+}
+
+// verifyTemplates returns an error if s contains a template which is not supported for this egress type
+func (p *Params) verifyTemplates(field, s string, replacements map[string]string) error {
+	var allowed []string
+	switch p.Info.Request.(type) {
+	case *livekit.EgressInfo_TrackComposite:
+		allowed = trackCompositeTemplates
+	case *livekit.EgressInfo_Track:
+		allowed = trackTemplates
+	}
+
+	for _, template := range templateRegexp.FindAllString(s, -1) {
+		if _, ok := replacements[template]; ok {
+			continue
+		}
+		known := false
+		for _, t := range allowed {
+			if t == template {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return errors.ErrInvalidInput(fmt.Sprintf("%s (unknown template %s)", field, template))
+		}
+	}
+
+	return nil
+}
+
+// sanitizeFilename replaces characters which can't be used in a filename
+func sanitizeFilename(s string) string {
+	return invalidFilenameChars.ReplaceAllString(s, "_")
+}
+
+// templateReplace fills in templates, escaping values with escape if it is set
+func templateReplace(s string, replacements map[string]string, escape func(string) string) string {
+	for template, value := range replacements {
+		if escape != nil {
+			value = escape(value)
+		}
+		s = strings.Replace(s, template, value, -1)
+	}
+	return s
+}
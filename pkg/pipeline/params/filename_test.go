@@ -0,0 +1,56 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestFilenameTemplates(t *testing.T) {
+	p := &Params{
+		Info: &livekit.EgressInfo{
+			EgressId: "EG_123",
+			RoomName: "a/b: c?",
+			Request:  &livekit.EgressInfo_RoomComposite{},
+		},
+	}
+	replacements := p.getFilenameReplacements()
+
+	require.NoError(t, p.verifyTemplates("Filepath", "{date}/{room_name}-{egress_id}-{time}.mp4", replacements))
+	require.Equal(t, "a_b_ c_-EG_123.mp4", stringReplace("{room_name}-{egress_id}.mp4", replacements))
+
+	require.Error(t, p.verifyTemplates("Filepath", "{rom_name}.mp4", replacements))
+	require.Error(t, p.verifyTemplates("Filepath", "{track_id}.mp4", replacements))
+
+	p.Info.Request = &livekit.EgressInfo_Track{}
+	require.NoError(t, p.verifyTemplates("Filepath", "{publisher_identity}/{track_id}.mp4", replacements))
+}
+
+func TestUploadConfigTemplates(t *testing.T) {
+	defaults := &livekit.S3Upload{
+		AccessKey: "key",
+		Secret:    "secret",
+		Region:    "us-west-2",
+		Bucket:    "bucket",
+		Metadata:  map[string]string{"room": "{room_name}"},
+		Tagging:   "room_id={room_id}&egress={egress_id}",
+	}
+	p := &Params{
+		conf: &config.Config{},
+		Info: &livekit.EgressInfo{EgressId: "EG_123", RoomId: "RM_123", RoomName: "my room"},
+		UploadParams: UploadParams{
+			UploadConfig: defaults,
+		},
+	}
+
+	require.NoError(t, p.updateUploadConfig())
+	u := p.UploadConfig.(*livekit.S3Upload)
+	require.Equal(t, "my room", u.Metadata["room"])
+	require.Equal(t, "room_id=RM_123&egress=EG_123", u.Tagging)
+
+	// the node defaults are unchanged
+	require.Equal(t, "{room_name}", defaults.Metadata["room"])
+}
@@ -0,0 +1,83 @@
+package params
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultFramerate = 30
+	minFramerate     = 1
+	maxFramerate     = 60
+)
+
+// Framerate is a number of frames per second, kept as a fraction so that ntsc rates like 29.97 (30000/1001) are exact
+type Framerate struct {
+	Num int32
+	Den int32
+}
+
+// ntsc rates are written as decimals, but are whole rates slowed by 1000/1001
+var ntscFramerates = map[float64]int32{
+	23.976: 24,
+	29.97:  30,
+	59.94:  60,
+}
+
+// NewFramerate returns a whole frame rate, or its ntsc rate if ntsc is set and it has one
+func NewFramerate(fps int32, ntsc bool) Framerate {
+	if ntsc {
+		for _, whole := range ntscFramerates {
+			if whole == fps {
+				return Framerate{Num: fps * 1000, Den: 1001}
+			}
+		}
+	}
+	return Framerate{Num: fps, Den: 1}
+}
+
+// FramerateFromFloat converts a decimal frame rate, such as 25, 29.97 or 12.5
+func FramerateFromFloat(fps float64, ntsc bool) Framerate {
+	if whole, ok := ntscFramerates[fps]; ok {
+		return Framerate{Num: whole * 1000, Den: 1001}
+	}
+	if fps == math.Trunc(fps) {
+		return NewFramerate(int32(fps), ntsc)
+	}
+
+	// milliframes per second, reduced
+	num, den := int32(math.Round(fps*1000)), int32(1000)
+	for _, f := range []int32{2, 5} {
+		for num%f == 0 && den%f == 0 {
+			num, den = num/f, den/f
+		}
+	}
+	return Framerate{Num: num, Den: den}
+}
+
+func (f Framerate) Float() float64 {
+	return float64(f.Num) / float64(f.Den)
+}
+
+// String returns the fraction, as used in caps
+func (f Framerate) String() string {
+	return fmt.Sprintf("%d/%d", f.Num, f.Den)
+}
+
+// Frames returns the whole number of frames closest to a duration in seconds, and at least one
+func (f Framerate) Frames(seconds float64) int {
+	frames := int(math.Round(seconds * f.Float()))
+	if frames < 1 {
+		return 1
+	}
+	return frames
+}
+
+// Seconds returns the duration of a number of frames
+func (f Framerate) Seconds(frames int) float64 {
+	return float64(frames) * float64(f.Den) / float64(f.Num)
+}
+
+func (f Framerate) valid() bool {
+	return f.Den > 0 && f.Float() >= minFramerate && f.Float() <= maxFramerate
+}
@@ -0,0 +1,42 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestFramerate(t *testing.T) {
+	require.Equal(t, Framerate{Num: 25, Den: 1}, FramerateFromFloat(25, false))
+	require.Equal(t, Framerate{Num: 30000, Den: 1001}, FramerateFromFloat(29.97, false))
+	require.Equal(t, Framerate{Num: 25, Den: 2}, FramerateFromFloat(12.5, false))
+	require.Equal(t, "30000/1001", NewFramerate(30, true).String())
+	require.Equal(t, "25/1", NewFramerate(25, true).String())
+
+	// key frames land on whole frames
+	ntsc := NewFramerate(30, true)
+	require.Equal(t, 60, ntsc.Frames(2))
+	require.InDelta(t, 2.002, ntsc.Seconds(60), 0.0001)
+	require.Equal(t, 1, ntsc.Frames(0.01))
+
+	require.Equal(t, int32(4500), scaleVideoBitrate(1920, 1080, NewFramerate(30, false)))
+	require.Equal(t, int32(6000), scaleVideoBitrate(1920, 1080, NewFramerate(60, false)))
+	require.Equal(t, int32(3750), scaleVideoBitrate(1920, 1080, NewFramerate(15, false)))
+}
+
+func TestApplyFramerate(t *testing.T) {
+	p := &Params{conf: &config.Config{}, VideoParams: VideoParams{Width: 1920, Height: 1080}}
+	require.NoError(t, p.applyAdvanced(&livekit.EncodingOptions{Framerate: 25}))
+	require.Equal(t, NewFramerate(25, false), p.Framerate)
+	require.Equal(t, int32(4250), p.VideoBitrate)
+
+	require.Error(t, p.applyAdvanced(&livekit.EncodingOptions{Framerate: 61}))
+	require.Error(t, p.applyAdvanced(&livekit.EncodingOptions{Framerate: -1}))
+
+	p.conf.VideoEncoding.NTSC = true
+	p.applyPreset(livekit.EncodingOptionsPreset_H264_720P_60)
+	require.Equal(t, Framerate{Num: 60000, Den: 1001}, p.Framerate)
+}
@@ -0,0 +1,45 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestLowLatencySegments(t *testing.T) {
+	p := &Params{
+		SegmentedFileParams: SegmentedFileParams{
+			LocalFilePrefix: "/tmp/EG_123/room",
+			SegmentDuration: 6,
+		},
+	}
+	require.False(t, p.LowLatency())
+	require.Equal(t, time.Second*6, p.GetSplitDuration())
+	require.Equal(t, "/tmp/EG_123/room_%05d.ts", p.GetMuxLocation())
+
+	p.PartDuration = time.Millisecond * 500
+	require.True(t, p.LowLatency())
+	require.Equal(t, time.Millisecond*500, p.GetSplitDuration())
+	require.Equal(t, 12, p.GetPartsPerSegment())
+	require.Equal(t, "/tmp/EG_123/room_part_%05d.ts", p.GetMuxLocation())
+	require.Equal(t, "/tmp/EG_123/room_part_00013.ts", p.GetPartFilepath(13))
+	require.Equal(t, "/tmp/EG_123/room_00002.ts", p.GetSegmentFilepath(2))
+}
+
+func TestLowLatencyPartDuration(t *testing.T) {
+	p := &Params{
+		conf: &config.Config{
+			LowLatencyHLS: config.LowLatencyHLSConfig{Enabled: true, PartDuration: time.Millisecond * 700},
+		},
+		Info: &livekit.EgressInfo{},
+	}
+
+	// segments must be made of whole parts
+	err := p.updateSegmentsParams("room", "", 6, nil)
+	require.EqualError(t, err, errors.ErrInvalidInput("SegmentDuration").Error())
+}
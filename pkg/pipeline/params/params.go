@@ -5,25 +5,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"google.golang.org/protobuf/proto"
+
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/egress/pkg/stats"
 	"github.com/livekit/protocol/egress"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/tracer"
 )
 
+const (
+	defaultWidth        = 1920
+	defaultHeight       = 1080
+	defaultVideoBitrate = 4500
+
+	minVideoDimension = 16
+	maxVideoDimension = 3840
+	minVideoBitrate   = 500
+
+	minAudioBitrate = 32
+	maxAudioBitrate = 320
+)
+
 type Params struct {
 	conf *config.Config
 
 	Logger   logger.Logger
 	Info     *livekit.EgressInfo
 	GstReady chan struct{}
+	*Redactor
+
+	EOSTimeout time.Duration       // how long to wait for the pipeline to flush once stopped
+	AVSync     config.AVSyncConfig // drift measurement and correction at the muxer
+	Warnings   []string            // problems which didn't fail the egress, recorded in the manifest
 
 	SourceParams
 	AudioParams
@@ -36,8 +60,14 @@ type Params struct {
 	StreamParams
 	FileParams
 	SegmentedFileParams
+	ThumbnailParams
+	RoomEventParams
+	DataCaptureParams
+	RestartParams
 
 	UploadParams
+
+	ScheduledStop ScheduledStop // timed by the service, which tells the handler once it's reached
 }
 
 type SourceParams struct {
@@ -52,6 +82,17 @@ type SourceParams struct {
 	CustomBase string
 	WebUrl     string
 
+	ChromeLogLevel    string // the lowest severity of chrome messages logged
+	ChromeLogMaxLines int    // chrome messages logged before the rest are dropped
+
+	// room composite
+	RoomEmptyTimeout time.Duration // end once the room has been empty this long, 0 to keep recording
+	RoomEmptyTracks  bool          // a room without published tracks is empty
+	EndReason        string        // why the egress ended on its own, if it did
+	StartTimeout     time.Duration // how long to wait for the template's start signal, 0 to wait indefinitely
+	StartTimeoutFail bool          // fail instead of starting when the start signal times out
+	StartDelay       time.Duration // time spent waiting for the start signal
+
 	// sdk source
 	TrackID             string
 	TrackSource         string
@@ -59,6 +100,15 @@ type SourceParams struct {
 	AudioTrackID        string
 	VideoTrackID        string
 	ParticipantIdentity string
+	Passthrough         bool                 // remux track payloads without decoding
+	VideoQuality        livekit.VideoQuality // simulcast layer to subscribe to
+	JitterLatency       time.Duration        // max time to wait for missing packets, 0 for the default
+	PLIRetryInterval    time.Duration        // between key frame requests until the first key frame arrives
+	PLIMinInterval      time.Duration        // between later key frame requests
+
+	// track composite
+	RepublishTimeout     time.Duration // how long to wait for an unpublished track to be replaced, 0 to end instead
+	RepublishPlaceholder bool          // write blank video while waiting
 }
 
 type AudioParams struct {
@@ -66,6 +116,9 @@ type AudioParams struct {
 	AudioCodec     MimeType
 	AudioBitrate   int32
 	AudioFrequency int32
+
+	Loudness    *config.LoudnessConfig    // normalization of mixed audio before it's encoded
+	AudioFilter *config.AudioFilterConfig // clean up of decoded audio before it's encoded or sent
 }
 
 type VideoParams struct {
@@ -75,19 +128,42 @@ type VideoParams struct {
 	Width        int32
 	Height       int32
 	Depth        int32
-	Framerate    int32
+	Framerate    Framerate
 	VideoBitrate int32
+
+	// set from the encoder and video_encoding config
+	VideoEncoder     string
+	KeyFrameInterval float64 // seconds, 0 uses the encoder default
+	RateControl      RateControl
+	Quantizer        uint // used by cqp and vbr, 0 uses the encoder default
+
+	Crop         *CropParams
+	Watermark    *WatermarkParams
+	ClockOverlay *config.ClockOverlayConfig
+
+	// measured while recording, and recorded in the manifest
+	FramesDropped int64         // by videorate, because the encoder or compositor fell behind
+	MaxQueueDepth int           // most frames seen waiting to be encoded
+	MaxDrift      time.Duration // largest a/v drift measured at the muxer, either way
 }
 
 type StreamParams struct {
-	WebsocketUrl string
-	StreamUrls   []string
-	StreamInfo   map[string]*livekit.StreamInfo
+	WebsocketUrl  string
+	WebsocketConf config.WebsocketConfig
+	StreamUrls    []string
+	StreamInfo    map[string]*livekit.StreamInfo
 }
 
 type FileParams struct {
-	FileInfo        *livekit.FileInfo
-	LocalFilepath   string
+	FileInfo          *livekit.FileInfo
+	LocalFilepath     string
+	Faststart         bool // write the mp4 moov before the media
+	ProgressiveUpload bool // upload the file while it is written
+
+	// limits for splitting the output into chunks, 0 for no limit
+	SplitSize       uint64
+	SplitDuration   time.Duration
+	FileChunks      []*FileChunk
 	StorageFilepath string
 }
 
@@ -97,11 +173,37 @@ type SegmentedFileParams struct {
 	StoragePathPrefix string
 	PlaylistFilename  string
 	SegmentDuration   int
+	PartDuration      time.Duration // low-latency HLS parts, 0 when segments are written whole
+
+	// DASH manifest listing the same segments, if enabled
+	DASHManifestFilename string
+	DASHManifestLocation string
 }
 
 type UploadParams struct {
 	UploadConfig    interface{}
 	DisableManifest bool
+	RetainedPath    string // where local files are moved after upload, if they are kept
+}
+
+// LogValues identify the egress on each of its log lines. The node ID is added by the base logger
+func LogValues(request *livekit.StartEgressRequest) []interface{} {
+	values := []interface{}{"egressID", request.EgressId, "requestType", stats.EgressType(request)}
+
+	var roomName string
+	switch req := request.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		roomName = req.RoomComposite.RoomName
+	case *livekit.StartEgressRequest_TrackComposite:
+		roomName = req.TrackComposite.RoomName
+	case *livekit.StartEgressRequest_Track:
+		roomName = req.Track.RoomName
+	}
+	if roomName != "" {
+		values = append(values, "roomName", roomName)
+	}
+
+	return values
 }
 
 func ValidateRequest(ctx context.Context, conf *config.Config, request *livekit.StartEgressRequest) (*livekit.EgressInfo, error) {
@@ -109,6 +211,9 @@ func ValidateRequest(ctx context.Context, conf *config.Config, request *livekit.
 	defer span.End()
 
 	p, err := getPipelineParams(conf, request)
+	if err == nil {
+		err = p.checkScheduledStop(time.Now())
+	}
 	return p.Info, err
 }
 
@@ -124,27 +229,52 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 	// start with defaults
 	p = &Params{
 		conf:   conf,
-		Logger: logger.Logger(logger.GetLogger().WithValues("egressID", request.EgressId)),
+		Logger: logger.Logger(logger.GetLogger().WithValues(LogValues(request)...)),
 		Info: &livekit.EgressInfo{
 			EgressId: request.EgressId,
 			RoomId:   request.RoomId,
 			Status:   livekit.EgressStatus_EGRESS_STARTING,
 		},
-		GstReady: make(chan struct{}),
+		GstReady:   make(chan struct{}),
+		EOSTimeout: conf.EOSTimeout,
+		AVSync:     conf.AVSync,
+		FileParams: FileParams{
+			Faststart: !conf.DisableFaststart,
+		},
+		Redactor: NewRedactor(conf.StreamKeyPattern),
 		AudioParams: AudioParams{
 			AudioBitrate:   128,
 			AudioFrequency: 44100,
 		},
 		VideoParams: VideoParams{
 			VideoProfile: ProfileMain,
-			Width:        1920,
-			Height:       1080,
+			Width:        defaultWidth,
+			Height:       defaultHeight,
 			Depth:        24,
-			Framerate:    30,
-			VideoBitrate: 4500,
+			Framerate:    defaultVideoFramerate(conf),
+			VideoBitrate: defaultVideoBitrate,
+
+			VideoEncoder:     conf.Encoder,
+			KeyFrameInterval: conf.VideoEncoding.KeyFrameInterval.Seconds(),
+			RateControl:      RateControlCBR,
+			Quantizer:        conf.VideoEncoding.Quantizer,
 		},
 	}
 
+	if conf.VideoEncoding.RateControl != "" {
+		p.RateControl = RateControl(conf.VideoEncoding.RateControl)
+	}
+	p.VideoQuality = livekit.VideoQuality_HIGH
+	if conf.VideoQuality != "" {
+		p.VideoQuality = livekit.VideoQuality(livekit.VideoQuality_value[strings.ToUpper(conf.VideoQuality)])
+	}
+	p.JitterLatency = conf.JitterBuffer.Latency
+	p.PLIRetryInterval = conf.PLI.RetryInterval
+	p.PLIMinInterval = conf.PLI.MinInterval
+	if p.ScheduledStop, err = GetScheduledStop(request); err != nil {
+		return
+	}
+
 	switch req := request.Request.(type) {
 	case *livekit.StartEgressRequest_RoomComposite:
 		p.Info.Request = &livekit.EgressInfo_RoomComposite{RoomComposite: req.RoomComposite}
@@ -156,8 +286,17 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 
 		// input params
 		p.Layout = req.RoomComposite.Layout
+		p.RoomEmptyTimeout = conf.RoomEmpty.Timeout
+		p.RoomEmptyTracks = conf.RoomEmpty.Tracks
+		p.StartTimeout = conf.StartSignal.Timeout
+		p.StartTimeoutFail = conf.StartSignal.OnTimeout == config.StartSignalFail
 		p.Display = fmt.Sprintf(":%d", 10+rand.Intn(2147483637))
+		p.ChromeLogLevel = conf.ChromeLogs.Level
+		p.ChromeLogMaxLines = conf.ChromeLogs.MaxLines
 		if req.RoomComposite.CustomBaseUrl != "" {
+			if err = checkTemplateBase(conf.TemplateAllowlist, req.RoomComposite.CustomBaseUrl); err != nil {
+				return
+			}
 			p.TemplateBase = req.RoomComposite.CustomBaseUrl
 		} else {
 			p.TemplateBase = conf.TemplateBase
@@ -175,20 +314,24 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 			p.applyPreset(opts.Preset)
 
 		case *livekit.RoomCompositeEgressRequest_Advanced:
-			p.applyAdvanced(opts.Advanced)
+			if err = p.applyAdvanced(opts.Advanced); err != nil {
+				return
+			}
 		}
 
 		// output params
 		switch o := req.RoomComposite.Output.(type) {
 		case *livekit.RoomCompositeEgressRequest_File:
 			p.DisableManifest = o.File.DisableManifest
-			p.updateOutputType(o.File.FileType)
+			if err = p.updateFileOutputType(o.File.FileType, o.File.Filepath); err != nil {
+				return
+			}
 			if err = p.updateFileParams(o.File.Filepath, o.File.Output); err != nil {
 				return
 			}
 
 		case *livekit.RoomCompositeEgressRequest_Stream:
-			if err = p.updateStreamParams(OutputTypeRTMP, o.Stream.Urls); err != nil {
+			if err = p.updateStreamParams(getStreamOutputType(o.Stream.Urls), o.Stream.Urls); err != nil {
 				return
 			}
 
@@ -214,6 +357,8 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 			return
 		}
 		p.Display = fmt.Sprintf(":%d", 10+rand.Intn(2147483637))
+		p.ChromeLogLevel = conf.ChromeLogs.Level
+		p.ChromeLogMaxLines = conf.ChromeLogs.MaxLines
 		p.AudioEnabled = !req.Web.VideoOnly
 		p.VideoEnabled = !req.Web.AudioOnly
 		if !p.AudioEnabled && !p.VideoEnabled {
@@ -227,20 +372,24 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 			p.applyPreset(opts.Preset)
 
 		case *livekit.WebEgressRequest_Advanced:
-			p.applyAdvanced(opts.Advanced)
+			if err = p.applyAdvanced(opts.Advanced); err != nil {
+				return
+			}
 		}
 
 		// output params
 		switch o := req.Web.Output.(type) {
 		case *livekit.WebEgressRequest_File:
 			p.DisableManifest = o.File.DisableManifest
-			p.updateOutputType(o.File.FileType)
+			if err = p.updateFileOutputType(o.File.FileType, o.File.Filepath); err != nil {
+				return
+			}
 			if err = p.updateFileParams(o.File.Filepath, o.File.Output); err != nil {
 				return
 			}
 
 		case *livekit.WebEgressRequest_Stream:
-			if err = p.updateStreamParams(OutputTypeRTMP, o.Stream.Urls); err != nil {
+			if err = p.updateStreamParams(getStreamOutputType(o.Stream.Urls), o.Stream.Urls); err != nil {
 				return
 			}
 
@@ -270,7 +419,9 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 			p.applyPreset(opts.Preset)
 
 		case *livekit.TrackCompositeEgressRequest_Advanced:
-			p.applyAdvanced(opts.Advanced)
+			if err = p.applyAdvanced(opts.Advanced); err != nil {
+				return
+			}
 		}
 
 		// input params
@@ -289,13 +440,21 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 			p.DisableManifest = o.File.DisableManifest
 			if o.File.FileType != livekit.EncodedFileType_DEFAULT_FILETYPE {
 				p.updateOutputType(o.File.FileType)
+				if err = p.verifyFileExtension(o.File.Filepath); err != nil {
+					return
+				}
+			} else {
+				// otherwise the output type is chosen once the tracks are known
+				p.inferFileOutputType(o.File.Filepath)
 			}
+			// tracks are remuxed without transcoding
+			p.Passthrough = p.OutputType == OutputTypeMKV
 			if err = p.updateFileParams(o.File.Filepath, o.File.Output); err != nil {
 				return
 			}
 
 		case *livekit.TrackCompositeEgressRequest_Stream:
-			if err = p.updateStreamParams(OutputTypeRTMP, o.Stream.Urls); err != nil {
+			if err = p.updateStreamParams(getStreamOutputType(o.Stream.Urls), o.Stream.Urls); err != nil {
 				return
 			}
 
@@ -311,6 +470,10 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 			return
 		}
 
+		p.RepublishTimeout = conf.Republish.Timeout
+		// blank frames can only be written when the video is decoded
+		p.RepublishPlaceholder = conf.Republish.Placeholder && !p.Passthrough
+
 	case *livekit.StartEgressRequest_Track:
 		p.Info.Request = &livekit.EgressInfo_Track{Track: req.Track}
 		p.Info.RoomName = req.Track.RoomName
@@ -329,6 +492,8 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 		switch o := req.Track.Output.(type) {
 		case *livekit.TrackEgressRequest_File:
 			p.DisableManifest = o.File.DisableManifest
+			// the depayloaded track is remuxed into a container chosen once its codec is known
+			p.Passthrough = true
 			if err = p.updateFileParams(o.File.Filepath, o.File.Output); err != nil {
 				return
 			}
@@ -357,6 +522,45 @@ func getPipelineParams(conf *config.Config, request *livekit.StartEgressRequest)
 		}
 	}
 
+	// web sources capture the display
+	if p.VideoEnabled && p.Display != "" && conf.Crop != nil {
+		if err = p.updateCrop(conf.Crop); err != nil {
+			return
+		}
+	}
+	if p.VideoEnabled && p.Display != "" && conf.Thumbnails.Interval > 0 {
+		p.updateThumbnails(conf.Thumbnails)
+	}
+	if p.Info.GetRoomComposite() != nil && conf.RoomEvents.Enabled {
+		p.updateRoomEvents(conf.RoomEvents)
+	}
+	if (p.Info.GetRoomComposite() != nil || p.Info.GetTrackComposite() != nil) && conf.DataCapture.Enabled {
+		p.updateDataCapture(conf.DataCapture)
+	}
+	if conf.PipelineRestart.MaxRestarts > 0 {
+		p.updateRestarts(conf.PipelineRestart)
+	}
+
+	if p.VideoEnabled && !p.Passthrough {
+		if p.KeyFrameInterval > 0 {
+			// key frames can only be placed on frames
+			p.KeyFrameInterval = p.Framerate.Seconds(p.Framerate.Frames(p.KeyFrameInterval))
+		}
+		if conf.Watermark != nil {
+			if err = p.updateWatermark(conf.Watermark); err != nil {
+				return
+			}
+		}
+		p.ClockOverlay = conf.ClockOverlay
+	}
+	if p.AudioEnabled && !p.Passthrough {
+		p.Loudness = conf.Loudness
+	}
+	// track egress only knows whether it has audio once subscribed
+	if !p.Passthrough && conf.AudioFilter != nil && (conf.AudioFilter.HighpassCutoff > 0 || conf.AudioFilter.NoiseSuppression) {
+		p.AudioFilter = conf.AudioFilter
+	}
+
 	return
 }
 
@@ -370,13 +574,13 @@ func (p *Params) applyPreset(preset livekit.EncodingOptionsPreset) {
 	case livekit.EncodingOptionsPreset_H264_720P_60:
 		p.Width = 1280
 		p.Height = 720
-		p.Framerate = 60
+		p.Framerate = NewFramerate(60, p.conf.VideoEncoding.NTSC)
 
 	case livekit.EncodingOptionsPreset_H264_1080P_30:
 		// default
 
 	case livekit.EncodingOptionsPreset_H264_1080P_60:
-		p.Framerate = 60
+		p.Framerate = NewFramerate(60, p.conf.VideoEncoding.NTSC)
 		p.VideoBitrate = 6000
 
 	case livekit.EncodingOptionsPreset_PORTRAIT_H264_720P_30:
@@ -387,7 +591,7 @@ func (p *Params) applyPreset(preset livekit.EncodingOptionsPreset) {
 	case livekit.EncodingOptionsPreset_PORTRAIT_H264_720P_60:
 		p.Width = 720
 		p.Height = 1280
-		p.Framerate = 60
+		p.Framerate = NewFramerate(60, p.conf.VideoEncoding.NTSC)
 
 	case livekit.EncodingOptionsPreset_PORTRAIT_H264_1080P_30:
 		p.Width = 1080
@@ -396,12 +600,12 @@ func (p *Params) applyPreset(preset livekit.EncodingOptionsPreset) {
 	case livekit.EncodingOptionsPreset_PORTRAIT_H264_1080P_60:
 		p.Width = 1080
 		p.Height = 1920
-		p.Framerate = 60
+		p.Framerate = NewFramerate(60, p.conf.VideoEncoding.NTSC)
 		p.VideoBitrate = 6000
 	}
 }
 
-func (p *Params) applyAdvanced(advanced *livekit.EncodingOptions) {
+func (p *Params) applyAdvanced(advanced *livekit.EncodingOptions) error {
 	// audio
 	switch advanced.AudioCodec {
 	case livekit.AudioCodec_OPUS:
@@ -411,9 +615,16 @@ func (p *Params) applyAdvanced(advanced *livekit.EncodingOptions) {
 	}
 
 	if advanced.AudioBitrate != 0 {
+		if advanced.AudioBitrate < minAudioBitrate || advanced.AudioBitrate > maxAudioBitrate {
+			return errors.ErrInvalidInput("AudioBitrate")
+		}
 		p.AudioBitrate = advanced.AudioBitrate
 	}
 	if advanced.AudioFrequency != 0 {
+		// opus is always encoded at 48kHz
+		if advanced.AudioFrequency != 44100 && advanced.AudioFrequency != 48000 {
+			return errors.ErrInvalidInput("AudioFrequency")
+		}
 		p.AudioFrequency = advanced.AudioFrequency
 	}
 
@@ -437,15 +648,60 @@ func (p *Params) applyAdvanced(advanced *livekit.EncodingOptions) {
 	if advanced.Height != 0 {
 		p.Height = advanced.Height
 	}
+	if err := verifyDimensions(p.Width, p.Height); err != nil {
+		return err
+	}
 	if advanced.Depth != 0 {
 		p.Depth = advanced.Depth
 	}
 	if advanced.Framerate != 0 {
-		p.Framerate = advanced.Framerate
+		if advanced.Framerate < minFramerate || advanced.Framerate > maxFramerate {
+			return errors.ErrInvalidInput("Framerate")
+		}
+		p.Framerate = NewFramerate(advanced.Framerate, p.conf.VideoEncoding.NTSC)
 	}
 	if advanced.VideoBitrate != 0 {
+		if p.RateControl == RateControlCQP {
+			// the quantizer is fixed, so a bitrate can't be met
+			return errors.ErrBitrateWithCQP
+		}
 		p.VideoBitrate = advanced.VideoBitrate
+	} else {
+		p.VideoBitrate = scaleVideoBitrate(p.Width, p.Height, p.Framerate)
 	}
+
+	return nil
+}
+
+// verifyDimensions checks that the output can be encoded, since encoders require even dimensions
+func verifyDimensions(width, height int32) error {
+	if width < minVideoDimension || width > maxVideoDimension || width%2 != 0 {
+		return errors.ErrInvalidInput("Width")
+	}
+	if height < minVideoDimension || height > maxVideoDimension || height%2 != 0 {
+		return errors.ErrInvalidInput("Height")
+	}
+	return nil
+}
+
+// scaleVideoBitrate returns the default bitrate scaled by pixel count, relative to 1080p, and by frame rate,
+// relative to 30fps. Doubling the rate takes a third more bits, as in the 60fps presets
+func scaleVideoBitrate(width, height int32, framerate Framerate) int32 {
+	pixels := float64(width) * float64(height)
+	rate := 1 + (framerate.Float()/defaultFramerate-1)/3
+	bitrate := int32(defaultVideoBitrate * pixels / (defaultWidth * defaultHeight) * rate)
+	if bitrate < minVideoBitrate {
+		return minVideoBitrate
+	}
+	return bitrate
+}
+
+// defaultVideoFramerate returns the configured frame rate for requests which don't set one
+func defaultVideoFramerate(conf *config.Config) Framerate {
+	if conf.VideoEncoding.Framerate == 0 {
+		return NewFramerate(defaultFramerate, conf.VideoEncoding.NTSC)
+	}
+	return FramerateFromFloat(conf.VideoEncoding.Framerate, conf.VideoEncoding.NTSC)
 }
 
 func (p *Params) updateOutputType(fileType interface{}) {
@@ -473,11 +729,57 @@ func (p *Params) updateOutputType(fileType interface{}) {
 	}
 }
 
+// updateFileOutputType infers the output type from the filepath if no file type is given, since there are no
+// file types for webm or mkv. An explicit file type must match any extension given.
+func (p *Params) updateFileOutputType(fileType livekit.EncodedFileType, filepath string) error {
+	if fileType == livekit.EncodedFileType_DEFAULT_FILETYPE {
+		if !p.inferFileOutputType(filepath) {
+			p.updateOutputType(fileType)
+		}
+		return nil
+	}
+
+	p.updateOutputType(fileType)
+	return p.verifyFileExtension(filepath)
+}
+
+// inferFileOutputType sets the output type from the extension of filepath, returning false if it has none
+func (p *Params) inferFileOutputType(filepath string) bool {
+	outputType, ok := OutputTypeForFileExtension[getFileExtension(filepath)]
+	if ok {
+		p.OutputType = outputType
+	}
+	return ok
+}
+
+// verifyFileExtension returns an error if filepath has an extension for a different output type
+func (p *Params) verifyFileExtension(filepath string) error {
+	ext := getFileExtension(filepath)
+	if outputType, ok := OutputTypeForFileExtension[ext]; ok && outputType != p.OutputType {
+		return errors.ErrExtensionIncompatible(ext, p.OutputType)
+	}
+	return nil
+}
+
+// getFileExtension returns the lowercase extension of filepath, if it is a known file extension
+func getFileExtension(filepath string) FileExtension {
+	ext := FileExtension(strings.ToLower(path.Ext(filepath)))
+	if _, ok := FileExtensions[ext]; !ok {
+		return ""
+	}
+	return ext
+}
+
 func (p *Params) updateFileParams(storageFilepath string, output interface{}) error {
 	p.EgressType = EgressTypeFile
 	p.StorageFilepath = storageFilepath
 	p.FileInfo = &livekit.FileInfo{}
 	p.Info.Result = &livekit.EgressInfo_File{File: p.FileInfo}
+	if p.TrackID == "" {
+		// track egress is not transcoded, so it can't be split on key frames
+		p.SplitSize = uint64(p.conf.FileSplit.MaxSize * bytesPerGB)
+		p.SplitDuration = p.conf.FileSplit.MaxDuration
+	}
 
 	// output location
 	switch o := output.(type) {
@@ -500,12 +802,14 @@ func (p *Params) updateFileParams(storageFilepath string, output interface{}) er
 	default:
 		p.UploadConfig = p.conf.FileUpload
 	}
+	if err := p.updateUploadConfig(); err != nil {
+		return err
+	}
 
 	// filename
-	replacements := map[string]string{
-		"{room_name}": p.Info.RoomName,
-		"{room_id}":   p.Info.RoomId,
-		"{time}":      time.Now().Format("2006-01-02T150405"),
+	replacements := p.getFilenameReplacements()
+	if err := p.verifyTemplates("Filepath", p.StorageFilepath, replacements); err != nil {
+		return err
 	}
 	if p.OutputType != "" {
 		err := p.updateFilepath(p.Info.RoomName, replacements)
@@ -516,14 +820,53 @@ func (p *Params) updateFileParams(storageFilepath string, output interface{}) er
 		p.StorageFilepath = stringReplace(p.StorageFilepath, replacements)
 	}
 
+	p.ProgressiveUpload = p.canUploadProgressive()
+
 	return nil
 }
 
+// canUploadProgressive returns true if the output can be uploaded while it is written, which requires
+// a container which is only appended to and an s3 upload
+func (p *Params) canUploadProgressive() bool {
+	if !p.conf.Upload.Progressive || p.SplitFile() {
+		return false
+	}
+	if _, ok := p.UploadConfig.(*livekit.S3Upload); !ok {
+		return false
+	}
+	return progressiveOutputTypes[p.OutputType]
+}
+
+// updateUploadConfig fills in templates in s3 metadata and tagging, so that objects can be tagged with the room,
+// then checks the upload config
+func (p *Params) updateUploadConfig() error {
+	if p.UploadConfig != nil && p.conf.Retention != nil {
+		p.RetainedPath = path.Join(p.conf.Retention.Directory, p.Info.EgressId)
+	}
+
+	if u, ok := p.UploadConfig.(*livekit.S3Upload); ok && (len(u.Metadata) > 0 || u.Tagging != "") {
+		// node defaults are shared
+		u = proto.Clone(u).(*livekit.S3Upload)
+		replacements := p.getFilenameReplacements()
+		for k, v := range u.Metadata {
+			u.Metadata[k] = templateReplace(v, replacements, nil)
+		}
+		// egress options, not object metadata
+		delete(u.Metadata, ScheduledStopMaxDurationKey)
+		delete(u.Metadata, ScheduledStopEndAtKey)
+		u.Tagging = templateReplace(u.Tagging, replacements, url.QueryEscape)
+		p.UploadConfig = u
+	}
+
+	_, err := uploader.New(p.conf, p.UploadConfig, nil)
+	return err
+}
+
 func (p *Params) updateStreamParams(outputType OutputType, urls []string) error {
 	p.OutputType = outputType
 
 	switch p.OutputType {
-	case OutputTypeRTMP:
+	case OutputTypeRTMP, OutputTypeSRT:
 		p.EgressType = EgressTypeStream
 		p.AudioCodec = MimeTypeAAC
 		p.VideoCodec = MimeTypeH264
@@ -533,6 +876,7 @@ func (p *Params) updateStreamParams(outputType OutputType, urls []string) error
 		p.EgressType = EgressTypeWebsocket
 		p.AudioCodec = MimeTypeRaw
 		p.WebsocketUrl = urls[0]
+		p.WebsocketConf = p.conf.Websocket
 		p.MutedChan = make(chan bool, 1)
 	}
 
@@ -543,7 +887,7 @@ func (p *Params) updateStreamParams(outputType OutputType, urls []string) error
 			return err
 		}
 
-		info := &livekit.StreamInfo{Url: url}
+		info := &livekit.StreamInfo{Url: p.StreamInfoUrl(url)}
 		p.StreamInfo[url] = info
 		streamInfoList = append(streamInfoList, info)
 	}
@@ -552,6 +896,15 @@ func (p *Params) updateStreamParams(outputType OutputType, urls []string) error
 	return nil
 }
 
+// getStreamOutputType returns the output type for the scheme of the first url. Other urls must use the same protocol,
+// since all outputs share a muxer
+func getStreamOutputType(urls []string) OutputType {
+	if len(urls) > 0 && strings.HasPrefix(urls[0], "srt://") {
+		return OutputTypeSRT
+	}
+	return OutputTypeRTMP
+}
+
 func (p *Params) updateSegmentsParams(filePrefix string, playlistFilename string, segmentDuration uint32, output interface{}) error {
 	p.EgressType = EgressTypeSegmentedFile
 	p.LocalFilePrefix = filePrefix
@@ -560,6 +913,17 @@ func (p *Params) updateSegmentsParams(filePrefix string, playlistFilename string
 	if p.SegmentDuration == 0 {
 		p.SegmentDuration = 6
 	}
+	if p.conf.LowLatencyHLS.Enabled {
+		p.PartDuration = p.conf.LowLatencyHLS.PartDuration
+		if (time.Duration(p.SegmentDuration)*time.Second)%p.PartDuration != 0 {
+			// segments are made of whole parts
+			return errors.ErrInvalidInput("SegmentDuration")
+		}
+	}
+	if p.KeyFrameInterval > 0 && p.Framerate.Frames(p.GetSplitDuration().Seconds())%p.Framerate.Frames(p.KeyFrameInterval) != 0 {
+		// segments are split on key frames, so they would not be of equal length
+		return errors.ErrInvalidInput("SegmentDuration")
+	}
 	p.SegmentsInfo = &livekit.SegmentsInfo{}
 	p.Info.Result = &livekit.EgressInfo_Segments{Segments: p.SegmentsInfo}
 
@@ -576,16 +940,24 @@ func (p *Params) updateSegmentsParams(filePrefix string, playlistFilename string
 	default:
 		p.UploadConfig = p.conf.FileUpload
 	}
+	if err := p.updateUploadConfig(); err != nil {
+		return err
+	}
 
 	// filename
-	err := p.UpdatePrefixAndPlaylist(p.Info.RoomName, map[string]string{
-		"{room_name}": p.Info.RoomName,
-		"{room_id}":   p.Info.RoomId,
-		"{time}":      time.Now().Format("2006-01-02T150405"),
-	})
-	if err != nil {
+	replacements := p.getFilenameReplacements()
+	if err := p.verifyTemplates("FilenamePrefix", p.LocalFilePrefix, replacements); err != nil {
+		return err
+	}
+	if err := p.verifyTemplates("PlaylistName", p.PlaylistFilename, replacements); err != nil {
+		return err
+	}
+	if err := p.UpdatePrefixAndPlaylist(p.Info.RoomName, replacements); err != nil {
 		return err
 	}
+	if p.conf.DASHManifest {
+		p.DASHManifestFilename = strings.TrimSuffix(p.PlaylistFilename, path.Ext(p.PlaylistFilename)) + FileExtensionMPD
+	}
 
 	return nil
 }
@@ -631,14 +1003,39 @@ func (p *Params) updateCodecs() error {
 	if p.VideoEnabled {
 		if p.VideoCodec == "" {
 			p.VideoCodec = DefaultVideoCodecs[p.OutputType]
+			if p.VideoCodec == "" {
+				// audio only format, such as ogg
+				return errors.ErrIncompatible(p.OutputType, "video")
+			}
 		} else if !codecCompatibility[p.OutputType][p.VideoCodec] {
 			return errors.ErrIncompatible(p.OutputType, p.VideoCodec)
 		}
+
+		if p.RateControl == RateControlCQP && p.VideoCodec != MimeTypeH264 && !p.Passthrough {
+			return errors.ErrNotSupported(fmt.Sprintf("%s rate control with %s", p.RateControl, p.VideoCodec))
+		}
 	}
 
 	return nil
 }
 
+// UpdateTrackOutputType chooses the container for track egress to a file. The filepath extension is used if it has one,
+// otherwise the default container for the track codec
+func (p *Params) UpdateTrackOutputType(codec MimeType) error {
+	if getFileExtension(p.StorageFilepath) == FileExtensionIVF {
+		p.OutputType = OutputTypeIVF
+	} else if !p.inferFileOutputType(p.StorageFilepath) {
+		outputType, ok := TrackOutputTypes[codec]
+		if !ok {
+			return errors.ErrNotSupported(fmt.Sprintf("track egress of %s to a file", codec))
+		}
+		p.OutputType = outputType
+	}
+
+	p.ProgressiveUpload = p.canUploadProgressive()
+	return nil
+}
+
 // used for sdk input source
 func (p *Params) UpdateFileInfoFromSDK(fileIdentifier string, replacements map[string]string) error {
 	if p.OutputType == "" {
@@ -671,16 +1068,10 @@ func (p *Params) updateFilepath(identifier string, replacements map[string]strin
 
 	if p.StorageFilepath == "" || strings.HasSuffix(p.StorageFilepath, "/") {
 		// generate filepath
-		p.StorageFilepath = fmt.Sprintf("%s%s-%s%s", p.StorageFilepath, identifier, time.Now().Format("2006-01-02T150405"), ext)
-	} else if !strings.HasSuffix(p.StorageFilepath, string(ext)) {
-		// check for existing (incorrect) extension
-		extIdx := strings.LastIndex(p.StorageFilepath, ".")
-		if extIdx > 0 {
-			existingExt := FileExtension(p.StorageFilepath[extIdx:])
-			if _, ok := FileExtensions[existingExt]; ok {
-				p.StorageFilepath = p.StorageFilepath[:extIdx]
-			}
-		}
+		p.StorageFilepath = fmt.Sprintf("%s%s-%s%s", p.StorageFilepath, sanitizeFilename(identifier), time.Now().Format(filenameTimeFormat), ext)
+	} else if existingExt := getFileExtension(p.StorageFilepath); existingExt != ext {
+		// remove existing (incorrect) extension
+		p.StorageFilepath = p.StorageFilepath[:len(p.StorageFilepath)-len(existingExt)]
 		// add file extension
 		p.StorageFilepath = p.StorageFilepath + string(ext)
 	}
@@ -723,13 +1114,13 @@ func (p *Params) UpdatePrefixAndPlaylist(identifier string, replacements map[str
 	ext := FileExtensionForOutputType[p.OutputType]
 
 	if p.LocalFilePrefix == "" || strings.HasSuffix(p.LocalFilePrefix, "/") {
-		p.LocalFilePrefix = fmt.Sprintf("%s%s-%s", p.LocalFilePrefix, identifier, time.Now().String())
+		p.LocalFilePrefix = fmt.Sprintf("%s%s-%s", p.LocalFilePrefix, sanitizeFilename(identifier), time.Now().Format(filenameTimeFormat))
 	}
 
 	// Playlist path is relative to file prefix. Only keep actual filename if a full path is given
 	_, p.PlaylistFilename = path.Split(p.PlaylistFilename)
 	if p.PlaylistFilename == "" {
-		p.PlaylistFilename = fmt.Sprintf("playlist-%s%s", identifier, ext)
+		p.PlaylistFilename = fmt.Sprintf("playlist-%s%s", sanitizeFilename(identifier), ext)
 	}
 
 	var filePrefix string
@@ -770,20 +1161,89 @@ func (p *Params) VerifyUrl(url string) error {
 
 	switch p.OutputType {
 	case OutputTypeRTMP:
+		if strings.HasPrefix(url, "srt://") {
+			return errors.ErrNotSupported("mixing rtmp and srt urls")
+		}
 		protocol = "rtmp"
 		prefix = "rtmp"
+	case OutputTypeSRT:
+		if strings.HasPrefix(url, "rtmp://") || strings.HasPrefix(url, "rtmps://") {
+			return errors.ErrNotSupported("mixing rtmp and srt urls")
+		}
+		if !strings.HasPrefix(url, "srt://") {
+			return errors.ErrInvalidUrl(p.RedactUrl(url), "srt")
+		}
+		return p.verifySRTOptions(url)
 	case OutputTypeRaw:
 		protocol = "websocket"
 		prefix = "ws"
 	}
 
 	if !strings.HasPrefix(url, prefix+"://") && !strings.HasPrefix(url, prefix+"s://") {
-		return errors.ErrInvalidUrl(url, protocol)
+		return errors.ErrInvalidUrl(p.RedactUrl(url), protocol)
+	}
+
+	return nil
+}
+
+// verifySRTOptions checks the options which can be set with srt url query params
+func (p *Params) verifySRTOptions(rawUrl string) error {
+	opts, err := ParseSRTOptions(rawUrl)
+	if err != nil {
+		return errors.ErrInvalidUrl(p.RedactUrl(rawUrl), "srt")
+	}
+
+	switch opts.Mode {
+	case "", SRTModeCaller, SRTModeListener, SRTModeRendezvous:
+	default:
+		return errors.ErrInvalidInput("srt mode")
+	}
+	if opts.Latency < 0 {
+		return errors.ErrInvalidInput("srt latency")
+	}
+	// required by libsrt
+	if opts.Passphrase != "" && (len(opts.Passphrase) < 10 || len(opts.Passphrase) > 79) {
+		return errors.ErrInvalidInput("srt passphrase")
 	}
 
 	return nil
 }
 
+// StreamInfoUrl returns the url to report in egress info, which is redacted if configured
+func (p *Params) StreamInfoUrl(url string) string {
+	if p.conf.RedactStreamUrls {
+		return p.RedactUrl(url)
+	}
+	return url
+}
+
+// SRTOptions are read from the query params of an srt url
+type SRTOptions struct {
+	Mode       string
+	Latency    int // ms, 0 uses the srtsink default
+	Passphrase string
+}
+
+func ParseSRTOptions(rawUrl string) (*SRTOptions, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	opts := &SRTOptions{
+		Mode:       query.Get("mode"),
+		Passphrase: query.Get("passphrase"),
+	}
+	if latency := query.Get("latency"); latency != "" {
+		if opts.Latency, err = strconv.Atoi(latency); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
 func (p *Params) GetSegmentOutputType() OutputType {
 	switch p.OutputType {
 	case OutputTypeHLS:
@@ -794,6 +1254,42 @@ func (p *Params) GetSegmentOutputType() OutputType {
 	}
 }
 
+// LowLatency is true when segments are written as low-latency HLS parts
+func (p *SegmentedFileParams) LowLatency() bool {
+	return p.PartDuration > 0
+}
+
+// GetSplitDuration returns the length of each file written by the muxer, which starts on a key frame
+func (p *SegmentedFileParams) GetSplitDuration() time.Duration {
+	if p.LowLatency() {
+		return p.PartDuration
+	}
+	return time.Duration(p.SegmentDuration) * time.Second
+}
+
+// GetPartsPerSegment returns the number of low-latency HLS parts in a full segment
+func (p *SegmentedFileParams) GetPartsPerSegment() int {
+	return int(time.Duration(p.SegmentDuration) * time.Second / p.PartDuration)
+}
+
+// GetMuxLocation returns the muxer's location pattern. Low-latency HLS parts are joined into segments afterwards
+func (p *SegmentedFileParams) GetMuxLocation() string {
+	if p.LowLatency() {
+		return fmt.Sprintf("%s_part_%%05d.ts", p.LocalFilePrefix)
+	}
+	return fmt.Sprintf("%s_%%05d.ts", p.LocalFilePrefix)
+}
+
+// GetPartFilepath returns the local path of a low-latency HLS part, as written by the muxer
+func (p *SegmentedFileParams) GetPartFilepath(index int) string {
+	return fmt.Sprintf("%s_part_%05d.ts", p.LocalFilePrefix, index)
+}
+
+// GetSegmentFilepath returns the local path of a segment joined from low-latency HLS parts
+func (p *SegmentedFileParams) GetSegmentFilepath(index int) string {
+	return fmt.Sprintf("%s_%05d.ts", p.LocalFilePrefix, index)
+}
+
 func (p *SegmentedFileParams) GetStorageFilepath(filename string) string {
 	// Remove any path prepended to the filename
 	_, filename = path.Split(filename)
@@ -801,32 +1297,66 @@ func (p *SegmentedFileParams) GetStorageFilepath(filename string) string {
 	return path.Join(p.StoragePathPrefix, filename)
 }
 
+// GetSessionTimeout returns the shorter of the global and output specific limits, or 0 if there is none
 func (p *Params) GetSessionTimeout() time.Duration {
+	var timeout time.Duration
 	switch p.EgressType {
 	case EgressTypeFile:
-		return p.conf.FileOutputMaxDuration
+		timeout = p.conf.FileOutputMaxDuration
 	case EgressTypeStream, EgressTypeWebsocket:
-		return p.conf.StreamOutputMaxDuration
+		timeout = p.conf.StreamOutputMaxDuration
 	case EgressTypeSegmentedFile:
-		return p.conf.SegmentOutputMaxDuration
+		timeout = p.conf.SegmentOutputMaxDuration
 	}
 
-	return 0
+	if max := p.conf.MaxDuration; max > 0 && (timeout <= 0 || max < timeout) {
+		timeout = max
+	}
+	return timeout
 }
 
 type Manifest struct {
-	EgressID          string `json:"egress_id,omitempty"`
-	RoomID            string `json:"room_id,omitempty"`
-	RoomName          string `json:"room_name,omitempty"`
-	StartedAt         int64  `json:"started_at,omitempty"`
-	EndedAt           int64  `json:"ended_at,omitempty"`
-	PublisherIdentity string `json:"publisher_identity,omitempty"`
-	TrackID           string `json:"track_id,omitempty"`
-	TrackKind         string `json:"track_kind,omitempty"`
-	TrackSource       string `json:"track_source,omitempty"`
-	AudioTrackID      string `json:"audio_track_id,omitempty"`
-	VideoTrackID      string `json:"video_track_id,omitempty"`
-	SegmentCount      int64  `json:"segment_count,omitempty"`
+	EgressID          string   `json:"egress_id,omitempty"`
+	RoomID            string   `json:"room_id,omitempty"`
+	RoomName          string   `json:"room_name,omitempty"`
+	StartedAt         int64    `json:"started_at,omitempty"`
+	EndedAt           int64    `json:"ended_at,omitempty"`
+	PublisherIdentity string   `json:"publisher_identity,omitempty"`
+	TrackID           string   `json:"track_id,omitempty"`
+	TrackKind         string   `json:"track_kind,omitempty"`
+	TrackSource       string   `json:"track_source,omitempty"`
+	AudioTrackID      string   `json:"audio_track_id,omitempty"`
+	VideoTrackID      string   `json:"video_track_id,omitempty"`
+	SegmentCount      int64    `json:"segment_count,omitempty"`
+	RetainedPath      string   `json:"retained_path,omitempty"` // local copy, kept until the retention ttl
+	EndReason         string   `json:"end_reason,omitempty"`
+	StartDelayMs      int64    `json:"start_delay_ms,omitempty"` // time spent waiting for the template to start
+	FramesDropped     int64    `json:"frames_dropped,omitempty"`
+	MaxQueueDepth     int      `json:"max_queue_depth,omitempty"`
+	MaxDriftMs        int64    `json:"max_drift_ms,omitempty"`
+	Warnings          []string `json:"warnings,omitempty"`
+
+	// EgressInfo only has room for the playlist
+	DASHManifestName     string `json:"dash_manifest_name,omitempty"`
+	DASHManifestLocation string `json:"dash_manifest_location,omitempty"`
+
+	// storage path of each thumbnail, with its index in place of %05d
+	ThumbnailPattern string `json:"thumbnail_pattern,omitempty"`
+	ThumbnailCount   int    `json:"thumbnail_count,omitempty"`
+
+	// storage path of the room events sidecar
+	RoomEvents     string `json:"room_events,omitempty"`
+	RoomEventCount int    `json:"room_event_count,omitempty"`
+
+	// storage path of the data capture sidecar
+	DataCapture      string `json:"data_capture,omitempty"`
+	DataMessageCount int    `json:"data_message_count,omitempty"`
+
+	Files []*FileChunk `json:"files,omitempty"`
+
+	// pipelines rebuilt after failing, and the parts of the file written before each restart
+	Restarts  int          `json:"restarts,omitempty"`
+	FileParts []*FileChunk `json:"file_parts,omitempty"`
 }
 
 func (p *Params) GetManifest() ([]byte, error) {
@@ -842,16 +1372,40 @@ func (p *Params) GetManifest() ([]byte, error) {
 		TrackSource:       p.TrackSource,
 		AudioTrackID:      p.AudioTrackID,
 		VideoTrackID:      p.VideoTrackID,
+		RetainedPath:      p.RetainedPath,
+		EndReason:         p.EndReason,
+		StartDelayMs:      p.StartDelay.Milliseconds(),
+		FramesDropped:     p.FramesDropped,
+		MaxQueueDepth:     p.MaxQueueDepth,
+		MaxDriftMs:        p.MaxDrift.Milliseconds(),
+		Warnings:          p.Warnings,
 	}
 	if p.SegmentsInfo != nil {
 		manifest.SegmentCount = p.SegmentsInfo.SegmentCount
 	}
+	if p.ThumbnailPrefix != "" {
+		manifest.ThumbnailPattern = p.GetThumbnailStorageFilepath(p.ThumbnailPrefix + "_%05d.jpg")
+		manifest.ThumbnailCount = p.ThumbnailCount
+	}
+	if p.RoomEventsFilepath != "" {
+		manifest.RoomEvents = p.GetRoomEventsStorageFilepath()
+		manifest.RoomEventCount = p.RoomEventCount
+	}
+	if p.DataCapture {
+		manifest.DataCapture = p.GetDataCaptureStorageFilepath()
+		manifest.DataMessageCount = p.DataMessageCount
+	}
+	if p.DASHManifestFilename != "" {
+		manifest.DASHManifestName = p.GetStorageFilepath(p.DASHManifestFilename)
+		manifest.DASHManifestLocation = p.DASHManifestLocation
+	}
+	manifest.Files = p.FileChunks
+	manifest.Restarts = p.Restarts
+	manifest.FileParts = p.FileParts
 	return json.Marshal(manifest)
 }
 
+// stringReplace fills in filename templates, replacing characters in their values which can't be used in a filename
 func stringReplace(s string, replacements map[string]string) string {
-	for template, value := range replacements {
-		s = strings.Replace(s, template, value, -1)
-	}
-	return s
+	return templateReplace(s, replacements, sanitizeFilename)
 }
@@ -0,0 +1,78 @@
+package params
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/livekit/protocol/logger"
+)
+
+const redacted = "{redacted}"
+
+var (
+	// stream urls embedded in other strings, such as gstreamer errors, without trailing punctuation
+	streamUrlRegexp = regexp.MustCompile(`(rtmps?|srt)://[^\s"']*[^\s"':,.;)]`)
+
+	// query params which carry secrets in srt urls
+	secretQueryParams = []string{"passphrase", "streamid"}
+)
+
+// Redactor masks stream keys in urls before they are logged or reported
+type Redactor struct {
+	pattern *regexp.Regexp
+}
+
+// NewRedactor returns a Redactor which masks matches of pattern. If pattern is empty, the last path segment
+// and any secret query params are masked instead.
+func NewRedactor(pattern string) *Redactor {
+	r := &Redactor{}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			// checked when the config is loaded
+			logger.Errorw("invalid stream key pattern, using default", err)
+		} else {
+			r.pattern = re
+		}
+	}
+	return r
+}
+
+// RedactUrl returns rawUrl with its stream key masked
+func (r *Redactor) RedactUrl(rawUrl string) string {
+	if r != nil && r.pattern != nil {
+		return r.pattern.ReplaceAllString(rawUrl, redacted)
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		// can't tell which part is the key
+		return redacted
+	}
+
+	u.User = nil
+	if path := strings.TrimSuffix(u.Path, "/"); path != "" {
+		if i := strings.LastIndex(path, "/"); i >= 0 && i < len(path)-1 {
+			u.Path = path[:i+1] + redacted
+		}
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for _, param := range secretQueryParams {
+			if query.Has(param) {
+				query.Set(param, redacted)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	// keep the placeholder readable
+	redactedUrl, _ := url.PathUnescape(u.String())
+	return redactedUrl
+}
+
+// RedactUrls masks the stream keys of any stream urls found in s
+func (r *Redactor) RedactUrls(s string) string {
+	return streamUrlRegexp.ReplaceAllStringFunc(s, r.RedactUrl)
+}
@@ -0,0 +1,114 @@
+package params
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+// RestartParams describe the pipelines rebuilt after failing mid-egress
+type RestartParams struct {
+	MaxRestarts int
+	Restarts    int // rebuilt so far, recorded in the manifest
+
+	// the next segment or chunk index, which carries on across restarts so that earlier ones aren't overwritten
+	SegmentIndex int
+
+	// earlier parts of a file output, recorded in the manifest
+	FileParts []*FileChunk
+}
+
+func (p *Params) updateRestarts(conf config.PipelineRestartConfig) {
+	switch p.EgressType {
+	case EgressTypeStream, EgressTypeFile:
+	case EgressTypeSegmentedFile:
+		if p.LowLatency() {
+			// parts are joined into segments as they're written
+			return
+		}
+	default:
+		return
+	}
+
+	p.MaxRestarts = conf.MaxRestarts
+}
+
+// CanRestart returns true if the pipeline can be rebuilt if it fails
+func (p *Params) CanRestart() bool {
+	return p.Restarts < p.MaxRestarts
+}
+
+// ContinueFrom carries the egress on from the params of a pipeline which failed. Streams go to the urls the
+// pipeline was sending to, segments and chunks are numbered after its own, and a file carries on in a new part
+func (p *Params) ContinueFrom(prev *Params) {
+	p.Restarts = prev.Restarts + 1
+	p.SegmentIndex = prev.SegmentIndex
+	p.Info.StartedAt = prev.Info.StartedAt
+	// the egress never stopped being active
+	p.Info.Status = livekit.EgressStatus_EGRESS_ACTIVE
+	p.Warnings = prev.Warnings
+
+	p.ThumbnailPrefix = prev.ThumbnailPrefix
+	p.ThumbnailCount = prev.ThumbnailCount
+	p.RoomEventsFilepath = prev.RoomEventsFilepath
+
+	switch p.EgressType {
+	case EgressTypeStream:
+		// urls may have been added or removed since the request
+		p.StreamInfo = prev.StreamInfo
+		p.StreamUrls = make([]string, 0, len(prev.StreamInfo))
+		for url := range prev.StreamInfo {
+			p.StreamUrls = append(p.StreamUrls, url)
+		}
+		sort.Strings(p.StreamUrls)
+		p.Info.Result = prev.Info.Result
+
+	case EgressTypeFile:
+		if prev.SplitFile() {
+			p.FileInfo = prev.FileInfo
+			p.FileChunks = prev.FileChunks
+			p.LocalFilepath = prev.LocalFilepath
+			p.StorageFilepath = prev.StorageFilepath
+			p.Info.Result = prev.Info.Result
+			return
+		}
+
+		p.FileParts = append(prev.FileParts, &FileChunk{
+			Filename: prev.FileInfo.Filename,
+			Location: prev.FileInfo.Location,
+			Size:     prev.FileInfo.Size,
+			Duration: prev.FileInfo.Duration,
+		})
+		first := p.FileParts[0].Filename
+		p.StorageFilepath = getPartFilepath(first, p.Restarts+1)
+		p.LocalFilepath = p.StorageFilepath
+		if p.UploadConfig != nil {
+			_, filename := path.Split(p.StorageFilepath)
+			p.LocalFilepath = path.Join(path.Dir(prev.LocalFilepath), filename)
+		}
+		p.FileInfo.Filename = p.StorageFilepath
+
+	case EgressTypeSegmentedFile:
+		p.SegmentsInfo = prev.SegmentsInfo
+		p.LocalFilePrefix = prev.LocalFilePrefix
+		p.StoragePathPrefix = prev.StoragePathPrefix
+		p.PlaylistFilename = prev.PlaylistFilename
+		p.DASHManifestFilename = prev.DASHManifestFilename
+		p.Info.Result = prev.Info.Result
+		if prev.DataCapture {
+			// file parts each have their own sidecar, but the playlist has one
+			p.DataFilepath = prev.GetDataCaptureFilepath()
+			p.DataMessageCount = prev.DataMessageCount
+		}
+	}
+}
+
+// getPartFilepath names a later part of a file, such as recording_part2.mp4
+func getPartFilepath(filepath string, part int) string {
+	ext := path.Ext(filepath)
+	return fmt.Sprintf("%s_part%d%s", strings.TrimSuffix(filepath, ext), part, ext)
+}
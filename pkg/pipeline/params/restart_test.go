@@ -0,0 +1,137 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestUpdateRestarts(t *testing.T) {
+	conf := config.PipelineRestartConfig{MaxRestarts: 2, Delay: time.Second}
+
+	for _, egressType := range []EgressType{EgressTypeStream, EgressTypeFile, EgressTypeSegmentedFile} {
+		p := &Params{EgressType: egressType}
+		p.updateRestarts(conf)
+		require.True(t, p.CanRestart(), egressType)
+		p.Restarts = 2
+		require.False(t, p.CanRestart(), egressType)
+	}
+
+	// websockets and low-latency HLS fail instead
+	p := &Params{EgressType: EgressTypeWebsocket}
+	p.updateRestarts(conf)
+	require.False(t, p.CanRestart())
+
+	p = &Params{
+		EgressType:          EgressTypeSegmentedFile,
+		SegmentedFileParams: SegmentedFileParams{SegmentDuration: 6, PartDuration: time.Second},
+	}
+	p.updateRestarts(conf)
+	require.False(t, p.CanRestart())
+}
+
+func newRestartParams(egressType EgressType) *Params {
+	p := &Params{
+		Info:          &livekit.EgressInfo{EgressId: "EG_123", Status: livekit.EgressStatus_EGRESS_STARTING},
+		EgressType:    egressType,
+		RestartParams: RestartParams{MaxRestarts: 3},
+		UploadParams:  UploadParams{UploadConfig: &livekit.S3Upload{}},
+	}
+	switch egressType {
+	case EgressTypeFile:
+		p.FileInfo = &livekit.FileInfo{}
+		p.Info.Result = &livekit.EgressInfo_File{File: p.FileInfo}
+	case EgressTypeSegmentedFile:
+		p.SegmentsInfo = &livekit.SegmentsInfo{}
+		p.Info.Result = &livekit.EgressInfo_Segments{Segments: p.SegmentsInfo}
+	}
+	return p
+}
+
+func TestContinueFileParts(t *testing.T) {
+	prev := newRestartParams(EgressTypeFile)
+	prev.Info.StartedAt = 1000
+	prev.LocalFilepath = "/tmp/EG_123/recording.webm"
+	prev.StorageFilepath = "recordings/recording.webm"
+	prev.FileInfo.Filename = prev.StorageFilepath
+	prev.FileInfo.Location = "https://bucket/recordings/recording.webm"
+	prev.FileInfo.Size = 100
+	prev.FileInfo.Duration = 10
+
+	p := newRestartParams(EgressTypeFile)
+	p.ContinueFrom(prev)
+	require.Equal(t, 1, p.Restarts)
+	require.Equal(t, int64(1000), p.Info.StartedAt)
+	require.Equal(t, livekit.EgressStatus_EGRESS_ACTIVE, p.Info.Status)
+	require.Equal(t, "/tmp/EG_123/recording_part2.webm", p.LocalFilepath)
+	require.Equal(t, "recordings/recording_part2.webm", p.StorageFilepath)
+	require.Equal(t, "recordings/recording_part2.webm", p.Info.GetFile().Filename)
+	require.Equal(t, []*FileChunk{{
+		Filename: "recordings/recording.webm",
+		Location: "https://bucket/recordings/recording.webm",
+		Size:     100,
+		Duration: 10,
+	}}, p.FileParts)
+
+	// parts are numbered from the first
+	p.FileInfo.Location = "https://bucket/recordings/recording_part2.webm"
+	next := newRestartParams(EgressTypeFile)
+	next.ContinueFrom(p)
+	require.Equal(t, 2, next.Restarts)
+	require.Equal(t, "recordings/recording_part3.webm", next.StorageFilepath)
+	require.Len(t, next.FileParts, 2)
+	require.Equal(t, "recordings/recording_part2.webm", next.FileParts[1].Filename)
+
+	// without an upload, the part is written where it's stored
+	prev.UploadConfig = nil
+	prev.LocalFilepath = prev.StorageFilepath
+	p = newRestartParams(EgressTypeFile)
+	p.UploadConfig = nil
+	p.ContinueFrom(prev)
+	require.Equal(t, "recordings/recording_part2.webm", p.LocalFilepath)
+}
+
+func TestContinueSegments(t *testing.T) {
+	prev := newRestartParams(EgressTypeSegmentedFile)
+	prev.LocalFilePrefix = "/tmp/EG_123/room-2023"
+	prev.StoragePathPrefix = "segments/"
+	prev.PlaylistFilename = "/tmp/EG_123/playlist.m3u8"
+	prev.SegmentIndex = 12
+	prev.SegmentsInfo.SegmentCount = 11
+	prev.DataCapture = true
+	prev.DataFormat = config.DataCaptureJSONL
+	prev.DataMessageCount = 4
+
+	p := newRestartParams(EgressTypeSegmentedFile)
+	p.DataCapture = true
+	p.DataFormat = config.DataCaptureJSONL
+	p.ContinueFrom(prev)
+	require.Equal(t, 12, p.SegmentIndex)
+	require.Equal(t, prev.LocalFilePrefix, p.LocalFilePrefix)
+	require.Equal(t, prev.PlaylistFilename, p.PlaylistFilename)
+	require.Equal(t, int64(11), p.Info.GetSegments().SegmentCount)
+	require.Equal(t, "/tmp/EG_123/playlist_data.jsonl", p.GetDataCaptureFilepath())
+	require.Equal(t, 4, p.DataMessageCount)
+}
+
+func TestContinueStreams(t *testing.T) {
+	prev := newRestartParams(EgressTypeStream)
+	removed := &livekit.StreamInfo{Url: "rtmp://a", Status: livekit.StreamInfo_FINISHED}
+	first := &livekit.StreamInfo{Url: "rtmp://b", StartedAt: 1000, Status: livekit.StreamInfo_ACTIVE}
+	added := &livekit.StreamInfo{Url: "rtmp://c", StartedAt: 2000, Status: livekit.StreamInfo_ACTIVE}
+	prev.StreamUrls = []string{"rtmp://a", "rtmp://b"}
+	prev.StreamInfo = map[string]*livekit.StreamInfo{"rtmp://c": added, "rtmp://b": first}
+	prev.Info.Result = &livekit.EgressInfo_Stream{Stream: &livekit.StreamInfoList{Info: []*livekit.StreamInfo{removed, first, added}}}
+
+	// urls added and removed since the request are kept
+	p := newRestartParams(EgressTypeStream)
+	p.StreamUrls = []string{"rtmp://a", "rtmp://b"}
+	p.ContinueFrom(prev)
+	require.Equal(t, []string{"rtmp://b", "rtmp://c"}, p.StreamUrls)
+	require.Equal(t, first, p.StreamInfo["rtmp://b"])
+	require.Len(t, p.Info.GetStream().Info, 3)
+}
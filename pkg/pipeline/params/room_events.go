@@ -0,0 +1,141 @@
+package params
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+const (
+	RoomEventTrackPublished   = "track_published"
+	RoomEventTrackUnpublished = "track_unpublished"
+	RoomEventSpeakerChanged   = "speaker_changed"
+	RoomEventChapter          = "chapter"
+
+	// mp4 chapter lists have a one byte count
+	maxChapters = 255
+	// speaker changes closer than this to the last chapter aren't chapters of their own
+	minSpeakerChapterGap = time.Second * 10
+)
+
+// RoomEventParams describe the room events collected during room composite egress to a file
+type RoomEventParams struct {
+	RoomEventsFilepath string // local path of the sidecar, empty when disabled
+	MaxRoomEvents      int
+	Chapters           bool // written into the mp4 once it's finished
+
+	RoomEventCount int // recorded in the manifest
+}
+
+// RoomEvents is the sidecar uploaded next to the file as <name>_events.json
+type RoomEvents struct {
+	EgressID  string       `json:"egress_id"`
+	RoomName  string       `json:"room_name"`
+	StartedAt int64        `json:"started_at"` // unix nanoseconds of the start of the file
+	Events    []*RoomEvent `json:"events"`
+	Dropped   int          `json:"dropped,omitempty"` // events after max_events was reached
+}
+
+// RoomEvent is an event seen in the room, in the order it was received
+type RoomEvent struct {
+	Type   string `json:"type"`      // one of the RoomEvent constants
+	Time   int64  `json:"time"`      // unix nanoseconds
+	Offset int64  `json:"offset_ms"` // into the file, negative if it happened before the file started
+
+	// identity of the publisher, the loudest speaker, or the sender of the chapter
+	Participant string `json:"participant,omitempty"`
+
+	// track_published and track_unpublished. Tracks already published when the egress joins are listed first
+	TrackID     string `json:"track_id,omitempty"`
+	TrackSource string `json:"track_source,omitempty"` // CAMERA, MICROPHONE, SCREEN_SHARE or SCREEN_SHARE_AUDIO
+
+	// speaker_changed, sent when the loudest speaker changes. Identities are listed loudest first
+	Speakers []string `json:"speakers,omitempty"`
+
+	// chapter, sent by a participant as a data message of {"chapter": "<title>"}
+	Title string `json:"title,omitempty"`
+}
+
+// Chapter starts at an offset into the file
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// updateRoomEvents names the sidecar after the file. Chapters can only be written once the file is finished,
+// so mp4s uploaded as they are written don't get them
+func (p *Params) updateRoomEvents(conf config.RoomEventsConfig) {
+	if p.EgressType != EgressTypeFile || p.SplitFile() {
+		return
+	}
+
+	p.RoomEventsFilepath = strings.TrimSuffix(p.LocalFilepath, path.Ext(p.LocalFilepath)) + "_events.json"
+	p.MaxRoomEvents = conf.MaxEvents
+	p.Chapters = p.OutputType == OutputTypeMP4 && !p.ProgressiveUpload
+}
+
+// GetRoomEventsStorageFilepath stores the sidecar next to the file
+func (p *Params) GetRoomEventsStorageFilepath() string {
+	_, filename := path.Split(p.RoomEventsFilepath)
+	dir, _ := path.Split(p.StorageFilepath)
+	return path.Join(dir, filename)
+}
+
+// GetChapters lists a chapter for each screen share started or stopped, each chapter sent by a participant,
+// and each change of speaker which isn't close to another chapter. Events need their offsets, and events
+// outside of the file are dropped
+func GetChapters(events []*RoomEvent, duration time.Duration) []*Chapter {
+	var chapters []*Chapter
+	var speaker string
+	add := func(start time.Duration, title string) {
+		if len(chapters) > 0 && chapters[len(chapters)-1].Start == start {
+			// the later title wins
+			chapters[len(chapters)-1].Title = title
+			return
+		}
+		chapters = append(chapters, &Chapter{Start: start, Title: title})
+	}
+
+	for _, event := range events {
+		start := time.Duration(event.Offset) * time.Millisecond
+		if start >= duration {
+			break
+		}
+		if start < 0 {
+			start = 0
+		}
+
+		switch event.Type {
+		case RoomEventTrackPublished, RoomEventTrackUnpublished:
+			if event.TrackSource != livekit.TrackSource_SCREEN_SHARE.String() {
+				continue
+			}
+			if event.Type == RoomEventTrackPublished {
+				add(start, "Screen share: "+event.Participant)
+			} else {
+				add(start, "Screen share ended: "+event.Participant)
+			}
+
+		case RoomEventSpeakerChanged:
+			if event.Participant == speaker {
+				continue
+			}
+			speaker = event.Participant
+			if len(chapters) > 0 && start-chapters[len(chapters)-1].Start < minSpeakerChapterGap {
+				continue
+			}
+			add(start, "Speaker: "+event.Participant)
+
+		case RoomEventChapter:
+			add(start, event.Title)
+		}
+	}
+
+	if len(chapters) > maxChapters {
+		chapters = chapters[:maxChapters]
+	}
+	return chapters
+}
@@ -0,0 +1,79 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+func TestRoomEvents(t *testing.T) {
+	conf := config.RoomEventsConfig{Enabled: true, MaxEvents: 100}
+
+	p := &Params{
+		EgressType: EgressTypeFile,
+		FileParams: FileParams{
+			LocalFilepath:   "/tmp/EG_123/recording.mp4",
+			StorageFilepath: "recordings/recording.mp4",
+		},
+	}
+	p.OutputType = OutputTypeMP4
+	p.updateRoomEvents(conf)
+	require.Equal(t, "/tmp/EG_123/recording_events.json", p.RoomEventsFilepath)
+	require.Equal(t, "recordings/recording_events.json", p.GetRoomEventsStorageFilepath())
+	require.Equal(t, 100, p.MaxRoomEvents)
+	require.True(t, p.Chapters)
+
+	// uploaded while it's written, so it can't be changed after
+	p.RoomEventParams = RoomEventParams{}
+	p.ProgressiveUpload = true
+	p.updateRoomEvents(conf)
+	require.NotEmpty(t, p.RoomEventsFilepath)
+	require.False(t, p.Chapters)
+
+	// only mp4s have chapters
+	p.RoomEventParams = RoomEventParams{}
+	p.ProgressiveUpload = false
+	p.OutputType = OutputTypeOGG
+	p.updateRoomEvents(conf)
+	require.NotEmpty(t, p.RoomEventsFilepath)
+	require.False(t, p.Chapters)
+
+	// segments have no file to sit next to
+	p = &Params{EgressType: EgressTypeSegmentedFile}
+	p.updateRoomEvents(conf)
+	require.Empty(t, p.RoomEventsFilepath)
+}
+
+func TestGetChapters(t *testing.T) {
+	events := []*RoomEvent{
+		// already published when the egress joined
+		{Type: RoomEventTrackPublished, Offset: -2000, Participant: "alice", TrackSource: "SCREEN_SHARE"},
+		{Type: RoomEventTrackPublished, Offset: -2000, Participant: "bob", TrackSource: "CAMERA"},
+		{Type: RoomEventSpeakerChanged, Offset: 3000, Participant: "bob", Speakers: []string{"bob", "alice"}},
+		{Type: RoomEventSpeakerChanged, Offset: 15000, Participant: "alice", Speakers: []string{"alice"}},
+		{Type: RoomEventSpeakerChanged, Offset: 18000, Participant: "alice", Speakers: []string{"alice", "bob"}},
+		{Type: RoomEventChapter, Offset: 20000, Participant: "bob", Title: "Q&A"},
+		{Type: RoomEventSpeakerChanged, Offset: 25000, Participant: "bob", Speakers: []string{"bob"}},
+		{Type: RoomEventTrackUnpublished, Offset: 40000, Participant: "alice", TrackSource: "SCREEN_SHARE"},
+		// after the end of the file
+		{Type: RoomEventChapter, Offset: 70000, Participant: "bob", Title: "Wrap up"},
+	}
+
+	chapters := GetChapters(events, time.Minute)
+	require.Equal(t, []*Chapter{
+		{Start: 0, Title: "Screen share: alice"},
+		{Start: time.Second * 15, Title: "Speaker: alice"},
+		{Start: time.Second * 20, Title: "Q&A"},
+		{Start: time.Second * 40, Title: "Screen share ended: alice"},
+	}, chapters)
+
+	// mp4 chapter lists are limited to 255
+	events = nil
+	for i := 0; i < 300; i++ {
+		events = append(events, &RoomEvent{Type: RoomEventChapter, Offset: int64(i * 1000), Title: "chapter"})
+	}
+	require.Len(t, GetChapters(events, time.Hour), 255)
+}
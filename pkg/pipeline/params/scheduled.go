@@ -0,0 +1,93 @@
+package params
+
+import (
+	"time"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+// StartEgressRequest has no field for a scheduled stop, so it is set with s3 metadata of the request's upload,
+// which clients can already send. These keys are removed before the object is uploaded
+const (
+	ScheduledStopMaxDurationKey = "egress-max-duration" // a duration, such as 1h30m
+	ScheduledStopEndAtKey       = "egress-end-at"       // an RFC 3339 time
+)
+
+// ScheduledStop ends an egress on its own, the same way as a stop request. Either or both may be set, and the
+// egress stops at whichever comes first
+type ScheduledStop struct {
+	MaxDuration time.Duration // running time, counted from when the egress becomes active
+	EndAt       time.Time
+}
+
+func (s ScheduledStop) IsZero() bool {
+	return s.MaxDuration == 0 && s.EndAt.IsZero()
+}
+
+// Deadline returns when an egress which became active at activeAt should stop
+func (s ScheduledStop) Deadline(activeAt time.Time) time.Time {
+	var deadline time.Time
+	if s.MaxDuration > 0 {
+		deadline = activeAt.Add(s.MaxDuration)
+	}
+	if !s.EndAt.IsZero() && (deadline.IsZero() || s.EndAt.Before(deadline)) {
+		deadline = s.EndAt
+	}
+	return deadline
+}
+
+// GetScheduledStop returns the scheduled stop of a request, which is zero if it has none
+func GetScheduledStop(request *livekit.StartEgressRequest) (ScheduledStop, error) {
+	var stop ScheduledStop
+	metadata := requestS3Upload(request).GetMetadata()
+
+	if v, ok := metadata[ScheduledStopMaxDurationKey]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return stop, errors.ErrInvalidInput(ScheduledStopMaxDurationKey)
+		}
+		stop.MaxDuration = d
+	}
+	if v, ok := metadata[ScheduledStopEndAtKey]; ok {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return stop, errors.ErrInvalidInput(ScheduledStopEndAtKey)
+		}
+		stop.EndAt = t
+	}
+	return stop, nil
+}
+
+// requestS3Upload returns the s3 upload of the request's file or segments output, if it has one
+func requestS3Upload(request *livekit.StartEgressRequest) *livekit.S3Upload {
+	switch req := request.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		if s3 := req.RoomComposite.GetFile().GetS3(); s3 != nil {
+			return s3
+		}
+		return req.RoomComposite.GetSegments().GetS3()
+	case *livekit.StartEgressRequest_Web:
+		if s3 := req.Web.GetFile().GetS3(); s3 != nil {
+			return s3
+		}
+		return req.Web.GetSegments().GetS3()
+	case *livekit.StartEgressRequest_TrackComposite:
+		if s3 := req.TrackComposite.GetFile().GetS3(); s3 != nil {
+			return s3
+		}
+		return req.TrackComposite.GetSegments().GetS3()
+	case *livekit.StartEgressRequest_Track:
+		return req.Track.GetFile().GetS3()
+	}
+	return nil
+}
+
+// checkScheduledStop rejects requests which would stop before they start. It's only checked when the request is
+// sent, since the handler starts it later
+func (p *Params) checkScheduledStop(now time.Time) error {
+	if !p.ScheduledStop.EndAt.IsZero() && !now.Before(p.ScheduledStop.EndAt) {
+		return errors.ErrInvalidInput(ScheduledStopEndAtKey)
+	}
+	return nil
+}
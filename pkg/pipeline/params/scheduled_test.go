@@ -0,0 +1,102 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+func newScheduledRequest(metadata map[string]string) *livekit.StartEgressRequest {
+	return &livekit.StartEgressRequest{
+		EgressId: "EG_123",
+		Request: &livekit.StartEgressRequest_RoomComposite{
+			RoomComposite: &livekit.RoomCompositeEgressRequest{
+				RoomName: "my room",
+				Output: &livekit.RoomCompositeEgressRequest_File{
+					File: &livekit.EncodedFileOutput{
+						Output: &livekit.EncodedFileOutput_S3{S3: &livekit.S3Upload{Metadata: metadata}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestGetScheduledStop(t *testing.T) {
+	stop, err := GetScheduledStop(newScheduledRequest(nil))
+	require.NoError(t, err)
+	require.True(t, stop.IsZero())
+
+	stop, err = GetScheduledStop(newScheduledRequest(map[string]string{
+		ScheduledStopMaxDurationKey: "1h30m",
+		ScheduledStopEndAtKey:       "2030-01-02T15:04:05Z",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, time.Hour+time.Minute*30, stop.MaxDuration)
+	require.True(t, time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC).Equal(stop.EndAt))
+
+	for _, metadata := range []map[string]string{
+		{ScheduledStopMaxDurationKey: "soon"},
+		{ScheduledStopMaxDurationKey: "-1m"},
+		{ScheduledStopEndAtKey: "tomorrow"},
+	} {
+		_, err = GetScheduledStop(newScheduledRequest(metadata))
+		require.Error(t, err, metadata)
+	}
+
+	// stream outputs have no s3 upload
+	stop, err = GetScheduledStop(&livekit.StartEgressRequest{
+		Request: &livekit.StartEgressRequest_RoomComposite{
+			RoomComposite: &livekit.RoomCompositeEgressRequest{
+				Output: &livekit.RoomCompositeEgressRequest_Stream{Stream: &livekit.StreamOutput{}},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, stop.IsZero())
+}
+
+func TestScheduledStopDeadline(t *testing.T) {
+	activeAt := time.Now()
+	endAt := activeAt.Add(time.Minute)
+
+	require.Equal(t, activeAt.Add(time.Hour), ScheduledStop{MaxDuration: time.Hour}.Deadline(activeAt))
+	require.Equal(t, endAt, ScheduledStop{EndAt: endAt}.Deadline(activeAt))
+	// whichever comes first
+	require.Equal(t, endAt, ScheduledStop{MaxDuration: time.Hour, EndAt: endAt}.Deadline(activeAt))
+	require.Equal(t, activeAt.Add(time.Second), ScheduledStop{MaxDuration: time.Second, EndAt: endAt}.Deadline(activeAt))
+
+	now := time.Now()
+	p := &Params{ScheduledStop: ScheduledStop{EndAt: now.Add(time.Minute)}}
+	require.NoError(t, p.checkScheduledStop(now))
+	p.ScheduledStop.EndAt = now
+	require.Error(t, p.checkScheduledStop(now))
+}
+
+func TestScheduledStopMetadataRemoved(t *testing.T) {
+	upload := &livekit.S3Upload{
+		AccessKey: "key",
+		Secret:    "secret",
+		Region:    "us-west-2",
+		Bucket:    "bucket",
+		Metadata: map[string]string{
+			"room":                      "{room_name}",
+			ScheduledStopMaxDurationKey: "1h",
+		},
+	}
+	p := &Params{
+		conf:         &config.Config{},
+		Info:         &livekit.EgressInfo{EgressId: "EG_123", RoomName: "my room"},
+		UploadParams: UploadParams{UploadConfig: upload},
+	}
+
+	require.NoError(t, p.updateUploadConfig())
+	require.Equal(t, map[string]string{"room": "my room"}, p.UploadConfig.(*livekit.S3Upload).Metadata)
+
+	// the request keeps it for the service
+	require.Equal(t, "1h", upload.Metadata[ScheduledStopMaxDurationKey])
+}
@@ -0,0 +1,45 @@
+package params
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const bytesPerGB = 1 << 30
+
+// FileChunk is one of the files written when a file output is split
+type FileChunk struct {
+	Filename string `json:"filename"`
+	Location string `json:"location"`
+	Size     int64  `json:"size"`
+	Duration int64  `json:"duration"`
+}
+
+// SplitFile returns true if the file output should be written as numbered chunks
+func (p *Params) SplitFile() bool {
+	if p.EgressType != EgressTypeFile || (p.SplitSize == 0 && p.SplitDuration == 0) {
+		return false
+	}
+
+	switch p.OutputType {
+	case OutputTypeMP4, OutputTypeWebM, OutputTypeMKV, OutputTypeOGG:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetChunkLocation returns the printf pattern of local chunk filenames, such as recording_%03d.mp4
+func (p *Params) GetChunkLocation() string {
+	ext := path.Ext(p.LocalFilepath)
+	prefix := strings.ReplaceAll(strings.TrimSuffix(p.LocalFilepath, ext), "%", "%%")
+	return fmt.Sprintf("%s_%%03d%s", prefix, ext)
+}
+
+// GetChunkStorageFilepath returns the storage path of a local chunk, next to the requested filepath
+func (p *Params) GetChunkStorageFilepath(localPath string) string {
+	dir, _ := path.Split(p.StorageFilepath)
+	_, filename := path.Split(localPath)
+	return path.Join(dir, filename)
+}
@@ -0,0 +1,27 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFile(t *testing.T) {
+	p := &Params{
+		EgressType: EgressTypeFile,
+		OutputType: OutputTypeMP4,
+		FileParams: FileParams{
+			LocalFilepath:   "/tmp/EG_123/recording_100%.mp4",
+			StorageFilepath: "recordings/recording_100%.mp4",
+		},
+	}
+	require.False(t, p.SplitFile())
+
+	p.SplitDuration = 3600
+	require.True(t, p.SplitFile())
+	require.Equal(t, "/tmp/EG_123/recording_100%%_%03d.mp4", p.GetChunkLocation())
+	require.Equal(t, "recordings/recording_100%_001.mp4", p.GetChunkStorageFilepath("/tmp/EG_123/recording_100%_001.mp4"))
+
+	p.OutputType = OutputTypeIVF
+	require.False(t, p.SplitFile())
+}
@@ -0,0 +1,36 @@
+package params
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/livekit/egress/pkg/errors"
+)
+
+// checkTemplateBase validates a custom template base url. Only http and https pages can be loaded, and if the node
+// has a template allowlist, the url must match one of its origins. An origin host like *.example.com matches any
+// subdomain
+func checkTemplateBase(allowlist []string, rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.ErrInvalidInput("custom_base_url")
+	}
+	if len(allowlist) == 0 {
+		return nil
+	}
+
+	host := strings.ToLower(u.Host)
+	for _, origin := range allowlist {
+		o, err := url.Parse(origin)
+		if err != nil || o.Scheme != u.Scheme {
+			continue
+		}
+
+		allowed := strings.ToLower(o.Host)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return nil
+		}
+	}
+
+	return errors.ErrTemplateNotAllowed(u.Scheme + "://" + u.Host)
+}
@@ -0,0 +1,39 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTemplateBase(t *testing.T) {
+	allowlist := []string{"https://layouts.example.com", "https://*.templates.example.com", "http://localhost:3000"}
+
+	for _, test := range []struct {
+		url       string
+		allowlist []string
+		allowed   bool
+	}{
+		{"https://anywhere.com/layout", nil, true},
+		{"file:///etc/passwd", nil, false},
+		{"chrome://settings", nil, false},
+		{"layouts.example.com", nil, false},
+		{"https://layouts.example.com", allowlist, true},
+		{"https://LAYOUTS.example.com/speaker?theme=dark", allowlist, true},
+		{"http://layouts.example.com", allowlist, false},
+		{"https://layouts.example.com:8443", allowlist, false},
+		{"https://a.templates.example.com/grid", allowlist, true},
+		{"https://templates.example.com", allowlist, false},
+		{"https://evil-templates.example.com", allowlist, false},
+		{"http://localhost:3000", allowlist, true},
+		{"http://localhost", allowlist, false},
+		{"https://example.com", allowlist, false},
+	} {
+		err := checkTemplateBase(test.allowlist, test.url)
+		if test.allowed {
+			require.NoError(t, err, test.url)
+		} else {
+			require.Error(t, err, test.url)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package params
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+// ThumbnailParams describe the jpegs taken from the video of room composite and web egress to files and segments
+type ThumbnailParams struct {
+	ThumbnailInterval time.Duration // 0 when disabled
+	ThumbnailWidth    int32
+	ThumbnailHeight   int32
+	ThumbnailQuality  int
+	ThumbnailPrefix   string // local path of each thumbnail, before its index
+
+	ThumbnailCount int // uploaded, recorded in the manifest
+}
+
+// updateThumbnails names thumbnails after the output, and scales them down to the configured width
+func (p *Params) updateThumbnails(conf config.ThumbnailsConfig) {
+	switch p.EgressType {
+	case EgressTypeFile:
+		p.ThumbnailPrefix = strings.TrimSuffix(p.LocalFilepath, path.Ext(p.LocalFilepath)) + "_thumb"
+	case EgressTypeSegmentedFile:
+		p.ThumbnailPrefix = p.LocalFilePrefix + "_thumb"
+	default:
+		return
+	}
+
+	p.ThumbnailInterval = conf.Interval
+	p.ThumbnailQuality = conf.Quality
+	p.ThumbnailWidth, p.ThumbnailHeight = p.Width, p.Height
+	if conf.Width < p.Width {
+		// even dimensions, as with the output
+		p.ThumbnailWidth = conf.Width
+		p.ThumbnailHeight = int32(math.Round(float64(p.Height)*float64(conf.Width)/float64(p.Width)/2)) * 2
+	}
+}
+
+// GetThumbnailFilepath returns the local path of a thumbnail, counting from 0
+func (p *Params) GetThumbnailFilepath(index int) string {
+	return fmt.Sprintf("%s_%05d.jpg", p.ThumbnailPrefix, index)
+}
+
+// GetThumbnailStorageFilepath stores thumbnails next to the output
+func (p *Params) GetThumbnailStorageFilepath(localPath string) string {
+	_, filename := path.Split(localPath)
+	if p.EgressType == EgressTypeSegmentedFile {
+		return p.GetStorageFilepath(filename)
+	}
+	dir, _ := path.Split(p.StorageFilepath)
+	return path.Join(dir, filename)
+}
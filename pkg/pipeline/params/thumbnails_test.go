@@ -0,0 +1,54 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+func TestThumbnails(t *testing.T) {
+	conf := config.ThumbnailsConfig{Interval: time.Second * 10, Width: 320, Quality: 85}
+
+	p := &Params{
+		EgressType: EgressTypeFile,
+		VideoParams: VideoParams{
+			Width:  1920,
+			Height: 1080,
+		},
+		FileParams: FileParams{
+			LocalFilepath:   "/tmp/EG_123/recording.mp4",
+			StorageFilepath: "recordings/recording.mp4",
+		},
+	}
+	p.updateThumbnails(conf)
+	require.Equal(t, time.Second*10, p.ThumbnailInterval)
+	require.Equal(t, int32(320), p.ThumbnailWidth)
+	require.Equal(t, int32(180), p.ThumbnailHeight)
+	require.Equal(t, "/tmp/EG_123/recording_thumb_00003.jpg", p.GetThumbnailFilepath(3))
+	require.Equal(t, "recordings/recording_thumb_00003.jpg", p.GetThumbnailStorageFilepath(p.GetThumbnailFilepath(3)))
+
+	// never larger than the output
+	p = &Params{
+		EgressType: EgressTypeSegmentedFile,
+		VideoParams: VideoParams{
+			Width:  240,
+			Height: 426,
+		},
+		SegmentedFileParams: SegmentedFileParams{
+			LocalFilePrefix:   "/tmp/EG_123/room",
+			StoragePathPrefix: "segments/",
+		},
+	}
+	p.updateThumbnails(conf)
+	require.Equal(t, int32(240), p.ThumbnailWidth)
+	require.Equal(t, int32(426), p.ThumbnailHeight)
+	require.Equal(t, "segments/room_thumb_00000.jpg", p.GetThumbnailStorageFilepath(p.GetThumbnailFilepath(0)))
+
+	// stream egress has nowhere to store them
+	p = &Params{EgressType: EgressTypeStream}
+	p.updateThumbnails(conf)
+	require.Zero(t, p.ThumbnailInterval)
+}
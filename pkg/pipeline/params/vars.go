@@ -2,6 +2,7 @@ package params
 
 type MimeType string
 type Profile string
+type RateControl string
 type EgressType string
 type OutputType string
 type FileExtension string
@@ -13,12 +14,18 @@ const (
 	MimeTypeRaw  MimeType = "audio/x-raw"
 	MimeTypeH264 MimeType = "video/h264"
 	MimeTypeVP8  MimeType = "video/vp8"
+	MimeTypeVP9  MimeType = "video/vp9"
 
 	// video profiles
 	ProfileBaseline Profile = "baseline"
 	ProfileMain     Profile = "main"
 	ProfileHigh     Profile = "high"
 
+	// video rate control modes
+	RateControlCBR RateControl = "cbr"
+	RateControlVBR RateControl = "vbr"
+	RateControlCQP RateControl = "cqp"
+
 	// egress types
 	EgressTypeStream        EgressType = "stream"
 	EgressTypeWebsocket     EgressType = "websocket"
@@ -26,14 +33,25 @@ const (
 	EgressTypeSegmentedFile EgressType = "segments"
 
 	// output types
-	OutputTypeRaw  OutputType = "audio/x-raw"
-	OutputTypeOGG  OutputType = "audio/ogg"
-	OutputTypeIVF  OutputType = "video/x-ivf"
-	OutputTypeMP4  OutputType = "video/mp4"
-	OutputTypeTS   OutputType = "video/mp2t"
-	OutputTypeWebM OutputType = "video/webm"
-	OutputTypeRTMP OutputType = "rtmp"
-	OutputTypeHLS  OutputType = "application/x-mpegurl"
+	OutputTypeRaw   OutputType = "audio/x-raw"
+	OutputTypeOGG   OutputType = "audio/ogg"
+	OutputTypeIVF   OutputType = "video/x-ivf"
+	OutputTypeMP4   OutputType = "video/mp4"
+	OutputTypeTS    OutputType = "video/mp2t"
+	OutputTypeWebM  OutputType = "video/webm"
+	OutputTypeMKV   OutputType = "video/x-matroska"
+	OutputTypeRTMP  OutputType = "rtmp"
+	OutputTypeSRT   OutputType = "srt"
+	OutputTypeHLS   OutputType = "application/x-mpegurl"
+	OutputTypeDASH  OutputType = "application/dash+xml" // only written alongside HLS
+	OutputTypeJPEG  OutputType = "image/jpeg"           // only written as thumbnails
+	OutputTypeJSONL OutputType = "application/jsonl"    // only written as data capture sidecars
+	OutputTypeVTT   OutputType = "text/vtt"             // only written as data capture sidecars
+
+	// srt connection modes
+	SRTModeCaller     = "caller"
+	SRTModeListener   = "listener"
+	SRTModeRendezvous = "rendezvous"
 
 	// file extensions
 	FileExtensionRaw  = ".raw"
@@ -42,7 +60,9 @@ const (
 	FileExtensionMP4  = ".mp4"
 	FileExtensionTS   = ".ts"
 	FileExtensionWebM = ".webm"
+	FileExtensionMKV  = ".mkv"
 	FileExtensionM3U8 = ".m3u8"
+	FileExtensionMPD  = ".mpd"
 )
 
 var (
@@ -52,7 +72,9 @@ var (
 		OutputTypeMP4:  MimeTypeAAC,
 		OutputTypeTS:   MimeTypeAAC,
 		OutputTypeWebM: MimeTypeOpus,
+		OutputTypeMKV:  MimeTypeOpus,
 		OutputTypeRTMP: MimeTypeAAC,
+		OutputTypeSRT:  MimeTypeAAC,
 		OutputTypeHLS:  MimeTypeAAC,
 	}
 
@@ -60,8 +82,10 @@ var (
 		OutputTypeIVF:  MimeTypeVP8,
 		OutputTypeMP4:  MimeTypeH264,
 		OutputTypeTS:   MimeTypeH264,
-		OutputTypeWebM: MimeTypeVP8,
+		OutputTypeWebM: MimeTypeVP9,
+		OutputTypeMKV:  MimeTypeH264,
 		OutputTypeRTMP: MimeTypeH264,
+		OutputTypeSRT:  MimeTypeH264,
 		OutputTypeHLS:  MimeTypeH264,
 	}
 
@@ -72,9 +96,25 @@ var (
 		FileExtensionMP4:  {},
 		FileExtensionTS:   {},
 		FileExtensionWebM: {},
+		FileExtensionMKV:  {},
 		FileExtensionM3U8: {},
 	}
 
+	// output types which can be inferred from the extension of an encoded file
+	OutputTypeForFileExtension = map[FileExtension]OutputType{
+		FileExtensionOGG:  OutputTypeOGG,
+		FileExtensionMP4:  OutputTypeMP4,
+		FileExtensionWebM: OutputTypeWebM,
+		FileExtensionMKV:  OutputTypeMKV,
+	}
+
+	// containers used for track egress to a file when the filepath has no extension
+	TrackOutputTypes = map[MimeType]OutputType{
+		MimeTypeOpus: OutputTypeOGG,
+		MimeTypeVP8:  OutputTypeWebM,
+		MimeTypeH264: OutputTypeMP4,
+	}
+
 	FileExtensionForOutputType = map[OutputType]FileExtension{
 		OutputTypeRaw:  FileExtensionRaw,
 		OutputTypeOGG:  FileExtensionOGG,
@@ -82,9 +122,18 @@ var (
 		OutputTypeMP4:  FileExtensionMP4,
 		OutputTypeTS:   FileExtensionTS,
 		OutputTypeWebM: FileExtensionWebM,
+		OutputTypeMKV:  FileExtensionMKV,
 		OutputTypeHLS:  FileExtensionM3U8,
 	}
 
+	// containers which are written without seeking back, so that completed bytes can be uploaded during the recording
+	progressiveOutputTypes = map[OutputType]bool{
+		OutputTypeOGG:  true,
+		OutputTypeTS:   true,
+		OutputTypeWebM: true,
+		OutputTypeMKV:  true,
+	}
+
 	codecCompatibility = map[OutputType]map[MimeType]bool{
 		OutputTypeRaw: {
 			MimeTypeRaw: true,
@@ -108,11 +157,23 @@ var (
 		OutputTypeWebM: {
 			MimeTypeOpus: true,
 			MimeTypeVP8:  true,
+			MimeTypeVP9:  true,
+		},
+		OutputTypeMKV: {
+			MimeTypeAAC:  true,
+			MimeTypeOpus: true,
+			MimeTypeH264: true,
+			MimeTypeVP8:  true,
+			MimeTypeVP9:  true,
 		},
 		OutputTypeRTMP: {
 			MimeTypeAAC:  true,
 			MimeTypeH264: true,
 		},
+		OutputTypeSRT: {
+			MimeTypeAAC:  true,
+			MimeTypeH264: true,
+		},
 		OutputTypeHLS: {
 			MimeTypeAAC:  true,
 			MimeTypeH264: true,
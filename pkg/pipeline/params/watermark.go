@@ -0,0 +1,104 @@
+package params
+
+import (
+	"context"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+)
+
+const watermarkFetchTimeout = time.Second * 10
+
+// WatermarkParams places an image over the video, in output pixels from the top left corner
+type WatermarkParams struct {
+	Location string
+	OffsetX  int
+	OffsetY  int
+	Alpha    float64
+}
+
+// updateWatermark loads the image before the pipeline is built, so that a missing image fails the request
+func (p *Params) updateWatermark(conf *config.WatermarkConfig) error {
+	location := conf.Image
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		var err error
+		if location, err = downloadWatermark(conf.Image); err != nil {
+			return errors.ErrWatermarkFailed(conf.Image, err)
+		}
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return errors.ErrWatermarkFailed(conf.Image, err)
+	}
+	defer f.Close()
+
+	img, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return errors.ErrWatermarkFailed(conf.Image, err)
+	}
+
+	x, y := conf.OffsetX, conf.OffsetY
+	if conf.Position == config.OverlayTopRight || conf.Position == config.OverlayBottomRight {
+		x = int(p.Width) - img.Width - conf.OffsetX
+	}
+	if conf.Position == config.OverlayBottomLeft || conf.Position == config.OverlayBottomRight {
+		y = int(p.Height) - img.Height - conf.OffsetY
+	}
+
+	// images larger than the video are cropped on the right and bottom
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+
+	p.Watermark = &WatermarkParams{
+		Location: location,
+		OffsetX:  x,
+		OffsetY:  y,
+		Alpha:    conf.Opacity,
+	}
+	return nil
+}
+
+// downloadWatermark saves the image to the handler's temporary directory, which is removed when it exits
+func downloadWatermark(imageUrl string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), watermarkFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New(res.Status)
+	}
+
+	f, err := os.CreateTemp("", "watermark-*"+path.Ext(req.URL.Path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(f, res.Body); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
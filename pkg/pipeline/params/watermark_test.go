@@ -0,0 +1,43 @@
+package params
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+func TestUpdateWatermark(t *testing.T) {
+	location := path.Join(t.TempDir(), "logo.png")
+	f, err := os.Create(location)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, image.NewRGBA(image.Rect(0, 0, 200, 100))))
+	require.NoError(t, f.Close())
+
+	p := &Params{VideoParams: VideoParams{Width: 1280, Height: 720}}
+
+	require.NoError(t, p.updateWatermark(&config.WatermarkConfig{
+		Image:    location,
+		Position: config.OverlayBottomRight,
+		OffsetX:  20,
+		OffsetY:  10,
+		Opacity:  0.5,
+	}))
+	require.Equal(t, &WatermarkParams{Location: location, OffsetX: 1060, OffsetY: 610, Alpha: 0.5}, p.Watermark)
+
+	require.NoError(t, p.updateWatermark(&config.WatermarkConfig{
+		Image:    location,
+		Position: config.OverlayTopLeft,
+		OffsetX:  20,
+		OffsetY:  10,
+		Opacity:  1,
+	}))
+	require.Equal(t, &WatermarkParams{Location: location, OffsetX: 20, OffsetY: 10, Alpha: 1}, p.Watermark)
+
+	require.Error(t, p.updateWatermark(&config.WatermarkConfig{Image: path.Join(t.TempDir(), "missing.png")}))
+}
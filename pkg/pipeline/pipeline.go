@@ -3,6 +3,7 @@ package pipeline
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"regexp"
@@ -12,30 +13,46 @@ import (
 
 	"github.com/tinyzimmer/go-glib/glib"
 	"github.com/tinyzimmer/go-gst/gst"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/pipeline/input"
+	"github.com/livekit/egress/pkg/pipeline/input/builder"
 	"github.com/livekit/egress/pkg/pipeline/input/sdk"
 	"github.com/livekit/egress/pkg/pipeline/input/web"
 	"github.com/livekit/egress/pkg/pipeline/output"
 	"github.com/livekit/egress/pkg/pipeline/params"
 	"github.com/livekit/egress/pkg/pipeline/sink"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/egress/pkg/stats"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/tracer"
 )
 
 const (
 	pipelineSource    = "pipeline"
-	eosTimeout        = time.Second * 30
+	muxEOSTimeout     = time.Second * 5
 	maxPendingUploads = 100
 
+	segmentUploadAttempts = 3
+	segmentRetryDelay     = time.Second
+
+	streamReconnectDelay = time.Second
+
+	endReasonScheduled = "scheduled stop"
+
+	diskCheckInterval = time.Second * 5
+	minRunningDisk    = 64 << 20 // fail before gstreamer runs out of space mid-write
+
 	fragmentOpenedMessage = "splitmuxsink-fragment-opened"
 	fragmentClosedMessage = "splitmuxsink-fragment-closed"
 	fragmentLocation      = "location"
 	fragmentRunningTime   = "running-time"
 
 	elementGstRtmp2Sink = "GstRtmp2Sink"
+	elementGstSRTSink   = "GstSRTSink"
 	elementGstAppSrc    = "GstAppSrc"
 )
 
@@ -55,14 +72,73 @@ type Pipeline struct {
 	closed     chan struct{}
 	closeOnce  sync.Once
 	eosTimer   *time.Timer
-
-	// segments
+	err        error
+
+	// pause
+	paused      bool
+	pausedAt    time.Time
+	pausedFor   time.Duration // total time spent paused, which is missing from the output
+	sourceEnded bool
+
+	// restarts, and the chunk of a split file being written, which is kept if the pipeline fails
+	restarting    bool
+	openChunk     string
+	openChunkAt   time.Time
+	openChunkTime int64 // running time
+
+	// stream reconnection
+	reconnectConf    config.StreamReconnectConfig
+	reconnects       map[string][]time.Time // recent reconnect attempts for each url
+	streamReconnects map[string]int
+
+	// uploads
+	uploader       uploader.Uploader
+	progressive    uploader.Progressive
+	uploadRetries  atomic.Int32
+	uploadFailures atomic.Int32
+
+	// upload progress of the output file
+	uploadConf        config.UploadConfig
+	uploadedBytes     atomic.Int64
+	uploadSize        atomic.Int64
+	progressMu        sync.Mutex
+	progressUpdatedAt time.Time
+	progressPercent   float64
+
+	// segments and split file chunks
 	playlistWriter *sink.PlaylistWriter
 	segmentsWg     sync.WaitGroup
+	chunkEndTime   int64 // running time at the end of the last segment or split file chunk
 	endedSegments  chan segmentUpdate
+	hooks          *segmentHooks
+
+	thumbnails *thumbnailWriter
+	dataWriter *sink.DataWriter
+
+	// low-latency HLS, with the parts of the segment being written
+	llPlaylist       *sink.LLPlaylistWriter
+	partIndex        int
+	segmentParts     []string
+	segmentStartTime int64
+
+	// spans for the phases of the egress, under Pipeline.Run
+	traceCtx     context.Context
+	activeSpan   tracer.Span
+	finalizeSpan tracer.Span
+
+	// debug dumps
+	debugConf  config.DebugConfig
+	busHistory *busHistory
+
+	// output progress, sampled by the watchdog
+	watchdogConf  config.WatchdogConfig
+	bytesWritten  atomic.Int64
+	framesDropped atomic.Int64
+	maxQueueDepth atomic.Int64
 
 	// callbacks
 	onStatusUpdate func(context.Context, *livekit.EgressInfo)
+	onProgress     func()
 }
 
 type segmentUpdate struct {
@@ -70,7 +146,7 @@ type segmentUpdate struct {
 	localPath string
 }
 
-func New(ctx context.Context, conf *config.Config, p *params.Params) (*Pipeline, error) {
+func New(ctx context.Context, conf *config.Config, p *params.Params) (_ *Pipeline, err error) {
 	ctx, span := tracer.Start(ctx, "Pipeline.New")
 	defer span.End()
 
@@ -83,22 +159,38 @@ func New(ctx context.Context, conf *config.Config, p *params.Params) (*Pipeline,
 	}()
 
 	// create input bin
-	in, err := input.New(ctx, conf, p)
+	in, err := newInput(ctx, conf, p)
 	if err != nil {
-		return nil, err
+		return nil, errors.WithCategory(errors.CategorySource, err)
 	}
+	defer func() {
+		// don't leave the room joined or chrome running if the rest of the pipeline can't be built
+		if err != nil {
+			in.Close()
+		}
+	}()
 
 	// create output bin
 	out, err := output.New(ctx, p)
 	if err != nil {
 		return nil, err
 	}
+	if s, ok := in.(*sdk.SDKInput); ok {
+		out.SetStartTime(s.GetStartTime)
+	}
 
 	// create pipeline
 	pipeline, err := gst.NewPipeline("pipeline")
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil && conf.Debug.DotDumps {
+			if files, e := writeDebugDump(conf.Debug, p, pipeline.Bin, nil, dumpLinkFailed); e == nil {
+				p.Logger.Errorw("could not build pipeline", err, "debugDump", path.Dir(files[0]))
+			}
+		}
+	}()
 
 	// add bins to pipeline
 	if err = pipeline.Add(in.Element()); err != nil {
@@ -127,75 +219,219 @@ func New(ctx context.Context, conf *config.Config, p *params.Params) (*Pipeline,
 	}
 
 	var playlistWriter *sink.PlaylistWriter
+	var llPlaylist *sink.LLPlaylistWriter
 	if p.OutputType == params.OutputTypeHLS {
-		playlistWriter, err = sink.NewPlaylistWriter(p)
-		if err != nil {
+		if p.LowLatency() {
+			llPlaylist = sink.NewLLPlaylistWriter(p)
+		} else {
+			playlistWriter, err = sink.NewPlaylistWriter(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	pl := &Pipeline{
+		Params:           p,
+		pipeline:         pipeline,
+		in:               in,
+		out:              out,
+		playlistWriter:   playlistWriter,
+		llPlaylist:       llPlaylist,
+		reconnectConf:    conf.StreamReconnect,
+		uploadConf:       conf.Upload,
+		reconnects:       make(map[string][]time.Time),
+		streamReconnects: make(map[string]int),
+		closed:           make(chan struct{}),
+		debugConf:        conf.Debug,
+		watchdogConf:     conf.Watchdog,
+	}
+	if conf.Debug.DotDumps {
+		pl.busHistory = newBusHistory(conf.Debug.BusMessages)
+	}
+	if (len(conf.SegmentHooks.Command) > 0 || conf.SegmentHooks.Publish) &&
+		(p.EgressType == params.EgressTypeSegmentedFile || p.SplitFile()) {
+		pl.hooks = newSegmentHooks(conf.SegmentHooks, p.Logger)
+	}
+	if p.ThumbnailInterval > 0 {
+		pl.thumbnails = newThumbnailWriter(pl)
+		in.OnThumbnail(pl.thumbnails.enqueue)
+	}
+
+	// the upload config was checked with the request
+	pl.uploader, err = uploader.New(conf, p.UploadConfig, pl.onUploadRetry)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.DataCapture {
+		// track composite outputs are named once the input has joined the room
+		if pl.dataWriter, err = sink.NewDataWriter(p); err != nil {
 			return nil, err
 		}
+		in.OnData(pl.dataWriter.Write)
 	}
 
-	return &Pipeline{
-		Params:         p,
-		pipeline:       pipeline,
-		in:             in,
-		out:            out,
-		playlistWriter: playlistWriter,
-		closed:         make(chan struct{}),
-	}, nil
+	return pl, nil
 }
 
+// GetInfo returns a copy of the egress info, which the pipeline's goroutines carry on updating
 func (p *Pipeline) GetInfo() *livekit.EgressInfo {
-	return p.Info
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return proto.Clone(p.Info).(*livekit.EgressInfo)
+}
+
+// setStatus changes the egress status, if it is one of from, or from any status if none are given. It is the
+// only place the status is written, since GetInfo reads it from other goroutines
+func (p *Pipeline) setStatus(status livekit.EgressStatus, from ...livekit.EgressStatus) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(from) == 0 {
+		p.Info.Status = status
+		return true
+	}
+	for _, s := range from {
+		if p.Info.Status == s {
+			p.Info.Status = status
+			return true
+		}
+	}
+	return false
+}
+
+// GetError returns the error which caused the pipeline to fail, if any
+func (p *Pipeline) GetError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// setError is called by the main loop, the source, and the disk, watchdog and upload goroutines
+func (p *Pipeline) setError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setErrorLocked(err)
+}
+
+// setFirstError sets the error unless the pipeline has already failed, returning whether it did. Segment and chunk
+// uploads fail concurrently, and only the first should end the egress
+func (p *Pipeline) setFirstError(err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return false
+	}
+	p.setErrorLocked(err)
+	return true
+}
+
+func (p *Pipeline) setErrorLocked(err error) {
+	p.err = err
+	// errors from stream sinks can include the url
+	p.Info.Error = errors.FormatMessage(errors.Code(err), p.RedactUrls(err.Error()))
+}
+
+// addSegment counts a segment and its size, which status updates read from other goroutines
+func (p *Pipeline) addSegment(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.SegmentsInfo.SegmentCount++
+	p.SegmentsInfo.Size += size
 }
 
 func (p *Pipeline) OnStatusUpdate(f func(context.Context, *livekit.EgressInfo)) {
 	p.onStatusUpdate = f
 }
 
+// OnSegment is called for each finished segment when segment hooks are configured to publish
+func (p *Pipeline) OnSegment(f func(context.Context, *SegmentEvent) error) {
+	if p.hooks != nil {
+		p.hooks.publish = f
+	}
+}
+
+// SegmentHookFailures returns the number of segment hooks which failed or were skipped
+func (p *Pipeline) SegmentHookFailures() int {
+	if p.hooks == nil {
+		return 0
+	}
+	return int(p.hooks.failures.Load())
+}
+
 func (p *Pipeline) Run(ctx context.Context) *livekit.EgressInfo {
 	ctx, span := tracer.Start(ctx, "Pipeline.Run")
 	defer span.End()
+	p.traceCtx = ctx
 
-	p.Info.StartedAt = time.Now().UnixNano()
+	p.mu.Lock()
+	if p.Info.StartedAt == 0 {
+		// restarted pipelines carry on with the same egress
+		p.Info.StartedAt = time.Now().UnixNano()
+	}
+	p.mu.Unlock()
 	defer func() {
+		p.mu.Lock()
 		p.Info.EndedAt = time.Now().UnixNano()
+		p.mu.Unlock()
 
 		// update status
-		if p.Info.Error != "" {
-			p.Info.Status = livekit.EgressStatus_EGRESS_FAILED
+		if p.GetError() != nil {
+			p.setStatus(livekit.EgressStatus_EGRESS_FAILED)
+		} else {
+			p.setStatus(livekit.EgressStatus_EGRESS_COMPLETE, livekit.EgressStatus_EGRESS_ENDING)
 		}
 
-		switch p.Info.Status {
-		case livekit.EgressStatus_EGRESS_STARTING:
-		case livekit.EgressStatus_EGRESS_ACTIVE:
-		case livekit.EgressStatus_EGRESS_ENDING:
-			p.Info.Status = livekit.EgressStatus_EGRESS_COMPLETE
+		if p.progressive != nil {
+			// no-op once finished
+			p.progressive.Abort()
+		}
+		if p.dataWriter != nil {
+			// no-op once closed
+			_, _, _ = p.dataWriter.Close()
+		}
+		if !p.restarting {
+			// the next pipeline carries on in the same directory
+			p.cleanup()
 		}
-
-		p.cleanup()
 	}()
 
 	// wait until room is ready
-	start := p.in.StartRecording()
-	if start != nil {
-		select {
-		case <-p.closed:
-			p.in.Close()
-			p.Info.Status = livekit.EgressStatus_EGRESS_ABORTED
-			return p.Info
-		case <-start:
-			// continue
-		}
+	if start := p.in.StartRecording(); start != nil && !p.waitForStart(start) {
+		p.in.Close()
+		return p.Info
 	}
 
 	// close when room ends
 	go func() {
 		<-p.in.EndRecording()
+		p.mu.Lock()
+		p.sourceEnded = true
+		p.mu.Unlock()
+		if p.EndReason != "" {
+			p.Logger.Infow("source ended", "reason", p.EndReason)
+		}
 		p.SendEOS(ctx)
 	}()
 
-	// session limit timer
-	p.startSessionLimitTimer(ctx)
+	// fail if a track composite track is not republished in time
+	if s, ok := p.in.(*sdk.SDKInput); ok {
+		go func() {
+			select {
+			case <-p.closed:
+			case err := <-s.Failure():
+				p.Logger.Errorw("source failed", err)
+				p.setError(err)
+				p.stop()
+			}
+		}()
+	}
 
 	// add watch
 	p.loop = glib.NewMainLoop(glib.MainContextDefault(), false)
@@ -205,39 +441,111 @@ func (p *Pipeline) Run(ctx context.Context) *livekit.EgressInfo {
 	if err := p.pipeline.SetState(gst.StatePlaying); err != nil {
 		span.RecordError(err)
 		p.Logger.Errorw("failed to set pipeline state", err)
-		p.Info.Error = err.Error()
+		p.setError(err)
 		return p.Info
 	}
 
-	if p.EgressType == params.EgressTypeSegmentedFile {
+	if p.ProgressiveUpload {
+		p.startProgressiveUpload()
+	}
+
+	if p.EgressType == params.EgressTypeSegmentedFile || p.SplitFile() {
+		if p.hooks != nil {
+			p.hooks.start()
+			defer func() {
+				// after the segment worker has finished
+				p.segmentsWg.Wait()
+				p.hooks.close()
+			}()
+		}
 		p.startSegmentWorker()
 		defer close(p.endedSegments)
 	}
 
+	switch p.EgressType {
+	case params.EgressTypeFile, params.EgressTypeSegmentedFile:
+		go p.watchDisk()
+	}
+	go p.watchProgress()
+	if p.thumbnails != nil {
+		p.thumbnails.start()
+	}
+
 	// run main loop
 	p.loop.Run()
+	p.endPhaseSpans()
+
+	if p.VideoEnabled {
+		p.sampleFrameStats()
+		p.FramesDropped, p.MaxQueueDepth = p.FrameStats()
+		p.Logger.Infow("video frame stats", "framesDropped", p.FramesDropped, "maxQueueDepth", p.MaxQueueDepth)
+	}
+	if p.AudioEnabled && p.VideoEnabled {
+		var correction time.Duration
+		p.MaxDrift, correction = p.in.DriftStats()
+		p.Logger.Infow("a/v drift stats", "maxDrift", p.MaxDrift, "correction", correction)
+	}
 
 	// close input source
 	p.in.Close()
 
+	if p.thumbnails != nil {
+		p.ThumbnailCount = p.thumbnails.close()
+	}
+	if p.dataWriter != nil {
+		p.closeDataCapture()
+	}
+
 	// update endedAt from sdk source
 	switch s := p.in.(type) {
 	case *sdk.SDKInput:
 		p.updateDuration(s.GetEndTime())
 	}
 
-	// skip upload if there was an error
-	if p.Info.Error != "" {
+	// skip upload if there was an error, unless the pipeline will be rebuilt
+	if p.restarting {
+		p.keepOutput(ctx)
+		return p.Info
+	}
+	if p.GetError() != nil {
 		return p.Info
 	}
 
 	// upload file
 	switch p.EgressType {
 	case params.EgressTypeFile:
-		var err error
-		p.FileInfo.Location, p.FileInfo.Size, err = p.storeFile(ctx, p.LocalFilepath, p.StorageFilepath, p.OutputType)
-		if err != nil {
-			p.Info.Error = err.Error()
+		if p.SplitFile() {
+			// chunks are uploaded as they are written
+			p.segmentsWg.Wait()
+		} else {
+			events, dropped := p.roomEvents()
+			if p.Chapters {
+				p.writeChapters(events)
+			}
+
+			var location string
+			var size int64
+			var err error
+			if p.progressive != nil {
+				location, size, err = p.finishProgressiveUpload(ctx)
+			} else {
+				location, size, err = p.storeOutput(ctx)
+			}
+			p.mu.Lock()
+			p.FileInfo.Location, p.FileInfo.Size = location, size
+			p.mu.Unlock()
+			if err != nil {
+				p.setError(err)
+			}
+
+			if p.RoomEventsFilepath != "" {
+				if err = p.storeRoomEvents(ctx, events, dropped); err != nil {
+					p.Logger.Errorw("could not store room events", err)
+				}
+			}
+		}
+		if p.dataWriter != nil {
+			p.storeDataCapture(ctx)
 		}
 
 		manifestLocalPath := fmt.Sprintf("%s.json", p.LocalFilepath)
@@ -252,15 +560,27 @@ func (p *Pipeline) Run(ctx context.Context) *livekit.EgressInfo {
 			p.segmentsWg.Wait()
 		}
 
-		if p.playlistWriter != nil {
+		if p.llPlaylist != nil {
+			// the last segment can have fewer parts
+			p.endLLSegment()
+			if err := p.llPlaylist.EOS(); err != nil {
+				p.Logger.Errorw("failed to send EOS to playlist writer", err)
+			}
+		} else if p.playlistWriter != nil {
 			if err := p.playlistWriter.EOS(); err != nil {
 				p.Logger.Errorw("failed to send EOS to playlist writer", err)
 			}
+		}
+
+		if p.dataWriter != nil {
+			p.storeDataCapture(ctx)
+		}
 
+		if p.playlistWriter != nil || p.llPlaylist != nil {
 			// upload the finalized playlist
-			playlistStoragePath := p.GetStorageFilepath(p.PlaylistFilename)
-			p.SegmentsInfo.PlaylistLocation, _, _ = p.storeFile(ctx, p.PlaylistFilename, playlistStoragePath, p.OutputType)
+			p.storePlaylist(ctx)
 
+			playlistStoragePath := p.GetStorageFilepath(p.PlaylistFilename)
 			manifestLocalPath := fmt.Sprintf("%s.json", p.PlaylistFilename)
 			manifestStoragePath := fmt.Sprintf("%s.json", playlistStoragePath)
 			if err := p.storeManifest(ctx, manifestLocalPath, manifestStoragePath); err != nil {
@@ -273,6 +593,10 @@ func (p *Pipeline) Run(ctx context.Context) *livekit.EgressInfo {
 }
 
 func (p *Pipeline) messageWatch(msg *gst.Message) bool {
+	if p.busHistory != nil {
+		p.busHistory.add(msg)
+	}
+
 	switch msg.Type() {
 	case gst.MessageEOS:
 		// EOS received - close and return
@@ -292,7 +616,8 @@ func (p *Pipeline) messageWatch(msg *gst.Message) bool {
 		// handle error if possible, otherwise close and return
 		err, handled := p.handleError(msg.ParseError())
 		if !handled {
-			p.Info.Error = err.Error()
+			p.restarting = p.canRestart(err)
+			p.setError(err)
 			p.stop()
 			return false
 		}
@@ -336,6 +661,10 @@ func (p *Pipeline) messageWatch(msg *gst.Message) bool {
 				}
 
 				p.Logger.Debugw("fragment opened", "location", filepath, "running time", t)
+				p.SegmentIndex++
+				if p.SplitFile() {
+					p.openChunk, p.openChunkAt, p.openChunkTime = filepath, time.Now(), t
+				}
 
 				if p.playlistWriter != nil {
 					if err = p.playlistWriter.StartSegment(filepath, t); err != nil {
@@ -352,6 +681,9 @@ func (p *Pipeline) messageWatch(msg *gst.Message) bool {
 				}
 
 				p.Logger.Debugw("fragment closed", "location", filepath, "running time", t)
+				if filepath == p.openChunk {
+					p.openChunk = ""
+				}
 
 				// We need to dispatch to a queue to:
 				// 1. Avoid concurrent access to the SegmentsInfo structure
@@ -385,7 +717,9 @@ func (p *Pipeline) UpdateStream(ctx context.Context, req *livekit.UpdateStreamRe
 	}
 
 	errs := make([]string, 0)
+	changed := false
 
+	// urls are added first, so that the last url can be replaced in a single request
 	now := time.Now().UnixNano()
 	for _, url := range req.AddOutputUrls {
 		if err := p.out.AddSink(url); err != nil {
@@ -395,19 +729,27 @@ func (p *Pipeline) UpdateStream(ctx context.Context, req *livekit.UpdateStreamRe
 
 		p.mu.Lock()
 		streamInfo := &livekit.StreamInfo{
-			Url:       url,
+			Url:       p.StreamInfoUrl(url),
 			StartedAt: now,
 			Status:    livekit.StreamInfo_ACTIVE,
 		}
 		p.StreamInfo[url] = streamInfo
 		p.Info.GetStream().Info = append(p.Info.GetStream().Info, streamInfo)
 		p.mu.Unlock()
+		changed = true
 	}
 
+	// removing the last url stops the egress
 	for _, url := range req.RemoveOutputUrls {
 		if err := p.removeSink(url, livekit.StreamInfo_FINISHED); err != nil {
 			errs = append(errs, err.Error())
+			continue
 		}
+		changed = true
+	}
+
+	if changed && p.onStatusUpdate != nil {
+		p.onStatusUpdate(ctx, p.GetInfo())
 	}
 
 	if len(errs) > 0 {
@@ -420,7 +762,11 @@ func (p *Pipeline) removeSink(url string, status livekit.StreamInfo_Status) erro
 	now := time.Now().UnixNano()
 
 	p.mu.Lock()
-	streamInfo := p.StreamInfo[url]
+	streamInfo, ok := p.StreamInfo[url]
+	if !ok {
+		p.mu.Unlock()
+		return errors.ErrStreamNotFound
+	}
 	streamInfo.Status = status
 	streamInfo.EndedAt = now
 	if streamInfo.StartedAt == 0 {
@@ -432,7 +778,7 @@ func (p *Pipeline) removeSink(url string, status livekit.StreamInfo_Status) erro
 	done := len(p.StreamInfo) == 0
 	p.mu.Unlock()
 
-	p.Logger.Debugw("removing stream sink", "url", url, "status", status, "duration", streamInfo.Duration)
+	p.Logger.Debugw("removing stream sink", "url", p.RedactUrl(url), "status", status, "duration", streamInfo.Duration)
 
 	switch status {
 	case livekit.StreamInfo_FINISHED:
@@ -444,11 +790,224 @@ func (p *Pipeline) removeSink(url string, status livekit.StreamInfo_Status) erro
 		if done {
 			return errors.New("could not connect")
 		} else if p.onStatusUpdate != nil {
-			p.onStatusUpdate(context.Background(), p.Info)
+			p.onStatusUpdate(context.Background(), p.GetInfo())
 		}
 	}
 
-	return p.out.RemoveSink(url)
+	// the sink may be missing if the url was reconnecting
+	if err := p.out.RemoveSink(url); err != nil && !errors.Is(err, errors.ErrStreamNotFound) {
+		return err
+	}
+	return nil
+}
+
+// reconnectSink removes a disconnected stream sink and schedules a new connection to url.
+// It returns false once reconnects within the configured window have been used up.
+func (p *Pipeline) reconnectSink(url string) bool {
+	now := time.Now()
+
+	p.mu.Lock()
+	if _, ok := p.StreamInfo[url]; !ok {
+		p.mu.Unlock()
+		return false
+	}
+
+	attempts := make([]time.Time, 0, len(p.reconnects[url])+1)
+	for _, t := range p.reconnects[url] {
+		if now.Sub(t) < p.reconnectConf.Window {
+			attempts = append(attempts, t)
+		}
+	}
+	if len(attempts) >= p.reconnectConf.MaxAttempts {
+		p.reconnects[url] = attempts
+		p.mu.Unlock()
+		return false
+	}
+
+	attempts = append(attempts, now)
+	p.reconnects[url] = attempts
+	p.streamReconnects[url]++
+	delay := streamReconnectDelay << (len(attempts) - 1)
+	p.mu.Unlock()
+
+	if err := p.out.RemoveSink(url); err != nil {
+		p.Logger.Errorw("failed to remove stream sink", err, "url", p.RedactUrl(url))
+		return false
+	}
+
+	p.Logger.Infow("reconnecting stream", "url", p.RedactUrl(url), "attempt", len(attempts), "delay", delay)
+	go p.restoreSink(url, delay)
+	return true
+}
+
+func (p *Pipeline) restoreSink(url string, delay time.Duration) {
+	select {
+	case <-time.After(delay):
+	case <-p.closed:
+		return
+	}
+
+	p.mu.Lock()
+	_, ok := p.StreamInfo[url]
+	p.mu.Unlock()
+	if !ok {
+		// removed while reconnecting
+		return
+	}
+
+	if err := p.out.AddSink(url); err != nil {
+		p.Logger.Errorw("failed to reconnect stream", err, "url", p.RedactUrl(url))
+		if err = p.removeSink(url, livekit.StreamInfo_FAILED); err != nil {
+			p.setError(err)
+			p.stop()
+		}
+		return
+	}
+
+	if p.onStatusUpdate != nil {
+		p.onStatusUpdate(context.Background(), p.GetInfo())
+	}
+}
+
+// StreamReconnects returns the number of reconnects made for each stream url
+func (p *Pipeline) StreamReconnects() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.EgressType == params.EgressTypeWebsocket {
+		// the websocket sink reconnects by itself
+		if count, _ := p.out.WebsocketStats(); count > 0 {
+			return map[string]int{p.WebsocketUrl: count}
+		}
+		return nil
+	}
+
+	if len(p.streamReconnects) == 0 {
+		return nil
+	}
+	reconnects := make(map[string]int, len(p.streamReconnects))
+	for url, count := range p.streamReconnects {
+		reconnects[url] = count
+	}
+	return reconnects
+}
+
+// WebsocketDroppedBytes returns the number of bytes dropped by the websocket output while its consumer
+// was disconnected or falling behind
+func (p *Pipeline) WebsocketDroppedBytes() int64 {
+	_, dropped := p.out.WebsocketStats()
+	return dropped
+}
+
+// VideoLayerSwitches returns the number of times the subscribed video changed dimensions, such as when the sfu
+// switches simulcast layers
+func (p *Pipeline) VideoLayerSwitches() int {
+	if s, ok := p.in.(*sdk.SDKInput); ok {
+		return s.LayerSwitches()
+	}
+	return 0
+}
+
+// PacketStats returns the number of rtp packets which were lost, arrived out of order, and were concealed
+func (p *Pipeline) PacketStats() (lost, reordered, concealed int64) {
+	if s, ok := p.in.(*sdk.SDKInput); ok {
+		return s.PacketStats()
+	}
+	return 0, 0, 0
+}
+
+// FirstKeyFrameDelay returns the time taken for the first video key frame to arrive, or 0 if none has arrived
+func (p *Pipeline) FirstKeyFrameDelay() time.Duration {
+	if s, ok := p.in.(*sdk.SDKInput); ok {
+		return s.FirstKeyFrameDelay()
+	}
+	return 0
+}
+
+// Pause stops writing to the output file until Resume is called
+func (p *Pipeline) Pause(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "Pipeline.Pause")
+	defer span.End()
+
+	if p.EgressType != params.EgressTypeFile {
+		return errors.ErrNotSupported("pausing non-file egress")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Info.Status != livekit.EgressStatus_EGRESS_ACTIVE {
+		return errors.ErrEgressNotActive
+	}
+	if p.paused {
+		return nil
+	}
+
+	if err := p.in.Pause(); err != nil {
+		return err
+	}
+	p.paused = true
+	p.pausedAt = time.Now()
+	p.Logger.Infow("egress paused")
+
+	return nil
+}
+
+// Resume continues writing to the output file, without a gap in the timeline
+func (p *Pipeline) Resume(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "Pipeline.Resume")
+	defer span.End()
+
+	if p.EgressType != params.EgressTypeFile {
+		return errors.ErrNotSupported("pausing non-file egress")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sourceEnded {
+		return errors.ErrSourceDisconnected
+	}
+	if p.Info.Status != livekit.EgressStatus_EGRESS_ACTIVE {
+		return errors.ErrEgressNotActive
+	}
+	if !p.paused {
+		return nil
+	}
+
+	pausedFor := time.Since(p.pausedAt)
+	if err := p.in.Resume(pausedFor); err != nil {
+		return err
+	}
+	p.paused = false
+	p.pausedFor += pausedFor
+	p.Logger.Infow("egress resumed", "pausedFor", pausedFor)
+
+	return nil
+}
+
+// IsPaused returns true while output is paused
+func (p *Pipeline) IsPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused
+}
+
+// unpauseForEOS reopens the valves so that EOS can reach the muxer
+func (p *Pipeline) unpauseForEOS() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return
+	}
+	pausedFor := time.Since(p.pausedAt)
+	if err := p.in.Resume(pausedFor); err != nil {
+		p.Logger.Errorw("failed to unpause pipeline", err)
+	}
+	p.paused = false
+	p.pausedFor += pausedFor
 }
 
 func (p *Pipeline) SendEOS(ctx context.Context) {
@@ -460,11 +1019,9 @@ func (p *Pipeline) SendEOS(ctx context.Context) {
 
 		go func() {
 			p.Logger.Debugw("sending EOS to pipeline")
-			p.eosTimer = time.AfterFunc(eosTimeout, func() {
-				p.Logger.Errorw("pipeline frozen", nil)
-				p.Info.Error = "pipeline frozen"
-				p.stop()
-			})
+			p.eosTimer = time.AfterFunc(p.EOSTimeout, p.onEOSTimeout)
+
+			p.unpauseForEOS()
 
 			switch s := p.in.(type) {
 			case *sdk.SDKInput:
@@ -476,27 +1033,78 @@ func (p *Pipeline) SendEOS(ctx context.Context) {
 	})
 }
 
+// ScheduledStop stops the egress at the time scheduled for it, the same way as SendEOS. An egress which is already
+// stopping keeps its end reason
+func (p *Pipeline) ScheduledStop(ctx context.Context) {
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+
+	p.Logger.Infow("scheduled stop reached, stopping egress")
+	p.EndReason = endReasonScheduled
+	p.SendEOS(ctx)
+}
+
+// onEOSTimeout stops a pipeline which hasn't flushed within the eos timeout, usually because a sink on a dead
+// connection never forwards EOS. The muxer is sent EOS directly first, so that what it has written is finalized
+// and uploaded
+func (p *Pipeline) onEOSTimeout() {
+	args := []interface{}{"timeout", p.EOSTimeout}
+	if dump := p.debugDump(dumpEOSTimeout); dump != "" {
+		args = append(args, "debugDump", dump)
+	}
+	p.Logger.Warnw("EOS timed out, forcing pipeline to stop", nil, args...)
+	p.Warnings = append(p.Warnings, fmt.Sprintf("pipeline did not flush within %v and was stopped, output may be truncated", p.EOSTimeout))
+
+	if p.in != nil && p.in.SendMuxEOS() {
+		// stops early if EOS reaches the sinks
+		time.AfterFunc(muxEOSTimeout, p.stop)
+		return
+	}
+	p.stop()
+}
+
 func (p *Pipeline) close(ctx context.Context) {
+	p.mu.Lock()
 	close(p.closed)
 	if p.limitTimer != nil {
 		p.limitTimer.Stop()
 	}
+	p.startFinalizeSpan()
+	p.mu.Unlock()
 
-	if p.Info.Status == livekit.EgressStatus_EGRESS_ACTIVE {
-		p.Info.Status = livekit.EgressStatus_EGRESS_ENDING
+	if p.setStatus(livekit.EgressStatus_EGRESS_ENDING, livekit.EgressStatus_EGRESS_ACTIVE) {
 		if p.onStatusUpdate != nil {
-			p.onStatusUpdate(ctx, p.Info)
+			p.onStatusUpdate(ctx, p.GetInfo())
 		}
 	}
 }
 
+// startSessionLimitTimer stops the egress once it has been active for its max duration
 func (p *Pipeline) startSessionLimitTimer(ctx context.Context) {
-	if timeout := p.GetSessionTimeout(); timeout > 0 {
-		p.limitTimer = time.AfterFunc(timeout, func() {
-			p.SendEOS(ctx)
-			p.Info.Status = livekit.EgressStatus_EGRESS_LIMIT_REACHED
-		})
+	timeout := p.GetSessionTimeout()
+	if timeout <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.closed:
+		// already stopping
+		return
+	default:
 	}
+
+	p.limitTimer = time.AfterFunc(timeout, func() {
+		p.Logger.Infow("max duration reached, stopping egress", "maxDuration", timeout)
+		// set before sending EOS so the status is not changed to ending
+		p.setStatus(livekit.EgressStatus_EGRESS_LIMIT_REACHED)
+		p.SendEOS(ctx)
+	})
 }
 
 func (p *Pipeline) updateStartTime(startedAt int64) {
@@ -505,20 +1113,71 @@ func (p *Pipeline) updateStartTime(startedAt int64) {
 		p.mu.Lock()
 		for _, streamInfo := range p.StreamInfo {
 			streamInfo.Status = livekit.StreamInfo_ACTIVE
-			streamInfo.StartedAt = startedAt
+			if streamInfo.StartedAt == 0 {
+				// streams carried on by a restarted pipeline keep their start
+				streamInfo.StartedAt = startedAt
+			}
 		}
 		p.mu.Unlock()
 
 	case params.EgressTypeFile:
+		p.mu.Lock()
 		p.FileInfo.StartedAt = startedAt
+		p.mu.Unlock()
 
 	case params.EgressTypeSegmentedFile:
-		p.SegmentsInfo.StartedAt = startedAt
+		p.mu.Lock()
+		if p.SegmentsInfo.StartedAt == 0 {
+			// restarted pipelines add to the same playlist
+			p.SegmentsInfo.StartedAt = startedAt
+		}
+		p.mu.Unlock()
+	}
+	if p.dataWriter != nil {
+		if p.EgressType == params.EgressTypeSegmentedFile {
+			p.dataWriter.SetStartTime(p.SegmentsInfo.StartedAt)
+		} else {
+			p.dataWriter.SetStartTime(startedAt)
+		}
 	}
 
-	p.Info.Status = livekit.EgressStatus_EGRESS_ACTIVE
+	p.setStatus(livekit.EgressStatus_EGRESS_ACTIVE)
 	if p.onStatusUpdate != nil {
-		p.onStatusUpdate(context.Background(), p.Info)
+		p.onStatusUpdate(context.Background(), p.GetInfo())
+	}
+
+	p.startSessionLimitTimer(context.Background())
+	p.startActiveSpan()
+}
+
+// watchDisk fails the egress before the local disk fills up
+func (p *Pipeline) watchDisk() {
+	var dir string
+	if p.EgressType == params.EgressTypeFile {
+		dir = path.Dir(p.LocalFilepath)
+	} else {
+		dir = path.Dir(p.PlaylistFilename)
+	}
+
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			free, err := stats.GetFreeDisk(dir)
+			if err != nil {
+				continue
+			}
+			if free < minRunningDisk {
+				p.Logger.Errorw("disk full", errors.ErrDiskFull, "path", dir, "freeBytes", free)
+				p.setError(errors.ErrDiskFull)
+				p.stop()
+				return
+			}
+		}
 	}
 }
 
@@ -527,15 +1186,32 @@ func (p *Pipeline) startSegmentWorker() {
 
 	go func() {
 		for update := range p.endedSegments {
+			if p.EgressType == params.EgressTypeFile {
+				p.storeChunk(update)
+				p.segmentsWg.Done()
+				continue
+			}
+
 			func() {
 				defer p.segmentsWg.Done()
 
-				p.SegmentsInfo.SegmentCount++
+				if p.llPlaylist != nil {
+					p.storePart(update)
+					return
+				}
 
 				segmentStoragePath := p.GetStorageFilepath(update.localPath)
-				// Ignore error. storeFile will log it.
-				_, size, _ := p.storeFile(context.Background(), update.localPath, segmentStoragePath, p.GetSegmentOutputType())
-				p.SegmentsInfo.Size += size
+				location, size, err := p.storeSegment(update.localPath, segmentStoragePath)
+				p.addSegment(size)
+				// a missing segment breaks the playlist, so the egress fails
+				if err != nil && p.setFirstError(err) {
+					p.SendEOS(context.Background())
+				}
+
+				if p.hooks != nil {
+					p.hooks.enqueue(p.segmentEvent(update, location, err), false)
+				}
+				p.chunkEndTime = update.endTime
 
 				if p.playlistWriter != nil {
 					err := p.playlistWriter.EndSegment(update.localPath, update.endTime)
@@ -543,14 +1219,94 @@ func (p *Pipeline) startSegmentWorker() {
 						p.Logger.Errorw("failed to end segment", err, "path", update.localPath)
 						return
 					}
-					playlistStoragePath := p.GetStorageFilepath(p.PlaylistFilename)
-					p.SegmentsInfo.PlaylistLocation, _, _ = p.storeFile(context.Background(), p.PlaylistFilename, playlistStoragePath, p.OutputType)
+					p.storePlaylist(context.Background())
 				}
 			}()
 		}
 	}()
 }
 
+// storePlaylist uploads the playlist and DASH manifest, once everything they list has been uploaded
+func (p *Pipeline) storePlaylist(ctx context.Context) {
+	playlistStoragePath := p.GetStorageFilepath(p.PlaylistFilename)
+	playlistLocation, _, _ := p.storeFile(ctx, p.PlaylistFilename, playlistStoragePath, p.OutputType, nil)
+	p.mu.Lock()
+	p.SegmentsInfo.PlaylistLocation = playlistLocation
+	p.mu.Unlock()
+
+	if p.DASHManifestFilename != "" {
+		manifestStoragePath := p.GetStorageFilepath(p.DASHManifestFilename)
+		p.DASHManifestLocation, _, _ = p.storeFile(ctx, p.DASHManifestFilename, manifestStoragePath, params.OutputTypeDASH, nil)
+	}
+}
+
+// storeChunk uploads a finished chunk of a split file. FileInfo describes the first chunk, with the total size.
+func (p *Pipeline) storeChunk(update segmentUpdate) {
+	storagePath := p.GetChunkStorageFilepath(update.localPath)
+	location, size, err := p.storeSegment(update.localPath, storagePath)
+	if err != nil && p.setFirstError(err) {
+		p.SendEOS(context.Background())
+	}
+
+	hookEvent := p.segmentEvent(update, location, err)
+	startTime := p.chunkEndTime
+	p.chunkEndTime = update.endTime
+
+	p.mu.Lock()
+	p.FileChunks = append(p.FileChunks, &params.FileChunk{
+		Filename: storagePath,
+		Location: location,
+		Size:     size,
+		Duration: update.endTime - startTime,
+	})
+	if len(p.FileChunks) == 1 {
+		p.FileInfo.Filename = storagePath
+		p.FileInfo.Location = location
+	}
+	p.FileInfo.Size += size
+	p.mu.Unlock()
+
+	// free up disk for the rest of the recording
+	remove := p.UploadConfig != nil && p.RetainedPath == "" && err == nil
+	if p.hooks != nil {
+		// removed once the hooks have run
+		p.hooks.enqueue(hookEvent, remove)
+	} else if remove {
+		_ = os.Remove(update.localPath)
+	}
+}
+
+func (p *Pipeline) segmentEvent(update segmentUpdate, location string, uploadErr error) *SegmentEvent {
+	event := &SegmentEvent{
+		EgressID:  p.Info.EgressId,
+		LocalPath: update.localPath,
+		StartTime: p.chunkEndTime,
+		Duration:  update.endTime - p.chunkEndTime,
+	}
+	if p.UploadConfig != nil && uploadErr == nil {
+		event.Location = location
+	}
+	return event
+}
+
+// storeSegment uploads a segment, retrying failed uploads
+func (p *Pipeline) storeSegment(localPath, storagePath string) (string, int64, error) {
+	for attempt := 1; ; attempt++ {
+		location, size, err := p.upload(context.Background(), localPath, storagePath, p.GetSegmentOutputType(), nil)
+		if err == nil {
+			return location, size, nil
+		}
+		if attempt >= segmentUploadAttempts {
+			p.uploadFailures.Inc()
+			return location, size, err
+		}
+
+		p.uploadRetries.Inc()
+		p.Logger.Infow("retrying segment upload", "path", localPath, "attempt", attempt)
+		time.Sleep(segmentRetryDelay * time.Duration(attempt))
+	}
+}
+
 func (p *Pipeline) enqueueSegmentUpload(segmentPath string, endTime int64) error {
 	p.segmentsWg.Add(1)
 	select {
@@ -585,7 +1341,7 @@ func (p *Pipeline) updateDuration(endedAt int64) {
 			p.FileInfo.StartedAt = endedAt
 		}
 		p.FileInfo.EndedAt = endedAt
-		p.FileInfo.Duration = endedAt - p.FileInfo.StartedAt
+		p.FileInfo.Duration = endedAt - p.FileInfo.StartedAt - int64(p.pausedFor)
 
 	case params.EgressTypeSegmentedFile:
 		if p.SegmentsInfo.StartedAt == 0 {
@@ -618,51 +1374,138 @@ func (p *Pipeline) stop() {
 	}
 }
 
-func (p *Pipeline) storeFile(ctx context.Context, localFilepath, storageFilepath string, mime params.OutputType) (destinationUrl string, size int64, err error) {
-	ctx, span := tracer.Start(ctx, "Pipeline.storeFile")
-	defer span.End()
+// startProgressiveUpload starts uploading the output while it is written. If the upload can't be started,
+// the file is uploaded once the pipeline has finished
+func (p *Pipeline) startProgressiveUpload() {
+	u, ok := p.uploader.(uploader.ProgressiveUploader)
+	if !ok {
+		return
+	}
 
-	fileInfo, err := os.Stat(localFilepath)
-	if err == nil {
+	progressive, err := u.UploadProgressive(p.LocalFilepath, p.StorageFilepath, string(p.OutputType))
+	if err != nil {
+		p.Logger.Warnw("could not start progressive upload", err, "location", u.Location())
+		return
+	}
+	p.progressive = progressive
+}
+
+// finishProgressiveUpload uploads the rest of the output, falling back to uploading the whole file
+func (p *Pipeline) finishProgressiveUpload(ctx context.Context) (string, int64, error) {
+	destinationUrl, err := p.progressive.Finish()
+	if err != nil {
+		p.Logger.Warnw("progressive upload failed, uploading file", err, "location", p.uploader.Location())
+		return p.storeOutput(ctx)
+	}
+
+	var size int64
+	if fileInfo, err := os.Stat(p.LocalFilepath); err == nil {
 		size = fileInfo.Size()
 	} else {
 		p.Logger.Errorw("could not read file size", err)
 	}
+	return destinationUrl, size, nil
+}
 
-	var location string
-	switch u := p.UploadConfig.(type) {
-	case *livekit.S3Upload:
-		location = "S3"
-		p.Logger.Debugw("uploading to s3")
-		destinationUrl, err = sink.UploadS3(u, localFilepath, storageFilepath, mime)
+// storeOutput uploads the output file, sending updates with its progress
+func (p *Pipeline) storeOutput(ctx context.Context) (string, int64, error) {
+	fileInfo, err := os.Stat(p.LocalFilepath)
+	if err != nil || p.uploader == nil {
+		return p.storeFile(ctx, p.LocalFilepath, p.StorageFilepath, p.OutputType, nil)
+	}
 
-	case *livekit.GCPUpload:
-		location = "GCP"
-		p.Logger.Debugw("uploading to gcp")
-		destinationUrl, err = sink.UploadGCP(u, localFilepath, storageFilepath, mime)
+	// the final size is known before the upload starts
+	p.mu.Lock()
+	p.FileInfo.Size = fileInfo.Size()
+	p.mu.Unlock()
+	p.uploadSize.Store(fileInfo.Size())
+	p.progressMu.Lock()
+	p.progressUpdatedAt = time.Now()
+	p.progressMu.Unlock()
 
-	case *livekit.AzureBlobUpload:
-		location = "Azure"
-		p.Logger.Debugw("uploading to azure")
-		destinationUrl, err = sink.UploadAzure(u, localFilepath, storageFilepath, mime)
+	return p.storeFile(ctx, p.LocalFilepath, p.StorageFilepath, p.OutputType, p.onUploadProgress)
+}
 
-	case *livekit.AliOSSUpload:
-		location = "AliOSS"
-		p.Logger.Debugw("uploading to alioss")
-		destinationUrl, err = sink.UploadAliOSS(u, localFilepath, storageFilepath, mime)
-	default:
-		destinationUrl = storageFilepath
+// onUploadProgress sends an update once ProgressInterval has passed, or ProgressStep percent more of the file
+// has been uploaded since the last update
+func (p *Pipeline) onUploadProgress(uploaded int64) {
+	size := p.uploadSize.Load()
+	if size <= 0 {
+		return
+	}
+	if uploaded > size {
+		uploaded = size
+	}
+	p.uploadedBytes.Store(uploaded)
+	percent := float64(uploaded) * 100 / float64(size)
+
+	// updates are sent in order
+	p.progressMu.Lock()
+	defer p.progressMu.Unlock()
+
+	if time.Since(p.progressUpdatedAt) < p.uploadConf.ProgressInterval && percent-p.progressPercent < p.uploadConf.ProgressStep {
+		return
 	}
+	p.progressUpdatedAt = time.Now()
+	p.progressPercent = percent
+
+	p.Logger.Debugw("upload progress", "uploaded", uploaded, "size", size, "percent", int(percent))
+	if p.onStatusUpdate != nil {
+		p.onStatusUpdate(context.Background(), p.GetInfo())
+	}
+}
 
+// UploadProgress returns the number of bytes of the output file which have been uploaded, and its size,
+// or zeros if the upload has not started
+func (p *Pipeline) UploadProgress() (uploaded, size int64) {
+	return p.uploadedBytes.Load(), p.uploadSize.Load()
+}
+
+// storeFile uploads a file, keeping the local file if the upload fails
+func (p *Pipeline) storeFile(ctx context.Context, localFilepath, storageFilepath string, mime params.OutputType, progress uploader.ProgressFunc) (string, int64, error) {
+	destinationUrl, size, err := p.upload(ctx, localFilepath, storageFilepath, mime, progress)
+	if err != nil {
+		p.uploadFailures.Inc()
+	}
+	return destinationUrl, size, err
+}
+
+func (p *Pipeline) upload(ctx context.Context, localFilepath, storageFilepath string, mime params.OutputType, progress uploader.ProgressFunc) (destinationUrl string, size int64, err error) {
+	ctx, span := tracer.Start(ctx, "Pipeline.upload")
+	defer span.End()
+
+	fileInfo, err := os.Stat(localFilepath)
+	if err == nil {
+		size = fileInfo.Size()
+	} else {
+		p.Logger.Errorw("could not read file size", err)
+	}
+
+	if p.uploader == nil {
+		return storageFilepath, size, nil
+	}
+
+	location := p.uploader.Location()
+	p.Logger.Debugw("uploading file", "location", location)
+	destinationUrl, err = p.uploader.Upload(localFilepath, storageFilepath, string(mime), progress)
 	if err != nil {
-		p.Logger.Errorw("could not upload file", err, "location", location)
-		err = errors.ErrUploadFailed(location, err)
+		p.Logger.Errorw("could not upload file", err, "location", location, "localFilepath", localFilepath)
+		err = errors.ErrUploadFailed(location, fmt.Errorf("%v, local file kept at %s", err, localFilepath))
 		span.RecordError(err)
 	}
 
 	return destinationUrl, size, err
 }
 
+func (p *Pipeline) onUploadRetry() {
+	p.uploadRetries.Inc()
+}
+
+// UploadStats returns the number of upload requests retried, and the number of uploads which failed
+func (p *Pipeline) UploadStats() (retries, failures int) {
+	return int(p.uploadRetries.Load()), int(p.uploadFailures.Load())
+}
+
 func (p *Pipeline) storeManifest(ctx context.Context, localFilepath, storageFilepath string) error {
 	manifest, err := os.Create(localFilepath)
 	if err != nil {
@@ -679,33 +1522,138 @@ func (p *Pipeline) storeManifest(ctx context.Context, localFilepath, storageFile
 		return err
 	}
 
-	_, _, err = p.storeFile(ctx, localFilepath, storageFilepath, "application/json")
+	_, _, err = p.storeFile(ctx, localFilepath, storageFilepath, "application/json", nil)
 	return err
 }
 
+// waitForStart prerolls the pipeline until the template signals that it is ready, so that capture begins
+// at the signaled moment. It returns false if the egress was stopped or failed in the meantime
+func (p *Pipeline) waitForStart(start chan struct{}) bool {
+	// live sources don't produce data until playing
+	if err := p.pipeline.SetState(gst.StatePaused); err != nil {
+		p.Logger.Warnw("failed to preroll pipeline", err)
+	}
+
+	var timeout <-chan time.Time
+	if p.StartTimeout > 0 {
+		timer := time.NewTimer(p.StartTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// the page can fail before it's ready
+	var failure <-chan error
+	if s, ok := p.in.(*web.WebInput); ok {
+		failure = s.Failure()
+	}
+
+	waitStart := time.Now()
+	select {
+	case <-p.closed:
+		_ = p.pipeline.SetState(gst.StateNull)
+		p.setStatus(livekit.EgressStatus_EGRESS_ABORTED)
+		return false
+	case <-start:
+		p.StartDelay = time.Since(waitStart)
+		p.Logger.Infow("start signal received", "wait", p.StartDelay)
+	case <-timeout:
+		p.StartDelay = time.Since(waitStart)
+		if p.StartTimeoutFail {
+			_ = p.pipeline.SetState(gst.StateNull)
+			p.setError(errors.ErrStartSignalTimeout)
+			return false
+		}
+		p.Logger.Warnw("no start signal received, starting anyway", nil, "wait", p.StartDelay)
+	case err := <-failure:
+		p.Logger.Errorw("source failed", err)
+		_ = p.pipeline.SetState(gst.StateNull)
+		p.setError(err)
+		return false
+	}
+	return true
+}
+
 func (p *Pipeline) cleanup() {
+	if p.uploadFailures.Load() > 0 {
+		p.Logger.Infow("keeping temporary directory after failed upload")
+		return
+	}
+
 	// clean up temp dir
 	if p.UploadConfig != nil {
+		var dir string
 		switch p.EgressType {
 		case params.EgressTypeFile:
-			dir, _ := path.Split(p.LocalFilepath)
-			if dir != "" {
-				p.Logger.Debugw("removing temporary directory", "path", dir)
-				if err := os.RemoveAll(dir); err != nil {
-					p.Logger.Errorw("could not delete temp dir", err)
-				}
-			}
-
+			dir, _ = path.Split(p.LocalFilepath)
 		case params.EgressTypeSegmentedFile:
-			dir, _ := path.Split(p.PlaylistFilename)
-			if dir != "" {
-				p.Logger.Debugw("removing temporary directory", "path", dir)
-				if err := os.RemoveAll(dir); err != nil {
-					p.Logger.Errorw("could not delete temp dir", err)
-				}
+			dir, _ = path.Split(p.PlaylistFilename)
+		}
+		if dir == "" {
+			return
+		}
+
+		if p.RetainedPath != "" {
+			p.Logger.Infow("retaining local files", "path", p.RetainedPath)
+			if err := moveDir(dir, p.RetainedPath); err != nil {
+				p.Logger.Errorw("could not retain local files", err)
+				return
 			}
+			// the retention ttl starts now
+			now := time.Now()
+			_ = os.Chtimes(p.RetainedPath, now, now)
+			return
+		}
+
+		p.Logger.Debugw("removing temporary directory", "path", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			p.Logger.Errorw("could not delete temp dir", err)
+		}
+	}
+}
+
+// moveDir moves src to dst, copying its files if dst is on another device
+func moveDir(src, dst string) error {
+	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err = copyFile(path.Join(src, entry.Name()), path.Join(dst, entry.Name())); err != nil {
+			return err
 		}
 	}
+	return os.RemoveAll(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
 }
 
 func getSegmentParamsFromGstStructure(s *gst.Structure) (filepath string, time int64, err error) {
@@ -733,21 +1681,32 @@ func getSegmentParamsFromGstStructure(s *gst.Structure) (filepath string, time i
 // handleError returns true if the error has been handled, false if the pipeline should quit
 func (p *Pipeline) handleError(gErr *gst.GError) (error, bool) {
 	element, name, message := parseDebugInfo(gErr)
-	err := errors.New(gErr.Error())
+	err := errors.New(p.RedactUrls(gErr.Error()))
+	message = p.RedactUrls(message)
 
 	switch {
-	case element == elementGstRtmp2Sink:
-		// bad URI or could not connect. Remove rtmp output
+	case element == elementGstRtmp2Sink, element == elementGstSRTSink:
+		// bad URI or could not connect. Reconnect, or remove stream output once retries are exhausted
+		err = errors.WithCode(errors.CategoryOutput, errors.CodeStreamFailed, err)
 		url, e := p.out.GetUrlFromName(name)
 		if e != nil {
-			p.Logger.Warnw("rtmp output not found", e, "url", url)
+			p.Logger.Warnw("stream output not found", e, "name", name)
 			return e, false
 		}
+		if p.reconnectSink(url) {
+			p.Logger.Warnw("stream output disconnected", err, "url", p.RedactUrl(url))
+			return err, true
+		}
 		if e = p.removeSink(url, livekit.StreamInfo_FAILED); e != nil {
 			return err, false
 		}
 		return err, true
 
+	case strings.HasPrefix(name, builder.ThumbnailElementPrefix):
+		// thumbnails stop, but the recording carries on
+		p.Logger.Warnw("thumbnails failed", err, "element", element, "message", message)
+		return err, true
+
 	case element == elementGstAppSrc:
 		if message == "streaming stopped, reason not-negotiated (-4)" {
 			// send eos to app src
@@ -755,13 +1714,19 @@ func (p *Pipeline) handleError(gErr *gst.GError) (error, bool) {
 			p.in.(*sdk.SDKInput).SendAppSrcEOS(name)
 			return err, true
 		}
+		err = errors.WithCategory(errors.CategorySource, err)
+	}
+
+	if strings.Contains(message, "No space left on device") {
+		err = errors.ErrDiskFull
 	}
 
 	// input failure or file write failure. Fatal
-	p.Logger.Errorw("pipeline error", err,
-		"element", element,
-		"message", message,
-	)
+	args := []interface{}{"element", element, "message", message}
+	if dump := p.debugDump(dumpError); dump != "" {
+		args = append(args, "debugDump", dump)
+	}
+	p.Logger.Errorw("pipeline error", err, args...)
 
 	return err, false
 }
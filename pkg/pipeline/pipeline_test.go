@@ -0,0 +1,132 @@
+//go:build integration
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinyzimmer/go-glib/glib"
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+func TestEOSTimeout(t *testing.T) {
+	gst.Init(nil)
+
+	pipeline, err := gst.NewPipelineFromString("audiotestsrc is-live=true ! identity name=stuck ! fakesink")
+	require.NoError(t, err)
+
+	// like a sink on a dead connection, EOS never reaches the end of the pipeline
+	stuck, err := pipeline.GetElementByName("stuck")
+	require.NoError(t, err)
+	stuck.GetStaticPad("src").AddProbe(gst.PadProbeTypeEventDownstream, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if info.GetEvent().Type() == gst.EventTypeEOS {
+			return gst.PadProbeDrop
+		}
+		return gst.PadProbeOK
+	})
+
+	p := &Pipeline{
+		Params: &params.Params{
+			Logger:     logger.Logger(logger.GetLogger()),
+			Info:       &livekit.EgressInfo{Status: livekit.EgressStatus_EGRESS_ACTIVE},
+			EOSTimeout: time.Second,
+		},
+		pipeline: pipeline,
+		closed:   make(chan struct{}),
+	}
+	p.loop = glib.NewMainLoop(glib.MainContextDefault(), false)
+	pipeline.GetPipelineBus().AddWatch(p.messageWatch)
+	require.NoError(t, pipeline.SetState(gst.StatePlaying))
+
+	time.AfterFunc(time.Second, func() { p.SendEOS(context.Background()) })
+
+	done := make(chan struct{})
+	go func() {
+		p.loop.Run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatal("pipeline not stopped after EOS timeout")
+	}
+
+	// the output is kept
+	require.Empty(t, p.Info.Error)
+	require.Equal(t, livekit.EgressStatus_EGRESS_ENDING, p.Info.Status)
+	require.Len(t, p.Warnings, 1)
+}
+
+// errors are set by the disk, watchdog and source goroutines while the main loop and handler read them
+func TestSetErrorConcurrent(t *testing.T) {
+	p := &Pipeline{
+		Params: &params.Params{
+			Logger:   logger.Logger(logger.GetLogger()),
+			Info:     &livekit.EgressInfo{Status: livekit.EgressStatus_EGRESS_ACTIVE},
+			Redactor: params.NewRedactor(""),
+		},
+		closed: make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.setError(errors.ErrDiskFull)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = p.GetError()
+			_ = p.GetInfo().Error
+		}()
+	}
+	wg.Wait()
+
+	require.ErrorIs(t, p.GetError(), errors.ErrDiskFull)
+	require.NotEmpty(t, p.GetInfo().Error)
+}
+
+// The status is changed by the main loop and timers while requests read it
+func TestSetStatusConcurrent(t *testing.T) {
+	p := &Pipeline{
+		Params: &params.Params{
+			Logger: logger.Logger(logger.GetLogger()),
+			Info:   &livekit.EgressInfo{Status: livekit.EgressStatus_EGRESS_STARTING},
+		},
+		closed: make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			p.setStatus(livekit.EgressStatus_EGRESS_ACTIVE, livekit.EgressStatus_EGRESS_STARTING)
+		}()
+		go func() {
+			defer wg.Done()
+			p.setStatus(livekit.EgressStatus_EGRESS_ENDING, livekit.EgressStatus_EGRESS_ACTIVE)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = p.GetInfo().Status
+			_ = p.expectingOutput()
+		}()
+	}
+	wg.Wait()
+
+	require.False(t, p.setStatus(livekit.EgressStatus_EGRESS_COMPLETE, livekit.EgressStatus_EGRESS_STARTING))
+	require.True(t, p.setStatus(livekit.EgressStatus_EGRESS_LIMIT_REACHED))
+	require.Equal(t, livekit.EgressStatus_EGRESS_LIMIT_REACHED, p.GetInfo().Status)
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// canRestart returns true if the pipeline failed once active in a way that rebuilding it could fix. Source
+// failures and full disks would only fail again
+func (p *Pipeline) canRestart(err error) bool {
+	if !p.CanRestart() || !p.playing {
+		return false
+	}
+	select {
+	case <-p.closed:
+		// already stopping
+		return false
+	default:
+	}
+
+	switch errors.Category(err) {
+	case errors.CategorySource, errors.CategoryValidation, errors.CategoryInternal:
+		return false
+	}
+	if errors.Is(err, errors.ErrDiskFull) {
+		return false
+	}
+
+	if p.EgressType == params.EgressTypeStream {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// every url has failed
+		return len(p.StreamInfo) > 0
+	}
+	return true
+}
+
+// Restarting returns true if the pipeline failed, and should be rebuilt
+func (p *Pipeline) Restarting() bool {
+	return p.restarting
+}
+
+// Continue takes over from a pipeline which failed, before Run, adding to its playlist and segment hooks
+func (p *Pipeline) Continue(prev *Pipeline) {
+	if prev.playlistWriter != nil && p.playlistWriter != nil {
+		prev.playlistWriter.Restart()
+		p.playlistWriter = prev.playlistWriter
+	}
+	if prev.hooks != nil && p.hooks != nil {
+		p.hooks.sequence = prev.hooks.sequence
+	}
+}
+
+// keepOutput stores what was written before the pipeline failed, for the next one to carry on from. Segments
+// already finished are uploaded, and a file is uploaded as it was
+func (p *Pipeline) keepOutput(ctx context.Context) {
+	switch p.EgressType {
+	case params.EgressTypeFile:
+		if p.SplitFile() {
+			if p.openChunk != "" {
+				// the chunk was cut off, so its end is estimated
+				endTime := p.openChunkTime + int64(time.Since(p.openChunkAt))
+				if err := p.enqueueSegmentUpload(p.openChunk, endTime); err != nil {
+					p.Logger.Errorw("could not store chunk", err, "path", p.openChunk)
+				}
+			}
+			p.segmentsWg.Wait()
+			return
+		}
+
+		var err error
+		if p.progressive != nil {
+			p.FileInfo.Location, p.FileInfo.Size, err = p.finishProgressiveUpload(ctx)
+		} else {
+			p.FileInfo.Location, p.FileInfo.Size, err = p.storeOutput(ctx)
+		}
+		if err != nil {
+			p.Logger.Errorw("could not store file part", err)
+		}
+
+	case params.EgressTypeSegmentedFile:
+		p.segmentsWg.Wait()
+	}
+}
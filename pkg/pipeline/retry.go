@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/input"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// newInput creates the input, retrying with backoff if the source fails to start, e.g. because the room
+// connection dropped while joining. Invalid requests are not retried. A failed input closes itself,
+// so nothing is left running between attempts
+func newInput(ctx context.Context, conf *config.Config, p *params.Params) (input.Input, error) {
+	backoff := conf.SourceRetry.Backoff
+	for attempt := 1; ; attempt++ {
+		in, err := input.New(ctx, conf, p)
+		if err == nil {
+			if attempt > 1 {
+				p.Logger.Infow("source started", "attempts", attempt)
+			}
+			return in, nil
+		}
+
+		if errors.IsValidation(err) {
+			return nil, err
+		}
+		if attempt >= conf.SourceRetry.MaxAttempts {
+			if attempt == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w (after %d attempts)", err, attempt)
+		}
+
+		p.Logger.Warnw("source failed to start, retrying", err, "attempt", attempt, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/livekit/egress/pkg/pipeline/input/web"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/egress/pkg/pipeline/sink"
+)
+
+// writeChapters lists chapters from the room events in the finished mp4, before it's uploaded. A file without
+// chapters is still a good recording, so failures are only logged
+func (p *Pipeline) writeChapters(events []*params.RoomEvent) {
+	chapters := params.GetChapters(events, time.Duration(p.FileInfo.Duration))
+	if len(chapters) == 0 {
+		return
+	}
+	if err := sink.WriteMP4Chapters(p.LocalFilepath, chapters); err != nil {
+		p.Logger.Warnw("could not write chapters", err)
+		return
+	}
+	p.Logger.Debugw("chapters written", "count", len(chapters))
+}
+
+// roomEvents returns the events collected by the web input, with their offsets into the file. Offsets are from
+// wall clock time, so time spent paused isn't taken out of them
+func (p *Pipeline) roomEvents() ([]*params.RoomEvent, int) {
+	s, ok := p.in.(*web.WebInput)
+	if !ok {
+		return nil, 0
+	}
+
+	events, dropped := s.RoomEvents()
+	for _, event := range events {
+		event.Offset = time.Duration(event.Time - p.FileInfo.StartedAt).Milliseconds()
+	}
+	return events, dropped
+}
+
+// storeRoomEvents uploads the room events sidecar next to the file
+func (p *Pipeline) storeRoomEvents(ctx context.Context, events []*params.RoomEvent, dropped int) error {
+	b, err := json.Marshal(&params.RoomEvents{
+		EgressID:  p.Info.EgressId,
+		RoomName:  p.Info.RoomName,
+		StartedAt: p.FileInfo.StartedAt,
+		Events:    events,
+		Dropped:   dropped,
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(p.RoomEventsFilepath, b, 0644); err != nil {
+		return err
+	}
+
+	if _, _, err = p.storeFile(ctx, p.RoomEventsFilepath, p.GetRoomEventsStorageFilepath(), "application/json", nil); err != nil {
+		return err
+	}
+	p.RoomEventCount = len(events)
+	return nil
+}
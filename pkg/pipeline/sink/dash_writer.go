@@ -0,0 +1,198 @@
+package sink
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// segments are MPEG-TS, which DASH supports through the mp2t profile
+const dashProfile = "urn:mpeg:dash:profile:mp2t-simple:2011"
+
+// DASHWriter writes an MPEG-DASH manifest listing the same segments as the HLS playlist. It's fed by the playlist
+// writers as each segment is listed, so both manifests always list the same segments
+type DASHWriter struct {
+	manifestPath    string
+	segmentDuration time.Duration
+	bandwidth       int32
+	width           int32
+	height          int32
+
+	availabilityStart time.Time
+	duration          time.Duration
+	segments          []dashSegment
+	ended             bool
+}
+
+type dashSegment struct {
+	uri      string
+	start    time.Duration
+	duration time.Duration
+}
+
+type mpd struct {
+	XMLName                   xml.Name  `xml:"MPD"`
+	Xmlns                     string    `xml:"xmlns,attr"`
+	Profiles                  string    `xml:"profiles,attr"`
+	Type                      string    `xml:"type,attr"`
+	AvailabilityStartTime     string    `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime               string    `xml:"publishTime,attr,omitempty"`
+	MediaPresentationDuration string    `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinimumUpdatePeriod       string    `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MinBufferTime             string    `xml:"minBufferTime,attr"`
+	Period                    mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID            string           `xml:"id,attr"`
+	Start         string           `xml:"start,attr"`
+	AdaptationSet mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	MimeType         string            `xml:"mimeType,attr"`
+	SegmentAlignment bool              `xml:"segmentAlignment,attr"`
+	Representation   mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID          string         `xml:"id,attr"`
+	Bandwidth   int32          `xml:"bandwidth,attr"`
+	Width       int32          `xml:"width,attr,omitempty"`
+	Height      int32          `xml:"height,attr,omitempty"`
+	SegmentList mpdSegmentList `xml:"SegmentList"`
+}
+
+type mpdSegmentList struct {
+	Timescale       int             `xml:"timescale,attr"`
+	SegmentTimeline []mpdTimelineS  `xml:"SegmentTimeline>S"`
+	SegmentURLs     []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdTimelineS struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+func NewDASHWriter(p *params.Params) *DASHWriter {
+	w := &DASHWriter{
+		manifestPath:    p.DASHManifestFilename,
+		segmentDuration: time.Duration(p.SegmentDuration) * time.Second,
+	}
+	if p.AudioEnabled {
+		w.bandwidth += p.AudioBitrate * 1000
+	}
+	if p.VideoEnabled {
+		w.bandwidth += p.VideoBitrate * 1000
+		w.width = p.Width
+		w.height = p.Height
+	}
+	return w
+}
+
+// AddSegment lists the next segment, which starts where the last one ended
+func (w *DASHWriter) AddSegment(filepath string, duration time.Duration) error {
+	if filepath == "" {
+		return fmt.Errorf("invalid filepath")
+	}
+	if duration <= 0 {
+		return fmt.Errorf("invalid segment duration")
+	}
+	if w.ended {
+		return fmt.Errorf("manifest ended")
+	}
+
+	w.segments = append(w.segments, dashSegment{
+		uri:      getFilenameFromFilePath(filepath),
+		start:    w.duration,
+		duration: duration,
+	})
+	w.duration += duration
+
+	if w.availabilityStart.IsZero() {
+		// segments are listed after they are uploaded, so players never expect them too early
+		w.availabilityStart = time.Now().Add(-w.duration)
+	}
+
+	return w.writeManifest()
+}
+
+// EOS makes the manifest static, with the duration of its segments
+func (w *DASHWriter) EOS() error {
+	w.ended = true
+	return w.writeManifest()
+}
+
+func (w *DASHWriter) encode() ([]byte, error) {
+	m := &mpd{
+		Xmlns:         "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:      dashProfile,
+		MinBufferTime: formatXSDuration(w.segmentDuration),
+		Period: mpdPeriod{
+			ID:    "0",
+			Start: formatXSDuration(0),
+			AdaptationSet: mpdAdaptationSet{
+				MimeType:         string(params.OutputTypeTS),
+				SegmentAlignment: true,
+				Representation: mpdRepresentation{
+					ID:        "0",
+					Bandwidth: w.bandwidth,
+					Width:     w.width,
+					Height:    w.height,
+					SegmentList: mpdSegmentList{
+						Timescale: 1000,
+					},
+				},
+			},
+		},
+	}
+
+	if w.ended {
+		m.Type = "static"
+		m.MediaPresentationDuration = formatXSDuration(w.duration)
+	} else {
+		m.Type = "dynamic"
+		m.PublishTime = time.Now().UTC().Format(time.RFC3339)
+		m.MinimumUpdatePeriod = formatXSDuration(w.segmentDuration)
+		if !w.availabilityStart.IsZero() {
+			m.AvailabilityStartTime = w.availabilityStart.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	list := &m.Period.AdaptationSet.Representation.SegmentList
+	for _, segment := range w.segments {
+		// rounded from the start of each segment, so that the timeline has no gaps
+		start := segment.start.Milliseconds()
+		list.SegmentTimeline = append(list.SegmentTimeline, mpdTimelineS{
+			T: start,
+			D: (segment.start + segment.duration).Milliseconds() - start,
+		})
+		list.SegmentURLs = append(list.SegmentURLs, mpdSegmentURL{Media: segment.uri})
+	}
+
+	b, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+func (w *DASHWriter) writeManifest() error {
+	b, err := w.encode()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(w.manifestPath, b, 0644)
+}
+
+// formatXSDuration formats a duration as an xs:duration, in seconds
+func formatXSDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%sS", formatDuration(d))
+}
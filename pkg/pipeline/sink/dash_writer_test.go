@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+func TestDASHWriter(t *testing.T) {
+	dir := t.TempDir()
+	p := &params.Params{}
+	p.PlaylistFilename = path.Join(dir, "playlist.m3u8")
+	p.DASHManifestFilename = path.Join(dir, "playlist.mpd")
+	p.SegmentDuration = 6
+	p.AudioEnabled, p.AudioBitrate = true, 128
+	p.VideoEnabled, p.VideoBitrate = true, 4500
+	p.Width, p.Height = 1920, 1080
+
+	w, err := NewPlaylistWriter(p)
+	require.NoError(t, err)
+
+	read := func() *mpd {
+		b, err := os.ReadFile(p.DASHManifestFilename)
+		require.NoError(t, err)
+		m := &mpd{}
+		require.NoError(t, xml.Unmarshal(b, m))
+		require.Equal(t, dashProfile, m.Profiles)
+		require.Equal(t, "video/mp2t", m.Period.AdaptationSet.MimeType)
+
+		r := m.Period.AdaptationSet.Representation
+		require.Equal(t, int32(4628000), r.Bandwidth)
+		require.Equal(t, int32(1920), r.Width)
+		require.Equal(t, int32(1080), r.Height)
+		require.Equal(t, 1000, r.SegmentList.Timescale)
+		require.Len(t, r.SegmentList.SegmentURLs, len(r.SegmentList.SegmentTimeline))
+
+		// the timeline has no gaps
+		var next int64
+		for _, s := range r.SegmentList.SegmentTimeline {
+			require.Equal(t, next, s.T)
+			require.Greater(t, s.D, int64(0))
+			next = s.T + s.D
+		}
+		return m
+	}
+
+	// segments run 6.0004s, which doesn't round to whole milliseconds
+	start := int64(0)
+	for i := 0; i < 5; i++ {
+		filename := path.Join(dir, fmt.Sprintf("segment_%05d.ts", i))
+		end := start + int64(time.Second*6+time.Microsecond*400)
+		require.NoError(t, w.StartSegment(filename, start))
+		require.NoError(t, w.EndSegment(filename, end))
+		start = end
+
+		m := read()
+		require.Equal(t, "dynamic", m.Type)
+		require.NotEmpty(t, m.AvailabilityStartTime)
+		require.Equal(t, "PT6.000S", m.MinimumUpdatePeriod)
+
+		list := m.Period.AdaptationSet.Representation.SegmentList
+		require.Len(t, list.SegmentURLs, i+1)
+		require.Equal(t, fmt.Sprintf("segment_%05d.ts", i), list.SegmentURLs[i].Media)
+	}
+
+	require.NoError(t, w.EOS())
+	m := read()
+	require.Equal(t, "static", m.Type)
+	require.Equal(t, "PT30.002S", m.MediaPresentationDuration)
+	require.Empty(t, m.MinimumUpdatePeriod)
+	require.Len(t, m.Period.AdaptationSet.Representation.SegmentList.SegmentURLs, 5)
+}
+
+func TestDASHWriterLowLatency(t *testing.T) {
+	dir := t.TempDir()
+	p := &params.Params{}
+	p.PlaylistFilename = path.Join(dir, "playlist.m3u8")
+	p.DASHManifestFilename = path.Join(dir, "playlist.mpd")
+	p.SegmentDuration = 2
+	p.PartDuration = time.Second
+	p.AudioEnabled, p.AudioBitrate = true, 128
+
+	w := NewLLPlaylistWriter(p)
+	require.NoError(t, w.AddPart("part_00000.ts", time.Second, "part_00001.ts"))
+
+	// parts aren't listed
+	_, err := os.Stat(p.DASHManifestFilename)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, w.AddPart("part_00001.ts", time.Second, "part_00002.ts"))
+	require.NoError(t, w.EndSegment("segment_00000.ts"))
+	require.NoError(t, w.EOS())
+
+	b, err := os.ReadFile(p.DASHManifestFilename)
+	require.NoError(t, err)
+	m := &mpd{}
+	require.NoError(t, xml.Unmarshal(b, m))
+	require.Equal(t, "static", m.Type)
+	require.Equal(t, "PT2.000S", m.MediaPresentationDuration)
+
+	r := m.Period.AdaptationSet.Representation
+	require.Equal(t, int32(128000), r.Bandwidth)
+	require.Zero(t, r.Width)
+	require.Equal(t, []mpdSegmentURL{{Media: "segment_00000.ts"}}, r.SegmentList.SegmentURLs)
+	require.Equal(t, []mpdTimelineS{{T: 0, D: 2000}}, r.SegmentList.SegmentTimeline)
+}
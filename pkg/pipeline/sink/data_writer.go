@@ -0,0 +1,279 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// messages queued for the disk, past which they're dropped
+const maxQueuedDataMessages = 100
+
+// DataWriter appends data messages to a sidecar as they arrive, as json lines or WebVTT cues. Messages are queued,
+// so callers never wait on the disk, and messages over the rate limit or the queue size are dropped
+type DataWriter struct {
+	format      string
+	topic       string
+	maxRate     int
+	cueDuration time.Duration
+
+	file      *os.File
+	w         *bufio.Writer
+	startedAt atomic.Int64
+	queue     chan *dataMessage
+	done      chan struct{}
+	pending   *dataMessage // vtt cues end when the next one starts
+	err       error
+
+	mu          sync.Mutex
+	closed      bool
+	windowStart time.Time
+	windowCount int
+	written     int
+	dropped     int
+}
+
+type dataMessage struct {
+	time        time.Time
+	participant string
+	data        []byte
+}
+
+// dataLine is a line of the jsonl sidecar
+type dataLine struct {
+	Time        int64       `json:"time"`      // unix nanoseconds
+	Offset      int64       `json:"offset_ms"` // into the output, 0 if it hadn't started yet
+	Participant string      `json:"participant,omitempty"`
+	Data        interface{} `json:"data"` // the message, as json if it is json, otherwise as a string
+}
+
+func NewDataWriter(p *params.Params) (*DataWriter, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if p.DataFilepath != "" {
+		// a restarted pipeline carries on with the sidecar of the one before
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(p.GetDataCaptureFilepath(), flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	w := &DataWriter{
+		format:      p.DataFormat,
+		topic:       p.DataTopic,
+		maxRate:     p.DataMaxRate,
+		cueDuration: p.DataCueDuration,
+		file:        file,
+		w:           bufio.NewWriter(file),
+		queue:       make(chan *dataMessage, maxQueuedDataMessages),
+		done:        make(chan struct{}),
+	}
+	if w.format == config.DataCaptureVTT && info.Size() == 0 {
+		_, w.err = w.w.WriteString("WEBVTT\n\n")
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// SetStartTime sets the time the output started, in unix nanoseconds, which offsets are measured from
+func (w *DataWriter) SetStartTime(startedAt int64) {
+	w.startedAt.Store(startedAt)
+}
+
+// Write queues a message from a participant. It never blocks
+func (w *DataWriter) Write(data []byte, participant string) {
+	w.add(time.Now(), data, participant)
+}
+
+func (w *DataWriter) add(now time.Time, data []byte, participant string) {
+	if w.topic != "" {
+		var msg struct {
+			Topic string `json:"topic"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Topic != w.topic {
+			return
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	if now.Sub(w.windowStart) >= time.Second {
+		w.windowStart = now
+		w.windowCount = 0
+	}
+	if w.windowCount >= w.maxRate {
+		w.dropped++
+		return
+	}
+	w.windowCount++
+
+	select {
+	case w.queue <- &dataMessage{time: now, participant: participant, data: append([]byte(nil), data...)}:
+	default:
+		w.dropped++
+	}
+}
+
+func (w *DataWriter) run() {
+	defer close(w.done)
+
+	for msg := range w.queue {
+		if w.err != nil {
+			// keep draining, so that the queue never fills
+			continue
+		}
+		if w.format == config.DataCaptureVTT {
+			w.err = w.writeCue(msg)
+		} else {
+			w.err = w.writeLine(msg)
+		}
+
+		if w.err == nil {
+			w.mu.Lock()
+			w.written++
+			w.mu.Unlock()
+		}
+	}
+
+	if w.err == nil && w.pending != nil {
+		w.err = w.encodeCue(w.pending, w.pending.time.Add(w.cueDuration))
+	}
+}
+
+func (w *DataWriter) writeLine(msg *dataMessage) error {
+	line := &dataLine{
+		Time:        msg.time.UnixNano(),
+		Offset:      w.offset(msg.time).Milliseconds(),
+		Participant: msg.participant,
+		Data:        string(msg.data),
+	}
+	if json.Valid(msg.data) {
+		line.Data = json.RawMessage(msg.data)
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	if _, err = w.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// writeCue writes the pending cue, which is shown until this one starts or for the cue duration
+func (w *DataWriter) writeCue(msg *dataMessage) error {
+	pending := w.pending
+	w.pending = msg
+	if pending == nil {
+		return nil
+	}
+
+	end := pending.time.Add(w.cueDuration)
+	if msg.time.Before(end) {
+		end = msg.time
+	}
+	return w.encodeCue(pending, end)
+}
+
+func (w *DataWriter) encodeCue(msg *dataMessage, endTime time.Time) error {
+	start := w.offset(msg.time)
+	end := w.offset(endTime)
+	if end <= start {
+		end = start + time.Millisecond
+	}
+
+	text := cueText(msg.data)
+	if text == "" {
+		return nil
+	}
+	if msg.participant != "" {
+		text = fmt.Sprintf("<v %s>%s", escapeVTT(msg.participant), text)
+	}
+
+	if _, err := fmt.Fprintf(w.w, "%s --> %s\n%s\n\n", formatVTTTime(start), formatVTTTime(end), text); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *DataWriter) offset(t time.Time) time.Duration {
+	startedAt := w.startedAt.Load()
+	if startedAt == 0 || t.UnixNano() < startedAt {
+		return 0
+	}
+	return time.Duration(t.UnixNano() - startedAt)
+}
+
+// Close writes the last cue and closes the file, returning the number of messages written and dropped
+func (w *DataWriter) Close() (int, int, error) {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+
+	err := w.err
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written, w.dropped, err
+}
+
+// cueText uses the "text" field of json messages, or the whole message. Blank lines would end the cue
+func cueText(data []byte) string {
+	var msg struct {
+		Text string `json:"text"`
+	}
+	text := string(data)
+	if err := json.Unmarshal(data, &msg); err == nil && msg.Text != "" {
+		text = msg.Text
+	}
+	if !utf8.ValidString(text) {
+		return ""
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, escapeVTT(line))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func escapeVTT(s string) string {
+	return vttEscaper.Replace(s)
+}
+
+func formatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+func newTestDataWriter(t *testing.T, format, topic string) (*DataWriter, *params.Params) {
+	p := &params.Params{
+		EgressType: params.EgressTypeFile,
+		FileParams: params.FileParams{
+			LocalFilepath: path.Join(t.TempDir(), "recording.mp4"),
+		},
+		DataCaptureParams: params.DataCaptureParams{
+			DataCapture:     true,
+			DataTopic:       topic,
+			DataFormat:      format,
+			DataMaxRate:     5,
+			DataCueDuration: time.Second * 3,
+		},
+	}
+	w, err := NewDataWriter(p)
+	require.NoError(t, err)
+	return w, p
+}
+
+func TestDataWriterJSONL(t *testing.T) {
+	w, p := newTestDataWriter(t, config.DataCaptureJSONL, "captions")
+	start := time.Unix(1000, 0)
+	w.SetStartTime(start.UnixNano())
+
+	w.add(start.Add(time.Second), []byte(`{"topic":"captions","text":"hello"}`), "alice")
+	w.add(start.Add(time.Second), []byte(`{"topic":"chat","text":"ignored"}`), "alice")
+	w.add(start.Add(time.Second), []byte(`not json, so it has no topic`), "alice")
+	// a burst past the rate limit, in a new window
+	for i := 0; i < 6; i++ {
+		w.add(start.Add(time.Second*2), []byte(`{"topic":"captions","text":"burst"}`), "bob")
+	}
+
+	written, dropped, err := w.Close()
+	require.NoError(t, err)
+	require.Equal(t, 6, written)
+	require.Equal(t, 1, dropped)
+	require.Equal(t, strings.TrimSuffix(p.LocalFilepath, ".mp4")+"_data.jsonl", p.GetDataCaptureFilepath())
+
+	b, err := os.ReadFile(p.GetDataCaptureFilepath())
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 6)
+
+	var first struct {
+		Time        int64           `json:"time"`
+		Offset      int64           `json:"offset_ms"`
+		Participant string          `json:"participant"`
+		Data        json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.Equal(t, start.Add(time.Second).UnixNano(), first.Time)
+	require.Equal(t, int64(1000), first.Offset)
+	require.Equal(t, "alice", first.Participant)
+	require.JSONEq(t, `{"topic":"captions","text":"hello"}`, string(first.Data))
+
+	// closed writers drop messages without blocking
+	w.Write([]byte(`{"topic":"captions"}`), "alice")
+}
+
+func TestDataWriterVTT(t *testing.T) {
+	w, p := newTestDataWriter(t, config.DataCaptureVTT, "")
+	start := time.Unix(1000, 0)
+	w.SetStartTime(start.UnixNano())
+
+	w.add(start.Add(time.Second), []byte(`{"text":"hello <everyone>"}`), "alice")
+	w.add(start.Add(time.Second*2), []byte("first line\n\nsecond line"), "bob")
+	w.add(start.Add(time.Second*10), []byte(`{"text":"goodbye"}`), "")
+
+	_, _, err := w.Close()
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(p.GetDataCaptureFilepath())
+	require.NoError(t, err)
+	require.Equal(t, "WEBVTT\n\n"+
+		// shown until the next cue
+		"00:00:01.000 --> 00:00:02.000\n<v alice>hello &lt;everyone&gt;\n\n"+
+		// or for the cue duration
+		"00:00:02.000 --> 00:00:05.000\n<v bob>first line\nsecond line\n\n"+
+		"00:00:10.000 --> 00:00:13.000\ngoodbye\n\n",
+		string(b))
+}
+
+func TestDataWriterRestart(t *testing.T) {
+	w, p := newTestDataWriter(t, config.DataCaptureVTT, "")
+	start := time.Unix(1000, 0)
+	w.SetStartTime(start.UnixNano())
+	w.add(start.Add(time.Second), []byte("before"), "")
+	_, _, err := w.Close()
+	require.NoError(t, err)
+
+	// a restarted pipeline adds to the same sidecar, without another header
+	p.DataFilepath = p.GetDataCaptureFilepath()
+	w, err = NewDataWriter(p)
+	require.NoError(t, err)
+	w.SetStartTime(start.UnixNano())
+	w.add(start.Add(time.Second*5), []byte("after"), "")
+	_, _, err = w.Close()
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(p.GetDataCaptureFilepath())
+	require.NoError(t, err)
+	require.Equal(t, "WEBVTT\n\n"+
+		"00:00:01.000 --> 00:00:04.000\nbefore\n\n"+
+		"00:00:05.000 --> 00:00:08.000\nafter\n\n",
+		string(b))
+}
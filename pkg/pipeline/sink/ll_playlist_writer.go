@@ -0,0 +1,180 @@
+package sink
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// parts are listed for segments in the last partHoldSegments target durations of the playlist
+const partHoldSegments = 3
+
+// LLPlaylistWriter writes low-latency HLS playlists. Each part is listed with EXT-X-PART as soon as it's added,
+// so that players can start on a segment before it's complete, and the next part is hinted with EXT-X-PRELOAD-HINT.
+// Callers only add parts and segments once they have been uploaded
+type LLPlaylistWriter struct {
+	playlistPath   string
+	dash           *DASHWriter
+	targetDuration time.Duration
+	partTarget     time.Duration
+
+	segments []*llSegment
+	parts    []*llPart // of the segment being written
+	nextPart string
+	ended    bool
+}
+
+type llSegment struct {
+	uri      string
+	duration time.Duration
+	parts    []*llPart
+}
+
+type llPart struct {
+	uri      string
+	duration time.Duration
+}
+
+func NewLLPlaylistWriter(p *params.Params) *LLPlaylistWriter {
+	w := &LLPlaylistWriter{
+		playlistPath:   p.PlaylistFilename,
+		targetDuration: time.Duration(p.SegmentDuration) * time.Second,
+		partTarget:     p.PartDuration,
+	}
+	if p.DASHManifestFilename != "" {
+		w.dash = NewDASHWriter(p)
+	}
+	return w
+}
+
+// AddPart lists a part of the segment being written, and hints the part which follows it
+func (w *LLPlaylistWriter) AddPart(filepath string, duration time.Duration, nextFilepath string) error {
+	if filepath == "" {
+		return fmt.Errorf("invalid filepath")
+	}
+	if duration <= 0 {
+		return fmt.Errorf("invalid part duration")
+	}
+	if w.ended {
+		return fmt.Errorf("playlist ended")
+	}
+
+	// parts are split on key frames, so they can run over
+	if duration > w.partTarget {
+		w.partTarget = duration
+	}
+
+	w.parts = append(w.parts, &llPart{
+		uri:      getFilenameFromFilePath(filepath),
+		duration: duration,
+	})
+	w.nextPart = getFilenameFromFilePath(nextFilepath)
+
+	return w.writePlaylist()
+}
+
+// EndSegment lists a segment made of the parts added since the last segment
+func (w *LLPlaylistWriter) EndSegment(filepath string) error {
+	if filepath == "" {
+		return fmt.Errorf("invalid filepath")
+	}
+	if len(w.parts) == 0 {
+		return fmt.Errorf("segment has no parts")
+	}
+
+	segment := &llSegment{
+		uri:   getFilenameFromFilePath(filepath),
+		parts: w.parts,
+	}
+	for _, part := range w.parts {
+		segment.duration += part.duration
+	}
+	if rounded := segment.duration.Round(time.Second); rounded > w.targetDuration {
+		w.targetDuration = rounded
+	}
+
+	w.segments = append(w.segments, segment)
+	w.parts = nil
+	if w.dash != nil {
+		// only whole segments are listed
+		if err := w.dash.AddSegment(segment.uri, segment.duration); err != nil {
+			return err
+		}
+	}
+
+	return w.writePlaylist()
+}
+
+// EOS ends the playlist. Parts of an unfinished segment are dropped, so it should be ended first
+func (w *LLPlaylistWriter) EOS() error {
+	w.parts = nil
+	w.nextPart = ""
+	w.ended = true
+	if w.dash != nil {
+		if err := w.dash.EOS(); err != nil {
+			return err
+		}
+	}
+
+	return w.writePlaylist()
+}
+
+func (w *LLPlaylistWriter) encode() string {
+	var b strings.Builder
+
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:6\n")
+	b.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(w.targetDuration/time.Second)))
+	b.WriteString(fmt.Sprintf("#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=%s\n", formatDuration(3*w.partTarget)))
+	b.WriteString(fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%s\n", formatDuration(w.partTarget)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+
+	// segments from this one on keep their parts
+	first := len(w.segments)
+	if !w.ended {
+		var held time.Duration
+		for _, part := range w.parts {
+			held += part.duration
+		}
+		for first > 0 && held < partHoldSegments*w.targetDuration {
+			first--
+			held += w.segments[first].duration
+		}
+	}
+
+	for i, segment := range w.segments {
+		if i >= first {
+			writeParts(&b, segment.parts)
+		}
+		b.WriteString(fmt.Sprintf("#EXTINF:%s,\n%s\n", formatDuration(segment.duration), segment.uri))
+	}
+	writeParts(&b, w.parts)
+
+	if w.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	} else if w.nextPart != "" {
+		b.WriteString(fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", w.nextPart))
+	}
+
+	return b.String()
+}
+
+func (w *LLPlaylistWriter) writePlaylist() error {
+	return os.WriteFile(w.playlistPath, []byte(w.encode()), 0644)
+}
+
+// every part starts on a key frame
+func writeParts(b *strings.Builder, parts []*llPart) {
+	for _, part := range parts {
+		b.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%s,URI=\"%s\",INDEPENDENT=YES\n", formatDuration(part.duration), part.uri))
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3f", math.Round(d.Seconds()*1000)/1000)
+}
@@ -0,0 +1,246 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+type testPlaylist struct {
+	version        int
+	targetDuration int
+	partTarget     float64
+	partHoldBack   float64
+	segments       []testSegment
+	openParts      []testPart
+	preloadHint    string
+	ended          bool
+}
+
+type testSegment struct {
+	uri      string
+	duration float64
+	parts    []testPart
+}
+
+type testPart struct {
+	uri         string
+	duration    float64
+	independent bool
+}
+
+// parseLLPlaylist parses a low-latency media playlist, failing on tags which are out of place
+func parseLLPlaylist(t *testing.T, playlist string) *testPlaylist {
+	lines := strings.Split(strings.TrimSuffix(playlist, "\n"), "\n")
+	require.Equal(t, "#EXTM3U", lines[0])
+
+	pl := &testPlaylist{}
+	var parts []testPart
+	var duration float64
+	var inf bool
+	for _, line := range lines[1:] {
+		require.False(t, pl.ended, "tag after EXT-X-ENDLIST")
+		require.Empty(t, pl.preloadHint, "tag after EXT-X-PRELOAD-HINT")
+
+		tag, value, _ := strings.Cut(line, ":")
+		attrs := parseAttributes(value)
+		switch {
+		case inf:
+			require.False(t, strings.HasPrefix(line, "#"), "EXTINF not followed by a uri")
+			pl.segments = append(pl.segments, testSegment{uri: line, duration: duration, parts: parts})
+			parts = nil
+			inf = false
+		case tag == "#EXT-X-VERSION":
+			pl.version = parseInt(t, value)
+		case tag == "#EXT-X-TARGETDURATION":
+			pl.targetDuration = parseInt(t, value)
+		case tag == "#EXT-X-PART-INF":
+			pl.partTarget = parseFloat(t, attrs["PART-TARGET"])
+		case tag == "#EXT-X-SERVER-CONTROL":
+			pl.partHoldBack = parseFloat(t, attrs["PART-HOLD-BACK"])
+		case tag == "#EXT-X-MEDIA-SEQUENCE", tag == "#EXT-X-PLAYLIST-TYPE":
+		case tag == "#EXT-X-PART":
+			parts = append(parts, testPart{
+				uri:         unquote(t, attrs["URI"]),
+				duration:    parseFloat(t, attrs["DURATION"]),
+				independent: attrs["INDEPENDENT"] == "YES",
+			})
+		case tag == "#EXTINF":
+			duration = parseFloat(t, strings.TrimSuffix(value, ","))
+			inf = true
+		case tag == "#EXT-X-PRELOAD-HINT":
+			require.Equal(t, "PART", attrs["TYPE"])
+			pl.preloadHint = unquote(t, attrs["URI"])
+		case tag == "#EXT-X-ENDLIST":
+			pl.ended = true
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	require.False(t, inf, "EXTINF not followed by a uri")
+	pl.openParts = parts
+
+	return pl
+}
+
+func parseAttributes(value string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(value, ",") {
+		if k, v, ok := strings.Cut(attr, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func parseInt(t *testing.T, s string) int {
+	i, err := strconv.Atoi(s)
+	require.NoError(t, err)
+	return i
+}
+
+func parseFloat(t *testing.T, s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	require.NoError(t, err)
+	return f
+}
+
+func unquote(t *testing.T, s string) string {
+	require.True(t, len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"', "value %q is not quoted", s)
+	return s[1 : len(s)-1]
+}
+
+// checkLLPlaylist checks the rules which apply to every low-latency playlist
+func checkLLPlaylist(t *testing.T, pl *testPlaylist) {
+	require.GreaterOrEqual(t, pl.version, 6)
+	require.Greater(t, pl.partTarget, 0.0)
+	require.GreaterOrEqual(t, pl.partHoldBack, 2*pl.partTarget)
+
+	for _, segment := range pl.segments {
+		require.LessOrEqual(t, int(segment.duration+0.5), pl.targetDuration)
+		if len(segment.parts) > 0 {
+			var sum float64
+			for _, part := range segment.parts {
+				sum += part.duration
+			}
+			require.InDelta(t, segment.duration, sum, 0.001*float64(len(segment.parts)))
+		}
+	}
+	for _, part := range append(allParts(pl), pl.openParts...) {
+		require.LessOrEqual(t, part.duration, pl.partTarget)
+		require.True(t, part.independent)
+	}
+}
+
+func allParts(pl *testPlaylist) []testPart {
+	var parts []testPart
+	for _, segment := range pl.segments {
+		parts = append(parts, segment.parts...)
+	}
+	return parts
+}
+
+func newTestLLPlaylistWriter(t *testing.T, segmentDuration int, partDuration time.Duration) (*LLPlaylistWriter, func() *testPlaylist) {
+	dir := t.TempDir()
+	p := &params.Params{}
+	p.PlaylistFilename = path.Join(dir, "playlist.m3u8")
+	p.SegmentDuration = segmentDuration
+	p.PartDuration = partDuration
+
+	w := NewLLPlaylistWriter(p)
+	read := func() *testPlaylist {
+		b, err := os.ReadFile(p.PlaylistFilename)
+		require.NoError(t, err)
+		pl := parseLLPlaylist(t, string(b))
+		checkLLPlaylist(t, pl)
+		return pl
+	}
+	return w, read
+}
+
+func TestLLPlaylistWriter(t *testing.T) {
+	w, read := newTestLLPlaylistWriter(t, 4, time.Second)
+	partName := func(i int) string { return fmt.Sprintf("/tmp/test_part_%05d.ts", i) }
+
+	part := 0
+	for segment := 0; segment < 6; segment++ {
+		for i := 0; i < 4; i++ {
+			require.NoError(t, w.AddPart(partName(part), time.Second, partName(part+1)))
+			part++
+
+			// the new part is listed after the finished segments, and the next one is hinted
+			pl := read()
+			require.Len(t, pl.segments, segment)
+			require.Len(t, pl.openParts, i+1)
+			require.Equal(t, fmt.Sprintf("test_part_%05d.ts", part-1), pl.openParts[i].uri)
+			require.Equal(t, fmt.Sprintf("test_part_%05d.ts", part), pl.preloadHint)
+			require.False(t, pl.ended)
+		}
+
+		require.NoError(t, w.EndSegment(fmt.Sprintf("/tmp/test_%05d.ts", segment)))
+		pl := read()
+		require.Len(t, pl.segments, segment+1)
+		require.Empty(t, pl.openParts)
+		require.Equal(t, fmt.Sprintf("test_%05d.ts", segment), pl.segments[segment].uri)
+		require.Equal(t, 4.0, pl.segments[segment].duration)
+		require.Equal(t, 4, pl.targetDuration)
+		require.Equal(t, 1.0, pl.partTarget)
+		require.Equal(t, 3.0, pl.partHoldBack)
+
+		// only the last three target durations keep their parts
+		for i, s := range pl.segments {
+			if i >= len(pl.segments)-partHoldSegments {
+				require.Len(t, s.parts, 4)
+				for j, p := range s.parts {
+					require.Equal(t, fmt.Sprintf("test_part_%05d.ts", i*4+j), p.uri)
+				}
+			} else {
+				require.Empty(t, s.parts)
+			}
+		}
+	}
+
+	require.NoError(t, w.EOS())
+	pl := read()
+	require.True(t, pl.ended)
+	require.Empty(t, pl.preloadHint)
+	require.Len(t, pl.segments, 6)
+	require.Empty(t, allParts(pl))
+	require.Error(t, w.AddPart(partName(part), time.Second, partName(part+1)))
+}
+
+func TestLLPlaylistWriterLongParts(t *testing.T) {
+	w, read := newTestLLPlaylistWriter(t, 2, time.Millisecond*500)
+
+	// parts can run over when key frames are late, and the targets grow to cover them
+	require.NoError(t, w.AddPart("test_part_00000.ts", time.Millisecond*500, "test_part_00001.ts"))
+	require.NoError(t, w.AddPart("test_part_00001.ts", time.Millisecond*1500, "test_part_00002.ts"))
+	pl := read()
+	require.Equal(t, 1.5, pl.partTarget)
+	require.Equal(t, 4.5, pl.partHoldBack)
+
+	require.NoError(t, w.AddPart("test_part_00002.ts", time.Millisecond*1200, "test_part_00003.ts"))
+	require.NoError(t, w.EndSegment("test_00000.ts"))
+	pl = read()
+	require.Equal(t, 3, pl.targetDuration)
+	require.Equal(t, 3.2, pl.segments[0].duration)
+
+	// a short last segment
+	require.NoError(t, w.AddPart("test_part_00003.ts", time.Millisecond*250, "test_part_00004.ts"))
+	require.NoError(t, w.EndSegment("test_00001.ts"))
+	require.NoError(t, w.EOS())
+	pl = read()
+	require.Len(t, pl.segments, 2)
+	require.Equal(t, 0.25, pl.segments[1].duration)
+	require.True(t, pl.ended)
+
+	require.Error(t, w.EndSegment("test_00002.ts"))
+}
@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"unicode/utf8"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+// boxes which can hold chunk offset tables
+var chunkOffsetContainers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// WriteMP4Chapters lists chapters in a finished mp4 as a Nero chapter list (moov/udta/chpl), which is read by ffmpeg
+// and most players. The file is rewritten with the larger moov, and if the moov comes before the media, as it does
+// with faststart, chunk offsets are moved to match
+func WriteMP4Chapters(filepath string, chapters []*params.Chapter) error {
+	if len(chapters) == 0 {
+		return nil
+	}
+	if len(chapters) > math.MaxUint8 {
+		return fmt.Errorf("too many chapters: %d", len(chapters))
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	moovStart, moovSize, err := findMoov(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	moov := make([]byte, moovSize)
+	if _, err = f.ReadAt(moov, moovStart); err != nil {
+		return err
+	}
+
+	chpl := encodeChpl(chapters)
+	udtaStart, udtaSize, hasUdta := findChild(moov, "udta")
+	delta := int64(len(chpl))
+	if !hasUdta {
+		delta += 8
+	}
+
+	// media after the moov moves by the size of the new boxes
+	if err = shiftChunkOffsets(moov, moovStart+moovSize, delta); err != nil {
+		return err
+	}
+
+	var updated []byte
+	if hasUdta {
+		udtaEnd := udtaStart + udtaSize
+		updated = append(append(append(updated, moov[:udtaEnd]...), chpl...), moov[udtaEnd:]...)
+		binary.BigEndian.PutUint32(updated[udtaStart:], uint32(udtaSize+len(chpl)))
+	} else {
+		updated = append(append(updated, moov...), box("udta", chpl)...)
+	}
+	binary.BigEndian.PutUint32(updated, uint32(len(updated)))
+
+	tmpPath := filepath + ".chapters"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err = io.Copy(out, io.NewSectionReader(f, 0, moovStart)); err == nil {
+		if _, err = out.Write(updated); err == nil {
+			_, err = io.Copy(out, io.NewSectionReader(f, moovStart+moovSize, info.Size()-moovStart-moovSize))
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath)
+}
+
+// findMoov returns the offset and size of the top level moov box
+func findMoov(r io.ReaderAt, fileSize int64) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= fileSize; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		boxType := string(header[4:8])
+		switch size {
+		case 0:
+			// to the end of the file
+			size = fileSize - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < 8 {
+			return 0, 0, fmt.Errorf("invalid %s box size %d", boxType, size)
+		}
+
+		if boxType == "moov" {
+			if binary.BigEndian.Uint32(header) <= 1 || size > math.MaxUint32/2 {
+				return 0, 0, fmt.Errorf("unsupported moov size")
+			}
+			return offset, size, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("no moov box")
+}
+
+// findChild returns the offset and size of a child of a box
+func findChild(parent []byte, boxType string) (int, int, bool) {
+	for offset := 8; offset+8 <= len(parent); {
+		size := int(binary.BigEndian.Uint32(parent[offset:]))
+		if size < 8 || offset+size > len(parent) {
+			return 0, 0, false
+		}
+		if string(parent[offset+4:offset+8]) == boxType {
+			return offset, size, true
+		}
+		offset += size
+	}
+	return 0, 0, false
+}
+
+// shiftChunkOffsets adds delta to each stco and co64 entry past the end of the moov
+func shiftChunkOffsets(b []byte, moovEnd, delta int64) error {
+	for offset := 8; offset+8 <= len(b); {
+		size := int(binary.BigEndian.Uint32(b[offset:]))
+		if size < 8 || offset+size > len(b) {
+			return fmt.Errorf("invalid box size %d", size)
+		}
+		child := b[offset : offset+size]
+
+		switch boxType := string(child[4:8]); {
+		case chunkOffsetContainers[boxType]:
+			if err := shiftChunkOffsets(child, moovEnd, delta); err != nil {
+				return err
+			}
+
+		case boxType == "stco" || boxType == "co64":
+			entrySize := 4
+			if boxType == "co64" {
+				entrySize = 8
+			}
+			if len(child) < 16 {
+				return fmt.Errorf("invalid %s box", boxType)
+			}
+			count := int(binary.BigEndian.Uint32(child[12:]))
+			if 16+count*entrySize > len(child) {
+				return fmt.Errorf("invalid %s entry count %d", boxType, count)
+			}
+			for i := 0; i < count; i++ {
+				entry := child[16+i*entrySize:]
+				if entrySize == 4 {
+					chunkOffset := int64(binary.BigEndian.Uint32(entry))
+					if chunkOffset < moovEnd {
+						continue
+					}
+					if chunkOffset+delta > math.MaxUint32 {
+						return fmt.Errorf("chunk offset overflow")
+					}
+					binary.BigEndian.PutUint32(entry, uint32(chunkOffset+delta))
+				} else if chunkOffset := int64(binary.BigEndian.Uint64(entry)); chunkOffset >= moovEnd {
+					binary.BigEndian.PutUint64(entry, uint64(chunkOffset+delta))
+				}
+			}
+		}
+
+		offset += size
+	}
+	return nil
+}
+
+// encodeChpl writes a version 1 chpl box. Start times are in 100ns units, and titles are at most 255 bytes
+func encodeChpl(chapters []*params.Chapter) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{1, 0, 0, 0}) // version and flags
+	b.Write([]byte{0, 0, 0, 0}) // reserved
+	b.WriteByte(byte(len(chapters)))
+	for _, chapter := range chapters {
+		_ = binary.Write(&b, binary.BigEndian, uint64(chapter.Start/100))
+		title := truncateUTF8(chapter.Title, math.MaxUint8)
+		b.WriteByte(byte(len(title)))
+		b.WriteString(title)
+	}
+	return box("chpl", b.Bytes())
+}
+
+func box(boxType string, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], boxType)
+	return append(b, payload...)
+}
+
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+)
+
+func TestWriteMP4Chapters(t *testing.T) {
+	chapters := []*params.Chapter{
+		{Start: 0, Title: "Screen share: alice"},
+		{Start: time.Second * 15, Title: "Speaker: bob"},
+	}
+
+	for _, faststart := range []bool{false, true} {
+		for _, withUdta := range []bool{false, true} {
+			filepath := path.Join(t.TempDir(), "recording.mp4")
+			require.NoError(t, os.WriteFile(filepath, testMP4(faststart, withUdta), 0644))
+			require.NoError(t, WriteMP4Chapters(filepath, chapters))
+
+			b, err := os.ReadFile(filepath)
+			require.NoError(t, err)
+			moovStart, moovSize, err := findMoov(bytes.NewReader(b), int64(len(b)))
+			require.NoError(t, err)
+			moov := b[moovStart : moovStart+moovSize]
+
+			// chunk offsets still point at their samples
+			for _, offset := range readChunkOffsets(t, moov) {
+				require.Equal(t, "smpl", string(b[offset:offset+4]), "faststart %v", faststart)
+			}
+
+			udtaStart, udtaSize, ok := findChild(moov, "udta")
+			require.True(t, ok)
+			udta := moov[udtaStart : udtaStart+udtaSize]
+			if withUdta {
+				_, _, ok = findChild(udta, "name")
+				require.True(t, ok, "existing user data is kept")
+			}
+			chplStart, chplSize, ok := findChild(udta, "chpl")
+			require.True(t, ok)
+			require.Equal(t, chapters, decodeChpl(t, udta[chplStart:chplStart+chplSize]))
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	require.Equal(t, "abc", truncateUTF8("abc", 5))
+	require.Equal(t, "ab", truncateUTF8("abc", 2))
+	// é is two bytes, and isn't split
+	require.Equal(t, "a", truncateUTF8("aé", 2))
+}
+
+// testMP4 writes an ftyp, a moov with one chunk offset table and optional user data, and an mdat with two samples
+func testMP4(faststart, withUdta bool) []byte {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isom"))
+	mdat := box("mdat", []byte("smpl0000smpl0000"))
+
+	buildMoov := func(mdatStart int) []byte {
+		stco := make([]byte, 16)
+		binary.BigEndian.PutUint32(stco[4:], 2)
+		binary.BigEndian.PutUint32(stco[8:], uint32(mdatStart+8))
+		binary.BigEndian.PutUint32(stco[12:], uint32(mdatStart+16))
+		trak := box("trak", box("mdia", box("minf", box("stbl", box("stco", stco)))))
+		children := append([]byte(nil), box("mvhd", make([]byte, 100))...)
+		if withUdta {
+			children = append(children, box("udta", box("name", []byte("test")))...)
+		}
+		return box("moov", append(children, trak...))
+	}
+
+	if faststart {
+		moovSize := len(buildMoov(0))
+		return append(append(ftyp, buildMoov(len(ftyp)+moovSize)...), mdat...)
+	}
+	return append(append(ftyp, mdat...), buildMoov(len(ftyp))...)
+}
+
+func readChunkOffsets(t *testing.T, moov []byte) []int {
+	b := moov
+	for _, boxType := range []string{"trak", "mdia", "minf", "stbl", "stco"} {
+		start, size, ok := findChild(b, boxType)
+		require.True(t, ok, boxType)
+		b = b[start : start+size]
+	}
+
+	var offsets []int
+	for i := 0; i < int(binary.BigEndian.Uint32(b[12:])); i++ {
+		offsets = append(offsets, int(binary.BigEndian.Uint32(b[16+4*i:])))
+	}
+	return offsets
+}
+
+func decodeChpl(t *testing.T, chpl []byte) []*params.Chapter {
+	require.Equal(t, byte(1), chpl[8], "version")
+	count := int(chpl[16])
+	b := chpl[17:]
+
+	var chapters []*params.Chapter
+	for i := 0; i < count; i++ {
+		start := time.Duration(binary.BigEndian.Uint64(b)) * 100
+		length := int(b[8])
+		chapters = append(chapters, &params.Chapter{Start: start, Title: string(b[9 : 9+length])})
+		b = b[9+length:]
+	}
+	require.Empty(t, b)
+	return chapters
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// PCMFrameHeaderSize is the size of the header PCMFramer writes before each frame:
+// the capture time in unix nanoseconds (uint64), a sequence number (uint32), and the number of samples
+// per channel (uint32), all big endian
+const PCMFrameHeaderSize = 16
+
+const pcmBytesPerSample = 2 // s16le
+
+// PCMFramer prefixes s16le pcm buffers with their capture time and a sequence number.
+// Capture times never decrease, so frames stay in order when timestamps overlap after packet loss,
+// while gaps in the audio show up as jumps in the capture time.
+type PCMFramer struct {
+	sampleRate int
+	frameSize  int // bytes per sample across all channels
+	startTime  func() int64
+
+	sequence uint32
+	next     int64 // capture time at the end of the last frame
+}
+
+// NewPCMFramer returns a framer for audio with the given format. startTime returns the unix time in nanoseconds
+// of pts 0, which is derived from the rtp timestamps of the track
+func NewPCMFramer(sampleRate, channels int, startTime func() int64) *PCMFramer {
+	return &PCMFramer{
+		sampleRate: sampleRate,
+		frameSize:  channels * pcmBytesPerSample,
+		startTime:  startTime,
+	}
+}
+
+// Frame returns data with a header. pts is the running time of the buffer, or negative if it has none
+func (f *PCMFramer) Frame(pts time.Duration, data []byte) []byte {
+	samples := len(data) / f.frameSize
+
+	captureTime := f.next
+	if pts >= 0 {
+		if t := f.startTime() + int64(pts); t > captureTime {
+			captureTime = t
+		}
+	} else if captureTime == 0 {
+		captureTime = time.Now().UnixNano()
+	}
+	f.next = captureTime + int64(samples)*int64(time.Second)/int64(f.sampleRate)
+
+	frame := make([]byte, PCMFrameHeaderSize+len(data))
+	binary.BigEndian.PutUint64(frame[0:8], uint64(captureTime))
+	binary.BigEndian.PutUint32(frame[8:12], f.sequence)
+	binary.BigEndian.PutUint32(frame[12:16], uint32(samples))
+	copy(frame[PCMFrameHeaderSize:], data)
+
+	f.sequence++
+	return frame
+}
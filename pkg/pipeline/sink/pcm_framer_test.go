@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testStartTime = int64(1_700_000_000_000_000_000)
+
+func readHeader(t *testing.T, frame []byte) (int64, uint32, uint32) {
+	require.GreaterOrEqual(t, len(frame), PCMFrameHeaderSize)
+	return int64(binary.BigEndian.Uint64(frame[0:8])),
+		binary.BigEndian.Uint32(frame[8:12]),
+		binary.BigEndian.Uint32(frame[12:16])
+}
+
+func TestPCMFramer(t *testing.T) {
+	// 16kHz mono, 20ms frames
+	f := NewPCMFramer(16000, 1, func() int64 { return testStartTime })
+	data := make([]byte, 640)
+
+	frame := f.Frame(0, data)
+	require.Len(t, frame, PCMFrameHeaderSize+len(data))
+	captureTime, sequence, samples := readHeader(t, frame)
+	require.Equal(t, testStartTime, captureTime)
+	require.Equal(t, uint32(0), sequence)
+	require.Equal(t, uint32(320), samples)
+
+	captureTime, sequence, _ = readHeader(t, f.Frame(20*time.Millisecond, data))
+	require.Equal(t, testStartTime+int64(20*time.Millisecond), captureTime)
+	require.Equal(t, uint32(1), sequence)
+}
+
+func TestPCMFramerMonotonic(t *testing.T) {
+	f := NewPCMFramer(48000, 2, func() int64 { return testStartTime })
+	data := make([]byte, 3840) // 20ms
+
+	var last int64
+	var lastSequence uint32
+	for i, pts := range []time.Duration{
+		0,
+		20 * time.Millisecond,
+		100 * time.Millisecond, // packets lost
+		110 * time.Millisecond, // overlaps the previous frame
+		-1,                     // no timestamp
+		90 * time.Millisecond,  // late
+		200 * time.Millisecond,
+	} {
+		captureTime, sequence, _ := readHeader(t, f.Frame(pts, data))
+		if i > 0 {
+			require.Greater(t, captureTime, last, "frame %d", i)
+			require.Equal(t, lastSequence+1, sequence)
+		}
+		last, lastSequence = captureTime, sequence
+	}
+
+	// the gap from lost packets is kept
+	f = NewPCMFramer(48000, 2, func() int64 { return testStartTime })
+	f.Frame(0, data)
+	captureTime, _, _ := readHeader(t, f.Frame(100*time.Millisecond, data))
+	require.Equal(t, testStartTime+int64(100*time.Millisecond), captureTime)
+
+	// overlapping frames follow the previous one
+	captureTime, _, _ = readHeader(t, f.Frame(110*time.Millisecond, data))
+	require.Equal(t, testStartTime+int64(120*time.Millisecond), captureTime)
+}
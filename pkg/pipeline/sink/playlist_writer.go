@@ -18,6 +18,8 @@ type PlaylistWriter struct {
 	currentItemStartTimestamp int64
 	currentItemFilename       string
 	playlistPath              string
+	dash                      *DASHWriter
+	discontinuity             bool // the next segment follows a restart
 
 	openSegmentsStartTime map[string]int64
 	openSegmentsLock      sync.Mutex
@@ -35,11 +37,15 @@ func NewPlaylistWriter(p *params.Params) (*PlaylistWriter, error) {
 	playlist.MediaType = m3u8.EVENT
 	playlist.SetVersion(4) // Needed because we have float segment durations
 
-	return &PlaylistWriter{
+	w := &PlaylistWriter{
 		playlist:              playlist,
 		playlistPath:          p.PlaylistFilename,
 		openSegmentsStartTime: make(map[string]int64),
-	}, nil
+	}
+	if p.DASHManifestFilename != "" {
+		w.dash = NewDASHWriter(p)
+	}
+	return w, nil
 }
 
 func (w *PlaylistWriter) StartSegment(filepath string, startTime int64) error {
@@ -91,14 +97,40 @@ func (w *PlaylistWriter) EndSegment(filepath string, endTime int64) error {
 	if err != nil {
 		return err
 	}
+	if w.discontinuity {
+		w.discontinuity = false
+		if err = w.playlist.SetDiscontinuity(); err != nil {
+			return err
+		}
+	}
+	if w.dash != nil {
+		if err = w.dash.AddSegment(k, time.Duration(endTime-t)); err != nil {
+			return err
+		}
+	}
 
 	// Write playlist for every segment. This allows better crash recovery and to use
 	// it as an Event playlist, at the cost of extra I/O
 	return w.writePlaylist()
 }
 
+// Restart continues the playlist for a rebuilt pipeline. Its timestamps start over, and segments still open in the
+// pipeline which failed are never finished
+func (w *PlaylistWriter) Restart() {
+	w.openSegmentsLock.Lock()
+	defer w.openSegmentsLock.Unlock()
+
+	w.openSegmentsStartTime = make(map[string]int64)
+	w.discontinuity = true
+}
+
 func (w *PlaylistWriter) EOS() error {
 	w.playlist.Close()
+	if w.dash != nil {
+		if err := w.dash.EOS(); err != nil {
+			return err
+		}
+	}
 
 	return w.writePlaylist()
 }
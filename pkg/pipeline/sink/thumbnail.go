@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// EncodeThumbnail encodes an I420 frame, laid out as GStreamer writes it, as a jpeg.
+// Planes start on 4 byte strides, and chroma planes are half the size of the luma plane, rounded up
+func EncodeThumbnail(frame []byte, width, height, quality int) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", width, height)
+	}
+
+	yStride := roundUp4(width)
+	cStride := roundUp4((width + 1) / 2)
+	ySize := yStride * roundUp2(height)
+	cSize := cStride * roundUp2(height) / 2
+	if len(frame) < ySize+2*cSize {
+		return nil, fmt.Errorf("frame is %d bytes, expected %d for %dx%d", len(frame), ySize+2*cSize, width, height)
+	}
+
+	img := &image.YCbCr{
+		Y:              frame[:ySize],
+		Cb:             frame[ySize : ySize+cSize],
+		Cr:             frame[ySize+cSize : ySize+2*cSize],
+		YStride:        yStride,
+		CStride:        cStride,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           image.Rect(0, 0, width, height),
+	}
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func roundUp2(n int) int {
+	return (n + 1) &^ 1
+}
+
+func roundUp4(n int) int {
+	return (n + 3) &^ 3
+}
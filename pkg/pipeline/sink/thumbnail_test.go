@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeThumbnail(t *testing.T) {
+	for _, size := range [][2]int{{320, 180}, {342, 192}, {322, 181}} {
+		width, height := size[0], size[1]
+		yStride, cStride := roundUp4(width), roundUp4((width+1)/2)
+		ySize, cSize := yStride*roundUp2(height), cStride*roundUp2(height)/2
+
+		// mid grey, with blue-ish chroma
+		frame := make([]byte, ySize+2*cSize)
+		for i := range frame {
+			switch {
+			case i < ySize:
+				frame[i] = 128
+			case i < ySize+cSize:
+				frame[i] = 200
+			default:
+				frame[i] = 100
+			}
+		}
+
+		b, err := EncodeThumbnail(frame, width, height, 85)
+		require.NoError(t, err)
+
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		require.NoError(t, err)
+		require.Equal(t, width, img.Bounds().Dx())
+		require.Equal(t, height, img.Bounds().Dy())
+
+		r, g, bl, _ := img.At(width/2, height/2).RGBA()
+		require.Greater(t, bl, r)
+		require.Greater(t, bl, g)
+
+		_, err = EncodeThumbnail(frame[:len(frame)-1], width, height, 85)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,123 @@
+package uploader
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+const aliOSSPartConcurrency = 4
+
+type aliOSSUploader struct {
+	conf       *livekit.AliOSSUpload
+	uploadConf config.UploadConfig
+	onRetry    func()
+	endpoint   *url.URL
+	bucket     *oss.Bucket
+}
+
+// newAliOSSUploader uses the endpoint if one is given, otherwise the public endpoint for the region
+func newAliOSSUploader(conf *livekit.AliOSSUpload, uploadConf config.UploadConfig, onRetry func()) (Uploader, error) {
+	u := &aliOSSUploader{
+		conf:       conf,
+		uploadConf: uploadConf,
+		onRetry:    onRetry,
+	}
+
+	if conf.Bucket == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing bucket")
+	}
+	if conf.AccessKey == "" || conf.Secret == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing access key or secret")
+	}
+
+	endpoint := conf.Endpoint
+	switch {
+	case endpoint == "" && conf.Region == "":
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing endpoint or region")
+	case endpoint == "":
+		endpoint = fmt.Sprintf("https://oss-%s.aliyuncs.com", strings.TrimPrefix(conf.Region, "oss-"))
+	case !strings.Contains(endpoint, "://"):
+		endpoint = "https://" + endpoint
+	}
+	var err error
+	u.endpoint, err = url.Parse(endpoint)
+	if err != nil || u.endpoint.Host == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), fmt.Sprintf("invalid endpoint %s", conf.Endpoint))
+	}
+
+	client, err := oss.New(endpoint, conf.AccessKey, conf.Secret, oss.HTTPClient(newLimitedClient(nil)))
+	if err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+	u.bucket, err = client.Bucket(conf.Bucket)
+	if err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+
+	return u, nil
+}
+
+func (u *aliOSSUploader) Location() string {
+	return "AliOSS"
+}
+
+// Upload uploads files larger than the multipart threshold in parts. Failed uploads are retried with exponential
+// backoff, and multipart retries resume from a checkpoint so that completed parts are kept
+func (u *aliOSSUploader) Upload(localFilepath, storageFilepath, contentType string, progress ProgressFunc) (string, error) {
+	fileInfo, err := os.Stat(localFilepath)
+	if err != nil {
+		return "", err
+	}
+
+	opts := []oss.Option{oss.ContentType(contentType)}
+	if progress != nil {
+		opts = append(opts, oss.Progress(ossProgress(progress)))
+	}
+
+	if fileInfo.Size() > u.uploadConf.MultipartThreshold*bytesPerMB {
+		checkpoint := localFilepath + ".cp"
+		defer os.Remove(checkpoint)
+
+		err = retry(u.uploadConf.MaxAttempts, u.onRetry, isRetryableOSSError, func() error {
+			return u.bucket.UploadFile(storageFilepath, localFilepath, u.uploadConf.PartSize*bytesPerMB,
+				append(opts, oss.Routines(aliOSSPartConcurrency), oss.Checkpoint(true, checkpoint))...,
+			)
+		})
+	} else {
+		err = retry(u.uploadConf.MaxAttempts, u.onRetry, isRetryableOSSError, func() error {
+			return u.bucket.PutObjectFromFile(storageFilepath, localFilepath, opts...)
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s://%s.%s/%s", u.endpoint.Scheme, u.conf.Bucket, u.endpoint.Host, storageFilepath), nil
+}
+
+// ossProgress reports the bytes consumed by each transfer
+type ossProgress ProgressFunc
+
+func (f ossProgress) ProgressChanged(event *oss.ProgressEvent) {
+	if event.EventType == oss.TransferDataEvent || event.EventType == oss.TransferCompletedEvent {
+		f(event.ConsumedBytes)
+	}
+}
+
+// isRetryableOSSError retries connection errors, throttling, and server errors
+func isRetryableOSSError(err error) bool {
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.StatusCode >= http.StatusInternalServerError || serviceErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+type azureUploader struct {
+	conf         *livekit.AzureBlobUpload
+	containerUrl azblob.ContainerURL
+}
+
+// newAzureUploader authenticates with the account key, or with sasToken if there is no key
+func newAzureUploader(conf *livekit.AzureBlobUpload, sasToken string, uploadConf config.UploadConfig) (Uploader, error) {
+	u := &azureUploader{conf: conf}
+
+	if conf.AccountName == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing account name")
+	}
+	if conf.ContainerName == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing container name")
+	}
+
+	var credential azblob.Credential
+	sUrl := fmt.Sprintf("https://%s.blob.core.windows.net/%s", conf.AccountName, conf.ContainerName)
+	switch {
+	case conf.AccountKey != "":
+		var err error
+		credential, err = azblob.NewSharedKeyCredential(conf.AccountName, conf.AccountKey)
+		if err != nil {
+			return nil, errors.ErrInvalidUploadConfig(u.Location(), "invalid account key")
+		}
+	case sasToken != "":
+		credential = azblob.NewAnonymousCredential()
+		sUrl = fmt.Sprintf("%s?%s", sUrl, strings.TrimPrefix(sasToken, "?"))
+	default:
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing account key or sas token")
+	}
+
+	azUrl, err := url.Parse(sUrl)
+	if err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{
+			Policy:        azblob.RetryPolicyExponential,
+			MaxTries:      int32(uploadConf.MaxAttempts),
+			RetryDelay:    minDelay,
+			MaxRetryDelay: maxDelay,
+		},
+		HTTPSender: limitedSender(),
+	})
+	u.containerUrl = azblob.NewContainerURL(*azUrl, pipeline)
+
+	return u, nil
+}
+
+// limitedSender sends requests with the bandwidth limited client
+func limitedSender() pipeline.Factory {
+	client := newLimitedClient(nil)
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			res, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(res), err
+		}
+	})
+}
+
+func (u *azureUploader) Location() string {
+	return "Azure"
+}
+
+func (u *azureUploader) Upload(localFilepath, storageFilepath, contentType string, progress ProgressFunc) (string, error) {
+	blobUrl := u.containerUrl.NewBlockBlobURL(storageFilepath)
+
+	file, err := os.Open(localFilepath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// upload blocks in parallel for optimal performance
+	// it calls PutBlock/PutBlockList for files larger than 256 MBs and PutBlob for smaller files
+	opts := azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: contentType},
+		BlockSize:       4 * 1024 * 1024,
+		Parallelism:     16,
+	}
+	if progress != nil {
+		opts.Progress = func(bytesTransferred int64) {
+			progress(bytesTransferred)
+		}
+	}
+	_, err = azblob.UploadFileToBlockBlob(context.Background(), file, blobUrl, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", u.conf.AccountName, u.conf.ContainerName, storageFilepath), nil
+}
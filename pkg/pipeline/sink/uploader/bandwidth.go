@@ -0,0 +1,103 @@
+package uploader
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+)
+
+// the most read from an upload at once, so that a single read never needs more than a burst of tokens
+const bandwidthBurst = 64 * 1024
+
+// uploads share a token bucket, so that concurrent uploads together stay within the limit
+var bandwidth = &bandwidthLimiter{
+	limiter: rate.NewLimiter(rate.Inf, bandwidthBurst),
+}
+
+type bandwidthLimiter struct {
+	limiter *rate.Limiter
+	sent    atomic.Int64
+}
+
+// SetBandwidthLimit limits all uploads in this process to bytesPerSecond, or removes the limit if it is 0.
+// It can be changed while uploads are in progress
+func SetBandwidthLimit(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		bandwidth.limiter.SetLimit(rate.Inf)
+	} else {
+		bandwidth.limiter.SetLimit(rate.Limit(bytesPerSecond))
+	}
+}
+
+// BytesSent returns the number of bytes uploaded by this process, for measuring throughput
+func BytesSent() int64 {
+	return bandwidth.sent.Load()
+}
+
+// limitedReader waits for tokens as the upload reads from r
+type limitedReader struct {
+	r io.Reader
+}
+
+func newLimitedReader(r io.Reader) io.Reader {
+	return &limitedReader{r: r}
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if len(b) > bandwidthBurst {
+		b = b[:bandwidthBurst]
+	}
+	n, err := l.r.Read(b)
+	if n > 0 {
+		_ = bandwidth.limiter.WaitN(context.Background(), n)
+		bandwidth.sent.Add(int64(n))
+	}
+	return n, err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitedTransport limits request bodies as they are sent. SDKs which read the body more than once, to sign or
+// checksum it, are only limited by what goes over the network
+type limitedTransport struct {
+	base http.RoundTripper
+}
+
+// newLimitedClient returns a copy of client which limits request bodies, or of the default client if client is nil
+func newLimitedClient(client *http.Client) *http.Client {
+	limited := &http.Client{}
+	if client != nil {
+		*limited = *client
+	}
+	base := limited.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	limited.Transport = &limitedTransport{base: base}
+	return limited
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.base.RoundTrip(req)
+	}
+
+	limited := req.Clone(req.Context())
+	limited.Body = &limitedReadCloser{Reader: newLimitedReader(req.Body), Closer: req.Body}
+	if req.GetBody != nil {
+		limited.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return &limitedReadCloser{Reader: newLimitedReader(body), Closer: body}, nil
+		}
+	}
+	return t.base.RoundTrip(limited)
+}
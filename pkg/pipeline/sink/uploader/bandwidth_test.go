@@ -0,0 +1,52 @@
+package uploader
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedReader(t *testing.T) {
+	defer SetBandwidthLimit(0)
+
+	data := make([]byte, bandwidthBurst*3)
+	sent := BytesSent()
+
+	// the bucket starts with a burst of tokens, and the rest are added at 256KB/s
+	SetBandwidthLimit(bandwidthBurst * 4)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, newLimitedReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*400)
+	require.Equal(t, sent+n, BytesSent())
+
+	SetBandwidthLimit(0)
+	start = time.Now()
+	_, err = io.Copy(io.Discard, newLimitedReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Millisecond*100)
+}
+
+func TestLimitedClient(t *testing.T) {
+	defer SetBandwidthLimit(0)
+
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	sent := BytesSent()
+	data := make([]byte, bandwidthBurst*2)
+	res, err := newLimitedClient(nil).Post(server.URL, "application/octet-stream", bytes.NewReader(data))
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	require.Equal(t, int64(len(data)), received)
+	require.Equal(t, sent+received, BytesSent())
+}
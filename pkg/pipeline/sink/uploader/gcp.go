@@ -0,0 +1,104 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+type gcpUploader struct {
+	conf *livekit.GCPUpload
+}
+
+func newGCPUploader(conf *livekit.GCPUpload) (Uploader, error) {
+	u := &gcpUploader{conf: conf}
+
+	if conf.Bucket == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing bucket")
+	}
+
+	// parses the credentials, or finds default credentials if none are given
+	client, err := u.newClient(context.Background())
+	if err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+	_ = client.Close()
+
+	return u, nil
+}
+
+func (u *gcpUploader) Location() string {
+	return "GCP"
+}
+
+func (u *gcpUploader) newClient(ctx context.Context) (*storage.Client, error) {
+	if u.conf.Credentials != nil {
+		return storage.NewClient(ctx, option.WithCredentialsJSON(u.conf.Credentials))
+	}
+	return storage.NewClient(ctx)
+}
+
+func (u *gcpUploader) Upload(localFilepath, storageFilepath, contentType string, progress ProgressFunc) (string, error) {
+	ctx := context.Background()
+	client, err := u.newClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	file, err := os.Open(localFilepath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// In case where the total amount of data to upload is larger than googleapi.DefaultUploadChunkSize, each upload request will have a timeout of
+	// ChunkRetryDeadline, which is 32s by default. If the request payload is smaller than googleapi.DefaultUploadChunkSize, use a context deadline
+	// to apply the same timeout
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	var wctx context.Context
+	if fileInfo.Size() <= googleapi.DefaultUploadChunkSize {
+		var cancel context.CancelFunc
+		wctx, cancel = context.WithTimeout(ctx, time.Second*32)
+		defer cancel()
+	} else {
+		wctx = ctx
+	}
+
+	wc := client.Bucket(u.conf.Bucket).Object(storageFilepath).Retryer(storage.WithBackoff(gax.Backoff{
+		Initial:    minDelay,
+		Max:        maxDelay,
+		Multiplier: 2,
+	}),
+		storage.WithPolicy(storage.RetryAlways),
+	).NewWriter(wctx)
+	wc.ContentType = contentType
+	if progress != nil {
+		wc.ProgressFunc = progress
+	}
+
+	// the client sets up its own transport, and reads the file once, so the file is limited instead
+	if _, err = io.Copy(wc, newLimitedReader(file)); err != nil {
+		return "", err
+	}
+
+	if err = wc.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("https://%s.storage.googleapis.com/%s", u.conf.Bucket, storageFilepath), nil
+}
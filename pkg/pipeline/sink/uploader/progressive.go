@@ -0,0 +1,190 @@
+package uploader
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/livekit/egress/pkg/errors"
+)
+
+const progressivePollInterval = time.Second
+
+// Progressive uploads a file in parts while it is being written. The file must only be appended to.
+type Progressive interface {
+	// Finish uploads the rest of the file once it has been closed, returning its url.
+	// If it fails, the upload is aborted and the file can be uploaded as usual
+	Finish() (string, error)
+
+	// Abort stops the upload and discards any uploaded parts
+	Abort()
+}
+
+// ProgressiveUploader is implemented by uploaders which can upload a file while it is being written
+type ProgressiveUploader interface {
+	Uploader
+
+	UploadProgressive(localFilepath, storageFilepath, contentType string) (Progressive, error)
+}
+
+type s3Progressive struct {
+	u               *s3Uploader
+	svc             *s3.S3
+	localFilepath   string
+	storageFilepath string
+	uploadID        *string
+	partSize        int64
+
+	// owned by run until done is closed, then by Finish
+	offset int64
+	parts  []*s3.CompletedPart
+	err    error
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	abortOnce sync.Once
+}
+
+// UploadProgressive starts a multipart upload, uploading a part each time PartSize more bytes have been written
+func (u *s3Uploader) UploadProgressive(localFilepath, storageFilepath, contentType string) (Progressive, error) {
+	svc := s3.New(u.sess)
+	res, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(u.conf.Bucket),
+		Key:         aws.String(storageFilepath),
+		ContentType: aws.String(contentType),
+		Metadata:    convertS3Metadata(u.conf.Metadata),
+		Tagging:     u.tagging,
+
+		ServerSideEncryption: u.opts.sse,
+		SSEKMSKeyId:          u.opts.sseKMSKeyID,
+		ACL:                  u.opts.acl,
+		StorageClass:         u.opts.storageClass,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &s3Progressive{
+		u:               u,
+		svc:             svc,
+		localFilepath:   localFilepath,
+		storageFilepath: storageFilepath,
+		uploadID:        res.UploadId,
+		partSize:        u.uploadConf.PartSize * bytesPerMB,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *s3Progressive) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(progressivePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if p.err = p.uploadParts(false); p.err != nil {
+				return
+			}
+		}
+	}
+}
+
+// uploadParts uploads each complete part written since the last call. If final is set, the remainder is uploaded
+// as the last part
+func (p *s3Progressive) uploadParts(final bool) error {
+	file, err := os.Open(p.localFilepath)
+	if err != nil {
+		if os.IsNotExist(err) && !final {
+			// not created until the pipeline starts
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	for size := fileInfo.Size(); size > p.offset; {
+		n := size - p.offset
+		if n > p.partSize {
+			n = p.partSize
+		} else if n < p.partSize && !final {
+			break
+		}
+
+		partNumber := aws.Int64(int64(len(p.parts) + 1))
+		res, err := p.svc.UploadPart(&s3.UploadPartInput{
+			Bucket:        aws.String(p.u.conf.Bucket),
+			Key:           aws.String(p.storageFilepath),
+			UploadId:      p.uploadID,
+			PartNumber:    partNumber,
+			Body:          io.NewSectionReader(file, p.offset, n),
+			ContentLength: aws.Int64(n),
+		})
+		if err != nil {
+			return err
+		}
+
+		p.parts = append(p.parts, &s3.CompletedPart{ETag: res.ETag, PartNumber: partNumber})
+		p.offset += n
+	}
+
+	return nil
+}
+
+func (p *s3Progressive) Finish() (string, error) {
+	p.closeOnce.Do(func() { close(p.stop) })
+	<-p.done
+
+	err := p.err
+	if err == nil {
+		err = p.uploadParts(true)
+	}
+	if err == nil && len(p.parts) == 0 {
+		err = errors.New("file is empty")
+	}
+	if err == nil {
+		_, err = p.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(p.u.conf.Bucket),
+			Key:             aws.String(p.storageFilepath),
+			UploadId:        p.uploadID,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: p.parts},
+		})
+	}
+	if err != nil {
+		p.Abort()
+		return "", err
+	}
+
+	// nothing left to abort
+	p.abortOnce.Do(func() {})
+	return p.u.objectUrl(p.storageFilepath), nil
+}
+
+func (p *s3Progressive) Abort() {
+	p.closeOnce.Do(func() { close(p.stop) })
+	<-p.done
+
+	p.abortOnce.Do(func() {
+		_, _ = p.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(p.u.conf.Bucket),
+			Key:      aws.String(p.storageFilepath),
+			UploadId: p.uploadID,
+		})
+	})
+}
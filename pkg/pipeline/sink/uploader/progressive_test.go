@@ -0,0 +1,132 @@
+package uploader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+// fakeMultipartServer implements the s3 multipart upload requests
+type fakeMultipartServer struct {
+	mu        sync.Mutex
+	parts     map[int][]byte
+	completed bool
+	aborted   bool
+}
+
+func (f *fakeMultipartServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		_, _ = fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		body, _ := io.ReadAll(r.Body)
+		f.parts[partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, partNumber))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completed = true
+		_, _ = fmt.Fprint(w, `<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodDelete:
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeMultipartServer) object() ([]byte, []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	partNumbers := make([]int, 0, len(f.parts))
+	for partNumber := range f.parts {
+		partNumbers = append(partNumbers, partNumber)
+	}
+	sort.Ints(partNumbers)
+
+	var object []byte
+	sizes := make([]int, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		object = append(object, f.parts[partNumber]...)
+		sizes = append(sizes, len(f.parts[partNumber]))
+	}
+	return object, sizes
+}
+
+func newProgressiveTestUploader(t *testing.T, f *fakeMultipartServer) ProgressiveUploader {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	conf := &config.Config{Upload: config.UploadConfig{MaxAttempts: 1, PartSize: 1}}
+	u, err := New(conf, &livekit.S3Upload{
+		AccessKey:      "key",
+		Secret:         "secret",
+		Bucket:         "bucket",
+		Endpoint:       server.URL,
+		ForcePathStyle: true,
+	}, nil)
+	require.NoError(t, err)
+
+	pu, ok := u.(ProgressiveUploader)
+	require.True(t, ok)
+	return pu
+}
+
+func TestProgressiveUpload(t *testing.T) {
+	f := &fakeMultipartServer{parts: make(map[int][]byte)}
+	u := newProgressiveTestUploader(t, f)
+
+	localFilepath := path.Join(t.TempDir(), "room.mkv")
+	progressive, err := u.UploadProgressive(localFilepath, "room.mkv", "video/x-matroska")
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("egress"), bytesPerMB/2)
+	require.NoError(t, os.WriteFile(localFilepath, content, 0644))
+
+	location, err := progressive.Finish()
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%s/bucket/room.mkv", u.(*s3Uploader).endpoint), location)
+
+	object, sizes := f.object()
+	require.True(t, f.completed)
+	require.False(t, f.aborted)
+	require.Equal(t, content, object)
+	require.Equal(t, []int{bytesPerMB, bytesPerMB, bytesPerMB}, sizes)
+
+	// aborting a finished upload does nothing
+	progressive.Abort()
+	require.False(t, f.aborted)
+}
+
+func TestProgressiveUploadEmpty(t *testing.T) {
+	f := &fakeMultipartServer{parts: make(map[int][]byte)}
+	u := newProgressiveTestUploader(t, f)
+
+	localFilepath := path.Join(t.TempDir(), "room.ogg")
+	require.NoError(t, os.WriteFile(localFilepath, nil, 0644))
+
+	progressive, err := u.UploadProgressive(localFilepath, "room.ogg", "audio/ogg")
+	require.NoError(t, err)
+
+	_, err = progressive.Finish()
+	require.Error(t, err)
+	require.False(t, f.completed)
+	require.True(t, f.aborted)
+}
@@ -0,0 +1,324 @@
+package uploader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+const (
+	// s3 compatible services such as minio accept any region
+	defaultS3CompatibleRegion = "us-east-1"
+
+	// s3 object limits
+	maxS3MetadataSize = 2048
+	maxS3Tags         = 10
+	maxS3TagKeySize   = 128
+	maxS3TagValueSize = 256
+)
+
+type s3Uploader struct {
+	conf       *livekit.S3Upload
+	opts       s3Options
+	uploadConf config.UploadConfig
+	endpoint   *url.URL
+	region     string  // the request's, or else the default
+	tagging    *string // nil when the request has none, since s3 rejects empty tagging
+	sess       *session.Session
+}
+
+// s3Options are set by the node config for every upload
+type s3Options struct {
+	sse          *string
+	sseKMSKeyID  *string
+	acl          *string
+	storageClass *string
+}
+
+// s3Retryer counts retries made by the default exponential backoff retryer
+type s3Retryer struct {
+	client.DefaultRetryer
+	onRetry func()
+}
+
+func (r *s3Retryer) RetryRules(req *request.Request) time.Duration {
+	if r.onRetry != nil {
+		r.onRetry()
+	}
+	return r.DefaultRetryer.RetryRules(req)
+}
+
+func newS3Uploader(conf *livekit.S3Upload, s3Conf *config.S3Config, uploadConf config.UploadConfig, onRetry func()) (Uploader, error) {
+	u := &s3Uploader{
+		conf:       conf,
+		uploadConf: uploadConf,
+	}
+
+	if conf.Bucket == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing bucket")
+	}
+	if (conf.AccessKey == "") != (conf.Secret == "") {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "access key and secret must be set together")
+	}
+	if err := verifyS3Metadata(conf.Metadata); err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+	if err := verifyS3Tagging(conf.Tagging); err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+	if conf.Tagging != "" {
+		u.tagging = aws.String(conf.Tagging)
+	}
+	if s3Conf != nil {
+		opts, err := getS3Options(s3Conf)
+		if err != nil {
+			return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+		}
+		u.opts = opts
+	}
+
+	region := conf.Region
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if conf.Endpoint != "" {
+		endpoint, err := url.Parse(conf.Endpoint)
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return nil, errors.ErrInvalidUploadConfig(u.Location(), fmt.Sprintf("invalid endpoint %s", conf.Endpoint))
+		}
+		u.endpoint = endpoint
+		if region == "" {
+			region = defaultS3CompatibleRegion
+		}
+	} else if region == "" {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "missing region")
+	}
+
+	u.region = region
+
+	awsConf := &aws.Config{
+		Endpoint:         aws.String(conf.Endpoint),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(conf.ForcePathStyle),
+	}
+	if conf.AccessKey != "" {
+		awsConf.Credentials = credentials.NewStaticCredentials(conf.AccessKey, conf.Secret, "")
+	}
+
+	sess, err := session.NewSession(request.WithRetryer(awsConf, &s3Retryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries: uploadConf.MaxAttempts - 1,
+			MinRetryDelay: minDelay,
+			MaxRetryDelay: maxDelay,
+		},
+		onRetry: onRetry,
+	}))
+	if err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), err.Error())
+	}
+	if _, err = sess.Config.Credentials.Get(); err != nil {
+		return nil, errors.ErrInvalidUploadConfig(u.Location(), "no credentials found")
+	}
+	// after the session has set up its transport, which may load a custom CA bundle
+	sess.Config.HTTPClient = newLimitedClient(sess.Config.HTTPClient)
+	u.sess = sess
+
+	return u, nil
+}
+
+func (u *s3Uploader) Location() string {
+	return "S3"
+}
+
+// Upload uploads files larger than the multipart threshold in parts, retrying each failed request with exponential backoff
+func (u *s3Uploader) Upload(localFilepath, storageFilepath, contentType string, progress ProgressFunc) (string, error) {
+	file, err := os.Open(localFilepath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if fileInfo.Size() > u.uploadConf.MultipartThreshold*bytesPerMB {
+		// parts are retried individually, and the upload is aborted if one still fails
+		uploader := s3manager.NewUploader(u.sess, func(m *s3manager.Uploader) {
+			m.PartSize = u.uploadConf.PartSize * bytesPerMB
+			if progress != nil {
+				m.RequestOptions = append(m.RequestOptions, partProgress(progress))
+			}
+		})
+		_, err = uploader.Upload(&s3manager.UploadInput{
+			Bucket:      aws.String(u.conf.Bucket),
+			Key:         aws.String(storageFilepath),
+			Body:        file,
+			ContentType: aws.String(contentType),
+			Metadata:    convertS3Metadata(u.conf.Metadata),
+			Tagging:     u.tagging,
+
+			ServerSideEncryption: u.opts.sse,
+			SSEKMSKeyId:          u.opts.sseKMSKeyID,
+			ACL:                  u.opts.acl,
+			StorageClass:         u.opts.storageClass,
+		})
+	} else {
+		_, err = s3.New(u.sess).PutObject(&s3.PutObjectInput{
+			Bucket:        aws.String(u.conf.Bucket),
+			Key:           aws.String(storageFilepath),
+			Body:          file,
+			ContentLength: aws.Int64(fileInfo.Size()),
+			ContentType:   aws.String(contentType),
+			Metadata:      convertS3Metadata(u.conf.Metadata),
+			Tagging:       u.tagging,
+
+			ServerSideEncryption: u.opts.sse,
+			SSEKMSKeyId:          u.opts.sseKMSKeyID,
+			ACL:                  u.opts.acl,
+			StorageClass:         u.opts.storageClass,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	if progress != nil {
+		progress(fileInfo.Size())
+	}
+
+	return u.objectUrl(storageFilepath), nil
+}
+
+// partProgress reports the bytes uploaded each time a part is completed
+func partProgress(progress ProgressFunc) request.Option {
+	uploaded := atomic.NewInt64(0)
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Operation.Name == "UploadPart" && r.Error == nil {
+				progress(uploaded.Add(r.HTTPRequest.ContentLength))
+			}
+		})
+	}
+}
+
+func (u *s3Uploader) objectUrl(storageFilepath string) string {
+	if u.endpoint == nil {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", u.conf.Bucket, u.region, storageFilepath)
+	}
+
+	base := strings.TrimSuffix(u.endpoint.String(), "/")
+	if u.conf.ForcePathStyle {
+		return fmt.Sprintf("%s/%s/%s", base, u.conf.Bucket, storageFilepath)
+	}
+	return fmt.Sprintf("%s://%s.%s/%s", u.endpoint.Scheme, u.conf.Bucket, u.endpoint.Host, storageFilepath)
+}
+
+func getS3Options(conf *config.S3Config) (s3Options, error) {
+	opts := s3Options{}
+
+	switch conf.SSE {
+	case "":
+		if conf.SSEKMSKeyID != "" {
+			return opts, fmt.Errorf("sse_kms_key_id requires sse %s", s3.ServerSideEncryptionAwsKms)
+		}
+	case s3.ServerSideEncryptionAes256:
+		if conf.SSEKMSKeyID != "" {
+			return opts, fmt.Errorf("sse_kms_key_id requires sse %s", s3.ServerSideEncryptionAwsKms)
+		}
+		opts.sse = aws.String(conf.SSE)
+	case s3.ServerSideEncryptionAwsKms:
+		opts.sse = aws.String(conf.SSE)
+		if conf.SSEKMSKeyID != "" {
+			opts.sseKMSKeyID = aws.String(conf.SSEKMSKeyID)
+		}
+	default:
+		return opts, fmt.Errorf("invalid sse %s", conf.SSE)
+	}
+
+	if conf.ACL != "" {
+		if !contains(s3.ObjectCannedACL_Values(), conf.ACL) {
+			return opts, fmt.Errorf("invalid acl %s", conf.ACL)
+		}
+		opts.acl = aws.String(conf.ACL)
+	}
+
+	if conf.StorageClass != "" {
+		if !contains(s3.StorageClass_Values(), conf.StorageClass) {
+			return opts, fmt.Errorf("invalid storage class %s", conf.StorageClass)
+		}
+		opts.storageClass = aws.String(conf.StorageClass)
+	}
+
+	return opts, nil
+}
+
+// verifyS3Metadata checks the 2KB limit on user defined metadata
+func verifyS3Metadata(metadata map[string]string) error {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	if size > maxS3MetadataSize {
+		return fmt.Errorf("metadata is %d bytes, the limit is %d", size, maxS3MetadataSize)
+	}
+	return nil
+}
+
+// verifyS3Tagging checks that tagging is url encoded, and within the limits on object tags
+func verifyS3Tagging(tagging string) error {
+	if tagging == "" {
+		return nil
+	}
+
+	tags, err := url.ParseQuery(tagging)
+	if err != nil {
+		return fmt.Errorf("invalid tagging: %v", err)
+	}
+	if len(tags) > maxS3Tags {
+		return fmt.Errorf("%d tags, the limit is %d", len(tags), maxS3Tags)
+	}
+	for k, v := range tags {
+		if len(v) > 1 {
+			return fmt.Errorf("duplicate tag %s", k)
+		}
+		if utf8.RuneCountInString(k) > maxS3TagKeySize || utf8.RuneCountInString(v[0]) > maxS3TagValueSize {
+			return fmt.Errorf("tag %s is too long", k)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func convertS3Metadata(metadata map[string]string) map[string]*string {
+	var result = map[string]*string{}
+	for k, v := range metadata {
+		allocatedVal := v
+		result[k] = &allocatedVal
+	}
+	return result
+}
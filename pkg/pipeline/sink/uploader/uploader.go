@@ -0,0 +1,69 @@
+package uploader
+
+import (
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+const (
+	minDelay   = time.Millisecond * 100
+	maxDelay   = time.Second * 5
+	bytesPerMB = 1 << 20
+)
+
+// ProgressFunc is called with the number of bytes uploaded so far. It can be called from several goroutines
+type ProgressFunc func(uploaded int64)
+
+// Uploader stores local files in a storage backend
+type Uploader interface {
+	// Upload stores the file at localFilepath as storageFilepath, returning its url.
+	// If progress is set, it is called as the file is uploaded
+	Upload(localFilepath, storageFilepath, contentType string, progress ProgressFunc) (string, error)
+
+	// Location names the storage backend in errors and logs
+	Location() string
+}
+
+// New returns an uploader for upload, which is one of the livekit upload types, or nil if upload is nil.
+// Its config is checked without connecting, so that invalid configs fail before anything is recorded.
+// onRetry is called before each retried request, where the backend reports retries
+func New(conf *config.Config, upload interface{}, onRetry func()) (Uploader, error) {
+	switch u := upload.(type) {
+	case *livekit.S3Upload:
+		return newS3Uploader(u, conf.S3, conf.Upload, onRetry)
+	case *livekit.GCPUpload:
+		return newGCPUploader(u)
+	case *livekit.AzureBlobUpload:
+		var sasToken string
+		if conf.Azure != nil {
+			sasToken = conf.Azure.SASToken
+		}
+		return newAzureUploader(u, sasToken, conf.Upload)
+	case *livekit.AliOSSUpload:
+		return newAliOSSUploader(u, conf.Upload, onRetry)
+	default:
+		return nil, nil
+	}
+}
+
+// retry calls upload until it succeeds, returns an error which can't be retried, or maxAttempts is reached,
+// with exponential backoff between attempts
+func retry(maxAttempts int, onRetry func(), retryable func(error) bool, upload func() error) error {
+	delay := minDelay
+	for attempt := 1; ; attempt++ {
+		err := upload()
+		if err == nil || attempt >= maxAttempts || !retryable(err) {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry()
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
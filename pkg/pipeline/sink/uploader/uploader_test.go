@@ -0,0 +1,145 @@
+package uploader
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestNewS3Uploader(t *testing.T) {
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	conf := &config.Config{Upload: config.UploadConfig{MaxAttempts: 5}}
+
+	_, err := New(conf, &livekit.S3Upload{AccessKey: "key", Secret: "secret", Bucket: "bucket"}, nil)
+	require.Error(t, err, "aws requires a region")
+
+	_, err = New(conf, &livekit.S3Upload{AccessKey: "key", Bucket: "bucket", Region: "us-west-2"}, nil)
+	require.Error(t, err, "missing secret")
+
+	_, err = New(conf, &livekit.S3Upload{AccessKey: "key", Secret: "secret", Bucket: "bucket", Endpoint: "minio:9000"}, nil)
+	require.Error(t, err, "endpoint without scheme")
+
+	u, err := New(conf, &livekit.S3Upload{
+		AccessKey:      "key",
+		Secret:         "secret",
+		Bucket:         "bucket",
+		Endpoint:       "http://minio:9000",
+		ForcePathStyle: true,
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "http://minio:9000/bucket/a/b.mp4", u.(*s3Uploader).objectUrl("a/b.mp4"))
+
+	u, err = New(conf, &livekit.S3Upload{AccessKey: "key", Secret: "secret", Bucket: "bucket", Region: "us-west-2"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://bucket.s3.us-west-2.amazonaws.com/a/b.mp4", u.(*s3Uploader).objectUrl("a/b.mp4"))
+
+	// the region can come from the environment instead
+	t.Setenv("AWS_DEFAULT_REGION", "eu-central-1")
+	u, err = New(conf, &livekit.S3Upload{AccessKey: "key", Secret: "secret", Bucket: "bucket"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://bucket.s3.eu-central-1.amazonaws.com/a/b.mp4", u.(*s3Uploader).objectUrl("a/b.mp4"))
+}
+
+func TestNewAzureUploader(t *testing.T) {
+	conf := &config.Config{Upload: config.UploadConfig{MaxAttempts: 5}}
+	upload := &livekit.AzureBlobUpload{AccountName: "account", ContainerName: "container"}
+
+	_, err := New(conf, upload, nil)
+	require.Error(t, err, "missing key and sas token")
+
+	upload.AccountKey = "not base64!"
+	_, err = New(conf, upload, nil)
+	require.Error(t, err)
+
+	upload.AccountKey = ""
+	conf.Azure = &config.AzureConfig{SASToken: "?sv=2021-06-08&sig=abc"}
+	_, err = New(conf, upload, nil)
+	require.NoError(t, err)
+}
+
+func TestNoUploader(t *testing.T) {
+	u, err := New(&config.Config{}, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, u)
+}
+
+func TestNewAliOSSUploader(t *testing.T) {
+	conf := &config.Config{Upload: config.UploadConfig{MaxAttempts: 5}}
+	upload := &livekit.AliOSSUpload{AccessKey: "key", Secret: "secret", Bucket: "bucket"}
+
+	_, err := New(conf, upload, nil)
+	require.Error(t, err, "missing endpoint and region")
+
+	upload.Region = "cn-hangzhou"
+	u, err := New(conf, upload, nil)
+	require.NoError(t, err)
+	require.Equal(t, "oss-cn-hangzhou.aliyuncs.com", u.(*aliOSSUploader).endpoint.Host)
+
+	upload.Endpoint = "http://localhost:8080"
+	u, err = New(conf, upload, nil)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8080", u.(*aliOSSUploader).endpoint.String())
+}
+
+func TestRetry(t *testing.T) {
+	var attempts, retries int
+	err := retry(3, func() { retries++ }, func(error) bool { return true }, func() error {
+		attempts++
+		return errors.New("unavailable")
+	})
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+	require.Equal(t, 2, retries)
+
+	attempts = 0
+	err = retry(3, nil, func(error) bool { return false }, func() error {
+		attempts++
+		return errors.New("forbidden")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestS3ObjectOptions(t *testing.T) {
+	conf := &config.Config{
+		Upload: config.UploadConfig{MaxAttempts: 5},
+		S3:     &config.S3Config{SSE: "aws:kms", SSEKMSKeyID: "key-id", StorageClass: "STANDARD_IA"},
+	}
+	upload := &livekit.S3Upload{AccessKey: "key", Secret: "secret", Bucket: "bucket", Region: "us-west-2"}
+
+	u, err := New(conf, upload, nil)
+	require.NoError(t, err)
+	require.Equal(t, "key-id", *u.(*s3Uploader).opts.sseKMSKeyID)
+
+	conf.S3 = &config.S3Config{SSE: "AES256", SSEKMSKeyID: "key-id"}
+	_, err = New(conf, upload, nil)
+	require.Error(t, err)
+
+	conf.S3 = &config.S3Config{ACL: "everyone"}
+	_, err = New(conf, upload, nil)
+	require.Error(t, err)
+
+	conf.S3 = nil
+	upload.Metadata = map[string]string{"room": strings.Repeat("a", 2048)}
+	_, err = New(conf, upload, nil)
+	require.Error(t, err)
+
+	upload.Metadata = nil
+	u, err = New(conf, upload, nil)
+	require.NoError(t, err)
+	require.Nil(t, u.(*s3Uploader).tagging, "empty tagging is left out")
+
+	upload.Tagging = "a=1&b=2&c=3&d=4&e=5&f=6&g=7&h=8&i=9&j=10&k=11"
+	_, err = New(conf, upload, nil)
+	require.Error(t, err)
+
+	upload.Tagging = "room_id=RM_123"
+	u, err = New(conf, upload, nil)
+	require.NoError(t, err)
+	require.Equal(t, "room_id=RM_123", *u.(*s3Uploader).tagging)
+}
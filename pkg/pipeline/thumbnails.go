@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/egress/pkg/pipeline/sink"
+)
+
+// thumbnails which can't be written and uploaded in time are skipped once this many are waiting
+const maxPendingThumbnails = 10
+
+// thumbnailWriter encodes, writes and uploads thumbnails one at a time, numbering them in the order they're uploaded
+// so that stored thumbnails have no gaps. Failures are logged and counted, but never fail the egress
+type thumbnailWriter struct {
+	p *Pipeline
+
+	pending  chan []byte
+	done     chan struct{}
+	count    int
+	failures atomic.Int32
+}
+
+func newThumbnailWriter(p *Pipeline) *thumbnailWriter {
+	return &thumbnailWriter{
+		p:       p,
+		count:   p.ThumbnailCount, // restarted pipelines number thumbnails after the last one's
+		pending: make(chan []byte, maxPendingThumbnails),
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *thumbnailWriter) start() {
+	go func() {
+		defer close(w.done)
+		for frame := range w.pending {
+			if err := w.store(frame); err != nil {
+				w.failures.Inc()
+				w.p.Logger.Warnw("failed to store thumbnail", err, "index", w.count)
+			}
+		}
+	}()
+}
+
+// enqueue is called from the thumbnail branch's streaming thread, and never blocks it
+func (w *thumbnailWriter) enqueue(frame []byte) {
+	select {
+	case w.pending <- frame:
+	default:
+		w.failures.Inc()
+		w.p.Logger.Debugw("thumbnails falling behind, skipping thumbnail")
+	}
+}
+
+// close waits for the remaining thumbnails to be stored, returning the number stored
+func (w *thumbnailWriter) close() int {
+	close(w.pending)
+	<-w.done
+	if failures := w.failures.Load(); failures > 0 {
+		w.p.Logger.Infow("thumbnails skipped", "count", failures)
+	}
+	return w.count
+}
+
+func (w *thumbnailWriter) store(frame []byte) error {
+	data, err := sink.EncodeThumbnail(frame, int(w.p.ThumbnailWidth), int(w.p.ThumbnailHeight), w.p.ThumbnailQuality)
+	if err != nil {
+		return err
+	}
+
+	localPath := w.p.GetThumbnailFilepath(w.count)
+	if err = os.WriteFile(localPath, data, 0644); err != nil {
+		return err
+	}
+
+	// the next thumbnail takes the index of one which failed to upload
+	if _, _, err = w.p.upload(context.Background(), localPath, w.p.GetThumbnailStorageFilepath(localPath), params.OutputTypeJPEG, nil); err != nil {
+		return err
+	}
+	w.count++
+
+	if w.p.UploadConfig != nil && w.p.RetainedPath == "" {
+		_ = os.Remove(localPath)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"github.com/livekit/protocol/tracer"
+)
+
+// startActiveSpan traces the time the egress is active, until it is sent EOS or stops
+func (p *Pipeline) startActiveSpan() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+	if p.activeSpan == nil && p.traceCtx != nil {
+		_, p.activeSpan = tracer.Start(p.traceCtx, "Pipeline.active")
+	}
+}
+
+// startFinalizeSpan traces the time from EOS until the pipeline has flushed. Called with the lock held
+func (p *Pipeline) startFinalizeSpan() {
+	if p.activeSpan != nil {
+		p.activeSpan.End()
+		p.activeSpan = nil
+	}
+	if p.traceCtx != nil {
+		_, p.finalizeSpan = tracer.Start(p.traceCtx, "Pipeline.finalize")
+	}
+}
+
+// endPhaseSpans is called once the main loop has ended
+func (p *Pipeline) endPhaseSpans() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.activeSpan != nil {
+		// stopped without EOS
+		p.activeSpan.End()
+		p.activeSpan = nil
+	}
+	if p.finalizeSpan != nil {
+		p.finalizeSpan.End()
+		p.finalizeSpan = nil
+	}
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+)
+
+// OnProgress is called each time the watchdog finds that more bytes have been written
+func (p *Pipeline) OnProgress(f func()) {
+	p.onProgress = f
+}
+
+// BytesWritten returns the number of encoded bytes written at the last watchdog sample
+func (p *Pipeline) BytesWritten() int64 {
+	return p.bytesWritten.Load()
+}
+
+// FrameStats returns the number of video frames dropped, and the most frames seen waiting to be encoded
+func (p *Pipeline) FrameStats() (dropped int64, maxQueueDepth int) {
+	return p.framesDropped.Load(), int(p.maxQueueDepth.Load())
+}
+
+func (p *Pipeline) sampleFrameStats() {
+	dropped, queued := p.in.FrameStats()
+	p.framesDropped.Store(dropped)
+	if int64(queued) > p.maxQueueDepth.Load() {
+		p.maxQueueDepth.Store(int64(queued))
+	}
+}
+
+// watchProgress samples frame stats and the bytes written, and fails room composite and web egress once nothing has been
+// written for the stall timeout. Track output stops while its tracks are muted, so it is only sampled
+func (p *Pipeline) watchProgress() {
+	var checkStalls bool
+	switch p.Info.Request.(type) {
+	case *livekit.EgressInfo_RoomComposite, *livekit.EgressInfo_Web:
+		checkStalls = true
+	}
+
+	ticker := time.NewTicker(p.watchdogConf.Interval)
+	defer ticker.Stop()
+
+	lastProgress := time.Now()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			p.sampleFrameStats()
+			if written := p.in.BytesOut(); written != p.bytesWritten.Load() {
+				p.bytesWritten.Store(written)
+				lastProgress = time.Now()
+				if p.onProgress != nil {
+					p.onProgress()
+				}
+				continue
+			}
+
+			if !checkStalls || !p.expectingOutput() {
+				lastProgress = time.Now()
+				continue
+			}
+
+			if stalledFor := time.Since(lastProgress); stalledFor >= p.watchdogConf.StallTimeout {
+				p.Logger.Errorw("pipeline stalled", errors.ErrPipelineStalled,
+					"stalledFor", stalledFor,
+					"bytesWritten", p.bytesWritten.Load(),
+					"debugDump", p.debugDump(dumpStalled),
+				)
+				p.setError(errors.ErrPipelineStalled)
+				p.stop()
+				return
+			}
+		}
+	}
+}
+
+// expectingOutput returns true while the egress is active and not paused
+func (p *Pipeline) expectingOutput() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.Info.Status == livekit.EgressStatus_EGRESS_ACTIVE && !p.paused
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	// the service writes the handler's share of the upload bandwidth limit to this file descriptor, which it passes
+	// in as ExtraFiles[1]
+	bandwidthFd = 4
+	// set by the service when launching a handler with a bandwidth pipe
+	bandwidthEnv = "EGRESS_BANDWIDTH_FD"
+
+	bandwidthReportInterval = time.Second
+)
+
+// bandwidthAllocator shares the node's upload bandwidth limit between handlers, which upload in their own
+// processes. Handlers which are uploading split the limit evenly, and idle handlers hold the share they would have
+// if they started, so that a new upload only goes over the limit until the next rebalance
+type bandwidthAllocator struct {
+	mu           sync.Mutex
+	limit        int64
+	handlers     map[string]*bandwidthShare
+	onThroughput func(bytesPerSecond int64)
+}
+
+type bandwidthShare struct {
+	w     io.Writer
+	rate  int64 // bytes per second, as last reported by the handler
+	share int64 // last sent, -1 until sent
+}
+
+func newBandwidthAllocator(limit int64, onThroughput func(bytesPerSecond int64)) *bandwidthAllocator {
+	return &bandwidthAllocator{
+		limit:        limit,
+		handlers:     make(map[string]*bandwidthShare),
+		onThroughput: onThroughput,
+	}
+}
+
+func (a *bandwidthAllocator) add(egressID string, w io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.handlers[egressID] = &bandwidthShare{w: w, share: -1}
+	a.rebalance()
+}
+
+func (a *bandwidthAllocator) remove(egressID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if h, ok := a.handlers[egressID]; ok {
+		delete(a.handlers, egressID)
+		if h.rate > 0 {
+			a.rebalance()
+			a.reportThroughput()
+		}
+	}
+}
+
+// report records how fast a handler is uploading, which is 0 once it stops
+func (a *bandwidthAllocator) report(egressID string, bytesPerSecond int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	h, ok := a.handlers[egressID]
+	if !ok {
+		return
+	}
+	uploading := h.rate > 0
+	h.rate = bytesPerSecond
+	if uploading != (bytesPerSecond > 0) {
+		a.rebalance()
+	}
+	a.reportThroughput()
+}
+
+// setLimit changes the limit for running handlers and those launched later
+func (a *bandwidthAllocator) setLimit(limit int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.limit = limit
+	a.rebalance()
+}
+
+// rebalance sends each handler its share if it has changed. Called with the lock held
+func (a *bandwidthAllocator) rebalance() {
+	uploading := int64(0)
+	for _, h := range a.handlers {
+		if h.rate > 0 {
+			uploading++
+		}
+	}
+
+	for egressID, h := range a.handlers {
+		var share int64
+		if a.limit > 0 {
+			if h.rate > 0 {
+				share = a.limit / uploading
+			} else {
+				share = a.limit / (uploading + 1)
+			}
+			if share < 1 {
+				share = 1
+			}
+		}
+		if share == h.share {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(h.w, "%d\n", share); err != nil {
+			// the handler has exited
+			logger.Debugw("could not send bandwidth share", "egressID", egressID, "error", err)
+			continue
+		}
+		h.share = share
+	}
+}
+
+// reportThroughput is called with the lock held
+func (a *bandwidthAllocator) reportThroughput() {
+	if a.onThroughput == nil {
+		return
+	}
+	total := int64(0)
+	for _, h := range a.handlers {
+		total += h.rate
+	}
+	a.onThroughput(total)
+}
+
+// readBandwidthShares applies the shares of the bandwidth limit sent by the service, until the service closes the
+// pipe. Handlers which were not launched by the service keep the limit from their config
+func readBandwidthShares() {
+	if os.Getenv(bandwidthEnv) == "" {
+		return
+	}
+	f := os.NewFile(bandwidthFd, "bandwidth")
+	if f == nil {
+		return
+	}
+	if _, err := f.Stat(); err != nil {
+		logger.Errorw("bandwidth pipe unavailable", err)
+		return
+	}
+
+	// keep chrome and other children from holding the pipe open
+	syscall.CloseOnExec(bandwidthFd)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		share, err := strconv.ParseInt(scanner.Text(), 10, 64)
+		if err != nil {
+			logger.Errorw("failed to read bandwidth share", err)
+			continue
+		}
+		uploader.SetBandwidthLimit(share)
+	}
+}
+
+// reportUploadRate sends the handler's upload throughput to the service each interval while it is uploading, and
+// once more when it stops
+func (h *Handler) reportUploadRate(done chan struct{}) {
+	ticker := time.NewTicker(bandwidthReportInterval)
+	defer ticker.Stop()
+
+	sent := uploader.BytesSent()
+	uploading := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		total := uploader.BytesSent()
+		rate := int64(float64(total-sent) / bandwidthReportInterval.Seconds())
+		sent = total
+		if rate > 0 || uploading {
+			h.updates.writeUploadRate(rate)
+		}
+		uploading = rate > 0
+	}
+}
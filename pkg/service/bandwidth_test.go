@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// lastShare returns the last share written to a handler's pipe
+func lastShare(t *testing.T, buf *bytes.Buffer) string {
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.NotEmpty(t, lines)
+	return lines[len(lines)-1]
+}
+
+func TestBandwidthAllocator(t *testing.T) {
+	var throughput int64
+	a := newBandwidthAllocator(900, func(bytesPerSecond int64) { throughput = bytesPerSecond })
+
+	first, second, third := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	a.add("EG_1", first)
+	a.add("EG_2", second)
+	require.Equal(t, "900", lastShare(t, first), "idle handlers could start with the whole limit")
+	require.Equal(t, "900", lastShare(t, second))
+
+	a.report("EG_1", 800)
+	require.Equal(t, "900", lastShare(t, first))
+	require.Equal(t, "450", lastShare(t, second))
+	require.Equal(t, int64(800), throughput)
+
+	a.add("EG_3", third)
+	a.report("EG_2", 400)
+	require.Equal(t, "450", lastShare(t, first))
+	require.Equal(t, "450", lastShare(t, second))
+	require.Equal(t, "300", lastShare(t, third))
+	require.Equal(t, int64(1200), throughput)
+
+	// shares are only sent when they change
+	writes := first.Len()
+	a.report("EG_1", 500)
+	require.Equal(t, writes, first.Len())
+
+	a.setLimit(600)
+	require.Equal(t, "300", lastShare(t, first))
+	require.Equal(t, "200", lastShare(t, third))
+
+	a.remove("EG_2")
+	require.Equal(t, "600", lastShare(t, first))
+	require.Equal(t, "300", lastShare(t, third))
+	require.Equal(t, int64(500), throughput)
+
+	a.report("EG_1", 0)
+	require.Equal(t, "600", lastShare(t, third))
+	require.Equal(t, int64(0), throughput)
+
+	// unlimited
+	a.setLimit(0)
+	require.Equal(t, "0", lastShare(t, first))
+	require.Equal(t, "0", lastShare(t, third))
+
+	// reports from handlers which have exited are ignored
+	a.report("EG_2", 100)
+	require.Equal(t, int64(0), throughput)
+}
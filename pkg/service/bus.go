@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+// same as redis pubsub
+const localBusChannelSize = 100
+
+// localBus is a utils.MessageBus for a node without redis. It carries messages between the service, its handlers
+// over the handler socket, and grpc clients.
+type localBus struct {
+	mu     sync.Mutex
+	subs   map[string]map[*localPubSub]struct{}
+	queues map[string][]*localPubSub
+	next   map[string]int // the queue subscriber to get the next message
+}
+
+type localPubSub struct {
+	bus     *localBus
+	channel string
+	queue   bool
+	c       chan interface{}
+
+	once sync.Once
+}
+
+func newLocalBus() *localBus {
+	return &localBus{
+		subs:   make(map[string]map[*localPubSub]struct{}),
+		queues: make(map[string][]*localPubSub),
+		next:   make(map[string]int),
+	}
+}
+
+func (b *localBus) Subscribe(_ context.Context, channel string) (utils.PubSub, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ps := &localPubSub{bus: b, channel: channel, c: make(chan interface{}, localBusChannelSize)}
+	if b.subs[channel] == nil {
+		b.subs[channel] = make(map[*localPubSub]struct{})
+	}
+	b.subs[channel][ps] = struct{}{}
+	return ps, nil
+}
+
+// SubscribeQueue subscribes to messages which are each sent to one queue subscriber, in turn
+func (b *localBus) SubscribeQueue(_ context.Context, channel string) (utils.PubSub, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ps := &localPubSub{bus: b, channel: channel, queue: true, c: make(chan interface{}, localBusChannelSize)}
+	b.queues[channel] = append(b.queues[channel], ps)
+	return ps, nil
+}
+
+func (b *localBus) Publish(_ context.Context, channel string, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	b.publish(channel, payload)
+	return nil
+}
+
+func (b *localBus) publish(channel string, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ps := range b.subs[channel] {
+		ps.send(payload)
+	}
+	if queue := b.queues[channel]; len(queue) > 0 {
+		i := b.next[channel] % len(queue)
+		b.next[channel] = i + 1
+		queue[i].send(payload)
+	}
+}
+
+// send never blocks, since it's called with the bus lock held
+func (ps *localPubSub) send(payload []byte) {
+	select {
+	case ps.c <- payload:
+	default:
+		// a subscriber which isn't reading can't hold up the rest
+		logger.Warnw("subscriber full, dropping message", nil, "channel", ps.channel)
+	}
+}
+
+func (ps *localPubSub) Channel() <-chan interface{} {
+	return ps.c
+}
+
+func (ps *localPubSub) Payload(msg interface{}) []byte {
+	return msg.([]byte)
+}
+
+func (ps *localPubSub) Close() error {
+	ps.once.Do(func() {
+		b := ps.bus
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if ps.queue {
+			queue := b.queues[ps.channel]
+			for i, sub := range queue {
+				if sub == ps {
+					b.queues[ps.channel] = append(queue[:i], queue[i+1:]...)
+					break
+				}
+			}
+			if len(b.queues[ps.channel]) == 0 {
+				delete(b.queues, ps.channel)
+				delete(b.next, ps.channel)
+			}
+		} else {
+			delete(b.subs[ps.channel], ps)
+			if len(b.subs[ps.channel]) == 0 {
+				delete(b.subs, ps.channel)
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/egress"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+// accepted requests remembered at once, the oldest are forgotten first
+const dedupeMaxEntries = 10000
+
+// requestDedupe remembers the start requests this node accepted, so that a retried request is answered with the
+// egress the first one started
+type requestDedupe struct {
+	window     time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*dedupeEntry
+	order   []*dedupeEntry // by claim time, for eviction
+}
+
+type dedupeEntry struct {
+	egressID string
+	expires  time.Time
+	ready    chan struct{} // closed once the request is accepted or released
+	info     *livekit.EgressInfo
+}
+
+func newRequestDedupe(window time.Duration, maxEntries int) *requestDedupe {
+	return &requestDedupe{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*dedupeEntry),
+	}
+}
+
+// claim returns true if egressID has not been claimed within the window, in which case the caller must accept or
+// release it. Otherwise, the entry returned is the first request's
+func (d *requestDedupe) claim(egressID string) (*dedupeEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.evict(now)
+	if e := d.entries[egressID]; e != nil {
+		return e, false
+	}
+
+	e := &dedupeEntry{
+		egressID: egressID,
+		expires:  now.Add(d.window),
+		ready:    make(chan struct{}),
+	}
+	d.entries[egressID] = e
+	d.order = append(d.order, e)
+	return e, true
+}
+
+// evict forgets expired entries, and the oldest entries over the limit
+func (d *requestDedupe) evict(now time.Time) {
+	i := 0
+	for ; i < len(d.order); i++ {
+		e := d.order[i]
+		if now.Before(e.expires) && len(d.order)-i < d.maxEntries {
+			break
+		}
+		if d.entries[e.egressID] == e {
+			delete(d.entries, e.egressID)
+		}
+	}
+	d.order = d.order[i:]
+}
+
+// accept stores the info of a claimed egress, which duplicate requests are answered with
+func (d *requestDedupe) accept(info *livekit.EgressInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e := d.entries[info.EgressId]; e != nil && e.info == nil {
+		e.info = info
+		close(e.ready)
+	}
+}
+
+// update replaces the stored info of an accepted egress
+func (d *requestDedupe) update(info *livekit.EgressInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e := d.entries[info.EgressId]; e != nil && e.info != nil {
+		e.info = info
+	}
+}
+
+// release forgets a claim whose request was not accepted, so that a retry is handled as a new request
+func (d *requestDedupe) release(egressID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e := d.entries[egressID]; e != nil && e.info == nil {
+		delete(d.entries, egressID)
+		close(e.ready)
+	}
+}
+
+// wait returns the info of the first request once it is accepted, or nil if it was released or timeout passed
+func (d *requestDedupe) wait(e *dedupeEntry, timeout time.Duration) *livekit.EgressInfo {
+	select {
+	case <-e.ready:
+	case <-time.After(timeout):
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return e.info
+}
+
+// claimEgress claims an accepted request in redis, if configured, returning false if another node started it
+func (s *Service) claimEgress(ctx context.Context, req *livekit.StartEgressRequest) bool {
+	if !s.conf.Dedupe.Redis || s.state == nil {
+		return true
+	}
+
+	claimed, err := s.state.ClaimEgress(ctx, req.EgressId, s.conf.Dedupe.Window)
+	if err != nil {
+		// starting it twice is better than not starting it
+		logger.Warnw("could not claim egress", err, "egressID", req.EgressId)
+		return true
+	}
+	if !claimed {
+		logger.Infow("egress already started by another node", "egressID", req.EgressId, "requestID", req.RequestId)
+	}
+	return claimed
+}
+
+// sendDuplicateResponse answers a retried request with the egress the first request started. The first request
+// may still be validating, in which case its response is waited for
+func (s *Service) sendDuplicateResponse(ctx context.Context, req *livekit.StartEgressRequest, e *dedupeEntry) {
+	info := s.dedupe.wait(e, egress.RequestExpiration)
+	if info == nil {
+		// the first request was not accepted here, so neither is the retry
+		return
+	}
+	if p, ok := s.processes.Load(req.EgressId); ok {
+		info = p.(*process).egressInfo()
+	}
+
+	logger.Infow("duplicate request, returning existing egress",
+		"egressID", req.EgressId,
+		"requestID", req.RequestId,
+		"senderID", req.SenderId,
+	)
+	s.sendResponse(ctx, req, info, nil)
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestRequestDedupe(t *testing.T) {
+	d := newRequestDedupe(time.Minute, 10)
+
+	first, claimed := d.claim("EG_1")
+	require.True(t, claimed)
+	d.accept(&livekit.EgressInfo{EgressId: "EG_1", Status: livekit.EgressStatus_EGRESS_STARTING})
+
+	// a retry gets the first request's egress, as last updated
+	d.update(&livekit.EgressInfo{EgressId: "EG_1", Status: livekit.EgressStatus_EGRESS_ACTIVE})
+	e, claimed := d.claim("EG_1")
+	require.False(t, claimed)
+	require.Equal(t, first, e)
+	require.Equal(t, livekit.EgressStatus_EGRESS_ACTIVE, d.wait(e, time.Second).Status)
+
+	// a rejected request can be retried
+	_, claimed = d.claim("EG_2")
+	require.True(t, claimed)
+	d.release("EG_2")
+	_, claimed = d.claim("EG_2")
+	require.True(t, claimed)
+}
+
+func TestRequestDedupeEviction(t *testing.T) {
+	d := newRequestDedupe(time.Millisecond*50, 3)
+
+	_, claimed := d.claim("EG_1")
+	require.True(t, claimed)
+	time.Sleep(time.Millisecond * 100)
+	_, claimed = d.claim("EG_1")
+	require.True(t, claimed, "expired requests are forgotten")
+
+	// the oldest are forgotten once full
+	for _, egressID := range []string{"EG_2", "EG_3", "EG_4"} {
+		_, claimed = d.claim(egressID)
+		require.True(t, claimed)
+	}
+	_, claimed = d.claim("EG_1")
+	require.True(t, claimed)
+	_, claimed = d.claim("EG_4")
+	require.False(t, claimed)
+	require.LessOrEqual(t, len(d.order), 3)
+}
+
+func TestRequestDedupeConcurrent(t *testing.T) {
+	d := newRequestDedupe(time.Minute, 10)
+
+	const requests = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+	infos := make([]*livekit.EgressInfo, 0, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, claimed := d.claim("EG_concurrent")
+			info := &livekit.EgressInfo{EgressId: "EG_concurrent"}
+			if claimed {
+				// the duplicates wait while the first request validates
+				time.Sleep(time.Millisecond * 50)
+				d.accept(info)
+			} else {
+				info = d.wait(e, time.Second)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if claimed {
+				winners++
+			}
+			infos = append(infos, info)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, winners)
+	require.Len(t, infos, requests)
+	for _, info := range infos {
+		require.Same(t, infos[0], info)
+	}
+}
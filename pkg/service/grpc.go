@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/egress"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+const (
+	egressServiceName = "livekit.egress.Egress"
+
+	startEgressMethod  = "StartEgress"
+	updateStreamMethod = "UpdateStream"
+	stopEgressMethod   = "StopEgress"
+	updatesStream      = "Updates"
+)
+
+// egressServiceDesc describes the grpc api, which takes the same requests as the protocol's egress rpc. Responses
+// are egress info, and Updates streams every egress info update until the client disconnects
+var egressServiceDesc = grpc.ServiceDesc{
+	ServiceName: egressServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(egressServiceName, startEgressMethod,
+			func() proto.Message { return &livekit.StartEgressRequest{} },
+			func(srv interface{}, ctx context.Context, req proto.Message) (proto.Message, error) {
+				return srv.(*grpcServer).sendRequest(ctx, req)
+			}),
+		unaryMethod(egressServiceName, updateStreamMethod,
+			func() proto.Message { return &livekit.UpdateStreamRequest{} },
+			func(srv interface{}, ctx context.Context, req proto.Message) (proto.Message, error) {
+				r := req.(*livekit.UpdateStreamRequest)
+				return srv.(*grpcServer).sendRequest(ctx, &livekit.EgressRequest{
+					EgressId: r.EgressId,
+					Request:  &livekit.EgressRequest_UpdateStream{UpdateStream: r},
+				})
+			}),
+		unaryMethod(egressServiceName, stopEgressMethod,
+			func() proto.Message { return &livekit.StopEgressRequest{} },
+			func(srv interface{}, ctx context.Context, req proto.Message) (proto.Message, error) {
+				r := req.(*livekit.StopEgressRequest)
+				return srv.(*grpcServer).sendRequest(ctx, &livekit.EgressRequest{
+					EgressId: r.EgressId,
+					Request:  &livekit.EgressRequest_Stop{Stop: r},
+				})
+			}),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName: updatesStream,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(*grpcServer).streamUpdates(stream)
+		},
+		ServerStreams: true,
+	}},
+}
+
+// unaryMethod describes a grpc method without generated code, since the api only uses the protocol's messages
+func unaryMethod(
+	serviceName, methodName string,
+	newRequest func() proto.Message,
+	handle func(srv interface{}, ctx context.Context, req proto.Message) (proto.Message, error),
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: methodName,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return handle(srv, ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + serviceName + "/" + methodName,
+			}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return handle(srv, ctx, req.(proto.Message))
+			})
+		},
+	}
+}
+
+// grpcServer passes requests from grpc clients to the service over the local bus
+type grpcServer struct {
+	rpc *busRPC
+	bus utils.MessageBus
+}
+
+// startGRPCServer listens for grpc clients, with tls and client certificates if configured
+func startGRPCServer(conf *config.GRPCConfig, bus utils.MessageBus) (func(), error) {
+	tlsConfig, err := conf.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infow("grpc server listening", "address", conf.Address, "tls", tlsConfig != nil)
+	return serveGRPC(listener, tlsConfig, bus), nil
+}
+
+func serveGRPC(listener net.Listener, tlsConfig *tls.Config, bus utils.MessageBus) func() {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&egressServiceDesc, &grpcServer{
+		rpc: newBusRPC(bus),
+		bus: bus,
+	})
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Errorw("grpc server failed", err)
+		}
+	}()
+
+	// update streams only end when their clients disconnect, so they aren't waited for
+	return server.Stop
+}
+
+func (s *grpcServer) sendRequest(ctx context.Context, req proto.Message) (proto.Message, error) {
+	info, err := s.rpc.SendRequest(ctx, req)
+	if err != nil {
+		if errors.Is(err, egress.ErrNoResponse) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		// the message starts with the error code, as it would over redis
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	return info, nil
+}
+
+func (s *grpcServer) streamUpdates(stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		return err
+	}
+
+	sub, err := s.bus.Subscribe(stream.Context(), updateChannel)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	// the header tells the client it is subscribed
+	if err = stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg := <-sub.Channel():
+			info := &livekit.EgressInfo{}
+			if err = proto.Unmarshal(sub.Payload(msg), info); err != nil {
+				logger.Errorw("failed to read update", err)
+				continue
+			}
+			if err = stream.SendMsg(info); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type grpcClient struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewGRPCClient sends requests to an egress service over grpc, in place of egress.NewRedisRPCClient
+func NewGRPCClient(conn grpc.ClientConnInterface) egress.RPCClient {
+	return &grpcClient{conn: conn}
+}
+
+func (c *grpcClient) SendRequest(ctx context.Context, request proto.Message) (*livekit.EgressInfo, error) {
+	var method string
+	switch req := request.(type) {
+	case *livekit.StartEgressRequest:
+		method = startEgressMethod
+
+	case *livekit.EgressRequest:
+		switch r := req.Request.(type) {
+		case *livekit.EgressRequest_UpdateStream:
+			method, request = updateStreamMethod, r.UpdateStream
+		case *livekit.EgressRequest_Stop:
+			method, request = stopEgressMethod, r.Stop
+		default:
+			return nil, errors.New("invalid request type")
+		}
+
+	default:
+		return nil, errors.New("invalid request type")
+	}
+
+	info := &livekit.EgressInfo{}
+	if err := c.conn.Invoke(ctx, "/"+egressServiceName+"/"+method, request, info); err != nil {
+		// the same errors as the protocol's redis client
+		s := status.Convert(err)
+		if s.Code() == codes.Unavailable && s.Message() == egress.ErrNoResponse.Error() {
+			return nil, egress.ErrNoResponse
+		}
+		return nil, errors.New(s.Message())
+	}
+	return info, nil
+}
+
+func (c *grpcClient) GetUpdateChannel(ctx context.Context) (utils.PubSub, error) {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := c.conn.NewStream(streamCtx, &egressServiceDesc.Streams[0], "/"+egressServiceName+"/"+updatesStream)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return newGRPCPubSub(ctx, stream, cancel, &emptypb.Empty{}, func() ([]byte, error) {
+		info := &livekit.EgressInfo{}
+		if err := stream.RecvMsg(info); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(info)
+	})
+}
+
+// grpcPubSub reads messages from a grpc stream until it is closed. Like redis subscriptions, it outlives the
+// context it was created with. The channel is closed once the stream ends, so that subscribers don't wait forever
+// on a lost connection
+type grpcPubSub struct {
+	c      chan interface{}
+	cancel func()
+}
+
+func newGRPCPubSub(ctx context.Context, stream grpc.ClientStream, cancel func(), req proto.Message, recv func() ([]byte, error)) (*grpcPubSub, error) {
+	if err := stream.SendMsg(req); err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// wait until the server has subscribed
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := stream.Header()
+		subscribed <- err
+	}()
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+
+	ps := &grpcPubSub{
+		c:      make(chan interface{}, localBusChannelSize),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(ps.c)
+		for {
+			payload, err := recv()
+			if err != nil {
+				if status.Code(err) != codes.Canceled {
+					logger.Warnw("grpc subscription ended", err)
+				}
+				return
+			}
+			select {
+			case ps.c <- payload:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+	return ps, nil
+}
+
+func (ps *grpcPubSub) Channel() <-chan interface{} {
+	return ps.c
+}
+
+func (ps *grpcPubSub) Payload(msg interface{}) []byte {
+	return msg.([]byte)
+}
+
+func (ps *grpcPubSub) Close() error {
+	ps.cancel()
+	return nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/egress"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/utils"
+)
+
+func receive(t *testing.T, sub utils.PubSub, msg proto.Message) {
+	select {
+	case raw := <-sub.Channel():
+		require.NoError(t, proto.Unmarshal(sub.Payload(raw), msg))
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestGRPCTransport(t *testing.T) {
+	ctx := context.Background()
+	bus := newLocalBus()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer serveGRPC(listener, nil, bus)()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := NewGRPCClient(conn)
+
+	socket, stopSocket, err := startHandlerSocket("NE_grpc_test", bus)
+	require.NoError(t, err)
+	defer stopSocket()
+	handlerConn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer handlerConn.Close()
+	handlerBus := newBusClient(handlerConn)
+
+	// the service answers start requests on the local bus
+	serviceRPC := newBusRPC(bus)
+	requests, err := serviceRPC.GetRequestChannel(ctx)
+	require.NoError(t, err)
+	defer requests.Close()
+
+	updates, err := client.GetUpdateChannel(ctx)
+	require.NoError(t, err)
+	defer updates.Close()
+
+	started := make(chan *livekit.StartEgressRequest, 1)
+	go func() {
+		req := &livekit.StartEgressRequest{}
+		_ = proto.Unmarshal(requests.Payload(<-requests.Channel()), req)
+		started <- req
+		_ = serviceRPC.SendResponse(ctx, req, &livekit.EgressInfo{EgressId: req.EgressId}, nil)
+	}()
+	info, err := client.SendRequest(ctx, &livekit.StartEgressRequest{EgressId: "EG_grpc"})
+	require.NoError(t, err)
+	require.Equal(t, "EG_grpc", info.EgressId)
+	require.NotEmpty(t, (<-started).RequestId)
+
+	// the handler answers requests for its egress, and its updates reach grpc clients
+	handlerRPC := newBusRPC(handlerBus)
+	egressRequests, err := handlerRPC.EgressSubscription(ctx, "EG_grpc")
+	require.NoError(t, err)
+	defer egressRequests.Close()
+
+	errNotActive := errors.New("[invalid_request] egress not active")
+	go func() {
+		req := &livekit.EgressRequest{}
+		_ = proto.Unmarshal(egressRequests.Payload(<-egressRequests.Channel()), req)
+		_ = handlerRPC.SendUpdate(ctx, &livekit.EgressInfo{EgressId: "EG_grpc", Status: livekit.EgressStatus_EGRESS_COMPLETE})
+		_ = handlerRPC.SendResponse(ctx, req, nil, errNotActive)
+	}()
+	_, err = client.SendRequest(ctx, &livekit.EgressRequest{
+		EgressId: "EG_grpc",
+		Request:  &livekit.EgressRequest_Stop{Stop: &livekit.StopEgressRequest{EgressId: "EG_grpc"}},
+	})
+	require.EqualError(t, err, errNotActive.Error())
+
+	update := &livekit.EgressInfo{}
+	receive(t, updates, update)
+	require.Equal(t, livekit.EgressStatus_EGRESS_COMPLETE, update.Status)
+
+	// requests nobody answers time out as they would over redis
+	_, err = client.SendRequest(ctx, &livekit.EgressRequest{
+		EgressId: "EG_missing",
+		Request:  &livekit.EgressRequest_Stop{Stop: &livekit.StopEgressRequest{EgressId: "EG_missing"}},
+	})
+	require.ErrorIs(t, err, egress.ErrNoResponse)
+}
+
+// Subscribers ranging over a grpc subscription are released once the stream ends, as a closed redis
+// subscription would release them
+func TestGRPCSubscriptionClosed(t *testing.T) {
+	ctx := context.Background()
+	bus := newLocalBus()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	stopServer := serveGRPC(listener, nil, bus)
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	socket, stopSocket, err := startHandlerSocket("NE_grpc_closed", bus)
+	require.NoError(t, err)
+	handlerConn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer handlerConn.Close()
+
+	updates, err := NewGRPCClient(conn).GetUpdateChannel(ctx)
+	require.NoError(t, err)
+	defer updates.Close()
+	controls, err := newBusClient(handlerConn).Subscribe(ctx, controlChannelBase+"EG_grpc")
+	require.NoError(t, err)
+	defer controls.Close()
+
+	done := make(chan struct{}, 2)
+	for _, sub := range []utils.PubSub{updates, controls} {
+		go func(sub utils.PubSub) {
+			for range sub.Channel() {
+			}
+			done <- struct{}{}
+		}(sub)
+	}
+
+	stopServer()
+	stopSocket()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Fatal("subscription not closed")
+		}
+	}
+}
@@ -2,29 +2,45 @@ package service
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"go.uber.org/atomic"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/pipeline"
 	"github.com/livekit/egress/pkg/pipeline/params"
-	"github.com/livekit/protocol/egress"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/tracer"
+	"github.com/livekit/protocol/utils"
 )
 
 type Handler struct {
 	conf      *config.Config
-	rpcServer egress.RPCServer
+	rpcServer RPCServer
+	updates   *updateWriter
+	logger    logger.Logger
+	paused    atomic.Bool
 	kill      chan struct{}
+
+	// set once the pipeline is built. Stop requests can arrive before then
+	mu          sync.Mutex
+	pipeline    *pipeline.Pipeline
+	stopped     bool
+	cancelBuild context.CancelFunc
+	carried     handlerUpdate // counters of pipelines which were restarted
 }
 
-func NewHandler(conf *config.Config, rpcServer egress.RPCServer) *Handler {
+func NewHandler(conf *config.Config, rpcServer RPCServer) *Handler {
 	return &Handler{
 		conf:      conf,
 		rpcServer: rpcServer,
+		updates:   newUpdateWriter(),
+		logger:    logger.GetDefaultLogger(),
 		kill:      make(chan struct{}),
 	}
 }
@@ -33,30 +49,62 @@ func (h *Handler) HandleRequest(ctx context.Context, req *livekit.StartEgressReq
 	ctx, span := tracer.Start(ctx, "Handler.HandleRequest")
 	defer span.End()
 
-	p, err := h.buildPipeline(ctx, req)
+	h.logger = logger.Logger(logger.GetLogger().WithValues(params.LogValues(req)...))
+
+	// subscribe to request channel before building the pipeline, so that stop requests sent while the egress
+	// is starting are not missed
+	requests, err := h.rpcServer.EgressSubscription(context.Background(), req.EgressId)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	defer func() {
+		err := requests.Close()
+		if err != nil {
+			h.logger.Errorw("failed to unsubscribe from request channel", err)
+		}
+	}()
+
+	// stop and update stream requests are handled on their own, so they are never held up by the start
+	done := make(chan struct{})
+	defer close(done)
+	go h.handleRequests(ctx, req, requests, done)
+
+	// uploads keep to the node's limit until the service sends this handler's share
+	uploader.SetBandwidthLimit(h.conf.Upload.BandwidthLimit)
+	go readBandwidthShares()
+	go h.reportUploadRate(done)
+
+	buildCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h.mu.Lock()
+	h.cancelBuild = cancel
+	h.mu.Unlock()
+
+	p, err := h.buildPipeline(buildCtx, req, nil)
 	if err != nil {
 		span.RecordError(err)
 		return
 	}
 
-	// subscribe to request channel
-	requests, err := h.rpcServer.EgressSubscription(context.Background(), p.GetInfo().EgressId)
+	// subscribe to pause/resume and scheduled stop requests
+	controls, err := h.rpcServer.ControlSubscription(context.Background(), p.GetInfo().EgressId)
 	if err != nil {
 		span.RecordError(err)
 		return
 	}
 	defer func() {
-		err := requests.Close()
+		err := controls.Close()
 		if err != nil {
-			logger.Errorw("failed to unsubscribe from request channel", err)
+			h.logger.Errorw("failed to unsubscribe from control channel", err)
 		}
 	}()
 
+	controlRequests := controls.Channel()
+
 	// start egress
 	result := make(chan *livekit.EgressInfo, 1)
-	go func() {
-		result <- p.Run(ctx)
-	}()
+	h.runPipeline(ctx, p, result)
 
 	for {
 		select {
@@ -65,35 +113,199 @@ func (h *Handler) HandleRequest(ctx context.Context, req *livekit.StartEgressReq
 			p.SendEOS(ctx)
 
 		case res := <-result:
+			if p.Restarting() {
+				// failed, but can be rebuilt
+				if p = h.restartPipeline(ctx, req, p); p == nil {
+					return
+				}
+				h.runPipeline(ctx, p, result)
+				continue
+			}
+
 			// recording finished
-			h.sendUpdate(ctx, res)
+			h.sendResult(ctx, res, p.GetError())
 			return
 
-		case msg := <-requests.Channel():
-			// request received
-			request := &livekit.EgressRequest{}
-			err = proto.Unmarshal(requests.Payload(msg), request)
+		case msg, ok := <-controlRequests:
+			if !ok {
+				// the egress carries on without pause, resume or scheduled stop requests
+				h.logger.Warnw("control subscription closed", nil)
+				controlRequests = nil
+				continue
+			}
+			// pause or resume request received
+			requestID, action, err := parseControlRequest(controls.Payload(msg))
 			if err != nil {
-				logger.Errorw("failed to read request", err, "egressID", p.GetInfo().EgressId)
+				h.logger.Errorw("failed to read control request", err)
+				continue
+			}
+			h.logger.Debugw("handling control request", "requestID", requestID, "action", action)
+
+			switch action {
+			case ActionPause:
+				err = p.Pause(ctx)
+			case ActionResume:
+				err = p.Resume(ctx)
+			case actionScheduledStop:
+				p.ScheduledStop(ctx)
+			default:
+				err = errors.ErrInvalidRPC
+			}
+
+			paused := p.IsPaused()
+			if err == nil && h.paused.Swap(paused) != paused {
+				h.sendUpdate(ctx, p.GetInfo())
+			}
+			h.sendControlResponse(ctx, requestID, p.GetInfo(), err)
+		}
+	}
+}
+
+// runPipeline runs the pipeline until it sends its result, stopping it straight away if a stop request arrived
+// while it was built
+func (h *Handler) runPipeline(ctx context.Context, p *pipeline.Pipeline, result chan *livekit.EgressInfo) {
+	stopped := h.setPipeline(p)
+	go func() {
+		result <- p.Run(ctx)
+	}()
+	if stopped {
+		p.SendEOS(ctx)
+	}
+}
+
+// restartPipeline rebuilds a pipeline which failed, once the restart delay has passed. It returns nil if the
+// egress ends instead, having sent its result
+func (h *Handler) restartPipeline(ctx context.Context, req *livekit.StartEgressRequest, prev *pipeline.Pipeline) *pipeline.Pipeline {
+	h.logger.Warnw("pipeline failed, restarting", prev.GetError(),
+		"restart", prev.Restarts+1,
+		"maxRestarts", prev.MaxRestarts,
+		"delay", h.conf.PipelineRestart.Delay,
+	)
+
+	// counters carry on from the failed pipeline, which requests can no longer reach
+	h.mu.Lock()
+	carried := h.carried
+	h.mu.Unlock()
+	carried = addPipelineState(carried, prev)
+	carried.PipelineRestarts++
+	h.mu.Lock()
+	h.carried = carried
+	h.pipeline = nil
+	h.mu.Unlock()
+	h.paused.Store(false)
+
+	select {
+	case <-time.After(h.conf.PipelineRestart.Delay):
+	case <-h.kill:
+	}
+	if h.killed() || h.isStopped() {
+		h.sendResult(ctx, prev.GetInfo(), prev.GetError())
+		return nil
+	}
+
+	buildCtx, cancel := context.WithCancel(ctx)
+	h.mu.Lock()
+	h.cancelBuild = cancel
+	h.mu.Unlock()
+
+	p, err := h.buildPipeline(buildCtx, req, prev)
+	if err != nil {
+		return nil
+	}
+	h.updates.write(p.GetInfo(), h.state(), nil)
+	return p
+}
+
+// handleRequests handles stop and update stream requests until done is closed. A stop request received while
+// the pipeline is built cancels the build, and stops the pipeline once it's ready
+func (h *Handler) handleRequests(ctx context.Context, req *livekit.StartEgressRequest, requests utils.PubSub, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+
+		case msg, ok := <-requests.Channel():
+			if !ok {
+				return
+			}
+
+			request := &livekit.EgressRequest{}
+			if err := proto.Unmarshal(requests.Payload(msg), request); err != nil {
+				h.logger.Errorw("failed to read request", err)
 				continue
 			}
-			logger.Debugw("handling request", "egressID", p.GetInfo().EgressId, "requestID", request.RequestId)
+			h.logger.Debugw("handling request", "requestID", request.RequestId)
 
+			var err error
+			var p *pipeline.Pipeline
 			switch r := request.Request.(type) {
 			case *livekit.EgressRequest_UpdateStream:
-				err = p.UpdateStream(ctx, r.UpdateStream)
+				if p = h.getPipeline(); p == nil {
+					err = errors.ErrEgressNotActive
+				} else {
+					err = p.UpdateStream(ctx, r.UpdateStream)
+				}
 			case *livekit.EgressRequest_Stop:
-				p.SendEOS(ctx)
+				if p = h.stop(); p != nil {
+					p.SendEOS(ctx)
+				}
 			default:
+				p = h.getPipeline()
 				err = errors.ErrInvalidRPC
 			}
 
-			h.sendResponse(ctx, request, p.GetInfo(), err)
+			info := &livekit.EgressInfo{
+				EgressId: req.EgressId,
+				RoomId:   req.RoomId,
+				Status:   livekit.EgressStatus_EGRESS_STARTING,
+			}
+			if p != nil {
+				info = p.GetInfo()
+			}
+			h.sendResponse(ctx, request, info, err)
+		}
+	}
+}
+
+// stop records a stop request, returning the pipeline if it has been built
+func (h *Handler) stop() *pipeline.Pipeline {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.stopped {
+		h.stopped = true
+		if h.cancelBuild != nil {
+			h.cancelBuild()
 		}
 	}
+	return h.pipeline
 }
 
-func (h *Handler) buildPipeline(ctx context.Context, req *livekit.StartEgressRequest) (*pipeline.Pipeline, error) {
+// setPipeline makes the pipeline available to requests, returning true if it was stopped while being built
+func (h *Handler) setPipeline(p *pipeline.Pipeline) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pipeline = p
+	return h.stopped
+}
+
+func (h *Handler) getPipeline() *pipeline.Pipeline {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.pipeline
+}
+
+func (h *Handler) isStopped() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.stopped
+}
+
+// buildPipeline builds the pipeline for the request, carrying on from prev if it's being restarted
+func (h *Handler) buildPipeline(ctx context.Context, req *livekit.StartEgressRequest, prev *pipeline.Pipeline) (*pipeline.Pipeline, error) {
 	ctx, span := tracer.Start(ctx, "Handler.buildPipeline")
 	defer span.End()
 
@@ -102,52 +314,162 @@ func (h *Handler) buildPipeline(ctx context.Context, req *livekit.StartEgressReq
 	var p *pipeline.Pipeline
 
 	if err == nil {
+		if prev != nil {
+			pipelineParams.ContinueFrom(prev.Params)
+		}
 		// create the pipeline
 		p, err = pipeline.New(ctx, h.conf, pipelineParams)
 	}
 
 	if err != nil {
 		info := pipelineParams.Info
-		info.Error = err.Error()
+		if prev != nil {
+			// keep what the egress had written
+			info = prev.GetInfo()
+		}
+		if h.isStopped() {
+			// the build was cancelled by a stop request
+			info.Status = livekit.EgressStatus_EGRESS_ABORTED
+			h.sendResult(ctx, info, nil)
+			return nil, err
+		}
+		info.Error = errors.FormatMessage(errors.Code(err), pipelineParams.RedactUrls(err.Error()))
 		info.Status = livekit.EgressStatus_EGRESS_FAILED
-		h.sendUpdate(ctx, info)
+		h.sendResult(ctx, info, err)
 		return nil, err
 	}
 
+	if prev != nil {
+		p.Continue(prev)
+	}
 	p.OnStatusUpdate(h.sendUpdate)
+	p.OnSegment(h.rpcServer.PublishSegment)
+	p.OnProgress(func() {
+		// only the service needs progress, for metrics
+		h.updates.write(p.GetInfo(), h.state(), nil)
+	})
 	return p, nil
 }
 
 func (h *Handler) sendUpdate(ctx context.Context, info *livekit.EgressInfo) {
+	state := h.state()
+	published := h.publishUpdate(ctx, info)
+	state.Published = &published
+	h.updates.write(info, state, nil)
+}
+
+// sendResult sends the final egress info, forwarding the failure category to the service
+func (h *Handler) sendResult(ctx context.Context, info *livekit.EgressInfo, err error) {
+	state := h.state()
+	state.Paused = false
+	published := h.publishUpdate(ctx, info)
+	state.Published = &published
+	h.updates.write(info, state, err)
+}
+
+// state returns the handler state which is forwarded with each update
+func (h *Handler) state() handlerUpdate {
+	h.mu.Lock()
+	p := h.pipeline
+	carried := h.carried
+	h.mu.Unlock()
+
+	state := addPipelineState(carried, p)
+	state.Paused = h.paused.Load()
+	return state
+}
+
+// addPipelineState adds the counters of p to those carried from pipelines which were restarted
+func addPipelineState(state handlerUpdate, p *pipeline.Pipeline) handlerUpdate {
+	if p == nil {
+		return state
+	}
+
+	reconnects := make(map[string]int)
+	for url, count := range state.StreamReconnects {
+		reconnects[url] = count
+	}
+	for url, count := range p.StreamReconnects() {
+		reconnects[url] += count
+	}
+	state.StreamReconnects = reconnects
+
+	uploadRetries, uploadFailures := p.UploadStats()
+	state.UploadRetries += uploadRetries
+	state.UploadFailures += uploadFailures
+	state.UploadedBytes, state.UploadSize = p.UploadProgress()
+	state.WebsocketDropped += p.WebsocketDroppedBytes()
+	state.LayerSwitches += p.VideoLayerSwitches()
+	lost, reordered, concealed := p.PacketStats()
+	state.PacketsLost += lost
+	state.PacketsReordered += reordered
+	state.PacketsConcealed += concealed
+	if state.FirstKeyFrame == 0 {
+		state.FirstKeyFrame = p.FirstKeyFrameDelay()
+	}
+	state.BytesWritten += p.BytesWritten()
+	framesDropped, maxQueueDepth := p.FrameStats()
+	state.FramesDropped += framesDropped
+	if maxQueueDepth > state.MaxQueueDepth {
+		state.MaxQueueDepth = maxQueueDepth
+	}
+	state.SegmentHookFails += p.SegmentHookFailures()
+	return state
+}
+
+// publishUpdate returns whether info was sent, for the service to replay it if not
+func (h *Handler) publishUpdate(ctx context.Context, info *livekit.EgressInfo) bool {
 	switch info.Status {
 	case livekit.EgressStatus_EGRESS_FAILED:
-		logger.Warnw("egress failed", errors.New(info.Error), "egressID", info.EgressId)
+		h.logger.Warnw("egress failed", errors.New(info.Error))
 	case livekit.EgressStatus_EGRESS_COMPLETE:
-		logger.Infow("egress completed", "egressID", info.EgressId)
+		h.logger.Infow("egress completed")
 	default:
-		logger.Infow("egress updated", "egressID", info.EgressId, "status", info.Status)
+		h.logger.Infow("egress updated", "status", info.Status)
 	}
 
 	if err := h.rpcServer.SendUpdate(ctx, info); err != nil {
-		logger.Errorw("failed to send update", err)
+		h.logger.Errorw("failed to send update", err)
+		return false
 	}
+	return true
 }
 
 func (h *Handler) sendResponse(ctx context.Context, req *livekit.EgressRequest, info *livekit.EgressInfo, err error) {
 	args := []interface{}{
-		"egressID", info.EgressId,
 		"requestID", req.RequestId,
 		"senderID", req.SenderId,
 	}
 
 	if err != nil {
-		logger.Warnw("request failed", err, args...)
+		h.logger.Warnw("request failed", err, args...)
 	} else {
-		logger.Debugw("request handled", args...)
+		h.logger.Debugw("request handled", args...)
 	}
 
 	if err := h.rpcServer.SendResponse(ctx, req, info, err); err != nil {
-		logger.Errorw("failed to send response", err, args...)
+		h.logger.Errorw("failed to send response", err, args...)
+	}
+}
+
+func (h *Handler) sendControlResponse(ctx context.Context, requestID string, info *livekit.EgressInfo, err error) {
+	if err != nil {
+		h.logger.Warnw("control request failed", err, "requestID", requestID)
+	} else {
+		h.logger.Debugw("control request handled", "requestID", requestID)
+	}
+
+	if err := h.rpcServer.SendControlResponse(ctx, requestID, info, err); err != nil {
+		h.logger.Errorw("failed to send response", err, "requestID", requestID)
+	}
+}
+
+func (h *Handler) killed() bool {
+	select {
+	case <-h.kill:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+const (
+	// set by the service when launching a handler with the grpc transport
+	handlerSocketEnv = "EGRESS_HANDLER_SOCKET"
+
+	busServiceName   = "livekit.egress.HandlerBus"
+	busPublishMethod = "Publish"
+	busSubscribe     = "Subscribe"
+
+	busChannelField = "channel"
+	busPayloadField = "payload"
+	busQueueField   = "queue"
+)
+
+// busServiceDesc gives handlers the service's local bus, over a unix socket which only the node can reach
+var busServiceDesc = grpc.ServiceDesc{
+	ServiceName: busServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(busServiceName, busPublishMethod,
+			func() proto.Message { return &structpb.Struct{} },
+			func(srv interface{}, _ context.Context, req proto.Message) (proto.Message, error) {
+				fields := req.(*structpb.Struct).GetFields()
+				payload, err := base64.StdEncoding.DecodeString(fields[busPayloadField].GetStringValue())
+				if err != nil {
+					return nil, err
+				}
+				srv.(*localBus).publish(fields[busChannelField].GetStringValue(), payload)
+				return &emptypb.Empty{}, nil
+			}),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName: busSubscribe,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return serveSubscription(srv.(*localBus), stream)
+		},
+		ServerStreams: true,
+	}},
+}
+
+func handlerSocketPath(nodeID string) string {
+	return path.Join(os.TempDir(), fmt.Sprintf("egress_%s.sock", nodeID))
+}
+
+// startHandlerSocket serves the bus to handlers, returning the socket's path
+func startHandlerSocket(nodeID string, bus *localBus) (string, func(), error) {
+	socket := handlerSocketPath(nodeID)
+	// left behind if the service was killed
+	_ = os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return "", nil, err
+	}
+
+	server := grpc.NewServer()
+	server.RegisterService(&busServiceDesc, bus)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Errorw("handler socket failed", err)
+		}
+	}()
+
+	return socket, server.Stop, nil
+}
+
+func serveSubscription(bus *localBus, stream grpc.ServerStream) error {
+	req := &structpb.Struct{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	fields := req.GetFields()
+	channel := fields[busChannelField].GetStringValue()
+	var sub utils.PubSub
+	var err error
+	if fields[busQueueField].GetBoolValue() {
+		sub, err = bus.SubscribeQueue(stream.Context(), channel)
+	} else {
+		sub, err = bus.Subscribe(stream.Context(), channel)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	// the header tells the handler it is subscribed
+	if err = stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg := <-sub.Channel():
+			if err = stream.SendMsg(&wrapperspb.BytesValue{Value: sub.Payload(msg)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// busClient is the message bus of a handler launched with the grpc transport
+type busClient struct {
+	conn grpc.ClientConnInterface
+}
+
+func newBusClient(conn grpc.ClientConnInterface) *busClient {
+	return &busClient{conn: conn}
+}
+
+func (c *busClient) Subscribe(ctx context.Context, channel string) (utils.PubSub, error) {
+	return c.subscribe(ctx, channel, false)
+}
+
+func (c *busClient) SubscribeQueue(ctx context.Context, channel string) (utils.PubSub, error) {
+	return c.subscribe(ctx, channel, true)
+}
+
+func (c *busClient) subscribe(ctx context.Context, channel string, queue bool) (utils.PubSub, error) {
+	req, err := structpb.NewStruct(map[string]interface{}{
+		busChannelField: channel,
+		busQueueField:   queue,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := c.conn.NewStream(streamCtx, &busServiceDesc.Streams[0], "/"+busServiceName+"/"+busSubscribe)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return newGRPCPubSub(ctx, stream, cancel, req, func() ([]byte, error) {
+		msg := &wrapperspb.BytesValue{}
+		if err := stream.RecvMsg(msg); err != nil {
+			return nil, err
+		}
+		return msg.Value, nil
+	})
+}
+
+func (c *busClient) Publish(ctx context.Context, channel string, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := structpb.NewStruct(map[string]interface{}{
+		busChannelField: channel,
+		busPayloadField: base64.StdEncoding.EncodeToString(payload),
+	})
+	if err != nil {
+		return err
+	}
+	return c.conn.Invoke(ctx, "/"+busServiceName+"/"+busPublishMethod, req, &emptypb.Empty{})
+}
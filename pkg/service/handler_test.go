@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+type testPubSub struct {
+	messages chan interface{}
+}
+
+func (t *testPubSub) Channel() <-chan interface{} {
+	return t.messages
+}
+
+func (t *testPubSub) Payload(msg interface{}) []byte {
+	return msg.([]byte)
+}
+
+func (t *testPubSub) Close() error {
+	return nil
+}
+
+type testRPCServer struct {
+	RPCServer
+	responses chan *livekit.EgressInfo
+}
+
+func (t *testRPCServer) SendResponse(_ context.Context, _ proto.Message, info *livekit.EgressInfo, _ error) error {
+	t.responses <- info
+	return nil
+}
+
+// A stop request is handled by the egress' handler, which never goes through the service's admission checks,
+// so it's acted on even when the node is rejecting every start request, and even before the pipeline is built
+func TestStopWhileStarting(t *testing.T) {
+	rpcServer := &testRPCServer{responses: make(chan *livekit.EgressInfo, 1)}
+	h := NewHandler(&config.Config{}, rpcServer)
+
+	buildCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.cancelBuild = cancel
+
+	requests := &testPubSub{messages: make(chan interface{}, 1)}
+	done := make(chan struct{})
+	defer close(done)
+
+	req := &livekit.StartEgressRequest{EgressId: "EG_test", RoomId: "RM_test"}
+	go h.handleRequests(context.Background(), req, requests, done)
+
+	b, err := proto.Marshal(&livekit.EgressRequest{
+		EgressId: req.EgressId,
+		Request:  &livekit.EgressRequest_Stop{Stop: &livekit.StopEgressRequest{EgressId: req.EgressId}},
+	})
+	require.NoError(t, err)
+	requests.messages <- b
+
+	select {
+	case info := <-rpcServer.responses:
+		require.Equal(t, req.EgressId, info.EgressId)
+	case <-time.After(time.Second):
+		t.Fatal("stop request not handled")
+	}
+
+	require.True(t, h.isStopped())
+	require.Error(t, buildCtx.Err())
+	require.True(t, h.setPipeline(nil))
+}
+
+func TestHandlerExitMessage(t *testing.T) {
+	require.Equal(t, "egress handler exited unexpectedly", handlerExitMessage(nil))
+
+	err := exec.Command("sh", "-c", "kill -SEGV $$").Run()
+	require.Equal(t, "egress handler exited unexpectedly (signal: segmentation fault)", handlerExitMessage(err))
+
+	err = exec.Command("sh", "-c", "exit 2").Run()
+	require.Equal(t, "egress handler exited unexpectedly (exit status 2)", handlerExitMessage(err))
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+// MetricsHandler serves the monitor's prometheus metrics, including per-egress metrics, behind metrics_auth if
+// it is configured
+func (s *Service) MetricsHandler() http.Handler {
+	return s.metrics
+}
+
+// DrainHandler drains the service on a POST to /drain on the health port, behind metrics_auth. Without
+// metrics_auth the endpoint is disabled, and the service can only be drained with SIGQUIT
+func (s *Service) DrainHandler() http.Handler {
+	return s.drain
+}
+
+func newDrainHandler(auth *config.MetricsAuthConfig, drain func()) http.Handler {
+	if auth == nil {
+		return http.NotFoundHandler()
+	}
+	return requireMetricsAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		drain()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+}
+
+func newMetricsHandler(s *Service) http.Handler {
+	handler := promhttp.InstrumentMetricHandler(
+		s.monitor.Registerer(),
+		promhttp.HandlerFor(s.monitor.Gatherer(), promhttp.HandlerOpts{}),
+	)
+	if s.conf.MetricsAuth == nil {
+		return handler
+	}
+	return requireMetricsAuth(s.conf.MetricsAuth, handler)
+}
+
+// requireMetricsAuth only passes requests with the configured basic auth or bearer token on to next
+func requireMetricsAuth(auth *config.MetricsAuthConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.Username != "" {
+			if username, password, ok := r.BasicAuth(); ok &&
+				secureCompare(username, auth.Username) && secureCompare(password, auth.Password) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if auth.BearerToken != "" {
+			header := r.Header.Get("Authorization")
+			if strings.HasPrefix(header, "Bearer ") && secureCompare(strings.TrimPrefix(header, "Bearer "), auth.BearerToken) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if auth.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
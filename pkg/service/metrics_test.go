@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+func TestRequireMetricsAuth(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(handler http.Handler, setAuth func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if setAuth != nil {
+			setAuth(r)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	basic := func(username, password string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	handler := requireMetricsAuth(&config.MetricsAuthConfig{Username: "prometheus", Password: "secret"}, next)
+	w := serve(handler, nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	require.Equal(t, http.StatusUnauthorized, serve(handler, basic("prometheus", "wrong")).Code)
+	require.Equal(t, http.StatusUnauthorized, serve(handler, bearer("secret")).Code)
+	require.Equal(t, http.StatusOK, serve(handler, basic("prometheus", "secret")).Code)
+
+	handler = requireMetricsAuth(&config.MetricsAuthConfig{BearerToken: "token"}, next)
+	w = serve(handler, bearer("wrong"))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Empty(t, w.Header().Get("WWW-Authenticate"))
+	require.Equal(t, http.StatusUnauthorized, serve(handler, func(r *http.Request) { r.Header.Set("Authorization", "token") }).Code)
+	require.Equal(t, http.StatusOK, serve(handler, bearer("token")).Code)
+
+	// either is accepted when both are set
+	handler = requireMetricsAuth(&config.MetricsAuthConfig{Username: "prometheus", Password: "secret", BearerToken: "token"}, next)
+	require.Equal(t, http.StatusOK, serve(handler, basic("prometheus", "secret")).Code)
+	require.Equal(t, http.StatusOK, serve(handler, bearer("token")).Code)
+}
+
+func TestDrainHandler(t *testing.T) {
+	drained := 0
+	serve := func(handler http.Handler, method, token string) int {
+		r := httptest.NewRequest(method, "/drain", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	drain := func() { drained++ }
+
+	// disabled without metrics_auth
+	require.Equal(t, http.StatusNotFound, serve(newDrainHandler(nil, drain), http.MethodPost, ""))
+	require.Equal(t, 0, drained)
+
+	handler := newDrainHandler(&config.MetricsAuthConfig{BearerToken: "token"}, drain)
+	require.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodPost, ""))
+	require.Equal(t, http.StatusUnauthorized, serve(handler, http.MethodPost, "wrong"))
+	require.Equal(t, 0, drained)
+	require.Equal(t, http.StatusMethodNotAllowed, serve(handler, http.MethodGet, "token"))
+	require.Equal(t, http.StatusAccepted, serve(handler, http.MethodPost, "token"))
+	require.Equal(t, 1, drained)
+}
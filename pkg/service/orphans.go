@@ -0,0 +1,263 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/input/web"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+// Each service records the handlers it launches in an instance file. Handlers lead their own process group, which
+// chrome and Xvfb inherit, so a service starting after another was killed can find what it left behind without
+// touching the handlers, sinks or temp files of other services on the same host.
+
+const instancesDirName = "egress-instances"
+
+type instanceRecord struct {
+	PID       int                       `json:"pid"`
+	StartTime uint64                    `json:"start_time"` // from /proc, so that a reused pid isn't taken for the service
+	Handlers  map[string]*handlerRecord `json:"handlers"`   // by egress id
+
+	path string
+}
+
+type handlerRecord struct {
+	PID       int    `json:"pid"` // also its process group id, 0 until launched
+	StartTime uint64 `json:"start_time"`
+}
+
+type instance struct {
+	mu     sync.Mutex
+	record *instanceRecord
+}
+
+func instancesDir() string {
+	return path.Join(os.TempDir(), instancesDirName)
+}
+
+func newInstance(dir string) (*instance, error) {
+	pid := os.Getpid()
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	i := &instance{
+		record: &instanceRecord{
+			PID:       pid,
+			StartTime: startTime,
+			Handlers:  make(map[string]*handlerRecord),
+			path:      path.Join(dir, fmt.Sprintf("%d.json", pid)),
+		},
+	}
+	return i, i.write()
+}
+
+// recordHandler records a handler before it is launched, with a pid of 0, and again once it is running
+func (i *instance) recordHandler(egressID string, pid int) {
+	if i == nil {
+		return
+	}
+
+	h := &handlerRecord{PID: pid}
+	if pid != 0 {
+		h.StartTime, _ = processStartTime(pid)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.record.Handlers[egressID] = h
+	if err := i.write(); err != nil {
+		logger.Warnw("could not write instance record", err)
+	}
+}
+
+func (i *instance) removeHandler(egressID string) {
+	if i == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.record.Handlers, egressID)
+	if err := i.write(); err != nil {
+		logger.Warnw("could not write instance record", err)
+	}
+}
+
+func (i *instance) close() {
+	if i == nil {
+		return
+	}
+	_ = os.Remove(i.record.path)
+}
+
+// write replaces the record, so that it is never read half written
+func (i *instance) write() error {
+	b, err := json.Marshal(i.record)
+	if err != nil {
+		return err
+	}
+	tmp := i.record.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, i.record.path)
+}
+
+// readInstances returns the egress ids of handlers recorded by running services, and the records of services
+// which are no longer running. Unreadable records are left alone
+func readInstances(dir string, self int) (map[string]bool, []*instanceRecord) {
+	live := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnw("could not read instance records", err, "path", dir)
+		}
+		return live, nil
+	}
+
+	var dead []*instanceRecord
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		recordPath := path.Join(dir, entry.Name())
+		b, err := os.ReadFile(recordPath)
+		if err != nil {
+			continue
+		}
+		record := &instanceRecord{}
+		if err = json.Unmarshal(b, record); err != nil {
+			logger.Warnw("could not parse instance record", err, "path", recordPath)
+			continue
+		}
+		record.path = recordPath
+
+		if record.PID == self {
+			continue
+		}
+		if processRunning(record.PID, record.StartTime) {
+			for egressID := range record.Handlers {
+				live[egressID] = true
+			}
+		} else {
+			dead = append(dead, record)
+		}
+	}
+	return live, dead
+}
+
+// cleanOrphans kills handlers left running by services which were killed, then removes their records, pulse sinks
+// and temp files. Temp files which no running service owns are also removed once they're older than the threshold
+func cleanOrphans(conf *config.Config, dir string) {
+	live, dead := readInstances(dir, os.Getpid())
+
+	orphaned := make(map[string]bool)
+	for _, record := range dead {
+		for egressID, h := range record.Handlers {
+			orphaned[egressID] = true
+			if h.PID == 0 || !processRunning(h.PID, h.StartTime) {
+				continue
+			}
+			logger.Infow("killing orphaned handler", "egressID", egressID, "pid", h.PID, "servicePID", record.PID)
+			if err := syscall.Kill(-h.PID, syscall.SIGKILL); err != nil {
+				logger.Errorw("failed to kill orphaned handler", err, "egressID", egressID)
+			}
+		}
+		logger.Infow("removing record of stopped service", "servicePID", record.PID, "handlers", len(record.Handlers))
+		_ = os.Remove(record.path)
+	}
+
+	web.RemovePulseSinks(func(egressID string) bool { return live[egressID] })
+
+	tempDirs := []string{os.TempDir()}
+	if conf.LocalOutputDirectory != path.Clean(os.TempDir()) {
+		tempDirs = append(tempDirs, conf.LocalOutputDirectory)
+	}
+	var exclude string
+	if conf.Retention != nil {
+		exclude = conf.Retention.Directory
+	}
+	for _, removed := range cleanTempFiles(tempDirs, exclude, live, orphaned, conf.OrphanCleanup.TempFileAge, time.Now()) {
+		logger.Infow("removed orphaned temp files", "path", removed)
+	}
+}
+
+// cleanTempFiles removes egress entries under dirs, named by egress id, which belonged to a stopped service or
+// were last modified before now-age. Entries owned by running services, and the excluded path, are kept
+func cleanTempFiles(dirs []string, exclude string, live, orphaned map[string]bool, age time.Duration, now time.Time) []string {
+	var removed []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			entryPath := path.Join(dir, name)
+			if !strings.HasPrefix(name, utils.EgressPrefix) || live[name] || entryPath == exclude {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if !orphaned[name] && now.Sub(info.ModTime()) < age {
+				continue
+			}
+
+			if err = os.RemoveAll(entryPath); err != nil {
+				logger.Errorw("failed to remove orphaned temp files", err, "path", entryPath)
+				continue
+			}
+			removed = append(removed, entryPath)
+		}
+	}
+	return removed
+}
+
+// processRunning checks that pid is still the process which was recorded, rather than a reused pid
+func processRunning(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+	current, err := processStartTime(pid)
+	return err == nil && current == startTime
+}
+
+// processStartTime reads the start time of a process, in clock ticks since boot
+func processStartTime(pid int) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// the command name may contain spaces, so fields are counted from its closing parenthesis
+	stat := string(b)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, errors.New("malformed process stat")
+	}
+	fields := strings.Fields(stat[end+1:])
+	// starttime is field 22, and fields here start at 3
+	if len(fields) < 20 {
+		return 0, errors.New("malformed process stat")
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
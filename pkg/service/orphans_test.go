@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadInstances(t *testing.T) {
+	dir := t.TempDir()
+
+	self, err := newInstance(dir)
+	require.NoError(t, err)
+	self.recordHandler("EG_self", 0)
+
+	// a running process which isn't this one stands in for another service
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	startTime, err := processStartTime(cmd.Process.Pid)
+	require.NoError(t, err)
+
+	for name, record := range map[string]*instanceRecord{
+		"running.json": {
+			PID:       cmd.Process.Pid,
+			StartTime: startTime,
+			Handlers:  map[string]*handlerRecord{"EG_running": {}},
+		},
+		// the pid was reused after the service was killed
+		"reused.json": {
+			PID:       cmd.Process.Pid,
+			StartTime: startTime + 1,
+			Handlers:  map[string]*handlerRecord{"EG_reused": {}},
+		},
+	} {
+		b, err := json.Marshal(record)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path.Join(dir, name), b, 0644))
+	}
+	require.NoError(t, os.WriteFile(path.Join(dir, "corrupt.json"), []byte("{"), 0644))
+
+	live, dead := readInstances(dir, os.Getpid())
+	require.Equal(t, map[string]bool{"EG_running": true}, live)
+	require.Len(t, dead, 1)
+	require.Contains(t, dead[0].Handlers, "EG_reused")
+	require.Equal(t, path.Join(dir, "reused.json"), dead[0].path)
+
+	self.close()
+	_, err = os.Stat(self.record.path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCleanTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := t.TempDir()
+	now := time.Now()
+
+	for name, age := range map[string]time.Duration{
+		path.Join(dir, "EG_fresh"):          time.Minute,
+		path.Join(dir, "EG_old"):            time.Hour * 25,
+		path.Join(dir, "EG_orphaned"):       time.Minute,
+		path.Join(dir, "EG_live"):           time.Hour * 25,
+		path.Join(dir, "chrome"):            time.Hour * 25,
+		path.Join(outputDir, "EG_old"):      time.Hour * 25,
+		path.Join(outputDir, "EG_retained"): time.Hour * 25,
+	} {
+		require.NoError(t, os.MkdirAll(name, 0755))
+		require.NoError(t, os.WriteFile(path.Join(name, "out.mp4"), make([]byte, 10), 0644))
+		require.NoError(t, os.Chtimes(name, now.Add(-age), now.Add(-age)))
+	}
+
+	removed := cleanTempFiles(
+		[]string{dir, outputDir, path.Join(dir, "missing")},
+		path.Join(outputDir, "EG_retained"),
+		map[string]bool{"EG_live": true},
+		map[string]bool{"EG_orphaned": true},
+		time.Hour*24,
+		now,
+	)
+	sort.Strings(removed)
+	require.Equal(t, []string{
+		path.Join(dir, "EG_old"),
+		path.Join(dir, "EG_orphaned"),
+		path.Join(outputDir, "EG_old"),
+	}, removed)
+
+	for _, name := range []string{"EG_fresh", "EG_live", "chrome"} {
+		_, err := os.Stat(path.Join(dir, name))
+		require.NoError(t, err)
+	}
+	_, err := os.Stat(path.Join(outputDir, "EG_retained"))
+	require.NoError(t, err)
+}
+
+func TestProcessStartTime(t *testing.T) {
+	startTime, err := processStartTime(os.Getpid())
+	require.NoError(t, err)
+	require.NotZero(t, startTime)
+	require.True(t, processRunning(os.Getpid(), startTime))
+	require.False(t, processRunning(os.Getpid(), startTime+1))
+	require.False(t, processRunning(0, 0))
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+// NewRedisClient connects to redis, or to the master found by sentinel. A sentinel client follows the master when
+// it changes, and commands sent during the failover are retried with backoff. Subscriptions resubscribe on their
+// own once they reconnect.
+func NewRedisClient(conf *config.RedisConfig) (*redis.Client, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	tlsConfig, err := conf.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var rc *redis.Client
+	if len(conf.SentinelAddresses) > 0 {
+		logger.Infow("connecting to redis", "sentinel", true, "addr", conf.SentinelAddresses, "masterName", conf.MasterName)
+		rc = redis.NewFailoverClient(&redis.FailoverOptions{
+			SentinelAddrs:    conf.SentinelAddresses,
+			SentinelUsername: conf.SentinelUsername,
+			SentinelPassword: conf.SentinelPassword,
+			MasterName:       conf.MasterName,
+			Username:         conf.Username,
+			Password:         conf.Password,
+			DB:               conf.DB,
+			TLSConfig:        tlsConfig,
+			MaxRetries:       conf.MaxRetries,
+			MinRetryBackoff:  conf.MinRetryBackoff,
+			MaxRetryBackoff:  conf.MaxRetryBackoff,
+		})
+	} else {
+		logger.Infow("connecting to redis", "sentinel", false, "addr", conf.Address)
+		rc = redis.NewClient(&redis.Options{
+			Addr:            conf.Address,
+			Username:        conf.Username,
+			Password:        conf.Password,
+			DB:              conf.DB,
+			TLSConfig:       tlsConfig,
+			MaxRetries:      conf.MaxRetries,
+			MinRetryBackoff: conf.MinRetryBackoff,
+			MaxRetryBackoff: conf.MaxRetryBackoff,
+		})
+	}
+
+	if err = rc.Ping(context.Background()).Err(); err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("unable to connect to redis: %v", err)
+	}
+
+	return rc, nil
+}
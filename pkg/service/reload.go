@@ -0,0 +1,72 @@
+package service
+
+import (
+	"reflect"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+// Reload applies the cpu costs, log level, webhook and upload bandwidth limit from a changed config. Handlers
+// launched afterwards get them too. Other changes only take effect once the service is restarted, and are logged.
+func (s *Service) Reload(conf *config.Config) {
+	select {
+	case s.reloads <- conf:
+	case <-s.stopped:
+	}
+}
+
+// applyConfig is called by Run, once the monitor and webhooks are started
+func (s *Service) applyConfig(conf *config.Config) {
+	s.confMu.Lock()
+	defer s.confMu.Unlock()
+
+	var changed, ignored []string
+	if !reflect.DeepEqual(conf.CPUCost, s.conf.CPUCost) {
+		if err := s.monitor.SetCPUCostConfig(conf.CPUCost); err != nil {
+			logger.Errorw("invalid cpu_cost, keeping the current costs", err)
+		} else {
+			s.conf.CPUCost = conf.CPUCost
+			changed = append(changed, "cpu_cost")
+		}
+	}
+	if conf.LogLevel != s.conf.LogLevel {
+		config.SetLogLevel(conf.LogLevel)
+		s.conf.LogLevel = conf.LogLevel
+		changed = append(changed, "log_level")
+	}
+	if !reflect.DeepEqual(conf.Webhook, s.conf.Webhook) {
+		if s.webhooks == nil || conf.Webhook == nil || conf.Webhook.URL == "" {
+			// the sender is only created on start
+			ignored = append(ignored, "webhook")
+		} else {
+			s.webhooks.setConfig(conf.Webhook)
+			s.conf.Webhook = conf.Webhook
+			changed = append(changed, "webhook")
+		}
+	}
+	if conf.Upload.BandwidthLimit != s.conf.Upload.BandwidthLimit {
+		s.SetUploadBandwidthLimit(conf.Upload.BandwidthLimit)
+		s.conf.Upload.BandwidthLimit = conf.Upload.BandwidthLimit
+		changed = append(changed, "upload.bandwidth_limit")
+	}
+
+	if conf.NodeID != s.conf.NodeID {
+		ignored = append(ignored, "node_id")
+	}
+	if conf.HealthPort != s.conf.HealthPort {
+		ignored = append(ignored, "health_port")
+	}
+	if conf.PrometheusPort != s.conf.PrometheusPort {
+		ignored = append(ignored, "prometheus_port")
+	}
+	if !reflect.DeepEqual(conf.Redis, s.conf.Redis) {
+		ignored = append(ignored, "redis")
+	}
+
+	s.monitor.ConfigReloaded()
+	logger.Infow("config reloaded", "changed", changed)
+	if len(ignored) > 0 {
+		logger.Warnw("config changes require a restart", nil, "fields", ignored)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+const retentionInterval = time.Minute * 10
+
+// cleanRetention periodically deletes retained files older than the configured ttl
+func (s *Service) cleanRetention(conf *config.RetentionConfig, stop chan struct{}) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		retained, err := cleanRetained(conf.Directory, conf.TTL, time.Now())
+		if err != nil {
+			logger.Errorw("failed to clean retained files", err)
+		}
+		s.monitor.SetRetainedBytes(retained)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanRetained removes egress directories under dir last modified before now-ttl,
+// and returns the size of the files which remain
+func cleanRetained(dir string, ttl time.Duration, now time.Time) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var retained int64
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if now.Sub(info.ModTime()) >= ttl {
+			logger.Debugw("removing retained files", "path", entryPath)
+			if err = os.RemoveAll(entryPath); err != nil {
+				logger.Errorw("failed to remove retained files", err, "path", entryPath)
+			} else {
+				continue
+			}
+		}
+
+		_ = filepath.WalkDir(entryPath, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if fileInfo, err := d.Info(); err == nil {
+					retained += fileInfo.Size()
+				}
+			}
+			return nil
+		})
+	}
+
+	return retained, nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanRetained(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	for name, age := range map[string]time.Duration{
+		"EG_fresh": time.Minute,
+		"EG_old":   time.Hour * 25,
+	} {
+		egressDir := path.Join(dir, name)
+		require.NoError(t, os.MkdirAll(path.Join(egressDir, "segments"), 0755))
+		require.NoError(t, os.WriteFile(path.Join(egressDir, "segments", "0.ts"), make([]byte, 100), 0644))
+		require.NoError(t, os.WriteFile(path.Join(egressDir, "playlist.m3u8"), make([]byte, 10), 0644))
+		require.NoError(t, os.Chtimes(egressDir, now.Add(-age), now.Add(-age)))
+	}
+
+	retained, err := cleanRetained(dir, time.Hour*24, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(110), retained)
+
+	_, err = os.Stat(path.Join(dir, "EG_fresh"))
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(dir, "EG_old"))
+	require.True(t, os.IsNotExist(err))
+
+	// a missing directory has nothing to clean
+	retained, err = cleanRetained(path.Join(dir, "missing"), time.Hour, now)
+	require.NoError(t, err)
+	require.Zero(t, retained)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+// roomIndex finds the egresses running on this node for a room, by room ID or name
+type roomIndex struct {
+	mu     sync.Mutex
+	byID   map[string]map[string]struct{}
+	byName map[string]map[string]struct{}
+}
+
+func newRoomIndex() *roomIndex {
+	return &roomIndex{
+		byID:   make(map[string]map[string]struct{}),
+		byName: make(map[string]map[string]struct{}),
+	}
+}
+
+func (r *roomIndex) add(req *livekit.StartEgressRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	addToRoom(r.byID, req.RoomId, req.EgressId)
+	addToRoom(r.byName, getRoomName(req), req.EgressId)
+}
+
+func (r *roomIndex) remove(req *livekit.StartEgressRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removeFromRoom(r.byID, req.RoomId, req.EgressId)
+	removeFromRoom(r.byName, getRoomName(req), req.EgressId)
+}
+
+// egresses returns the egresses whose room has either the ID or the name, sorted by egress ID
+func (r *roomIndex) egresses(roomID, roomName string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found := make(map[string]struct{})
+	if roomID != "" {
+		for egressID := range r.byID[roomID] {
+			found[egressID] = struct{}{}
+		}
+	}
+	if roomName != "" {
+		for egressID := range r.byName[roomName] {
+			found[egressID] = struct{}{}
+		}
+	}
+
+	egressIDs := make([]string, 0, len(found))
+	for egressID := range found {
+		egressIDs = append(egressIDs, egressID)
+	}
+	sort.Strings(egressIDs)
+	return egressIDs
+}
+
+func addToRoom(rooms map[string]map[string]struct{}, room, egressID string) {
+	if room == "" {
+		// web egress has no room
+		return
+	}
+	if rooms[room] == nil {
+		rooms[room] = make(map[string]struct{})
+	}
+	rooms[room][egressID] = struct{}{}
+}
+
+func removeFromRoom(rooms map[string]map[string]struct{}, room, egressID string) {
+	delete(rooms[room], egressID)
+	if len(rooms[room]) == 0 {
+		delete(rooms, room)
+	}
+}
+
+// handleStopRoomRequest stops every egress of the room on this node, responding with their infos. Each handler
+// stops its egress as it would for a StopEgressRequest, and sends the usual updates
+func (s *Service) handleStopRoomRequest(payload []byte) {
+	requestID, roomID, roomName, err := parseStopRoomRequest(payload)
+	if err != nil {
+		logger.Errorw("malformed stop room request", err)
+		return
+	}
+
+	ctx := context.Background()
+	res := &livekit.ListEgressResponse{}
+	for _, egressID := range s.rooms.egresses(roomID, roomName) {
+		p, ok := s.processes.Load(egressID)
+		if !ok {
+			continue
+		}
+		if err = s.rpcServer.StopEgress(ctx, egressID); err != nil {
+			logger.Errorw("failed to stop egress", err, "egressID", egressID)
+			continue
+		}
+		res.Items = append(res.Items, p.(*process).egressInfo())
+	}
+	if len(res.Items) > 0 {
+		logger.Infow("stopping room egresses", "roomID", roomID, "roomName", roomName, "count", len(res.Items))
+	}
+
+	if err = s.rpcServer.SendStopRoomResponse(ctx, requestID, res); err != nil {
+		logger.Errorw("failed to send stop room response", err)
+	}
+}
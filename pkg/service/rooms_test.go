@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestRoomIndex(t *testing.T) {
+	rooms := newRoomIndex()
+	first := &livekit.StartEgressRequest{
+		EgressId: "EG_1",
+		RoomId:   "RM_1",
+		Request:  &livekit.StartEgressRequest_RoomComposite{RoomComposite: &livekit.RoomCompositeEgressRequest{RoomName: "room"}},
+	}
+	second := &livekit.StartEgressRequest{
+		EgressId: "EG_2",
+		Request:  &livekit.StartEgressRequest_Track{Track: &livekit.TrackEgressRequest{RoomName: "room"}},
+	}
+	rooms.add(first)
+	rooms.add(second)
+	rooms.add(&livekit.StartEgressRequest{EgressId: "EG_web", Request: &livekit.StartEgressRequest_Web{}})
+
+	require.Equal(t, []string{"EG_1"}, rooms.egresses("RM_1", ""))
+	require.Equal(t, []string{"EG_1", "EG_2"}, rooms.egresses("", "room"))
+	require.Equal(t, []string{"EG_1", "EG_2"}, rooms.egresses("RM_1", "room"))
+	require.Empty(t, rooms.egresses("", ""))
+
+	rooms.remove(first)
+	require.Empty(t, rooms.egresses("RM_1", ""))
+	require.Equal(t, []string{"EG_2"}, rooms.egresses("", "room"))
+	rooms.remove(second)
+	require.Empty(t, rooms.byName)
+}
+
+// Two egresses of a room running at once are both stopped by one request, while other rooms carry on
+func TestStopRoom(t *testing.T) {
+	ctx := context.Background()
+	bus := newLocalBus()
+	s := &Service{
+		rpcServer: NewRPCServer(newBusRPC(bus), bus),
+		rooms:     newRoomIndex(),
+	}
+
+	stopped := make(chan string, 3)
+	for _, req := range []*livekit.StartEgressRequest{
+		{EgressId: "EG_1", RoomId: "RM_1", Request: &livekit.StartEgressRequest_RoomComposite{
+			RoomComposite: &livekit.RoomCompositeEgressRequest{RoomName: "room"}}},
+		{EgressId: "EG_2", RoomId: "RM_1", Request: &livekit.StartEgressRequest_Track{
+			Track: &livekit.TrackEgressRequest{RoomName: "room"}}},
+		{EgressId: "EG_3", RoomId: "RM_2", Request: &livekit.StartEgressRequest_Track{
+			Track: &livekit.TrackEgressRequest{RoomName: "other"}}},
+	} {
+		s.processes.Store(req.EgressId, &process{req: req})
+		s.rooms.add(req)
+
+		// the handler's stop requests
+		requests, err := bus.Subscribe(ctx, requestChannelBase+req.EgressId)
+		require.NoError(t, err)
+		defer requests.Close()
+		go func() {
+			for msg := range requests.Channel() {
+				request := &livekit.EgressRequest{}
+				_ = proto.Unmarshal(requests.Payload(msg), request)
+				if request.GetStop() != nil {
+					stopped <- request.EgressId
+				}
+			}
+		}()
+	}
+
+	stopRoomRequests, err := s.rpcServer.StopRoomRequestChannel(ctx)
+	require.NoError(t, err)
+	defer stopRoomRequests.Close()
+	go func() {
+		s.handleStopRoomRequest(stopRoomRequests.Payload(<-stopRoomRequests.Channel()))
+	}()
+
+	infos, err := StopRoomEgress(ctx, bus, "", "room", time.Millisecond*200)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, "EG_1", infos[0].EgressId)
+	require.Equal(t, "EG_2", infos[1].EgressId)
+
+	require.ElementsMatch(t, []string{"EG_1", "EG_2"}, []string{<-stopped, <-stopped})
+	select {
+	case egressID := <-stopped:
+		t.Fatalf("%s was stopped", egressID)
+	default:
+	}
+
+	_, err = StopRoomEgress(ctx, bus, "", "", time.Millisecond*200)
+	require.Error(t, err)
+}
@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline"
+	"github.com/livekit/protocol/egress"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+const (
+	listEgressChannel       = "EG_LIST"
+	listResponseChannelBase = "EG_LIST_RES_"
+	controlChannelBase      = "EG_CONTROL_"
+	validateEgressChannel   = "EG_VALIDATE"
+	validateResponseBase    = "EG_VALIDATE_RES_"
+	stopRoomChannel         = "EG_STOP_ROOM"
+	stopRoomResponseBase    = "EG_STOP_ROOM_RES_"
+	SegmentChannel          = "EG_SEGMENTS"
+	responseChannelBase     = "RES_"
+
+	// used by the protocol's redis rpc, and by the local bus in its place
+	startChannel       = "EG_START"
+	updateChannel      = "EG_RESULTS"
+	requestChannelBase = "REQ_"
+
+	listRequestIDField    = "request_id"
+	listRoomIDField       = "room_id"
+	listRoomNameField     = "room_name"
+	controlActionField    = "action"
+	validateFailuresField = "failures"
+	validateCheckField    = "check"
+	validateCodeField     = "code"
+	validateErrorField    = "error"
+
+	segmentEgressIDField  = "egress_id"
+	segmentSequenceField  = "sequence"
+	segmentLocalPathField = "local_path"
+	segmentLocationField  = "location"
+	segmentStartField     = "start_time"
+	segmentDurationField  = "duration"
+)
+
+// returned when a subscription ends before its response arrives, such as when a grpc stream is lost
+var errSubscriptionClosed = errors.New("subscription closed")
+
+// control actions, for requests which have no equivalent in livekit.EgressRequest
+const (
+	ActionPause  = "pause"
+	ActionResume = "resume"
+
+	// sent by the service once an egress reaches its scheduled stop
+	actionScheduledStop = "scheduled_stop"
+)
+
+// RPCServer extends egress.RPCServer with node level requests which are not part of the protocol
+type RPCServer interface {
+	egress.RPCServer
+
+	// ListRequestChannel returns a subscription for list egress requests
+	ListRequestChannel(ctx context.Context) (utils.PubSub, error)
+	// SendListResponse returns the egresses running on this node
+	SendListResponse(ctx context.Context, requestID string, res *livekit.ListEgressResponse) error
+	// ControlSubscription returns a subscription for pause, resume and scheduled stop requests to an egress
+	ControlSubscription(ctx context.Context, egressID string) (utils.PubSub, error)
+	// SendControlResponse responds to a control request the same way as to a livekit.EgressRequest
+	SendControlResponse(ctx context.Context, requestID string, info *livekit.EgressInfo, err error) error
+	// ValidateRequestChannel returns a subscription for dry run requests, each of which is handled by one node
+	ValidateRequestChannel(ctx context.Context) (utils.PubSub, error)
+	// SendValidateResponse returns the checks a dry run request failed
+	SendValidateResponse(ctx context.Context, requestID string, failures []*ValidationFailure) error
+	// StopRoomRequestChannel returns a subscription for requests to stop every egress of a room
+	StopRoomRequestChannel(ctx context.Context) (utils.PubSub, error)
+	// SendStopRoomResponse returns the egresses this node stopped
+	SendStopRoomResponse(ctx context.Context, requestID string, res *livekit.ListEgressResponse) error
+	// StopEgress sends a stop request to the handler of an egress, without waiting for its response
+	StopEgress(ctx context.Context, egressID string) error
+	// SendScheduledStop tells the handler of an egress that its scheduled stop has been reached
+	SendScheduledStop(ctx context.Context, egressID string) error
+	// PublishSegment announces a finished segment on SegmentChannel, for segment hooks
+	PublishSegment(ctx context.Context, event *pipeline.SegmentEvent) error
+}
+
+type rpcServer struct {
+	egress.RPCServer
+	bus utils.MessageBus
+}
+
+func NewRPCServer(server egress.RPCServer, bus utils.MessageBus) RPCServer {
+	return &rpcServer{
+		RPCServer: server,
+		bus:       bus,
+	}
+}
+
+func (r *rpcServer) ListRequestChannel(ctx context.Context) (utils.PubSub, error) {
+	return r.bus.Subscribe(ctx, listEgressChannel)
+}
+
+func (r *rpcServer) SendListResponse(ctx context.Context, requestID string, res *livekit.ListEgressResponse) error {
+	return r.bus.Publish(ctx, listResponseChannelBase+requestID, res)
+}
+
+func (r *rpcServer) ControlSubscription(ctx context.Context, egressID string) (utils.PubSub, error) {
+	return r.bus.Subscribe(ctx, controlChannelBase+egressID)
+}
+
+func (r *rpcServer) SendControlResponse(ctx context.Context, requestID string, info *livekit.EgressInfo, err error) error {
+	res := &livekit.EgressResponse{
+		RequestId: requestID,
+		Info:      info,
+	}
+	if err != nil {
+		res.Error = errors.Format(err)
+	}
+	return r.bus.Publish(ctx, responseChannelBase+requestID, res)
+}
+
+func (r *rpcServer) ValidateRequestChannel(ctx context.Context) (utils.PubSub, error) {
+	return r.bus.SubscribeQueue(ctx, validateEgressChannel)
+}
+
+func (r *rpcServer) SendValidateResponse(ctx context.Context, requestID string, failures []*ValidationFailure) error {
+	items := make([]interface{}, 0, len(failures))
+	for _, f := range failures {
+		items = append(items, map[string]interface{}{
+			validateCheckField: f.Check,
+			validateCodeField:  f.Code,
+			validateErrorField: f.Error,
+		})
+	}
+
+	res, err := structpb.NewStruct(map[string]interface{}{
+		listRequestIDField:    requestID,
+		validateFailuresField: items,
+	})
+	if err != nil {
+		return err
+	}
+	return r.bus.Publish(ctx, validateResponseBase+requestID, res)
+}
+
+func (r *rpcServer) StopRoomRequestChannel(ctx context.Context) (utils.PubSub, error) {
+	return r.bus.Subscribe(ctx, stopRoomChannel)
+}
+
+func (r *rpcServer) SendStopRoomResponse(ctx context.Context, requestID string, res *livekit.ListEgressResponse) error {
+	return r.bus.Publish(ctx, stopRoomResponseBase+requestID, res)
+}
+
+func (r *rpcServer) StopEgress(ctx context.Context, egressID string) error {
+	return r.bus.Publish(ctx, requestChannelBase+egressID, &livekit.EgressRequest{
+		EgressId:  egressID,
+		RequestId: utils.NewGuid(utils.RPCPrefix),
+		Request:   &livekit.EgressRequest_Stop{Stop: &livekit.StopEgressRequest{EgressId: egressID}},
+	})
+}
+
+func (r *rpcServer) SendScheduledStop(ctx context.Context, egressID string) error {
+	req, err := structpb.NewStruct(map[string]interface{}{
+		listRequestIDField: utils.NewGuid(utils.RPCPrefix),
+		controlActionField: actionScheduledStop,
+	})
+	if err != nil {
+		return err
+	}
+	return r.bus.Publish(ctx, controlChannelBase+egressID, req)
+}
+
+func (r *rpcServer) PublishSegment(ctx context.Context, event *pipeline.SegmentEvent) error {
+	msg, err := structpb.NewStruct(map[string]interface{}{
+		segmentEgressIDField:  event.EgressID,
+		segmentSequenceField:  event.Sequence,
+		segmentLocalPathField: event.LocalPath,
+		segmentLocationField:  event.Location,
+		segmentStartField:     event.StartTime,
+		segmentDurationField:  event.Duration,
+	})
+	if err != nil {
+		return err
+	}
+	return r.bus.Publish(ctx, SegmentChannel, msg)
+}
+
+// SendControlRequest sends a pause or resume request to an egress and waits for its response
+func SendControlRequest(ctx context.Context, bus utils.MessageBus, egressID, action string, timeout time.Duration) (*livekit.EgressInfo, error) {
+	requestID := utils.NewGuid(utils.RPCPrefix)
+
+	sub, err := bus.Subscribe(ctx, responseChannelBase+requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			logger.Errorw("failed to unsubscribe from response channel", err)
+		}
+	}()
+
+	req, err := structpb.NewStruct(map[string]interface{}{
+		listRequestIDField: requestID,
+		controlActionField: action,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = bus.Publish(ctx, controlChannelBase+egressID, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg, ok := <-sub.Channel():
+		if !ok {
+			return nil, errSubscriptionClosed
+		}
+		res := &livekit.EgressResponse{}
+		if err = proto.Unmarshal(sub.Payload(msg), res); err != nil {
+			return nil, err
+		}
+		if res.Error != "" {
+			return nil, errors.New(res.Error)
+		}
+		return res.Info, nil
+
+	case <-time.After(timeout):
+		return nil, errors.New("no response from egress")
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ListEgress asks every egress node for its active egresses, optionally filtered by room ID,
+// and collects responses until the timeout has passed
+func ListEgress(ctx context.Context, bus utils.MessageBus, roomID string, timeout time.Duration) ([]*livekit.EgressInfo, error) {
+	requestID := utils.NewGuid(utils.RPCPrefix)
+
+	sub, err := bus.Subscribe(ctx, listResponseChannelBase+requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			logger.Errorw("failed to unsubscribe from response channel", err)
+		}
+	}()
+
+	req, err := structpb.NewStruct(map[string]interface{}{
+		listRequestIDField: requestID,
+		listRoomIDField:    roomID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = bus.Publish(ctx, listEgressChannel, req); err != nil {
+		return nil, err
+	}
+
+	return collectListResponses(ctx, sub, timeout)
+}
+
+// StopRoomEgress asks every egress node to stop the active egresses of a room, matched by room ID or name, and
+// collects the egresses stopped until the timeout has passed. Each egress ends with the same updates as when it
+// is stopped with a StopEgressRequest
+func StopRoomEgress(ctx context.Context, bus utils.MessageBus, roomID, roomName string, timeout time.Duration) ([]*livekit.EgressInfo, error) {
+	if roomID == "" && roomName == "" {
+		return nil, errors.ErrInvalidInput("room")
+	}
+	requestID := utils.NewGuid(utils.RPCPrefix)
+
+	sub, err := bus.Subscribe(ctx, stopRoomResponseBase+requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			logger.Errorw("failed to unsubscribe from response channel", err)
+		}
+	}()
+
+	req, err := structpb.NewStruct(map[string]interface{}{
+		listRequestIDField: requestID,
+		listRoomIDField:    roomID,
+		listRoomNameField:  roomName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = bus.Publish(ctx, stopRoomChannel, req); err != nil {
+		return nil, err
+	}
+
+	return collectListResponses(ctx, sub, timeout)
+}
+
+func collectListResponses(ctx context.Context, sub utils.PubSub, timeout time.Duration) ([]*livekit.EgressInfo, error) {
+	items := make([]*livekit.EgressInfo, 0)
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return items, errSubscriptionClosed
+			}
+			res := &livekit.ListEgressResponse{}
+			if err := proto.Unmarshal(sub.Payload(msg), res); err != nil {
+				logger.Errorw("failed to read list response", err)
+				continue
+			}
+			items = append(items, res.Items...)
+
+		case <-deadline:
+			return items, nil
+
+		case <-ctx.Done():
+			return items, ctx.Err()
+		}
+	}
+}
+
+// ValidateEgress runs a dry run of a request on one egress node, returning the checks it failed. Nothing is
+// started, and the request is checked even if no node has capacity for it
+func ValidateEgress(ctx context.Context, bus utils.MessageBus, req *livekit.StartEgressRequest, timeout time.Duration) ([]*ValidationFailure, error) {
+	requestID := utils.NewGuid(utils.RPCPrefix)
+
+	sub, err := bus.Subscribe(ctx, validateResponseBase+requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			logger.Errorw("failed to unsubscribe from response channel", err)
+		}
+	}()
+
+	req = proto.Clone(req).(*livekit.StartEgressRequest)
+	req.RequestId = requestID
+	if err = bus.Publish(ctx, validateEgressChannel, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg, ok := <-sub.Channel():
+		if !ok {
+			return nil, errSubscriptionClosed
+		}
+		return parseValidateResponse(sub.Payload(msg))
+
+	case <-time.After(timeout):
+		return nil, errors.New("no response from egress")
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func parseValidateResponse(b []byte) ([]*ValidationFailure, error) {
+	res := &structpb.Struct{}
+	if err := proto.Unmarshal(b, res); err != nil {
+		return nil, err
+	}
+
+	var failures []*ValidationFailure
+	for _, item := range res.GetFields()[validateFailuresField].GetListValue().GetValues() {
+		fields := item.GetStructValue().GetFields()
+		failures = append(failures, &ValidationFailure{
+			Check: fields[validateCheckField].GetStringValue(),
+			Code:  fields[validateCodeField].GetStringValue(),
+			Error: fields[validateErrorField].GetStringValue(),
+		})
+	}
+	return failures, nil
+}
+
+func parseListRequest(b []byte) (requestID, roomID string, err error) {
+	req := &structpb.Struct{}
+	if err = proto.Unmarshal(b, req); err != nil {
+		return "", "", err
+	}
+
+	fields := req.GetFields()
+	requestID = fields[listRequestIDField].GetStringValue()
+	roomID = fields[listRoomIDField].GetStringValue()
+	if requestID == "" {
+		return "", "", errors.ErrInvalidInput(listRequestIDField)
+	}
+
+	return requestID, roomID, nil
+}
+
+func parseStopRoomRequest(b []byte) (requestID, roomID, roomName string, err error) {
+	req := &structpb.Struct{}
+	if err = proto.Unmarshal(b, req); err != nil {
+		return "", "", "", err
+	}
+
+	fields := req.GetFields()
+	requestID = fields[listRequestIDField].GetStringValue()
+	roomID = fields[listRoomIDField].GetStringValue()
+	roomName = fields[listRoomNameField].GetStringValue()
+	if requestID == "" {
+		return "", "", "", errors.ErrInvalidInput(listRequestIDField)
+	} else if roomID == "" && roomName == "" {
+		return "", "", "", errors.ErrInvalidInput("room")
+	}
+
+	return requestID, roomID, roomName, nil
+}
+
+func parseControlRequest(b []byte) (requestID, action string, err error) {
+	req := &structpb.Struct{}
+	if err = proto.Unmarshal(b, req); err != nil {
+		return "", "", err
+	}
+
+	fields := req.GetFields()
+	requestID = fields[listRequestIDField].GetStringValue()
+	action = fields[controlActionField].GetStringValue()
+	if requestID == "" {
+		return "", "", errors.ErrInvalidInput(listRequestIDField)
+	}
+
+	return requestID, action, nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+func (s *Service) sendScheduledStop(ctx context.Context, egressID string) {
+	logger.Infow("scheduled stop reached", "egressID", egressID)
+	if err := s.rpcServer.SendScheduledStop(ctx, egressID); err != nil {
+		logger.Errorw("failed to send scheduled stop", err, "egressID", egressID)
+	}
+}
+
+// updateScheduledStop arms the stop timer the first time the egress is active, and cancels it once the egress is
+// ending, however it was stopped. The deadline is counted from the first time it was active, so neither update
+// stream requests nor restarted pipelines move it. p.mu must be held
+func (p *process) updateScheduledStop(status livekit.EgressStatus, now time.Time) {
+	switch status {
+	case livekit.EgressStatus_EGRESS_ACTIVE:
+		if !p.activeAt.IsZero() {
+			return
+		}
+		p.activeAt = now
+		if p.scheduledStop.IsZero() || p.onScheduledStop == nil {
+			return
+		}
+		p.stopTimer = time.AfterFunc(p.scheduledStop.Deadline(now).Sub(now), p.onScheduledStop)
+
+	case livekit.EgressStatus_EGRESS_ENDING,
+		livekit.EgressStatus_EGRESS_LIMIT_REACHED,
+		livekit.EgressStatus_EGRESS_COMPLETE,
+		livekit.EgressStatus_EGRESS_ABORTED:
+		// a pipeline which failed can be restarted, so failures are left to the handler exiting
+		p.cancelScheduledStop()
+	}
+}
+
+// cancelScheduledStop stops the timer once the egress has stopped, or its handler has exited. p.mu must be held
+func (p *process) cancelScheduledStop() {
+	if p.stopTimer != nil {
+		p.stopTimer.Stop()
+		p.stopTimer = nil
+	}
+	p.onScheduledStop = nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/protocol/livekit"
+)
+
+// The timer starts once the egress is active, only the first time, and is cancelled once it's ending
+func TestProcessScheduledStop(t *testing.T) {
+	stopped := make(chan struct{}, 2)
+	newProcess := func(d time.Duration) *process {
+		return &process{
+			scheduledStop: params.ScheduledStop{MaxDuration: d},
+			onScheduledStop: func() {
+				stopped <- struct{}{}
+			},
+		}
+	}
+
+	p := newProcess(time.Millisecond * 100)
+	p.mu.Lock()
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_STARTING, time.Now())
+	require.Nil(t, p.stopTimer)
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_ACTIVE, time.Now())
+	timer := p.stopTimer
+	require.NotNil(t, timer)
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_ACTIVE, time.Now())
+	require.Same(t, timer, p.stopTimer)
+	p.mu.Unlock()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("not stopped")
+	}
+
+	// a stop request cancels it
+	p = newProcess(time.Millisecond * 200)
+	p.mu.Lock()
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_ACTIVE, time.Now())
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_ENDING, time.Now())
+	require.Nil(t, p.stopTimer)
+	// and it isn't armed again
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_ACTIVE, time.Now())
+	require.Nil(t, p.stopTimer)
+	p.mu.Unlock()
+
+	select {
+	case <-stopped:
+		t.Fatal("stopped after cancelling")
+	case <-time.After(time.Millisecond * 300):
+	}
+}
+
+// A scheduled stop reaches the egress handler as a control request
+func TestSendScheduledStop(t *testing.T) {
+	ctx := context.Background()
+	bus := newLocalBus()
+	s := &Service{
+		rpcServer: NewRPCServer(newBusRPC(bus), bus),
+	}
+
+	controls, err := s.rpcServer.ControlSubscription(ctx, "EG_1")
+	require.NoError(t, err)
+	defer controls.Close()
+
+	p := &process{
+		scheduledStop: params.ScheduledStop{EndAt: time.Now().Add(time.Millisecond * 100)},
+		onScheduledStop: func() {
+			s.sendScheduledStop(ctx, "EG_1")
+		},
+	}
+	p.mu.Lock()
+	p.updateScheduledStop(livekit.EgressStatus_EGRESS_ACTIVE, time.Now())
+	p.mu.Unlock()
+
+	select {
+	case msg := <-controls.Channel():
+		_, action, err := parseControlRequest(controls.Payload(msg))
+		require.NoError(t, err)
+		require.Equal(t, actionScheduledStop, action)
+	case <-time.After(time.Second):
+		t.Fatal("no scheduled stop")
+	}
+}
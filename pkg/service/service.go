@@ -13,15 +13,18 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/atomic"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"gopkg.in/yaml.v3"
 
 	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/input/builder"
+	"github.com/livekit/egress/pkg/pipeline/input/web"
 	"github.com/livekit/egress/pkg/pipeline/params"
 	"github.com/livekit/egress/pkg/stats"
+	"github.com/livekit/egress/pkg/tracing"
 	"github.com/livekit/egress/version"
 	"github.com/livekit/protocol/egress"
 	"github.com/livekit/protocol/livekit"
@@ -33,32 +36,136 @@ const shutdownTimer = time.Second * 30
 
 type Service struct {
 	conf       *config.Config
-	rpcServer  egress.RPCServer
+	confMu     sync.Mutex // guards the fields changed by Reload
+	rpcServer  RPCServer
+	state      *StateStore
+	webhooks   *webhookSender
+	updates    *updateBuffer // updates which could not be published, replayed once redis is back
+	dedupe     *requestDedupe
+	rooms      *roomIndex // the active egresses of each room
 	promServer *http.Server
+	metrics    http.Handler // also served at /metrics on the health port
+	drain      http.Handler // served at /drain on the health port
 	monitor    *stats.Monitor
+	redactor   *params.Redactor
+	instance   *instance
+	bandwidth  *bandwidthAllocator
 
 	handlingWeb atomic.Bool
+	draining    atomic.Bool
 	processes   sync.Map
 	shutdown    chan struct{}
+	stopped     chan struct{} // closed once Run has returned
+	reloads     chan *config.Config
+
+	// failed egresses and requests, for the status file
+	recentErrors errorLog
 }
 
 type process struct {
 	req *livekit.StartEgressRequest
 	cmd *exec.Cmd
+
+	mu               sync.Mutex
+	info             *livekit.EgressInfo
+	paused           bool
+	streamReconnects map[string]int
+	uploadRetries    int
+	uploadFailures   int
+	uploadedBytes    int64
+	uploadSize       int64
+	websocketDropped int64
+	layerSwitches    int
+	packetsLost      int64
+	packetsReordered int64
+	packetsConcealed int64
+	firstKeyFrame    time.Duration
+	bytesWritten     int64
+	framesDropped    int64
+	segmentHookFails int
+	pipelineRestarts int
+	errorCategory    string
+
+	// taken from the request, and timed from when the egress is first active
+	scheduledStop   params.ScheduledStop
+	onScheduledStop func()
+	activeAt        time.Time
+	stopTimer       *time.Timer
+}
+
+func countReconnects(reconnects map[string]int) int {
+	total := 0
+	for _, count := range reconnects {
+		total += count
+	}
+	return total
+}
+
+// duration returns the running time reported by the handler
+func (p *process) duration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.info == nil || p.info.StartedAt == 0 || p.info.EndedAt < p.info.StartedAt {
+		return 0
+	}
+	return time.Duration(p.info.EndedAt - p.info.StartedAt)
+}
+
+// result returns the error reported by the handler, or nil if the egress completed
+func (p *process) result(waitErr error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.info == nil {
+		if waitErr == nil {
+			waitErr = errors.New("handler exited without reporting status")
+		}
+		return errors.WithCategory(errors.CategoryPipeline, waitErr)
+	}
+
+	switch p.info.Status {
+	case livekit.EgressStatus_EGRESS_COMPLETE,
+		livekit.EgressStatus_EGRESS_LIMIT_REACHED:
+		return nil
+	case livekit.EgressStatus_EGRESS_ABORTED:
+		return errors.WithCategory(errors.CategoryAborted, errors.New("egress aborted"))
+	case livekit.EgressStatus_EGRESS_FAILED:
+		category := p.errorCategory
+		if category == "" {
+			category = errors.CategoryPipeline
+		}
+		return errors.WithCategory(category, errors.New(p.info.Error))
+	default:
+		if waitErr == nil {
+			waitErr = errors.New("handler exited before egress completed")
+		}
+		return errors.WithCategory(errors.CategoryPipeline, waitErr)
+	}
 }
 
-func NewService(conf *config.Config, rpcServer egress.RPCServer) *Service {
+// NewService creates a service. State may be nil, in which case egresses lost in a crash are not reported.
+func NewService(conf *config.Config, rpcServer RPCServer, state *StateStore, opts ...stats.MonitorOption) *Service {
 	s := &Service{
 		conf:      conf,
 		rpcServer: rpcServer,
-		monitor:   stats.NewMonitor(),
+		state:     state,
+		monitor:   stats.NewMonitor(opts...),
+		redactor:  params.NewRedactor(conf.StreamKeyPattern),
 		shutdown:  make(chan struct{}),
+		stopped:   make(chan struct{}),
+		reloads:   make(chan *config.Config),
+		dedupe:    newRequestDedupe(conf.Dedupe.Window, dedupeMaxEntries),
+		rooms:     newRoomIndex(),
 	}
+	s.bandwidth = newBandwidthAllocator(conf.Upload.BandwidthLimit, s.monitor.SetUploadThroughput)
 
+	s.metrics = newMetricsHandler(s)
+	s.drain = newDrainHandler(conf.MetricsAuth, s.Drain)
 	if conf.PrometheusPort > 0 {
 		s.promServer = &http.Server{
 			Addr:    fmt.Sprintf(":%d", conf.PrometheusPort),
-			Handler: promhttp.Handler(),
+			Handler: s.metrics,
 		}
 	}
 
@@ -78,9 +185,51 @@ func (s *Service) Run() error {
 		}()
 	}
 
+	// handlers are launched with the resolved encoder
+	encoder, err := builder.ResolveEncoder(s.conf.Encoder)
+	if err != nil {
+		return err
+	}
+	s.conf.Encoder = encoder
+
+	// handlers, sinks and temp files left behind by services which were killed
+	cleanOrphans(s.conf, instancesDir())
+	if s.instance, err = newInstance(instancesDir()); err != nil {
+		logger.Warnw("could not record instance, handlers will be left behind if the service is killed", err)
+	}
+	defer s.instance.close()
+
 	if err := s.monitor.Start(s.conf, s.isAvailable); err != nil {
 		return err
 	}
+	defer s.monitor.Stop()
+
+	s.webhooks = newWebhookSender(s.conf.Webhook, s.monitor.WebhookFailed)
+	defer s.webhooks.Stop()
+
+	s.updates = newUpdateBuffer(updateBufferSize, updateRetryInterval, s.rpcServer.SendUpdate,
+		s.monitor.UpdateReplayed, s.monitor.UpdateDropped)
+	defer s.updates.Stop()
+
+	if s.state != nil {
+		s.reportLostEgresses()
+
+		stopHeartbeat := make(chan struct{})
+		defer close(stopHeartbeat)
+		go s.heartbeat(stopHeartbeat)
+	}
+
+	if s.conf.Retention != nil {
+		stopRetention := make(chan struct{})
+		defer close(stopRetention)
+		go s.cleanRetention(s.conf.Retention, stopRetention)
+	}
+
+	if s.conf.StatusFile != nil {
+		stopStatusFile := make(chan struct{})
+		defer close(stopStatusFile)
+		go s.writeStatusFile(s.conf.StatusFile, stopStatusFile)
+	}
 
 	requests, err := s.rpcServer.GetRequestChannel(context.Background())
 	if err != nil {
@@ -91,18 +240,56 @@ func (s *Service) Run() error {
 		_ = requests.Close()
 	}()
 
+	listRequests, err := s.rpcServer.ListRequestChannel(context.Background())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = listRequests.Close()
+	}()
+
+	validateRequests, err := s.rpcServer.ValidateRequestChannel(context.Background())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = validateRequests.Close()
+	}()
+
+	stopRoomRequests, err := s.rpcServer.StopRoomRequestChannel(context.Background())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = stopRoomRequests.Close()
+	}()
+
 	logger.Debugw("service ready")
+	defer close(s.stopped)
 
+	startRequests := requests.Channel()
+	shutdown := s.shutdown
+	var idle chan struct{}
 	for {
 		select {
-		case <-s.shutdown:
+		case <-shutdown:
 			logger.Infow("shutting down")
-			for !s.isIdle() {
-				time.Sleep(shutdownTimer)
-			}
+			// no more egresses are started, but those still running can be listed, stopped and reloaded
+			startRequests, shutdown = nil, nil
+			idle = make(chan struct{})
+			go func() {
+				s.awaitIdle()
+				close(idle)
+			}()
+
+		case <-idle:
 			return nil
 
-		case msg := <-requests.Channel():
+		case msg := <-startRequests:
+			// the root span of the egress, which ends once its handler exits
 			ctx, span := tracer.Start(context.Background(), "Service.HandleRequest")
 
 			req := &livekit.StartEgressRequest{}
@@ -111,35 +298,154 @@ func (s *Service) Run() error {
 				span.End()
 				continue
 			}
+			tracing.SetEgress(ctx, req.EgressId, stats.EgressType(req))
+
+			// a retried request gets the egress its first request started
+			entry, claimed := s.dedupe.claim(req.EgressId)
+			if p, running := s.processes.Load(req.EgressId); claimed && running {
+				// still running after the window
+				s.dedupe.accept(p.(*process).egressInfo())
+				claimed = false
+			}
+			if !claimed {
+				go func() {
+					s.sendDuplicateResponse(ctx, req, entry)
+					span.End()
+				}()
+				continue
+			}
 
-			if s.acceptRequest(ctx, req) {
+			if accepted, release := s.acceptRequest(ctx, req); accepted {
 				// validate before launching handler
 				info, err := params.ValidateRequest(ctx, s.conf, req)
+				if err == nil && !s.claimEgress(ctx, req) {
+					// the node which started it answers
+					release()
+					s.dedupe.release(req.EgressId)
+					span.End()
+					continue
+				}
 				s.sendResponse(ctx, req, info, err)
 				if err != nil {
+					release()
+					s.dedupe.release(req.EgressId)
 					span.RecordError(err)
 					span.End()
 					continue
 				}
+				s.dedupe.accept(info)
+				s.webhooks.Notify(info)
 
 				switch req.Request.(type) {
 				case *livekit.StartEgressRequest_RoomComposite,
 					*livekit.StartEgressRequest_Web:
 					s.handlingWeb.Store(true)
 					go func() {
-						s.launchHandler(ctx, req)
+						s.launchHandler(ctx, req, release)
 						s.handlingWeb.Store(false)
+						span.End()
 					}()
 				default:
-					go s.launchHandler(ctx, req)
+					go func() {
+						s.launchHandler(ctx, req, release)
+						span.End()
+					}()
 				}
+				continue
 			}
 
+			s.dedupe.release(req.EgressId)
 			span.End()
+
+		case msg := <-listRequests.Channel():
+			s.handleListRequest(listRequests.Payload(msg))
+
+		case msg := <-validateRequests.Channel():
+			// connectivity checks can take a while, and shouldn't hold up start requests
+			go s.handleValidateRequest(validateRequests.Payload(msg))
+
+		case msg := <-stopRoomRequests.Channel():
+			s.handleStopRoomRequest(stopRoomRequests.Payload(msg))
+
+		case conf := <-s.reloads:
+			s.applyConfig(conf)
+		}
+	}
+}
+
+// reportLostEgresses fails egresses left behind by a previous run with the same node ID.
+// It runs on every heartbeat, since records from a recent run only become stale after a while.
+func (s *Service) reportLostEgresses() {
+	infos, err := s.state.RecoverStale(context.Background())
+	if err != nil {
+		logger.Errorw("failed to read egress state", err)
+		return
+	}
+
+	for _, info := range infos {
+		logger.Warnw("egress lost", errors.New(info.Error), "egressID", info.EgressId)
+		s.sendUpdate(context.Background(), info)
+	}
+}
+
+func (s *Service) heartbeat(stop chan struct{}) {
+	ticker := time.NewTicker(stateHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			infos := make([]*livekit.EgressInfo, 0)
+			s.processes.Range(func(key, value interface{}) bool {
+				infos = append(infos, value.(*process).egressInfo())
+				return true
+			})
+			if err := s.state.Heartbeat(context.Background(), infos); err != nil {
+				logger.Errorw("failed to refresh egress state", err)
+			}
+			s.reportLostEgresses()
 		}
 	}
 }
 
+// sendUpdate reports a status the handler could not send itself
+func (s *Service) sendUpdate(ctx context.Context, info *livekit.EgressInfo) {
+	s.updates.Send(ctx, info)
+	s.webhooks.Notify(info)
+}
+
+func (s *Service) updateState(info *livekit.EgressInfo) {
+	if s.state == nil {
+		return
+	}
+	if err := s.state.Update(context.Background(), info); err != nil {
+		logger.Errorw("failed to store egress state", err, "egressID", info.EgressId)
+	}
+}
+
+func (s *Service) handleListRequest(payload []byte) {
+	requestID, roomID, err := parseListRequest(payload)
+	if err != nil {
+		logger.Errorw("malformed list request", err)
+		return
+	}
+
+	res := &livekit.ListEgressResponse{}
+	s.processes.Range(func(key, value interface{}) bool {
+		info := value.(*process).egressInfo()
+		if roomID == "" || info.RoomId == roomID {
+			res.Items = append(res.Items, info)
+		}
+		return true
+	})
+
+	if err = s.rpcServer.SendListResponse(context.Background(), requestID, res); err != nil {
+		logger.Errorw("failed to send list response", err)
+	}
+}
+
 func (s *Service) isIdle() bool {
 	idle := true
 	s.processes.Range(func(key, value interface{}) bool {
@@ -149,14 +455,35 @@ func (s *Service) isIdle() bool {
 	return idle
 }
 
+// awaitIdle waits for active egresses to finish, stopping them once the drain timeout has passed
+func (s *Service) awaitIdle() {
+	var timeout <-chan time.Time
+	if s.draining.Load() && s.conf.DrainTimeout > 0 {
+		timeout = time.After(s.conf.DrainTimeout)
+	}
+
+	for !s.isIdle() {
+		select {
+		case <-timeout:
+			logger.Infow("drain timeout reached, stopping active egresses")
+			s.killProcesses()
+			timeout = nil
+		case <-time.After(shutdownTimer):
+		}
+	}
+}
+
 func (s *Service) isAvailable() float64 {
+	if s.draining.Load() {
+		return 0
+	}
 	if s.isIdle() {
 		return 1
 	}
 	return 0
 }
 
-func (s *Service) acceptRequest(ctx context.Context, req *livekit.StartEgressRequest) bool {
+func (s *Service) acceptRequest(ctx context.Context, req *livekit.StartEgressRequest) (bool, func()) {
 	ctx, span := tracer.Start(ctx, "Service.acceptRequest")
 	defer span.End()
 
@@ -169,13 +496,13 @@ func (s *Service) acceptRequest(ctx context.Context, req *livekit.StartEgressReq
 
 	// check request time
 	if time.Since(time.Unix(0, req.SentAt)) >= egress.RequestExpiration {
-		return false
+		return false, nil
 	}
 
 	if s.handlingWeb.Load() {
 		args = append(args, "reason", "already handling room composite")
 		logger.Debugw("rejecting request", args...)
-		return false
+		return false, nil
 	}
 
 	// check cpu load
@@ -186,53 +513,67 @@ func (s *Service) acceptRequest(ctx context.Context, req *livekit.StartEgressReq
 		if !s.isIdle() {
 			args = append(args, "reason", "already recording")
 			logger.Debugw("rejecting request", args...)
-			return false
+			return false, nil
 		}
 	default:
 		// continue
 	}
 
-	if !s.monitor.CanAcceptRequest(req) {
-		args = append(args, "reason", "not enough cpu")
+	accepted, release := s.monitor.AcceptRequest(req)
+	if !accepted {
+		args = append(args, "reason", "not enough resources")
 		logger.Debugw("rejecting request", args...)
-		return false
+		return false, nil
 	}
 
 	// claim request
 	claimed, err := s.rpcServer.ClaimRequest(context.Background(), req)
 	if err != nil {
+		release()
 		logger.Warnw("could not claim request", err, args...)
-		return false
+		return false, nil
 	} else if !claimed {
-		return false
+		release()
+		return false, nil
 	}
 
-	s.monitor.AcceptRequest(req)
 	logger.Infow("request accepted", args...)
 
-	return true
+	return true, release
 }
 
 func (s *Service) sendResponse(ctx context.Context, req *livekit.StartEgressRequest, info *livekit.EgressInfo, err error) {
 	if err != nil {
-		logger.Infow("bad request",
-			"error", err,
+		args := []interface{}{
 			"egressID", info.EgressId,
 			"requestID", req.RequestId,
 			"senderID", req.SenderId,
-		)
-	}
+		}
+		if errors.IsValidation(err) {
+			logger.Infow("bad request", append(args, "error", err)...)
+		} else {
+			logger.Warnw("could not start egress", err, args...)
+			s.recentErrors.add(info.EgressId, errors.Format(err))
+		}
 
+		// the request is rejected with the same code a failed egress would report
+		err = errors.New(errors.Format(err))
+	}
 	if err = s.rpcServer.SendResponse(ctx, req, info, err); err != nil {
 		logger.Errorw("failed to send response", err)
 	}
 }
 
-func (s *Service) launchHandler(ctx context.Context, req *livekit.StartEgressRequest) {
+func (s *Service) launchHandler(ctx context.Context, req *livekit.StartEgressRequest, release func()) {
 	ctx, span := tracer.Start(ctx, "Service.launchHandler")
 	defer span.End()
 
+	// the resource hold is released once the pipeline is active, or when the handler exits
+	defer release()
+
+	s.confMu.Lock()
 	confString, err := yaml.Marshal(s.conf)
+	s.confMu.Unlock()
 	if err != nil {
 		span.RecordError(err)
 		logger.Errorw("could not marshal config", err)
@@ -250,43 +591,257 @@ func (s *Service) launchHandler(ctx context.Context, req *livekit.StartEgressReq
 
 	cmd := exec.Command("egress",
 		"run-handler",
-		"--config-body", string(confString),
+		// the handler expands the config again
+		"--config-body", config.EscapeEnv(string(confString)),
 		"--request", string(reqString),
 		"--temp-path", tempPath,
 	)
 	cmd.Dir = "/"
+	// chrome and Xvfb join the handler's process group, so that they can be killed with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	s.monitor.EgressStarted(req)
-	s.processes.Store(req.EgressId, &process{
-		req: req,
-		cmd: cmd,
-	})
+	updatesReader, updatesWriter, err := os.Pipe()
+	if err != nil {
+		span.RecordError(err)
+		logger.Errorw("could not create updates pipe", err)
+		return
+	}
+	defer updatesReader.Close()
+
+	bandwidthReader, bandwidthWriter, err := os.Pipe()
+	if err != nil {
+		_ = updatesWriter.Close()
+		span.RecordError(err)
+		logger.Errorw("could not create bandwidth pipe", err)
+		return
+	}
+	defer bandwidthWriter.Close()
+
+	cmd.ExtraFiles = []*os.File{updatesWriter, bandwidthReader}
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", updatesEnv, updatesFd),
+		fmt.Sprintf("%s=%d", bandwidthEnv, bandwidthFd),
+	)
+	// the handler's spans continue the trace
+	cmd.Env = append(cmd.Env, tracing.Environ(ctx)...)
+	if t, ok := s.rpcServer.(handlerTransport); ok {
+		// the handler connects back to the service
+		cmd.Env = append(cmd.Env, t.handlerEnv()...)
+	}
+	if s.conf.ClockOverlay != nil {
+		// clockoverlay renders local time
+		cmd.Env = append(cmd.Env, "TZ=UTC")
+	}
+
+	// already checked by ValidateRequest
+	scheduledStop, _ := params.GetScheduledStop(req)
+	p := &process{
+		req:           req,
+		cmd:           cmd,
+		scheduledStop: scheduledStop,
+		onScheduledStop: func() {
+			s.sendScheduledStop(context.Background(), req.EgressId)
+		},
+	}
+
+	s.processes.Store(req.EgressId, p)
+	s.rooms.add(req)
+	s.instance.recordHandler(req.EgressId, 0)
 
 	defer func() {
+		p.mu.Lock()
+		p.cancelScheduledStop()
+		p.mu.Unlock()
 		s.monitor.EgressEnded(req)
+		s.rooms.remove(req)
 		s.processes.Delete(req.EgressId)
+		s.instance.removeHandler(req.EgressId)
 		logger.Debugw("deleting handler temporary directory", "path", tempPath)
 		_ = os.RemoveAll(tempPath)
 	}()
 
-	if err = cmd.Run(); err != nil {
+	err = cmd.Start()
+	// the child holds its own copies of the updates write end and bandwidth read end
+	_ = updatesWriter.Close()
+	_ = bandwidthReader.Close()
+	if err != nil {
 		logger.Errorw("could not launch handler", err)
+		return
 	}
+
+	s.bandwidth.add(req.EgressId, bandwidthWriter)
+	defer s.bandwidth.remove(req.EgressId)
+
+	s.instance.recordHandler(req.EgressId, cmd.Process.Pid)
+	s.monitor.EgressProcessStarted(req, cmd.Process.Pid)
+
+	egressType := stats.EgressType(req)
+	launchedAt := time.Now()
+	var active sync.Once
+
+	updatesDone := make(chan struct{})
+	go func() {
+		defer close(updatesDone)
+		readUpdates(updatesReader, func(info *livekit.EgressInfo, update *handlerUpdate) {
+			p.mu.Lock()
+			changed := p.info == nil || p.info.Status != info.Status
+			reconnects := countReconnects(update.StreamReconnects) - countReconnects(p.streamReconnects)
+			uploadRetries := update.UploadRetries - p.uploadRetries
+			uploadFailures := update.UploadFailures - p.uploadFailures
+			websocketDropped := update.WebsocketDropped - p.websocketDropped
+			layerSwitches := update.LayerSwitches - p.layerSwitches
+			packetsLost := update.PacketsLost - p.packetsLost
+			packetsReordered := update.PacketsReordered - p.packetsReordered
+			packetsConcealed := update.PacketsConcealed - p.packetsConcealed
+			firstKeyFrame := p.firstKeyFrame == 0 && update.FirstKeyFrame > 0
+			bytesWritten := update.BytesWritten != p.bytesWritten
+			framesDropped := update.FramesDropped - p.framesDropped
+			hookFailures := update.SegmentHookFails - p.segmentHookFails
+			restarts := update.PipelineRestarts - p.pipelineRestarts
+			p.info = info
+			p.paused = update.Paused
+			p.streamReconnects = update.StreamReconnects
+			p.uploadRetries = update.UploadRetries
+			p.uploadFailures = update.UploadFailures
+			p.uploadedBytes = update.UploadedBytes
+			p.uploadSize = update.UploadSize
+			p.websocketDropped = update.WebsocketDropped
+			p.layerSwitches = update.LayerSwitches
+			p.packetsLost = update.PacketsLost
+			p.packetsReordered = update.PacketsReordered
+			p.packetsConcealed = update.PacketsConcealed
+			p.firstKeyFrame = update.FirstKeyFrame
+			p.bytesWritten = update.BytesWritten
+			p.framesDropped = update.FramesDropped
+			p.segmentHookFails = update.SegmentHookFails
+			p.pipelineRestarts = update.PipelineRestarts
+			p.errorCategory = update.ErrorCategory
+			p.updateScheduledStop(info.Status, time.Now())
+			p.mu.Unlock()
+
+			if reconnects > 0 {
+				s.monitor.StreamReconnected(egressType, reconnects)
+			}
+			if uploadRetries > 0 || uploadFailures > 0 {
+				s.monitor.UploadsRetried(egressType, uploadRetries, uploadFailures)
+			}
+			if websocketDropped > 0 {
+				s.monitor.WebsocketDropped(egressType, websocketDropped)
+			}
+			if layerSwitches > 0 {
+				s.monitor.VideoLayerSwitched(egressType, layerSwitches)
+			}
+			if packetsLost > 0 || packetsReordered > 0 || packetsConcealed > 0 {
+				s.monitor.PacketsReceived(egressType, packetsLost, packetsReordered, packetsConcealed)
+			}
+			if firstKeyFrame {
+				s.monitor.RecordFirstKeyFrame(egressType, update.FirstKeyFrame)
+			}
+			if bytesWritten {
+				s.monitor.SetBytesWritten(req, update.BytesWritten)
+			}
+			if hookFailures > 0 {
+				s.monitor.SegmentHooksFailed(egressType, hookFailures)
+			}
+			if restarts > 0 {
+				s.monitor.PipelineRestarted(egressType, restarts)
+			}
+			if framesDropped > 0 || update.MaxQueueDepth > 0 {
+				// also called without new drops, so that the last minute gauge decays
+				s.monitor.FramesDropped(req, framesDropped, update.MaxQueueDepth)
+			}
+
+			s.updateState(info)
+			s.dedupe.update(info)
+			if update.Published != nil {
+				if *update.Published {
+					s.updates.published(info)
+				} else {
+					s.updates.add(info)
+				}
+			}
+			if changed {
+				s.webhooks.Notify(info)
+			}
+
+			if info.Status != livekit.EgressStatus_EGRESS_STARTING {
+				release()
+			}
+			if info.Status == livekit.EgressStatus_EGRESS_ACTIVE {
+				active.Do(func() {
+					s.monitor.EgressStarted(req)
+					s.monitor.RecordStartup(egressType, time.Since(launchedAt))
+				})
+			}
+		}, func(bytesPerSecond int64) {
+			s.bandwidth.report(req.EgressId, bytesPerSecond)
+		})
+	}()
+
+	if err = cmd.Wait(); err != nil {
+		logger.Errorw("handler failed", err, "egressID", req.EgressId)
+	}
+	<-updatesDone
+
+	// a crashed handler leaves chrome and Xvfb running
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+	// or its pulse sink behind
+	switch req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite, *livekit.StartEgressRequest_Web:
+		web.RemovePulseSinks(func(egressID string) bool { return egressID != req.EgressId })
+	}
+
+	// the handler may have crashed before sending its final status
+	if info := p.egressInfo(); !isEnded(info.Status) {
+		info.Status = livekit.EgressStatus_EGRESS_FAILED
+		info.Error = errors.FormatMessage(errors.CodeInternal, handlerExitMessage(err))
+		info.EndedAt = time.Now().UnixNano()
+		s.sendUpdate(ctx, info)
+		s.updateState(info)
+	}
+	if info := p.egressInfo(); info.Status == livekit.EgressStatus_EGRESS_FAILED {
+		s.recentErrors.add(info.EgressId, info.Error)
+	}
+
+	s.monitor.EgressFinished(req, p.result(err))
+	if duration := p.duration(); duration > 0 {
+		s.monitor.RecordDuration(egressType, duration)
+	}
+}
+
+// handlerExitMessage says how a handler which never reported its result exited, e.g. killed by a segfault in
+// gstreamer or a panic, which only takes down that egress
+func handlerExitMessage(waitErr error) string {
+	if waitErr == nil {
+		return "egress handler exited unexpectedly"
+	}
+	return fmt.Sprintf("egress handler exited unexpectedly (%s)", waitErr)
 }
 
 func (s *Service) Status() ([]byte, error) {
-	info := map[string]interface{}{
-		"CpuLoad": s.monitor.GetCPULoad(),
+	return json.Marshal(s.status())
+}
+
+func (s *Service) status() *ServiceStatus {
+	egressCPU := s.monitor.GetEgressCPULoads()
+	status := &ServiceStatus{
+		CpuLoad:        s.monitor.GetCPULoad(),
+		MemoryLoad:     s.monitor.GetMemoryLoad(),
+		EgressCounts:   s.monitor.GetEgressCounts(),
+		AvailableSlots: s.monitor.AvailableSlots(),
+		Draining:       s.draining.Load(),
+		Egresses:       make(map[string]*EgressStatus),
 	}
 	s.processes.Range(func(key, value interface{}) bool {
-		p := value.(*process)
-		info[key.(string)] = p.req.Request
+		egressID := key.(string)
+		status.Egresses[egressID] = value.(*process).status(egressCPU[egressID], s.redactor)
 		return true
 	})
 
-	return json.Marshal(info)
+	return status
 }
 
 func (s *Service) Stop(kill bool) {
@@ -297,15 +852,35 @@ func (s *Service) Stop(kill bool) {
 	}
 
 	if kill {
-		s.processes.Range(func(key, value interface{}) bool {
-			if err := value.(*process).cmd.Process.Signal(syscall.SIGINT); err != nil {
-				logger.Errorw("failed to kill process", err, "egressID", key.(string))
-			}
-			return true
-		})
+		s.killProcesses()
 	}
 }
 
+// SetUploadBandwidthLimit changes the bandwidth limit shared by uploads on the node, including running egresses
+func (s *Service) SetUploadBandwidthLimit(bytesPerSecond int64) {
+	logger.Infow("setting upload bandwidth limit", "bytesPerSecond", bytesPerSecond)
+	s.bandwidth.setLimit(bytesPerSecond)
+}
+
+// Drain stops accepting new requests, and shuts the service down once active egresses have finished
+// or the drain timeout has passed
+func (s *Service) Drain() {
+	if s.draining.Swap(true) {
+		return
+	}
+	logger.Infow("draining", "timeout", s.conf.DrainTimeout)
+	s.Stop(false)
+}
+
+func (s *Service) killProcesses() {
+	s.processes.Range(func(key, value interface{}) bool {
+		if err := value.(*process).cmd.Process.Signal(syscall.SIGINT); err != nil {
+			logger.Errorw("failed to kill process", err, "egressID", key.(string))
+		}
+		return true
+	})
+}
+
 func (s *Service) ListEgress() []string {
 	res := make([]string, 0)
 
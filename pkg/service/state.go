@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	stateKeyPrefix         = "egress_state:"
+	claimKeyPrefix         = "egress_claim:"
+	stateHeartbeatInterval = time.Second * 10
+	// records which have not been refreshed for this long belong to a service which is no longer running
+	stateStaleTimeout = stateHeartbeatInterval * 3
+)
+
+var lostEgressError = errors.FormatMessage(errors.CodeInternal, "egress service stopped unexpectedly")
+
+// egressRecord is the state of an egress kept in redis
+type egressRecord struct {
+	EgressID  string `json:"egress_id"`
+	RoomID    string `json:"room_id,omitempty"`
+	RoomName  string `json:"room_name,omitempty"`
+	Status    string `json:"status"`
+	NodeID    string `json:"node_id"`
+	StartedAt int64  `json:"started_at,omitempty"`
+	UpdatedAt int64  `json:"updated_at"` // heartbeat, unix nanoseconds
+}
+
+// StateStore keeps a record of each egress running on this node in redis, so that egresses lost
+// in a crash can be reported as failed once a service with the same node ID starts again
+type StateStore struct {
+	rc     redis.UniversalClient
+	nodeID string
+	key    string
+}
+
+func NewStateStore(rc redis.UniversalClient, nodeID string) *StateStore {
+	return &StateStore{
+		rc:     rc,
+		nodeID: nodeID,
+		key:    stateKeyPrefix + nodeID,
+	}
+}
+
+// Update stores the latest status of an egress, removing its record once the egress has ended
+func (s *StateStore) Update(ctx context.Context, info *livekit.EgressInfo) error {
+	if isEnded(info.Status) {
+		return s.rc.HDel(ctx, s.key, info.EgressId).Err()
+	}
+
+	b, err := json.Marshal(s.newRecord(info, time.Now()))
+	if err != nil {
+		return err
+	}
+	return s.rc.HSet(ctx, s.key, info.EgressId, b).Err()
+}
+
+// Heartbeat refreshes the records of all active egresses
+func (s *StateStore) Heartbeat(ctx context.Context, infos []*livekit.EgressInfo) error {
+	if len(infos) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	values := make([]interface{}, 0, len(infos)*2)
+	for _, info := range infos {
+		if isEnded(info.Status) {
+			continue
+		}
+		b, err := json.Marshal(s.newRecord(info, now))
+		if err != nil {
+			return err
+		}
+		values = append(values, info.EgressId, b)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	return s.rc.HSet(ctx, s.key, values...).Err()
+}
+
+// RecoverStale removes stale records owned by this node, returning failed infos to be sent for them
+func (s *StateStore) RecoverStale(ctx context.Context) ([]*livekit.EgressInfo, error) {
+	values, err := s.rc.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stale, invalid := findStale(values, s.nodeID, time.Now(), stateStaleTimeout)
+
+	fields := invalid
+	infos := make([]*livekit.EgressInfo, 0, len(stale))
+	for _, record := range stale {
+		fields = append(fields, record.EgressID)
+		infos = append(infos, record.failedInfo())
+	}
+
+	if len(fields) > 0 {
+		if err = s.rc.HDel(ctx, s.key, fields...).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return infos, nil
+}
+
+// ClaimEgress records that this node is starting an egress, returning false if any node claimed it within window
+func (s *StateStore) ClaimEgress(ctx context.Context, egressID string, window time.Duration) (bool, error) {
+	return s.rc.SetNX(ctx, claimKeyPrefix+egressID, s.nodeID, window).Result()
+}
+
+func (s *StateStore) newRecord(info *livekit.EgressInfo, now time.Time) *egressRecord {
+	return &egressRecord{
+		EgressID:  info.EgressId,
+		RoomID:    info.RoomId,
+		RoomName:  info.RoomName,
+		Status:    info.Status.String(),
+		NodeID:    s.nodeID,
+		StartedAt: info.StartedAt,
+		UpdatedAt: now.UnixNano(),
+	}
+}
+
+func (r *egressRecord) failedInfo() *livekit.EgressInfo {
+	return &livekit.EgressInfo{
+		EgressId:  r.EgressID,
+		RoomId:    r.RoomID,
+		RoomName:  r.RoomName,
+		Status:    livekit.EgressStatus_EGRESS_FAILED,
+		StartedAt: r.StartedAt,
+		EndedAt:   time.Now().UnixNano(),
+		Error:     lostEgressError,
+	}
+}
+
+// findStale returns records owned by nodeID which have not been updated within timeout,
+// along with the fields of records which could not be read
+func findStale(values map[string]string, nodeID string, now time.Time, timeout time.Duration) ([]*egressRecord, []string) {
+	var stale []*egressRecord
+	var invalid []string
+
+	for egressID, value := range values {
+		record := &egressRecord{}
+		if err := json.Unmarshal([]byte(value), record); err != nil || record.EgressID != egressID {
+			logger.Warnw("invalid egress record", err, "egressID", egressID)
+			invalid = append(invalid, egressID)
+			continue
+		}
+
+		if record.NodeID != nodeID {
+			continue
+		}
+		if now.Sub(time.Unix(0, record.UpdatedAt)) > timeout {
+			stale = append(stale, record)
+		}
+	}
+
+	return stale, invalid
+}
+
+func isEnded(status livekit.EgressStatus) bool {
+	switch status {
+	case livekit.EgressStatus_EGRESS_COMPLETE,
+		livekit.EgressStatus_EGRESS_FAILED,
+		livekit.EgressStatus_EGRESS_ABORTED,
+		livekit.EgressStatus_EGRESS_LIMIT_REACHED:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+func newRecordValue(t *testing.T, egressID, nodeID string, updatedAt time.Time) string {
+	b, err := json.Marshal(&egressRecord{
+		EgressID:  egressID,
+		Status:    livekit.EgressStatus_EGRESS_ACTIVE.String(),
+		NodeID:    nodeID,
+		UpdatedAt: updatedAt.UnixNano(),
+	})
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestFindStale(t *testing.T) {
+	now := time.Now()
+	values := map[string]string{
+		"fresh":    newRecordValue(t, "fresh", "node", now.Add(-time.Second)),
+		"stale":    newRecordValue(t, "stale", "node", now.Add(-time.Minute)),
+		"boundary": newRecordValue(t, "boundary", "node", now.Add(-stateStaleTimeout)),
+		"other":    newRecordValue(t, "other", "other_node", now.Add(-time.Hour)),
+	}
+
+	stale, invalid := findStale(values, "node", now, stateStaleTimeout)
+	require.Empty(t, invalid)
+	require.Len(t, stale, 1)
+	require.Equal(t, "stale", stale[0].EgressID)
+}
+
+func TestFindStaleInvalidRecords(t *testing.T) {
+	now := time.Now()
+	values := map[string]string{
+		"malformed":  "{",
+		"mismatched": newRecordValue(t, "other_egress", "node", now.Add(-time.Minute)),
+	}
+
+	stale, invalid := findStale(values, "node", now, stateStaleTimeout)
+	require.Empty(t, stale)
+	require.ElementsMatch(t, []string{"malformed", "mismatched"}, invalid)
+}
+
+func TestFailedInfo(t *testing.T) {
+	record := &egressRecord{
+		EgressID:  "egress",
+		RoomID:    "room",
+		RoomName:  "name",
+		Status:    livekit.EgressStatus_EGRESS_ACTIVE.String(),
+		StartedAt: 1,
+	}
+
+	info := record.failedInfo()
+	require.Equal(t, "egress", info.EgressId)
+	require.Equal(t, "room", info.RoomId)
+	require.Equal(t, livekit.EgressStatus_EGRESS_FAILED, info.Status)
+	require.Equal(t, lostEgressError, info.Error)
+	require.Greater(t, info.EndedAt, info.StartedAt)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/egress/pkg/stats"
+	"github.com/livekit/protocol/livekit"
+)
+
+// ServiceStatus is returned by the health endpoint. Field names are part of its API and should not change.
+type ServiceStatus struct {
+	CpuLoad        float64                  `json:"CpuLoad"`
+	MemoryLoad     float64                  `json:"MemoryLoad"`
+	EgressCounts   map[string]int64         `json:"EgressCounts"`
+	AvailableSlots map[string]int           `json:"AvailableSlots"`
+	Draining       bool                     `json:"Draining"`
+	Egresses       map[string]*EgressStatus `json:"Egresses"`
+}
+
+type EgressStatus struct {
+	EgressId         string         `json:"EgressId"`
+	Type             string         `json:"Type"`
+	RoomName         string         `json:"RoomName,omitempty"`
+	Status           string         `json:"Status"`
+	Paused           bool           `json:"Paused,omitempty"`
+	StartedAt        int64          `json:"StartedAt,omitempty"`
+	Duration         int64          `json:"Duration,omitempty"` // nanoseconds since the egress started
+	Outputs          []string       `json:"Outputs,omitempty"`
+	StreamReconnects map[string]int `json:"StreamReconnects,omitempty"` // reconnects made for each stream url
+	Upload           *UploadStatus  `json:"Upload,omitempty"`           // set while the output file is uploaded
+	PipelineRestarts int            `json:"PipelineRestarts,omitempty"` // pipelines rebuilt after failing
+	CpuLoad          float64        `json:"CpuLoad"`
+}
+
+type UploadStatus struct {
+	UploadedBytes int64   `json:"UploadedBytes"`
+	Size          int64   `json:"Size"`
+	Percent       float64 `json:"Percent"`
+}
+
+func (p *process) status(cpu float64, redactor *params.Redactor) *EgressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &EgressStatus{
+		EgressId: p.req.EgressId,
+		Type:     stats.EgressType(p.req),
+		RoomName: getRoomName(p.req),
+		Status:   livekit.EgressStatus_EGRESS_STARTING.String(),
+		CpuLoad:  cpu,
+	}
+
+	if p.info == nil {
+		return s
+	}
+
+	s.Status = p.info.Status.String()
+	s.Paused = p.paused
+	s.PipelineRestarts = p.pipelineRestarts
+	for url, count := range p.streamReconnects {
+		if s.StreamReconnects == nil {
+			s.StreamReconnects = make(map[string]int)
+		}
+		s.StreamReconnects[redactor.RedactUrl(url)] = count
+	}
+	if p.uploadSize > 0 {
+		s.Upload = &UploadStatus{
+			UploadedBytes: p.uploadedBytes,
+			Size:          p.uploadSize,
+			Percent:       float64(p.uploadedBytes) * 100 / float64(p.uploadSize),
+		}
+	}
+	if p.info.RoomName != "" {
+		s.RoomName = p.info.RoomName
+	}
+	if p.info.StartedAt > 0 {
+		s.StartedAt = p.info.StartedAt
+		s.Duration = time.Now().UnixNano() - p.info.StartedAt
+	}
+
+	switch res := p.info.Result.(type) {
+	case *livekit.EgressInfo_File:
+		s.Outputs = []string{res.File.Filename}
+	case *livekit.EgressInfo_Stream:
+		for _, stream := range res.Stream.Info {
+			s.Outputs = append(s.Outputs, redactor.RedactUrl(stream.Url))
+		}
+	case *livekit.EgressInfo_Segments:
+		s.Outputs = []string{res.Segments.PlaylistName}
+	}
+
+	return s
+}
+
+// egressInfo returns the latest info reported by the handler
+func (p *process) egressInfo() *livekit.EgressInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.info != nil {
+		return proto.Clone(p.info).(*livekit.EgressInfo)
+	}
+
+	return &livekit.EgressInfo{
+		EgressId: p.req.EgressId,
+		RoomId:   p.req.RoomId,
+		RoomName: getRoomName(p.req),
+		Status:   livekit.EgressStatus_EGRESS_STARTING,
+	}
+}
+
+func getRoomName(req *livekit.StartEgressRequest) string {
+	switch r := req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		return r.RoomComposite.RoomName
+	case *livekit.StartEgressRequest_TrackComposite:
+		return r.TrackComposite.RoomName
+	case *livekit.StartEgressRequest_Track:
+		return r.Track.RoomName
+	default:
+		return ""
+	}
+}
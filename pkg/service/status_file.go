@@ -0,0 +1,121 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	maxRecentErrors = 20
+
+	// the file is stale once this many writes have been missed
+	statusFileStaleIntervals = 3
+)
+
+// NodeStatus is written to the status file. Field names are part of its API and should not change.
+type NodeStatus struct {
+	*ServiceStatus
+	UpdatedAt    time.Time      `json:"UpdatedAt"`
+	StaleAfter   time.Time      `json:"StaleAfter"` // the service has stopped writing the file once this has passed
+	RecentErrors []*RecentError `json:"RecentErrors"`
+}
+
+type RecentError struct {
+	Time     time.Time `json:"Time"`
+	EgressId string    `json:"EgressId,omitempty"`
+	Error    string    `json:"Error"`
+}
+
+// errorLog keeps the most recent errors
+type errorLog struct {
+	mu     sync.Mutex
+	errors []*RecentError
+}
+
+func (l *errorLog) add(egressID, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, &RecentError{
+		Time:     time.Now(),
+		EgressId: egressID,
+		Error:    msg,
+	})
+	if len(l.errors) > maxRecentErrors {
+		l.errors = l.errors[len(l.errors)-maxRecentErrors:]
+	}
+}
+
+// recent returns the errors, oldest first
+func (l *errorLog) recent() []*RecentError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append(make([]*RecentError, 0, len(l.errors)), l.errors...)
+}
+
+// writeStatusFile writes the node's status each interval. It only depends on local state, so that the file stays
+// up to date while redis is unreachable
+func (s *Service) writeStatusFile(conf *config.StatusFileConfig, stop chan struct{}) {
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		b, err := json.MarshalIndent(&NodeStatus{
+			ServiceStatus: s.status(),
+			UpdatedAt:     now,
+			StaleAfter:    now.Add(conf.Interval * statusFileStaleIntervals),
+			RecentErrors:  s.recentErrors.recent(),
+		}, "", "  ")
+		if err == nil {
+			err = writeFileAtomic(conf.Path, b)
+		}
+		if err != nil {
+			logger.Errorw("failed to write status file", err, "path", conf.Path)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeFileAtomic replaces the file by renaming a temp file written next to it, so that readers always see a
+// complete file
+func writeFileAtomic(filepath string, data []byte) error {
+	dir, filename := path.Split(filepath)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := os.CreateTemp(dir, "."+filename+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// temp files are only readable by their owner
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	filepath := path.Join(t.TempDir(), "status.json")
+
+	docs := make([][]byte, 2)
+	for i := range docs {
+		status := &NodeStatus{
+			ServiceStatus: &ServiceStatus{Egresses: make(map[string]*EgressStatus)},
+			UpdatedAt:     time.Now(),
+		}
+		// large enough to take more than one write
+		for j := 0; j < 2000; j++ {
+			egressID := fmt.Sprintf("EG_%d_%d", i, j)
+			status.Egresses[egressID] = &EgressStatus{EgressId: egressID, Status: strings.Repeat("x", 100)}
+		}
+		b, err := json.Marshal(status)
+		require.NoError(t, err)
+		docs[i] = b
+	}
+	require.NoError(t, writeFileAtomic(filepath, docs[0]))
+
+	done := make(chan struct{})
+	var writeErr error
+	go func() {
+		defer close(done)
+		for i := 0; i < 100 && writeErr == nil; i++ {
+			writeErr = writeFileAtomic(filepath, docs[i%2])
+		}
+	}()
+
+	// readers only ever see a complete document
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		b, err := os.ReadFile(filepath)
+		require.NoError(t, err)
+		require.True(t, json.Valid(b))
+	}
+	require.NoError(t, writeErr)
+
+	entries, err := os.ReadDir(path.Dir(filepath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
+
+func TestErrorLog(t *testing.T) {
+	l := &errorLog{}
+	for i := 0; i < maxRecentErrors+5; i++ {
+		l.add(fmt.Sprintf("EG_%d", i), "failed")
+	}
+
+	recent := l.recent()
+	require.Len(t, recent, maxRecentErrors)
+	require.Equal(t, "EG_5", recent[0].EgressId)
+	require.Equal(t, fmt.Sprintf("EG_%d", maxRecentErrors+4), recent[maxRecentErrors-1].EgressId)
+}
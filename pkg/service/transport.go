@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/egress"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+// how long a client waits for a node to respond, as with the protocol's redis rpc
+const rpcRequestTimeout = time.Second * 3
+
+// Transport carries egress requests to the service and its handlers, and their responses and updates back, over
+// redis or grpc
+type Transport struct {
+	RPCServer RPCServer
+	// nil without redis, in which case egresses lost in a crash are not reported
+	State *StateStore
+
+	close func()
+}
+
+// handlerTransport is implemented by rpc servers whose handlers connect back to the service
+type handlerTransport interface {
+	// handlerEnv is added to the environment of each handler
+	handlerEnv() []string
+}
+
+// NewServiceTransport connects the service to redis, or starts its grpc server if grpc is configured. With grpc,
+// redis is optional, and only used to store egress state
+func NewServiceTransport(conf *config.Config) (*Transport, error) {
+	var rc *redis.Client
+	if conf.Redis != nil {
+		var err error
+		if rc, err = NewRedisClient(conf.Redis); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &Transport{
+		close: func() {},
+	}
+	if rc != nil {
+		t.State = NewStateStore(rc, conf.NodeID)
+		t.close = func() { _ = rc.Close() }
+	}
+
+	if conf.GRPC == nil {
+		t.RPCServer = NewRPCServer(egress.NewRedisRPCServer(rc), utils.NewRedisMessageBus(rc))
+		return t, nil
+	}
+
+	bus := newLocalBus()
+	stopGRPC, err := startGRPCServer(conf.GRPC, bus)
+	if err != nil {
+		t.close()
+		return nil, err
+	}
+	socket, stopSocket, err := startHandlerSocket(conf.NodeID, bus)
+	if err != nil {
+		stopGRPC()
+		t.close()
+		return nil, err
+	}
+
+	closeRedis := t.close
+	t.RPCServer = &socketRPCServer{
+		RPCServer: NewRPCServer(newBusRPC(bus), bus),
+		socket:    socket,
+	}
+	t.close = func() {
+		stopGRPC()
+		stopSocket()
+		closeRedis()
+	}
+	return t, nil
+}
+
+// NewHandlerTransport connects a handler to redis, or with grpc, to the service which launched it
+func NewHandlerTransport(conf *config.Config) (*Transport, error) {
+	if conf.GRPC == nil {
+		rc, err := NewRedisClient(conf.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return &Transport{
+			RPCServer: NewRPCServer(egress.NewRedisRPCServer(rc), utils.NewRedisMessageBus(rc)),
+			close:     func() { _ = rc.Close() },
+		}, nil
+	}
+
+	socket := os.Getenv(handlerSocketEnv)
+	if socket == "" {
+		return nil, errors.New("handler socket not set, grpc handlers must be launched by the service")
+	}
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	bus := newBusClient(conn)
+	return &Transport{
+		RPCServer: NewRPCServer(newBusRPC(bus), bus),
+		close:     func() { _ = conn.Close() },
+	}, nil
+}
+
+// Close stops the grpc server and disconnects from redis
+func (t *Transport) Close() {
+	t.close()
+}
+
+// socketRPCServer tells handlers where to find the service's handler socket
+type socketRPCServer struct {
+	RPCServer
+	socket string
+}
+
+func (r *socketRPCServer) handlerEnv() []string {
+	return []string{handlerSocketEnv + "=" + r.socket}
+}
+
+// busRPC is the protocol's egress rpc over any message bus. Requests are not claimed, since the bus only reaches
+// one node
+type busRPC struct {
+	bus utils.MessageBus
+}
+
+func newBusRPC(bus utils.MessageBus) *busRPC {
+	return &busRPC{bus: bus}
+}
+
+func (r *busRPC) GetRequestChannel(ctx context.Context) (utils.PubSub, error) {
+	return r.bus.Subscribe(ctx, startChannel)
+}
+
+func (r *busRPC) ClaimRequest(_ context.Context, _ *livekit.StartEgressRequest) (bool, error) {
+	return true, nil
+}
+
+func (r *busRPC) EgressSubscription(ctx context.Context, egressID string) (utils.PubSub, error) {
+	return r.bus.Subscribe(ctx, requestChannelBase+egressID)
+}
+
+func (r *busRPC) SendResponse(ctx context.Context, request proto.Message, info *livekit.EgressInfo, err error) error {
+	res := &livekit.EgressResponse{
+		Info: info,
+	}
+
+	switch req := request.(type) {
+	case *livekit.StartEgressRequest:
+		res.RequestId = req.RequestId
+	case *livekit.EgressRequest:
+		res.RequestId = req.RequestId
+	}
+
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return r.bus.Publish(ctx, responseChannelBase+res.RequestId, res)
+}
+
+func (r *busRPC) SendUpdate(ctx context.Context, info *livekit.EgressInfo) error {
+	return r.bus.Publish(ctx, updateChannel, info)
+}
+
+// GetUpdateChannel subscribes to every update, unlike the protocol's client which shares them with other servers
+func (r *busRPC) GetUpdateChannel(ctx context.Context) (utils.PubSub, error) {
+	return r.bus.Subscribe(ctx, updateChannel)
+}
+
+// SendRequest sends a request to the service and waits for its response
+func (r *busRPC) SendRequest(ctx context.Context, request proto.Message) (*livekit.EgressInfo, error) {
+	requestID := utils.NewGuid(utils.RPCPrefix)
+	var channel string
+
+	switch req := request.(type) {
+	case *livekit.StartEgressRequest:
+		if req.EgressId == "" {
+			req.EgressId = utils.NewGuid(utils.EgressPrefix)
+		}
+		req.RequestId = requestID
+		req.SentAt = time.Now().UnixNano()
+		channel = startChannel
+
+	case *livekit.EgressRequest:
+		req.RequestId = requestID
+		channel = requestChannelBase + req.EgressId
+
+	default:
+		return nil, errors.New("invalid request type")
+	}
+
+	sub, err := r.bus.Subscribe(ctx, responseChannelBase+requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			logger.Errorw("failed to unsubscribe from response channel", err)
+		}
+	}()
+
+	if err = r.bus.Publish(ctx, channel, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg, ok := <-sub.Channel():
+		if !ok {
+			return nil, errSubscriptionClosed
+		}
+		res := &livekit.EgressResponse{}
+		if err = proto.Unmarshal(sub.Payload(msg), res); err != nil {
+			return nil, err
+		}
+		if res.Error != "" {
+			return nil, errors.New(res.Error)
+		}
+		return res.Info, nil
+
+	case <-time.After(rpcRequestTimeout):
+		return nil, egress.ErrNoResponse
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	updateBufferSize    = 1000
+	updateRetryInterval = time.Second
+	// how long a stopping service keeps trying to publish buffered updates
+	updateFlushTimeout = time.Second * 10
+)
+
+// updateBuffer holds egress updates which could not be published while redis was unavailable, and replays them
+// once it is back. Only the newest update of each egress is kept, and when the buffer is full, egresses which are
+// still running are dropped before ended ones, so that their final status is the last thing lost.
+type updateBuffer struct {
+	send     func(ctx context.Context, info *livekit.EgressInfo) error
+	size     int
+	interval time.Duration
+	onReplay func()
+	onDrop   func()
+
+	mu      sync.Mutex
+	pending map[string]*livekit.EgressInfo
+	order   []string // egress IDs, oldest update first
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newUpdateBuffer(
+	size int,
+	interval time.Duration,
+	send func(ctx context.Context, info *livekit.EgressInfo) error,
+	onReplay, onDrop func(),
+) *updateBuffer {
+	b := &updateBuffer{
+		send:     send,
+		size:     size,
+		interval: interval,
+		onReplay: onReplay,
+		onDrop:   onDrop,
+		pending:  make(map[string]*livekit.EgressInfo),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Send publishes info, or buffers it if that fails. Updates are buffered without being sent while earlier ones are
+// waiting, so that they go out in order.
+func (b *updateBuffer) Send(ctx context.Context, info *livekit.EgressInfo) {
+	b.mu.Lock()
+	waiting := len(b.pending) > 0
+	b.mu.Unlock()
+
+	if !waiting {
+		err := b.send(ctx, info)
+		if err == nil {
+			return
+		}
+		logger.Warnw("failed to send update, buffering until redis is available", err, "egressID", info.EgressId)
+	}
+	b.add(info)
+}
+
+// add buffers info, replacing any earlier update of the same egress
+func (b *updateBuffer) add(info *livekit.EgressInfo) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		logger.Warnw("update buffer stopped, dropping update", nil, "egressID", info.EgressId, "status", info.Status)
+		b.onDrop()
+		return
+	}
+
+	if _, ok := b.pending[info.EgressId]; ok {
+		b.remove(info.EgressId)
+	} else if len(b.order) >= b.size {
+		b.evict()
+	}
+	b.pending[info.EgressId] = info
+	b.order = append(b.order, info.EgressId)
+}
+
+// published discards the buffered update of an egress whose handler has since published a newer one
+func (b *updateBuffer) published(info *livekit.EgressInfo) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.pending[info.EgressId]; ok {
+		b.remove(info.EgressId)
+	}
+}
+
+// evict drops the oldest update of a running egress, or the oldest update if every egress has ended.
+// Called with the lock held
+func (b *updateBuffer) evict() {
+	egressID := b.order[0]
+	for _, id := range b.order {
+		if !isEnded(b.pending[id].Status) {
+			egressID = id
+			break
+		}
+	}
+
+	info := b.pending[egressID]
+	logger.Warnw("update buffer full, dropping update", nil, "egressID", egressID, "status", info.Status)
+	b.remove(egressID)
+	b.onDrop()
+}
+
+// remove is called with the lock held
+func (b *updateBuffer) remove(egressID string) {
+	delete(b.pending, egressID)
+	for i, id := range b.order {
+		if id == egressID {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Stop tries to publish buffered updates for a while, then drops the rest
+func (b *updateBuffer) Stop() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+	}
+	b.mu.Unlock()
+
+	<-b.done
+}
+
+func (b *updateBuffer) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flush(context.Background())
+
+		case <-b.stop:
+			ctx, cancel := context.WithTimeout(context.Background(), updateFlushTimeout)
+			b.flush(ctx)
+			cancel()
+
+			b.mu.Lock()
+			for _, egressID := range b.order {
+				logger.Warnw("redis unavailable, dropping update", nil, "egressID", egressID, "status", b.pending[egressID].Status)
+				b.onDrop()
+			}
+			b.pending = make(map[string]*livekit.EgressInfo)
+			b.order = nil
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// flush publishes buffered updates in order, until one fails
+func (b *updateBuffer) flush(ctx context.Context) {
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		if len(b.order) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		info := b.pending[b.order[0]]
+		b.mu.Unlock()
+
+		if err := b.send(ctx, info); err != nil {
+			logger.Debugw("failed to replay update", "error", err, "egressID", info.EgressId)
+			return
+		}
+
+		b.mu.Lock()
+		// keep a newer update which was buffered during the send
+		if b.pending[info.EgressId] == info {
+			b.remove(info.EgressId)
+		}
+		b.mu.Unlock()
+
+		logger.Infow("replayed update", "egressID", info.EgressId, "status", info.Status)
+		b.onReplay()
+	}
+}
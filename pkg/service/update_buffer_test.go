@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+// fakePublisher records updates while up, and fails while down
+type fakePublisher struct {
+	mu   sync.Mutex
+	up   bool
+	sent []*livekit.EgressInfo
+}
+
+func (f *fakePublisher) send(_ context.Context, info *livekit.EgressInfo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.up {
+		return errors.New("redis unavailable")
+	}
+	f.sent = append(f.sent, info)
+	return nil
+}
+
+func (f *fakePublisher) setUp(up bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up = up
+}
+
+func (f *fakePublisher) statuses() map[string]livekit.EgressStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	statuses := make(map[string]livekit.EgressStatus)
+	for _, info := range f.sent {
+		statuses[info.EgressId] = info.Status
+	}
+	return statuses
+}
+
+func TestUpdateBuffer(t *testing.T) {
+	publisher := &fakePublisher{up: true}
+	var replayed, dropped atomic.Int32
+	b := newUpdateBuffer(2, time.Millisecond*10, publisher.send,
+		func() { replayed.Inc() }, func() { dropped.Inc() })
+	ctx := context.Background()
+
+	b.Send(ctx, &livekit.EgressInfo{EgressId: "EG_1", Status: livekit.EgressStatus_EGRESS_STARTING})
+	require.Len(t, publisher.statuses(), 1)
+
+	publisher.setUp(false)
+	b.Send(ctx, &livekit.EgressInfo{EgressId: "EG_1", Status: livekit.EgressStatus_EGRESS_ACTIVE})
+	b.Send(ctx, &livekit.EgressInfo{EgressId: "EG_2", Status: livekit.EgressStatus_EGRESS_ACTIVE})
+	// the newest update of each egress wins
+	b.Send(ctx, &livekit.EgressInfo{EgressId: "EG_1", Status: livekit.EgressStatus_EGRESS_COMPLETE})
+	// a full buffer drops running egresses before ended ones
+	b.add(&livekit.EgressInfo{EgressId: "EG_3", Status: livekit.EgressStatus_EGRESS_FAILED})
+	require.Equal(t, int32(1), dropped.Load())
+
+	publisher.setUp(true)
+	require.Eventually(t, func() bool { return replayed.Load() == 2 }, time.Second, time.Millisecond*10)
+	require.Equal(t, map[string]livekit.EgressStatus{
+		"EG_1": livekit.EgressStatus_EGRESS_COMPLETE,
+		"EG_3": livekit.EgressStatus_EGRESS_FAILED,
+	}, publisher.statuses())
+
+	// updates the handler published itself aren't replayed
+	publisher.setUp(false)
+	b.add(&livekit.EgressInfo{EgressId: "EG_4", Status: livekit.EgressStatus_EGRESS_ACTIVE})
+	b.published(&livekit.EgressInfo{EgressId: "EG_4", Status: livekit.EgressStatus_EGRESS_COMPLETE})
+
+	// what can't be sent on stop is dropped
+	b.add(&livekit.EgressInfo{EgressId: "EG_5", Status: livekit.EgressStatus_EGRESS_COMPLETE})
+	b.Stop()
+	require.Equal(t, int32(2), replayed.Load())
+	require.Equal(t, int32(2), dropped.Load())
+	b.add(&livekit.EgressInfo{EgressId: "EG_6", Status: livekit.EgressStatus_EGRESS_COMPLETE})
+	require.Equal(t, int32(3), dropped.Load())
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	// the handler writes its egress updates to this file descriptor, which the service passes in as ExtraFiles[0]
+	updatesFd = 3
+	// set by the service when launching a handler with an updates pipe
+	updatesEnv = "EGRESS_UPDATES_FD"
+)
+
+type handlerUpdate struct {
+	Info             json.RawMessage `json:"info"`
+	Paused           bool            `json:"paused,omitempty"`
+	StreamReconnects map[string]int  `json:"stream_reconnects,omitempty"`
+	UploadRetries    int             `json:"upload_retries,omitempty"`
+	UploadFailures   int             `json:"upload_failures,omitempty"`
+	UploadedBytes    int64           `json:"uploaded_bytes,omitempty"`
+	UploadSize       int64           `json:"upload_size,omitempty"`
+	WebsocketDropped int64           `json:"websocket_dropped,omitempty"`
+	LayerSwitches    int             `json:"layer_switches,omitempty"`
+	PacketsLost      int64           `json:"packets_lost,omitempty"`
+	PacketsReordered int64           `json:"packets_reordered,omitempty"`
+	PacketsConcealed int64           `json:"packets_concealed,omitempty"`
+	FirstKeyFrame    time.Duration   `json:"first_key_frame,omitempty"`
+	BytesWritten     int64           `json:"bytes_written,omitempty"`
+	FramesDropped    int64           `json:"frames_dropped,omitempty"`
+	MaxQueueDepth    int             `json:"max_queue_depth,omitempty"`
+	SegmentHookFails int             `json:"segment_hook_failures,omitempty"`
+	PipelineRestarts int             `json:"pipeline_restarts,omitempty"`
+	ErrorCategory    string          `json:"error_category,omitempty"`
+
+	// whether the handler published info, unset for progress updates. The service replays those it couldn't
+	Published *bool `json:"published,omitempty"`
+
+	// bytes per second, sent on its own while the handler is uploading
+	UploadRate *int64 `json:"upload_rate,omitempty"`
+}
+
+// updateWriter forwards EgressInfo updates from the handler process back to the service
+type updateWriter struct {
+	w io.Writer
+}
+
+func newUpdateWriter() *updateWriter {
+	if os.Getenv(updatesEnv) == "" {
+		// not launched by the service
+		return nil
+	}
+
+	f := os.NewFile(updatesFd, "updates")
+	if f == nil {
+		return nil
+	}
+	if _, err := f.Stat(); err != nil {
+		logger.Errorw("updates pipe unavailable", err)
+		return nil
+	}
+
+	// keep chrome and other children from holding the pipe open
+	syscall.CloseOnExec(updatesFd)
+	return &updateWriter{w: f}
+}
+
+// write forwards info and the handler state in update, along with the category of egressErr if the egress failed
+func (u *updateWriter) write(info *livekit.EgressInfo, update handlerUpdate, egressErr error) {
+	if u == nil {
+		return
+	}
+
+	infoBytes, err := protojson.Marshal(info)
+	if err != nil {
+		logger.Errorw("failed to marshal update", err)
+		return
+	}
+
+	update.Info = infoBytes
+	if egressErr != nil {
+		update.ErrorCategory = errors.Category(egressErr)
+	}
+
+	b, err := json.Marshal(update)
+	if err != nil {
+		logger.Errorw("failed to marshal update", err)
+		return
+	}
+
+	if _, err = u.w.Write(append(b, '\n')); err != nil {
+		logger.Errorw("failed to forward update", err)
+	}
+}
+
+// writeUploadRate reports how fast the handler is uploading, without an egress update
+func (u *updateWriter) writeUploadRate(bytesPerSecond int64) {
+	if u == nil {
+		return
+	}
+
+	b, err := json.Marshal(handlerUpdate{UploadRate: &bytesPerSecond})
+	if err != nil {
+		logger.Errorw("failed to marshal update", err)
+		return
+	}
+
+	if _, err = u.w.Write(append(b, '\n')); err != nil {
+		logger.Errorw("failed to forward update", err)
+	}
+}
+
+// readUpdates reads EgressInfo updates and upload rates written by the handler process until it exits
+func readUpdates(r io.Reader, onUpdate func(info *livekit.EgressInfo, update *handlerUpdate), onUploadRate func(bytesPerSecond int64)) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		update := &handlerUpdate{}
+		if err := json.Unmarshal(scanner.Bytes(), update); err != nil {
+			logger.Errorw("failed to read update", err)
+			continue
+		}
+		if update.UploadRate != nil {
+			onUploadRate(*update.UploadRate)
+			continue
+		}
+
+		info := &livekit.EgressInfo{}
+		if err := protojson.Unmarshal(update.Info, info); err != nil {
+			logger.Errorw("failed to read update", err)
+			continue
+		}
+
+		onUpdate(info, update)
+	}
+}
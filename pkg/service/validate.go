@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/params"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+// checks run by a dry run
+const (
+	CheckRequest  = "request"  // the request params, including codecs, containers and the template allowlist
+	CheckUpload   = "upload"   // storage config and credentials
+	CheckTemplate = "template" // the room composite template or web page can be loaded
+	CheckOutput   = "output"   // stream and websocket hosts can be resolved
+)
+
+// connectivity checks share this timeout
+const dryRunTimeout = time.Second * 5
+
+// ValidationFailure is a check which a dry run request failed
+type ValidationFailure struct {
+	Check string // one of the checks above
+	Code  string // error code, as in EgressInfo.Error
+	Error string
+}
+
+func (s *Service) handleValidateRequest(payload []byte) {
+	req := &livekit.StartEgressRequest{}
+	if err := proto.Unmarshal(payload, req); err != nil {
+		logger.Errorw("malformed validate request", err)
+		return
+	}
+	if req.RequestId == "" {
+		logger.Errorw("malformed validate request", errors.ErrInvalidInput("request_id"))
+		return
+	}
+
+	failures := s.dryRun(context.Background(), req)
+	logger.Debugw("validated request", "requestID", req.RequestId, "failures", len(failures))
+
+	if err := s.rpcServer.SendValidateResponse(context.Background(), req.RequestId, failures); err != nil {
+		logger.Errorw("failed to send validate response", err)
+	}
+}
+
+// dryRun validates a request and checks that its template, storage and stream hosts can be reached.
+// It doesn't join the room, launch chrome or build a pipeline, and isn't subject to admission checks
+func (s *Service) dryRun(ctx context.Context, req *livekit.StartEgressRequest) []*ValidationFailure {
+	// validation can create the egress' local directory, so it uses an egress ID of its own which can be cleaned up
+	req = proto.Clone(req).(*livekit.StartEgressRequest)
+	req.EgressId = utils.NewGuid(utils.EgressPrefix)
+	defer func() {
+		_ = os.RemoveAll(path.Join(s.conf.LocalOutputDirectory, req.EgressId))
+	}()
+
+	p, err := params.GetPipelineParams(ctx, s.conf, req)
+	if err != nil {
+		// the remaining checks need valid params
+		return []*ValidationFailure{s.validationFailure(CheckRequest, err)}
+	}
+
+	var failures []*ValidationFailure
+	if p.UploadConfig != nil {
+		if _, err = uploader.New(s.conf, p.UploadConfig, nil); err != nil {
+			failures = append(failures, s.validationFailure(CheckUpload, err))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dryRunTimeout)
+	defer cancel()
+
+	switch req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		if err = checkReachable(ctx, p.TemplateBase); err != nil {
+			failures = append(failures, s.validationFailure(CheckTemplate, err))
+		}
+	case *livekit.StartEgressRequest_Web:
+		if err = checkReachable(ctx, p.WebUrl); err != nil {
+			failures = append(failures, s.validationFailure(CheckTemplate, err))
+		}
+	}
+
+	outputs := p.StreamUrls
+	if p.WebsocketUrl != "" {
+		outputs = append(outputs, p.WebsocketUrl)
+	}
+	for _, output := range outputs {
+		if err = checkResolvable(ctx, output); err != nil {
+			failures = append(failures, s.validationFailure(CheckOutput, err))
+		}
+	}
+
+	return failures
+}
+
+func (s *Service) validationFailure(check string, err error) *ValidationFailure {
+	return &ValidationFailure{
+		Check: check,
+		Code:  errors.Code(err),
+		Error: s.redactor.RedactUrls(err.Error()),
+	}
+}
+
+// checkReachable sends a HEAD request to a page. Query params are left out of errors, since they can hold tokens
+func checkReachable(ctx context.Context, rawUrl string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawUrl, nil)
+	if err != nil {
+		return errors.ErrInvalidInput("url")
+	}
+
+	u := *req.URL
+	u.RawQuery, u.Fragment = "", ""
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return errors.WithCode(errors.CategorySource, errors.CodeSourceFailed, fmt.Errorf("could not reach %s: %v", u.String(), err))
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode < http.StatusBadRequest,
+		// the page is served, but not to HEAD requests
+		res.StatusCode == http.StatusMethodNotAllowed,
+		res.StatusCode == http.StatusNotImplemented:
+		return nil
+	default:
+		return errors.WithCode(errors.CategorySource, errors.CodeSourceFailed, fmt.Errorf("%s returned status %d", u.String(), res.StatusCode))
+	}
+}
+
+// checkResolvable looks up the host of a stream or websocket url
+func checkResolvable(ctx context.Context, rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Hostname() == "" {
+		return errors.ErrInvalidInput("url")
+	}
+
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, err = net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return errors.WithCode(errors.CategoryOutput, errors.CodeStreamFailed, fmt.Errorf("could not resolve %s: %v", host, err))
+	}
+	return nil
+}
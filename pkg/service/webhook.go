@@ -0,0 +1,202 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+	"github.com/livekit/protocol/webhook"
+)
+
+const (
+	webhookTimeout       = time.Second * 10
+	webhookBaseBackoff   = time.Second
+	webhookQueueSize     = 100
+	webhookTokenTTL      = time.Minute * 5
+	webhookContentType   = "application/webhook+json"
+	webhookAuthHeader    = "Authorization"
+	webhookEventIDPrefix = "EV_"
+)
+
+// webhookSender posts egress status changes to the configured webhook, in order.
+// Payloads are signed the same way as livekit server webhooks, so they can be read with webhook.ReceiveWebhookEvent.
+type webhookSender struct {
+	client    *http.Client
+	onFailure func()
+
+	mu     sync.Mutex
+	conf   *config.WebhookConfig
+	closed bool
+	queue  chan *livekit.WebhookEvent
+	done   chan struct{}
+}
+
+// newWebhookSender returns nil if no webhook is configured
+func newWebhookSender(conf *config.WebhookConfig, onFailure func()) *webhookSender {
+	if conf == nil || conf.URL == "" {
+		return nil
+	}
+
+	w := &webhookSender{
+		conf:      conf,
+		client:    &http.Client{Timeout: webhookTimeout},
+		onFailure: onFailure,
+		queue:     make(chan *livekit.WebhookEvent, webhookQueueSize),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Notify queues info to be sent
+func (w *webhookSender) Notify(info *livekit.EgressInfo) {
+	if w == nil {
+		return
+	}
+
+	event := &livekit.WebhookEvent{
+		Event:      getWebhookEvent(info.Status),
+		EgressInfo: info,
+		Id:         utils.NewGuid(webhookEventIDPrefix),
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		logger.Warnw("webhook sender stopped, dropping event", nil, "egressID", info.EgressId, "event", event.Event)
+		w.onFailure()
+		return
+	}
+
+	select {
+	case w.queue <- event:
+	default:
+		logger.Warnw("webhook queue full, dropping event", nil, "egressID", info.EgressId, "event", event.Event)
+		w.onFailure()
+	}
+}
+
+// setConfig changes the url and keys used for events sent from now on
+func (w *webhookSender) setConfig(conf *config.WebhookConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.conf = conf
+}
+
+func (w *webhookSender) getConfig() *config.WebhookConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conf
+}
+
+// Stop sends any queued events, then stops the sender
+func (w *webhookSender) Stop() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+func (w *webhookSender) run() {
+	defer close(w.done)
+
+	for event := range w.queue {
+		if err := w.send(event); err != nil {
+			logger.Warnw("failed to send webhook", err,
+				"egressID", event.EgressInfo.EgressId,
+				"event", event.Event,
+			)
+			w.onFailure()
+		}
+	}
+}
+
+// send posts event, retrying with exponential backoff on connection errors and 5xx responses
+func (w *webhookSender) send(event *livekit.WebhookEvent) error {
+	body, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	conf := w.getConfig()
+	sum := sha256.Sum256(body)
+	token, err := auth.NewAccessToken(conf.ApiKey, conf.ApiSecret).
+		SetValidFor(webhookTokenTTL).
+		SetSha256(base64.StdEncoding.EncodeToString(sum[:])).
+		ToJWT()
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookBaseBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(conf.URL, body, token)
+		if err == nil || !retry || attempt >= conf.Retries {
+			return err
+		}
+
+		logger.Debugw("retrying webhook", "error", err, "attempt", attempt+1, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post returns an error if the event was not accepted, and whether it should be retried
+func (w *webhookSender) post(url string, body []byte, token string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(webhookAuthHeader, token)
+	// a custom mime type ensures the signature is checked before parsing
+	req.Header.Set("Content-Type", webhookContentType)
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", res.Status)
+	case res.StatusCode >= 300:
+		return false, fmt.Errorf("webhook returned %s", res.Status)
+	default:
+		return false, nil
+	}
+}
+
+func getWebhookEvent(status livekit.EgressStatus) string {
+	switch status {
+	case livekit.EgressStatus_EGRESS_STARTING:
+		return webhook.EventEgressStarted
+	case livekit.EgressStatus_EGRESS_ACTIVE, livekit.EgressStatus_EGRESS_ENDING:
+		return webhook.EventEgressUpdated
+	default:
+		return webhook.EventEgressEnded
+	}
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"syscall"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// DiskStats samples free space on the filesystem holding a directory
+type DiskStats struct {
+	path string
+
+	freeBytes      atomic.Uint64
+	updateCallback func(freeBytes uint64)
+	closeChan      chan struct{}
+}
+
+func NewDiskStats(path string, updateCallback func(freeBytes uint64)) (*DiskStats, error) {
+	d := &DiskStats{
+		path:           path,
+		updateCallback: updateCallback,
+		closeChan:      make(chan struct{}),
+	}
+
+	free, err := GetFreeDisk(path)
+	if err != nil {
+		return nil, err
+	}
+	d.freeBytes.Store(free)
+
+	go d.monitorDiskUsage()
+
+	return d, nil
+}
+
+// GetFreeBytes returns free space available to unprivileged users, in bytes
+func (d *DiskStats) GetFreeBytes() uint64 {
+	return d.freeBytes.Load()
+}
+
+func (d *DiskStats) Stop() {
+	close(d.closeChan)
+}
+
+func (d *DiskStats) monitorDiskUsage() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closeChan:
+			return
+		case <-ticker.C:
+			free, err := GetFreeDisk(d.path)
+			if err != nil {
+				logger.Errorw("failed retrieving disk usage", err)
+				continue
+			}
+
+			d.freeBytes.Store(free)
+			if d.updateCallback != nil {
+				d.updateCallback(free)
+			}
+		}
+	}
+}
+
+// GetFreeDisk returns the free space in bytes on the filesystem containing path
+func GetFreeDisk(path string) (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return fs.Bavail * uint64(fs.Bsize), nil
+}
@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	nvidiaSmi       = "nvidia-smi"
+	gpuPollInterval = time.Second * 2
+	gpuQueryTimeout = time.Second * 5
+)
+
+// GPUStats polls nvidia-smi for utilization (averaged across GPUs) and encoder sessions (summed across GPUs)
+type GPUStats struct {
+	path string
+
+	utilization     atomic.Float64
+	encoderSessions atomic.Int64
+	updateCallback  func(utilization float64, encoderSessions int64)
+	closeChan       chan struct{}
+}
+
+// NewGPUStats returns nil if no nvidia GPU is present
+func NewGPUStats(updateCallback func(utilization float64, encoderSessions int64)) *GPUStats {
+	path, err := exec.LookPath(nvidiaSmi)
+	if err != nil {
+		return nil
+	}
+
+	g := &GPUStats{
+		path:           path,
+		updateCallback: updateCallback,
+		closeChan:      make(chan struct{}),
+	}
+
+	if err = g.sample(); err != nil {
+		logger.Debugw("gpu stats unavailable", "error", err)
+		return nil
+	}
+
+	go g.monitorGPUUsage()
+
+	return g
+}
+
+// GetUtilization returns average GPU utilization, from 0 to 100
+func (g *GPUStats) GetUtilization() float64 {
+	return g.utilization.Load()
+}
+
+func (g *GPUStats) GetEncoderSessions() int64 {
+	return g.encoderSessions.Load()
+}
+
+func (g *GPUStats) Stop() {
+	close(g.closeChan)
+}
+
+func (g *GPUStats) monitorGPUUsage() {
+	ticker := time.NewTicker(gpuPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.closeChan:
+			return
+		case <-ticker.C:
+			if err := g.sample(); err != nil {
+				logger.Errorw("failed retrieving gpu usage", err)
+				continue
+			}
+
+			if g.updateCallback != nil {
+				g.updateCallback(g.utilization.Load(), g.encoderSessions.Load())
+			}
+		}
+	}
+}
+
+func (g *GPUStats) sample() error {
+	ctx, cancel := context.WithTimeout(context.Background(), gpuQueryTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, g.path,
+		"--query-gpu=utilization.gpu,encoder.stats.sessionCount",
+		"--format=csv,noheader,nounits",
+	).Output()
+	if err != nil {
+		return err
+	}
+
+	utilization, sessions, err := parseGPUStats(string(out))
+	if err != nil {
+		return err
+	}
+
+	g.utilization.Store(utilization)
+	g.encoderSessions.Store(sessions)
+	return nil
+}
+
+func parseGPUStats(out string) (float64, int64, error) {
+	var total float64
+	var sessions int64
+	var count int
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			continue
+		}
+
+		utilization, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		s, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		total += utilization
+		sessions += s
+		count++
+	}
+
+	if count == 0 {
+		return 0, 0, errors.New("could not parse gpu stats")
+	}
+
+	return total / float64(count), sessions, nil
+}
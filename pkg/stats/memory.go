@@ -0,0 +1,198 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/protocol/logger"
+)
+
+const bytesPerGB = 1 << 30
+
+// cgroup memory files, in the order they're tried. Variables so that tests can read fixtures instead
+var (
+	cgroupMemoryFiles = []cgroupMemoryFile{
+		{
+			usage:    "/sys/fs/cgroup/memory.current",
+			limit:    "/sys/fs/cgroup/memory.max",
+			stat:     "/sys/fs/cgroup/memory.stat",
+			inactive: "inactive_file",
+		},
+		{
+			usage:    "/sys/fs/cgroup/memory/memory.usage_in_bytes",
+			limit:    "/sys/fs/cgroup/memory/memory.limit_in_bytes",
+			stat:     "/sys/fs/cgroup/memory/memory.stat",
+			inactive: "total_inactive_file",
+		},
+	}
+	memInfoPath = "/proc/meminfo"
+)
+
+type cgroupMemoryFile struct {
+	usage    string
+	limit    string
+	stat     string
+	inactive string // the memory.stat key of page cache which can be reclaimed
+}
+
+// MemoryStats returns cgroup limit aware memory stats, falling back to /proc/meminfo
+type MemoryStats struct {
+	usedGB  atomic.Float64
+	totalGB atomic.Float64
+
+	updateCallback func(used, total float64)
+	closeChan      chan struct{}
+}
+
+func NewMemoryStats(updateCallback func(used, total float64)) (*MemoryStats, error) {
+	m := &MemoryStats{
+		updateCallback: updateCallback,
+		closeChan:      make(chan struct{}),
+	}
+
+	if err := m.sample(); err != nil {
+		return nil, err
+	}
+
+	go m.monitorMemoryUsage()
+
+	return m, nil
+}
+
+// GetMemoryUsed returns memory in use, in GB
+func (m *MemoryStats) GetMemoryUsed() float64 {
+	return m.usedGB.Load()
+}
+
+// GetMemoryTotal returns total available memory, in GB
+func (m *MemoryStats) GetMemoryTotal() float64 {
+	return m.totalGB.Load()
+}
+
+func (m *MemoryStats) Stop() {
+	close(m.closeChan)
+}
+
+func (m *MemoryStats) monitorMemoryUsage() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.closeChan:
+			return
+		case <-ticker.C:
+			if err := m.sample(); err != nil {
+				logger.Errorw("failed retrieving memory usage", err)
+				continue
+			}
+
+			if m.updateCallback != nil {
+				m.updateCallback(m.usedGB.Load(), m.totalGB.Load())
+			}
+		}
+	}
+}
+
+func (m *MemoryStats) sample() error {
+	memTotal, memAvailable, err := readMemInfo()
+	if err != nil {
+		return err
+	}
+
+	used := memTotal - memAvailable
+	total := memTotal
+
+	// prefer cgroup values when running inside a limited container
+	for _, files := range cgroupMemoryFiles {
+		usage, err := readUint(files.usage)
+		if err != nil {
+			continue
+		}
+		limit, err := readUint(files.limit)
+		if err == nil && limit > 0 && limit < total {
+			total = limit
+		}
+		// usage includes page cache, which fills up while long files are written. Like kubelet, count the
+		// working set, without the inactive cache the kernel can reclaim
+		if inactive, err := readStat(files.stat, files.inactive); err == nil && inactive < usage {
+			usage -= inactive
+		}
+		used = usage
+		break
+	}
+
+	m.usedGB.Store(float64(used) / bytesPerGB)
+	m.totalGB.Store(float64(total) / bytesPerGB)
+	return nil
+}
+
+func readMemInfo() (total, available uint64, err error) {
+	b, err := os.ReadFile(memInfoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total, err = strconv.ParseUint(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, err = strconv.ParseUint(fields[1], 10, 64)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if total == 0 {
+		return 0, 0, errors.New("could not parse memory stats")
+	}
+
+	// meminfo values are in kB
+	return total * 1024, available * 1024, nil
+}
+
+// readStat returns a value from a memory.stat file
+func readStat(path, key string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, errors.New("missing memory stat " + key)
+}
+
+func readUint(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,67 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFixture(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestMemorySample(t *testing.T) {
+	files, infoPath := cgroupMemoryFiles, memInfoPath
+	t.Cleanup(func() {
+		cgroupMemoryFiles, memInfoPath = files, infoPath
+	})
+
+	dir := t.TempDir()
+	// 16GB, 12GB available
+	memInfoPath = writeFixture(t, dir, "meminfo", "MemTotal:       16777216 kB\nMemFree:         1048576 kB\nMemAvailable:   12582912 kB\n")
+	v2 := cgroupMemoryFile{
+		usage:    writeFixture(t, dir, "memory.current", "6442450944\n"),
+		limit:    writeFixture(t, dir, "memory.max", "8589934592\n"),
+		stat:     writeFixture(t, dir, "memory.stat", "anon 1073741824\nfile 5368709120\nactive_file 1073741824\ninactive_file 4294967296\n"),
+		inactive: "inactive_file",
+	}
+	v1 := cgroupMemoryFile{
+		usage:    writeFixture(t, dir, "memory.usage_in_bytes", "3221225472\n"),
+		limit:    writeFixture(t, dir, "memory.limit_in_bytes", "9223372036854771712\n"),
+		stat:     writeFixture(t, dir, "v1.memory.stat", "cache 2147483648\ninactive_file 0\ntotal_inactive_file 1073741824\n"),
+		inactive: "total_inactive_file",
+	}
+	missing := cgroupMemoryFile{
+		usage: filepath.Join(dir, "missing"),
+		limit: filepath.Join(dir, "missing"),
+		stat:  filepath.Join(dir, "missing"),
+	}
+
+	// the inactive page cache is not counted as used
+	cgroupMemoryFiles = []cgroupMemoryFile{v2, v1}
+	m := &MemoryStats{}
+	require.NoError(t, m.sample())
+	require.Equal(t, float64(2), m.GetMemoryUsed())
+	require.Equal(t, float64(8), m.GetMemoryTotal())
+
+	// cgroup v1, whose limit is unset
+	cgroupMemoryFiles = []cgroupMemoryFile{missing, v1}
+	require.NoError(t, m.sample())
+	require.Equal(t, float64(2), m.GetMemoryUsed())
+	require.Equal(t, float64(16), m.GetMemoryTotal())
+
+	// without memory.stat, usage is counted as it is
+	cgroupMemoryFiles = []cgroupMemoryFile{{usage: v2.usage, limit: v2.limit, stat: missing.stat, inactive: "inactive_file"}}
+	require.NoError(t, m.sample())
+	require.Equal(t, float64(6), m.GetMemoryUsed())
+
+	// outside a cgroup
+	cgroupMemoryFiles = []cgroupMemoryFile{missing}
+	require.NoError(t, m.sample())
+	require.Equal(t, float64(4), m.GetMemoryUsed())
+	require.Equal(t, float64(16), m.GetMemoryTotal())
+}
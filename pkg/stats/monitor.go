@@ -1,8 +1,11 @@
 package stats
 
 import (
+	"math"
+	"path"
 	"runtime"
-	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/frostbyte73/go-throttle"
@@ -17,29 +20,143 @@ import (
 )
 
 type Monitor struct {
-	cpuCostConfig config.CPUCostConfig
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	collectors []prometheus.Collector
 
-	promCPULoad  prometheus.Gauge
-	requestGauge *prometheus.GaugeVec
+	cpuCostConfig    config.CPUCostConfig // guarded by mu once started
+	memoryCostConfig config.MemoryCostConfig
+	gpuCostConfig    config.GPUCostConfig
+	limits           config.ConcurrencyLimits
+	minFreeDisk      uint64
+	hardwareEncoder  bool
+	faststart        bool
+	defaultFramerate float64 // for encoding options which don't set one
 
-	cpuStats *utils.CPUStats
+	promCPULoad      prometheus.Gauge
+	promMemoryLoad   prometheus.Gauge
+	promGPULoad      prometheus.Gauge
+	promGPUEncoder   prometheus.Gauge
+	promDiskFree     prometheus.Gauge
+	promEgressCPU    *prometheus.GaugeVec
+	bytesWritten     *prometheus.GaugeVec
+	framesDropped    *prometheus.CounterVec
+	recentDropped    *prometheus.GaugeVec
+	maxQueueDepth    *prometheus.GaugeVec
+	requestGauge     *prometheus.GaugeVec
+	completedTotal   *prometheus.CounterVec
+	failedTotal      *prometheus.CounterVec
+	availableSlots   *prometheus.GaugeVec
+	disabledGauge    *prometheus.GaugeVec
+	startupTime      *prometheus.HistogramVec
+	firstKeyFrame    *prometheus.HistogramVec
+	egressDuration   *prometheus.HistogramVec
+	webhookFailed    prometheus.Counter
+	configReloads    prometheus.Counter
+	bufferedUpdates  *prometheus.CounterVec
+	rtmpReconnects   *prometheus.CounterVec
+	uploadRetries    *prometheus.CounterVec
+	uploadFailures   *prometheus.CounterVec
+	hookFailures     *prometheus.CounterVec
+	restarts         *prometheus.CounterVec
+	retainedBytes    prometheus.Gauge
+	uploadRate       prometheus.Gauge
+	wsDropped        *prometheus.CounterVec
+	layerSwitches    *prometheus.CounterVec
+	packetsLost      *prometheus.CounterVec
+	packetsReordered *prometheus.CounterVec
+	packetsConcealed *prometheus.CounterVec
 
+	cpuStats    cpuSampler
+	memoryStats *MemoryStats
+	gpuStats    *GPUStats
+	diskStats   *DiskStats
+
+	mu        sync.Mutex
+	processes map[string]*processCPU
+	holds     map[string]func()
+	active    map[string]bool
+	running   map[string]string // egress types, from acceptance until the egress ends
+	drops     map[string][]dropSample
+
+	stopOnce sync.Once
+	done     chan struct{}
+
+	disabledTypes   map[string]bool
 	pendingCPUs     atomic.Float64
+	pendingMemory   atomic.Float64
+	pendingSessions atomic.Int64
+	completed       atomic.Int64
+	failed          atomic.Int64
 	numCPUs         float64
 	warningThrottle func(func())
 }
 
-func NewMonitor() *Monitor {
-	return &Monitor{
+type cpuSampler interface {
+	GetCPUIdle() float64
+	Stop()
+}
+
+// frames_dropped_last_minute covers this window
+const dropWindow = time.Minute
+
+type dropSample struct {
+	at      time.Time
+	dropped int64
+}
+
+type MonitorOption func(*Monitor)
+
+// WithRegistry registers the monitor's collectors with reg instead of the default prometheus registry
+func WithRegistry(reg *prometheus.Registry) MonitorOption {
+	return func(m *Monitor) {
+		m.registerer = reg
+		m.gatherer = reg
+	}
+}
+
+func NewMonitor(opts ...MonitorOption) *Monitor {
+	m := &Monitor{
+		registerer:      prometheus.DefaultRegisterer,
+		gatherer:        prometheus.DefaultGatherer,
+		processes:       make(map[string]*processCPU),
+		holds:           make(map[string]func()),
+		active:          make(map[string]bool),
+		running:         make(map[string]string),
+		drops:           make(map[string][]dropSample),
+		disabledTypes:   make(map[string]bool),
+		done:            make(chan struct{}),
 		numCPUs:         float64(runtime.NumCPU()),
 		warningThrottle: throttle.New(time.Minute),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Monitor) Registerer() prometheus.Registerer {
+	return m.registerer
+}
+
+func (m *Monitor) Gatherer() prometheus.Gatherer {
+	return m.gatherer
 }
 
 func (m *Monitor) Start(conf *config.Config, isAvailable func() float64) error {
-	if err := m.checkCPUConfig(conf.CPUCost); err != nil {
+	disabled, err := m.checkCPUConfig(conf.CPUCost)
+	if err != nil {
 		return err
 	}
+	m.cpuCostConfig = conf.CPUCost
+	m.disabledTypes = disabled
+	m.defaultFramerate = conf.VideoEncoding.Framerate
+	m.memoryCostConfig = conf.MemoryCost
+	m.gpuCostConfig = conf.GPUCost
+	m.limits = conf.ConcurrencyLimits
+	m.minFreeDisk = uint64(conf.MinFreeDisk * bytesPerGB)
+	m.hardwareEncoder = conf.Encoder == config.EncoderVAAPI || conf.Encoder == config.EncoderNVENC
+	m.faststart = !conf.DisableFaststart
 
 	promNodeAvailable := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace:   "livekit",
@@ -55,6 +172,69 @@ func (m *Monitor) Start(conf *config.Config, isAvailable func() float64) error {
 		ConstLabels: prometheus.Labels{"node_id": conf.NodeID, "node_type": "EGRESS"},
 	})
 
+	m.promMemoryLoad = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "node",
+		Name:        "memory_load",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID, "node_type": "EGRESS"},
+	})
+
+	m.promGPULoad = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "node",
+		Name:        "gpu_load",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID, "node_type": "EGRESS"},
+	})
+
+	m.promGPUEncoder = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "node",
+		Name:        "gpu_encoder_sessions",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID, "node_type": "EGRESS"},
+	})
+
+	m.promDiskFree = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "node",
+		Name:        "disk_free_bytes",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID, "node_type": "EGRESS"},
+	})
+
+	m.promEgressCPU = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "cpu_load",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"egress_id", "egress_type"})
+
+	m.bytesWritten = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "bytes_written",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"egress_id", "egress_type"})
+
+	m.framesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "frames_dropped_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"egress_id", "egress_type"})
+
+	m.recentDropped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "frames_dropped_last_minute",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"egress_id", "egress_type"})
+
+	m.maxQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "max_queue_depth",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"egress_id", "egress_type"})
+
 	m.requestGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace:   "livekit",
 		Subsystem:   "egress",
@@ -62,145 +242,1012 @@ func (m *Monitor) Start(conf *config.Config, isAvailable func() float64) error {
 		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
 	}, []string{"type"})
 
-	prometheus.MustRegister(promNodeAvailable, m.promCPULoad, m.requestGauge)
+	m.completedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "completed_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.failedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "failed_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type", "error_category"})
+
+	m.availableSlots = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "available_slots",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.disabledGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "types_disabled",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+	for egressType := range m.disabledTypes {
+		m.disabledGauge.With(prometheus.Labels{"type": egressType}).Set(1)
+	}
+
+	m.startupTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "startup_duration_seconds",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     []float64{0.5, 1, 2, 3, 5, 7.5, 10, 15, 20, 30, 45, 60},
+	}, []string{"type"})
+
+	m.firstKeyFrame = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "first_key_frame_seconds",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20},
+	}, []string{"type"})
+
+	m.egressDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "duration_seconds",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     []float64{10, 30, 60, 300, 600, 1800, 3600, 7200, 14400, 28800, 86400},
+	}, []string{"type"})
+
+	m.webhookFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "webhook_failures_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	})
+
+	m.configReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "config_reloads_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	})
+
+	m.bufferedUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "buffered_updates_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"result"})
+
+	m.rtmpReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "rtmp_reconnects_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.uploadRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "upload_retries_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.uploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "upload_failures_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.hookFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "segment_hook_failures_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.restarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "pipeline_restarts_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.wsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "websocket_dropped_bytes_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.layerSwitches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "video_layer_switches_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.packetsLost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "packets_lost_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.packetsReordered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "packets_reordered_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.packetsConcealed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "packets_concealed_total",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"type"})
+
+	m.retainedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "retained_bytes",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	})
+
+	m.uploadRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "upload_throughput_bytes",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	})
+
+	if err := m.register(
+		promNodeAvailable, m.promCPULoad, m.promMemoryLoad, m.promDiskFree, m.promEgressCPU, m.bytesWritten,
+		m.framesDropped, m.recentDropped, m.maxQueueDepth,
+		m.requestGauge, m.completedTotal, m.failedTotal, m.availableSlots, m.disabledGauge,
+		m.startupTime, m.egressDuration, m.webhookFailed, m.configReloads, m.bufferedUpdates, m.rtmpReconnects,
+		m.uploadRetries, m.uploadFailures, m.hookFailures, m.restarts, m.retainedBytes, m.uploadRate, m.wsDropped, m.layerSwitches,
+		m.packetsLost, m.packetsReordered, m.packetsConcealed, m.firstKeyFrame,
+	); err != nil {
+		return err
+	}
 
 	cpuStats, err := utils.NewCPUStats(func(idle float64) {
 		m.promCPULoad.Set(1 - idle/m.numCPUs)
+		for egressType, slots := range m.getAvailableSlots(idle) {
+			m.availableSlots.With(prometheus.Labels{"type": egressType}).Set(float64(slots))
+		}
 	})
 	if err != nil {
+		m.Stop()
 		return err
 	}
 
 	m.cpuStats = cpuStats
 
+	memoryStats, err := NewMemoryStats(func(used, total float64) {
+		if total > 0 {
+			m.promMemoryLoad.Set(used / total)
+		}
+	})
+	if err != nil {
+		m.Stop()
+		return err
+	}
+
+	m.memoryStats = memoryStats
+
+	diskStats, err := NewDiskStats(conf.LocalOutputDirectory, func(freeBytes uint64) {
+		m.promDiskFree.Set(float64(freeBytes))
+	})
+	if err != nil {
+		m.Stop()
+		return err
+	}
+
+	m.diskStats = diskStats
+	m.promDiskFree.Set(float64(diskStats.GetFreeBytes()))
+
+	// skipped if no gpu is present
+	m.gpuStats = NewGPUStats(func(utilization float64, encoderSessions int64) {
+		m.promGPULoad.Set(utilization / 100)
+		m.promGPUEncoder.Set(float64(encoderSessions))
+	})
+	if m.gpuStats != nil {
+		if err = m.register(m.promGPULoad, m.promGPUEncoder); err != nil {
+			m.Stop()
+			return err
+		}
+	}
+
+	go m.monitorProcesses()
+
 	return nil
 }
 
-func (m *Monitor) checkCPUConfig(costConfig config.CPUCostConfig) error {
-	if costConfig.RoomCompositeCpuCost < 2.5 {
-		logger.Warnw("room composite requirement too low", nil,
-			"config value", costConfig.RoomCompositeCpuCost,
-			"minimum value", 2.5,
-			"recommended value", 3,
-		)
-	}
-	if costConfig.WebCpuCost < 2.5 {
-		logger.Warnw("web requirement too low", nil,
-			"config value", costConfig.WebCpuCost,
-			"minimum value", 2.5,
-			"recommended value", 3,
-		)
+func (m *Monitor) register(collectors ...prometheus.Collector) error {
+	for _, c := range collectors {
+		if err := m.registerer.Register(c); err != nil {
+			m.unregister()
+			return err
+		}
+		m.collectors = append(m.collectors, c)
 	}
-	if costConfig.TrackCompositeCpuCost < 1 {
-		logger.Warnw("track composite requirement too low", nil,
-			"config value", costConfig.TrackCompositeCpuCost,
-			"minimum value", 1,
-			"recommended value", 2,
-		)
+	return nil
+}
+
+func (m *Monitor) unregister() {
+	for _, c := range m.collectors {
+		m.registerer.Unregister(c)
 	}
-	if costConfig.TrackCpuCost < 0.5 {
-		logger.Warnw("track requirement too low", nil,
-			"config value", costConfig.RoomCompositeCpuCost,
-			"minimum value", 0.5,
-			"recommended value", 1,
-		)
+	m.collectors = nil
+}
+
+// Stop halts all sampling and unregisters the monitor's collectors so that a new monitor can be
+// started in the same process. It is safe to call more than once.
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+		if m.cpuStats != nil {
+			m.cpuStats.Stop()
+		}
+		if m.memoryStats != nil {
+			m.memoryStats.Stop()
+		}
+		if m.gpuStats != nil {
+			m.gpuStats.Stop()
+		}
+		if m.diskStats != nil {
+			m.diskStats.Stop()
+		}
+		m.unregister()
+	})
+}
+
+func (m *Monitor) monitorProcesses() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.updateProcesses()
+		}
 	}
+}
+
+func (m *Monitor) updateProcesses() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	requirements := []float64{
-		costConfig.RoomCompositeCpuCost,
-		costConfig.WebCpuCost,
-		costConfig.TrackCompositeCpuCost,
-		costConfig.TrackCpuCost,
+	if len(m.processes) == 0 {
+		return
 	}
-	sort.Float64s(requirements)
 
-	recommendedMinimum := requirements[2]
-	if recommendedMinimum < 3 {
-		recommendedMinimum = 3
+	stats, children, err := readProcStats()
+	if err != nil {
+		logger.Errorw("failed retrieving process stats", err)
+		return
 	}
 
-	if m.numCPUs < requirements[0] {
-		logger.Errorw("not enough cpu", nil,
-			"minimum cpu", requirements[0],
-			"recommended", recommendedMinimum,
-			"available", m.numCPUs,
-		)
-		return errors.New("not enough cpu")
+	now := time.Now()
+	for egressID, p := range m.processes {
+		p.update(stats, children, now)
+		m.promEgressCPU.With(prometheus.Labels{"egress_id": egressID, "egress_type": p.egressType}).Set(p.cpu)
 	}
+}
 
-	if m.numCPUs < requirements[3] {
-		logger.Errorw("not enough cpu for some egress types", nil,
-			"minimum cpu", requirements[3],
-			"recommended", recommendedMinimum,
-			"available", m.numCPUs,
-		)
+// checkCPUConfig returns the egress types which cost more cpu than the node has. The costs themselves are
+// reported by config.Validate
+func (m *Monitor) checkCPUConfig(costConfig config.CPUCostConfig) (map[string]bool, error) {
+	disabled := make(map[string]bool)
+	for egressType, cost := range map[string]float64{
+		"room_composite":  costConfig.RoomCompositeCpuCost,
+		"web":             costConfig.WebCpuCost,
+		"track_composite": costConfig.TrackCompositeCpuCost,
+		"track":           costConfig.TrackCpuCost,
+	} {
+		if m.numCPUs < cost {
+			disabled[egressType] = true
+		}
+	}
+	if len(disabled) == 4 {
+		return nil, errors.New("not enough cpu")
 	}
 
-	return nil
+	return disabled, nil
 }
 
 func (m *Monitor) GetCPULoad() float64 {
 	return (m.numCPUs - m.cpuStats.GetCPUIdle()) / m.numCPUs * 100
 }
 
-func (m *Monitor) CanAcceptRequest(req *livekit.StartEgressRequest) bool {
-	accept := false
+func (m *Monitor) GetMemoryLoad() float64 {
+	total := m.memoryStats.GetMemoryTotal()
+	if total == 0 {
+		return 0
+	}
+	return m.memoryStats.GetMemoryUsed() / total * 100
+}
+
+// AvailableSlots returns the number of additional egresses of each type the node can currently take
+func (m *Monitor) AvailableSlots() map[string]int {
+	return m.getAvailableSlots(m.cpuStats.GetCPUIdle())
+}
+
+func (m *Monitor) getAvailableSlots(idle float64) map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	available := idle - m.pendingCPUs.Load()
+
+	slots := make(map[string]int, 4)
+	for egressType, cost := range map[string]float64{
+		"room_composite":  m.cpuCostConfig.RoomCompositeCpuCost,
+		"web":             m.cpuCostConfig.WebCpuCost,
+		"track_composite": m.cpuCostConfig.TrackCompositeCpuCost,
+		"track":           m.cpuCostConfig.TrackCpuCost,
+	} {
+		if cost <= 0 || available <= 0 {
+			slots[egressType] = 0
+			continue
+		}
+		slots[egressType] = int(available / cost)
+	}
+
+	for egressType, n := range slots {
+		if remaining, limited := m.remainingConcurrency(egressType); limited && remaining < n {
+			slots[egressType] = remaining
+		}
+	}
+	return slots
+}
+
+// remainingConcurrency returns how many more egresses of egressType may run, and false if there is no limit
+func (m *Monitor) remainingConcurrency(egressType string) (int, bool) {
+	var max int
+	switch egressType {
+	case "room_composite":
+		max = m.limits.MaxRoomComposite
+	case "web":
+		max = m.limits.MaxWeb
+	case "track_composite":
+		max = m.limits.MaxTrackComposite
+	case "track":
+		max = m.limits.MaxTrack
+	}
+
+	if max <= 0 && m.limits.MaxConcurrent <= 0 {
+		return 0, false
+	}
+
+	var total, ofType int
+	for _, t := range m.running {
+		total++
+		if t == egressType {
+			ofType++
+		}
+	}
+
+	remaining := math.MaxInt
+	if max > 0 {
+		remaining = max - ofType
+	}
+	if m.limits.MaxConcurrent > 0 && m.limits.MaxConcurrent-total < remaining {
+		remaining = m.limits.MaxConcurrent - total
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// AcceptRequest checks whether the node can afford req and, if so, reserves its cpu and memory costs.
+// The reservation is freed when EgressStarted is called, or after the configured hold duration.
+// The returned release func frees it early, and should be called if the egress fails to launch.
+// Accepted egresses count towards concurrency limits until EgressEnded is called, or until release
+// is called before EgressProcessStarted.
+func (m *Monitor) AcceptRequest(req *livekit.StartEgressRequest) (bool, func()) {
+	egressType := EgressType(req)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.disabledTypes[egressType] {
+		logger.Debugw("egress type disabled", "type", egressType)
+		return false, nil
+	}
+
+	if remaining, limited := m.remainingConcurrency(egressType); limited {
+		accept := remaining > 0
+
+		logger.Debugw("concurrency request", "accepted", accept, "type", egressType, "running", len(m.running))
+		if !accept {
+			return false, nil
+		}
+	}
+
+	cpuHold := m.getCPUCost(req)
 	available := m.cpuStats.GetCPUIdle() - m.pendingCPUs.Load()
+	accept := available > cpuHold
+
+	logger.Debugw("cpu request", "accepted", accept, "availableCPUs", available, "numCPUs", runtime.NumCPU())
+	if !accept {
+		return false, nil
+	}
+
+	memoryHold := m.getMemoryCost(req)
+	projected := m.memoryStats.GetMemoryUsed() + m.pendingMemory.Load() + memoryHold
+	limit := m.memoryStats.GetMemoryTotal() - m.memoryCostConfig.MemoryHeadroom
+	accept = projected <= limit
+
+	logger.Debugw("memory request", "accepted", accept, "projectedMemory", projected, "memoryLimit", limit)
+	if !accept {
+		return false, nil
+	}
+
+	if m.minFreeDisk > 0 && writesToDisk(req) {
+		minFreeDisk := m.minFreeDisk
+		if m.faststart && isMP4File(req) {
+			// the media is written twice while the moov is moved to the front
+			minFreeDisk *= 2
+		}
+		free := m.diskStats.GetFreeBytes()
+		accept = free >= minFreeDisk
+
+		logger.Debugw("disk request", "accepted", accept, "freeDisk", free, "minFreeDisk", minFreeDisk)
+		if !accept {
+			return false, nil
+		}
+	}
+
+	var sessionHold int64
+	if m.gpuStats != nil && m.gpuCostConfig.MaxEncoderSessions > 0 {
+		sessionHold = m.getEncoderSessionCost(req)
+		sessions := m.gpuStats.GetEncoderSessions() + m.pendingSessions.Load() + sessionHold
+		accept = sessions <= m.gpuCostConfig.MaxEncoderSessions
+
+		logger.Debugw("gpu request", "accepted", accept, "projectedSessions", sessions, "maxSessions", m.gpuCostConfig.MaxEncoderSessions)
+		if !accept {
+			return false, nil
+		}
+	}
+
+	m.pendingCPUs.Add(cpuHold)
+	m.pendingMemory.Add(memoryHold)
+	m.pendingSessions.Add(sessionHold)
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			m.pendingCPUs.Sub(cpuHold)
+			m.pendingMemory.Sub(memoryHold)
+			m.pendingSessions.Sub(sessionHold)
+		})
+	}
+	m.holds[req.EgressId] = release
+	m.running[req.EgressId] = egressType
+
+	egressID := req.EgressId
+
+	// fallback for requests that never start
+	time.AfterFunc(m.cpuCostConfig.CPUHoldDuration, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.releaseHold(egressID)
+	})
+
+	return true, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.releaseHold(egressID)
+		if _, launched := m.processes[egressID]; !launched {
+			delete(m.running, egressID)
+		}
+	}
+}
+
+// GetGPULoad returns gpu utilization, or 0 if no gpu is present
+func (m *Monitor) GetGPULoad() float64 {
+	if m.gpuStats == nil {
+		return 0
+	}
+	return m.gpuStats.GetUtilization()
+}
+
+func (m *Monitor) getEncoderSessionCost(req *livekit.StartEgressRequest) int64 {
+	if isAudioOnly(req) || isPassthrough(req) {
+		return 0
+	}
 
 	switch req.Request.(type) {
 	case *livekit.StartEgressRequest_RoomComposite:
-		accept = available > m.cpuCostConfig.RoomCompositeCpuCost
+		return m.gpuCostConfig.RoomCompositeEncoderSessions
 	case *livekit.StartEgressRequest_Web:
-		accept = available > m.cpuCostConfig.WebCpuCost
+		return m.gpuCostConfig.WebEncoderSessions
 	case *livekit.StartEgressRequest_TrackComposite:
-		accept = available > m.cpuCostConfig.TrackCompositeCpuCost
+		return m.gpuCostConfig.TrackCompositeEncoderSessions
 	case *livekit.StartEgressRequest_Track:
-		accept = available > m.cpuCostConfig.TrackCpuCost
+		return m.gpuCostConfig.TrackEncoderSessions
 	}
+	return 0
+}
 
-	logger.Debugw("cpu request", "accepted", accept, "availableCPUs", available, "numCPUs", runtime.NumCPU())
-	return accept
+// isAudioOnly returns true for composite requests which will not encode video
+func isAudioOnly(req *livekit.StartEgressRequest) bool {
+	switch r := req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		return r.RoomComposite.AudioOnly
+	case *livekit.StartEgressRequest_Web:
+		return r.Web.AudioOnly
+	case *livekit.StartEgressRequest_TrackComposite:
+		return r.TrackComposite.VideoTrackId == "" && r.TrackComposite.AudioTrackId != ""
+	}
+	return false
+}
+
+// encodesVideo returns true for requests which use the video encoder. Track requests are not transcoded
+func encodesVideo(req *livekit.StartEgressRequest) bool {
+	if _, ok := req.Request.(*livekit.StartEgressRequest_Track); ok {
+		return false
+	}
+	return !isAudioOnly(req) && !isPassthrough(req)
 }
 
-func (m *Monitor) AcceptRequest(req *livekit.StartEgressRequest) {
-	var cpuHold float64
+// isPassthrough returns true for track composite requests which will be remuxed to mkv without transcoding
+func isPassthrough(req *livekit.StartEgressRequest) bool {
+	r, ok := req.Request.(*livekit.StartEgressRequest_TrackComposite)
+	if !ok {
+		return false
+	}
+	file := r.TrackComposite.GetFile()
+	return file != nil &&
+		file.FileType == livekit.EncodedFileType_DEFAULT_FILETYPE &&
+		strings.HasSuffix(file.Filepath, ".mkv")
+}
+
+// isMP4File returns true for composite file requests which will be written as mp4
+func isMP4File(req *livekit.StartEgressRequest) bool {
+	var file *livekit.EncodedFileOutput
+	switch r := req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		file = r.RoomComposite.GetFile()
+	case *livekit.StartEgressRequest_Web:
+		file = r.Web.GetFile()
+	case *livekit.StartEgressRequest_TrackComposite:
+		file = r.TrackComposite.GetFile()
+	}
+	if file == nil {
+		return false
+	}
+
+	switch file.FileType {
+	case livekit.EncodedFileType_MP4:
+		return true
+	case livekit.EncodedFileType_DEFAULT_FILETYPE:
+		switch path.Ext(file.Filepath) {
+		case ".mp4":
+			return true
+		case "":
+			return !isAudioOnly(req)
+		}
+	}
+	return false
+}
+
+// writesToDisk returns true for file and segment requests, which need local storage
+func writesToDisk(req *livekit.StartEgressRequest) bool {
+	switch r := req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		switch r.RoomComposite.Output.(type) {
+		case *livekit.RoomCompositeEgressRequest_File, *livekit.RoomCompositeEgressRequest_Segments:
+			return true
+		}
+	case *livekit.StartEgressRequest_Web:
+		switch r.Web.Output.(type) {
+		case *livekit.WebEgressRequest_File, *livekit.WebEgressRequest_Segments:
+			return true
+		}
+	case *livekit.StartEgressRequest_TrackComposite:
+		switch r.TrackComposite.Output.(type) {
+		case *livekit.TrackCompositeEgressRequest_File, *livekit.TrackCompositeEgressRequest_Segments:
+			return true
+		}
+	case *livekit.StartEgressRequest_Track:
+		switch r.Track.Output.(type) {
+		case *livekit.TrackEgressRequest_File:
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) getCPUCost(req *livekit.StartEgressRequest) float64 {
+	if cost, ok := m.getFormulaCPUCost(req); ok {
+		return cost
+	}
+
+	cost := m.getBaseCPUCost(req)
+	if isPassthrough(req) && m.cpuCostConfig.PassthroughCpuCostRatio > 0 {
+		cost *= m.cpuCostConfig.PassthroughCpuCostRatio
+	} else if isAudioOnly(req) && m.cpuCostConfig.AudioOnlyCpuCostRatio > 0 {
+		cost *= m.cpuCostConfig.AudioOnlyCpuCostRatio
+	} else if m.hardwareEncoder && encodesVideo(req) && m.cpuCostConfig.HardwareCpuCostRatio > 0 {
+		cost *= m.cpuCostConfig.HardwareCpuCostRatio
+	}
+	return cost
+}
+
+func (m *Monitor) getBaseCPUCost(req *livekit.StartEgressRequest) float64 {
 	switch req.Request.(type) {
 	case *livekit.StartEgressRequest_RoomComposite:
-		cpuHold = m.cpuCostConfig.RoomCompositeCpuCost
+		return m.cpuCostConfig.RoomCompositeCpuCost
 	case *livekit.StartEgressRequest_Web:
-		cpuHold = m.cpuCostConfig.WebCpuCost
+		return m.cpuCostConfig.WebCpuCost
 	case *livekit.StartEgressRequest_TrackComposite:
-		cpuHold = m.cpuCostConfig.TrackCompositeCpuCost
+		return m.cpuCostConfig.TrackCompositeCpuCost
 	case *livekit.StartEgressRequest_Track:
-		cpuHold = m.cpuCostConfig.TrackCpuCost
+		return m.cpuCostConfig.TrackCpuCost
 	}
+	return 0
+}
 
-	m.pendingCPUs.Add(cpuHold)
-	time.AfterFunc(time.Second, func() { m.pendingCPUs.Sub(cpuHold) })
+// getFormulaCPUCost computes the cost of composite requests with encoding options, if a formula is configured
+func (m *Monitor) getFormulaCPUCost(req *livekit.StartEgressRequest) (float64, bool) {
+	formula := m.cpuCostConfig.Formula
+	if formula == nil || isPassthrough(req) {
+		return 0, false
+	}
+
+	var preset *livekit.EncodingOptionsPreset
+	var advanced *livekit.EncodingOptions
+	var chrome, audio bool
+	switch r := req.Request.(type) {
+	case *livekit.StartEgressRequest_RoomComposite:
+		switch o := r.RoomComposite.Options.(type) {
+		case *livekit.RoomCompositeEgressRequest_Preset:
+			preset = &o.Preset
+		case *livekit.RoomCompositeEgressRequest_Advanced:
+			advanced = o.Advanced
+		}
+		chrome, audio = true, !r.RoomComposite.VideoOnly
+	case *livekit.StartEgressRequest_Web:
+		switch o := r.Web.Options.(type) {
+		case *livekit.WebEgressRequest_Preset:
+			preset = &o.Preset
+		case *livekit.WebEgressRequest_Advanced:
+			advanced = o.Advanced
+		}
+		chrome, audio = true, !r.Web.VideoOnly
+	case *livekit.StartEgressRequest_TrackComposite:
+		switch o := r.TrackComposite.Options.(type) {
+		case *livekit.TrackCompositeEgressRequest_Preset:
+			preset = &o.Preset
+		case *livekit.TrackCompositeEgressRequest_Advanced:
+			advanced = o.Advanced
+		}
+		audio = r.TrackComposite.AudioTrackId != ""
+	}
+	if preset == nil && advanced == nil {
+		return 0, false
+	}
+
+	cost := formula.BaseCpuCost
+	if chrome {
+		cost += formula.ChromeCpuCost
+	}
+	if audio {
+		cost += formula.AudioCpuCost
+	}
+	if !isAudioOnly(req) {
+		var width, height int32
+		var framerate float64
+		if preset != nil {
+			width, height, framerate = presetDimensions(*preset)
+		} else {
+			width, height, framerate = advanced.Width, advanced.Height, float64(advanced.Framerate)
+		}
+		if width == 0 || height == 0 {
+			width, height = 1920, 1080
+		}
+		if framerate == 0 {
+			framerate = m.defaultFramerate
+		}
+		if framerate == 0 {
+			framerate = 30
+		}
+
+		video := formula.MegapixelCpuCost * float64(width) * float64(height) * framerate / 1e6
+		if m.hardwareEncoder && m.cpuCostConfig.HardwareCpuCostRatio > 0 {
+			video *= m.cpuCostConfig.HardwareCpuCostRatio
+		}
+		cost += video
+	}
+	return cost, true
 }
 
-func (m *Monitor) EgressStarted(req *livekit.StartEgressRequest) {
+// presetDimensions returns the width, height and framerate encoded by preset
+func presetDimensions(preset livekit.EncodingOptionsPreset) (int32, int32, float64) {
+	switch preset {
+	case livekit.EncodingOptionsPreset_H264_720P_30:
+		return 1280, 720, 30
+	case livekit.EncodingOptionsPreset_H264_720P_60:
+		return 1280, 720, 60
+	case livekit.EncodingOptionsPreset_H264_1080P_60:
+		return 1920, 1080, 60
+	case livekit.EncodingOptionsPreset_PORTRAIT_H264_720P_30:
+		return 720, 1280, 30
+	case livekit.EncodingOptionsPreset_PORTRAIT_H264_720P_60:
+		return 720, 1280, 60
+	case livekit.EncodingOptionsPreset_PORTRAIT_H264_1080P_30:
+		return 1080, 1920, 30
+	case livekit.EncodingOptionsPreset_PORTRAIT_H264_1080P_60:
+		return 1080, 1920, 60
+	default:
+		return 1920, 1080, 30
+	}
+}
+
+func (m *Monitor) getMemoryCost(req *livekit.StartEgressRequest) float64 {
 	switch req.Request.(type) {
 	case *livekit.StartEgressRequest_RoomComposite:
-		m.requestGauge.With(prometheus.Labels{"type": "room_composite"}).Add(1)
+		return m.memoryCostConfig.RoomCompositeMemoryCost
 	case *livekit.StartEgressRequest_Web:
-		m.requestGauge.With(prometheus.Labels{"type": "web"}).Add(1)
+		return m.memoryCostConfig.WebMemoryCost
 	case *livekit.StartEgressRequest_TrackComposite:
-		m.requestGauge.With(prometheus.Labels{"type": "track_composite"}).Add(1)
+		return m.memoryCostConfig.TrackCompositeMemoryCost
 	case *livekit.StartEgressRequest_Track:
-		m.requestGauge.With(prometheus.Labels{"type": "track"}).Add(1)
+		return m.memoryCostConfig.TrackMemoryCost
+	}
+	return 0
+}
+
+// EgressProcessStarted begins cpu accounting for the handler process running an egress
+func (m *Monitor) EgressProcessStarted(req *livekit.StartEgressRequest, pid int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.processes[req.EgressId] = &processCPU{
+		egressType: EgressType(req),
+		pid:        pid,
+	}
+}
+
+// GetEgressCPULoads returns cpu usage (in cores) for each running egress
+func (m *Monitor) GetEgressCPULoads() map[string]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	loads := make(map[string]float64, len(m.processes))
+	for egressID, p := range m.processes {
+		loads[egressID] = p.cpu
+	}
+	return loads
+}
+
+// EgressStarted should be called once the egress is active, releasing its resource hold
+func (m *Monitor) EgressStarted(req *livekit.StartEgressRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.releaseHold(req.EgressId)
+	if !m.active[req.EgressId] {
+		m.active[req.EgressId] = true
+		m.requestGauge.With(prometheus.Labels{"type": EgressType(req)}).Add(1)
 	}
 }
 
 func (m *Monitor) EgressEnded(req *livekit.StartEgressRequest) {
+	egressType := EgressType(req)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.releaseHold(req.EgressId)
+	delete(m.running, req.EgressId)
+	if m.active[req.EgressId] {
+		delete(m.active, req.EgressId)
+		m.requestGauge.With(prometheus.Labels{"type": egressType}).Sub(1)
+	}
+
+	if _, ok := m.processes[req.EgressId]; ok {
+		delete(m.processes, req.EgressId)
+		m.promEgressCPU.Delete(prometheus.Labels{"egress_id": req.EgressId, "egress_type": egressType})
+		labels := prometheus.Labels{"egress_id": req.EgressId, "egress_type": egressType}
+		m.bytesWritten.Delete(labels)
+		m.framesDropped.Delete(labels)
+		m.recentDropped.Delete(labels)
+		m.maxQueueDepth.Delete(labels)
+	}
+	delete(m.drops, req.EgressId)
+}
+
+func (m *Monitor) releaseHold(egressID string) {
+	if release, ok := m.holds[egressID]; ok {
+		release()
+		delete(m.holds, egressID)
+	}
+}
+
+// EgressFinished records the outcome of an egress. A nil err means the egress completed successfully.
+func (m *Monitor) EgressFinished(req *livekit.StartEgressRequest, err error) {
+	egressType := EgressType(req)
+	if err == nil {
+		m.completed.Inc()
+		m.completedTotal.With(prometheus.Labels{"type": egressType}).Inc()
+	} else {
+		m.failed.Inc()
+		m.failedTotal.With(prometheus.Labels{"type": egressType, "error_category": errors.Category(err)}).Inc()
+	}
+}
+
+// RecordStartup records the time taken for an egress to go from accepted to active
+func (m *Monitor) RecordStartup(egressType string, duration time.Duration) {
+	m.startupTime.With(prometheus.Labels{"type": egressType}).Observe(duration.Seconds())
+}
+
+// RecordFirstKeyFrame records the time taken for the first video key frame to arrive after subscribing to a track
+func (m *Monitor) RecordFirstKeyFrame(egressType string, delay time.Duration) {
+	m.firstKeyFrame.With(prometheus.Labels{"type": egressType}).Observe(delay.Seconds())
+}
+
+// SetBytesWritten records the encoded bytes written by a running egress
+func (m *Monitor) SetBytesWritten(req *livekit.StartEgressRequest, bytes int64) {
+	m.bytesWritten.With(prometheus.Labels{"egress_id": req.EgressId, "egress_type": EgressType(req)}).Set(float64(bytes))
+}
+
+// FramesDropped records video frames dropped by a running egress since its last update,
+// and the most frames its encoder has had waiting
+func (m *Monitor) FramesDropped(req *livekit.StartEgressRequest, dropped int64, maxQueueDepth int) {
+	labels := prometheus.Labels{"egress_id": req.EgressId, "egress_type": EgressType(req)}
+	m.framesDropped.With(labels).Add(float64(dropped))
+	m.maxQueueDepth.With(labels).Set(float64(maxQueueDepth))
+
+	m.mu.Lock()
+	recent := m.recordDrops(req.EgressId, dropped, time.Now())
+	m.mu.Unlock()
+
+	m.recentDropped.With(labels).Set(float64(recent))
+}
+
+// recordDrops adds dropped frames to an egress' history, and returns the number dropped within the last minute
+func (m *Monitor) recordDrops(egressID string, dropped int64, now time.Time) int64 {
+	samples := m.drops[egressID]
+	if dropped > 0 {
+		samples = append(samples, dropSample{at: now, dropped: dropped})
+	}
+
+	var recent int64
+	kept := samples[:0]
+	for _, sample := range samples {
+		if now.Sub(sample.at) < dropWindow {
+			kept = append(kept, sample)
+			recent += sample.dropped
+		}
+	}
+	m.drops[egressID] = kept
+	return recent
+}
+
+// RecordDuration records the total running time of a finished egress
+func (m *Monitor) RecordDuration(egressType string, duration time.Duration) {
+	m.egressDuration.With(prometheus.Labels{"type": egressType}).Observe(duration.Seconds())
+}
+
+// SetCPUCostConfig changes the cpu costs of requests accepted from now on. Running egresses keep their holds
+func (m *Monitor) SetCPUCostConfig(costConfig config.CPUCostConfig) error {
+	disabled, err := m.checkCPUConfig(costConfig)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cpuCostConfig = costConfig
+	m.disabledTypes = disabled
+	for _, egressType := range []string{"room_composite", "web", "track_composite", "track"} {
+		value := float64(0)
+		if disabled[egressType] {
+			value = 1
+		}
+		m.disabledGauge.With(prometheus.Labels{"type": egressType}).Set(value)
+	}
+	return nil
+}
+
+// ConfigReloaded records a config reload
+func (m *Monitor) ConfigReloaded() {
+	m.configReloads.Inc()
+}
+
+// UpdateReplayed records an egress update which was published after redis came back
+func (m *Monitor) UpdateReplayed() {
+	m.bufferedUpdates.With(prometheus.Labels{"result": "replayed"}).Inc()
+}
+
+// UpdateDropped records a buffered egress update which was never published
+func (m *Monitor) UpdateDropped() {
+	m.bufferedUpdates.With(prometheus.Labels{"result": "dropped"}).Inc()
+}
+
+// WebhookFailed records a webhook which could not be delivered
+func (m *Monitor) WebhookFailed() {
+	m.webhookFailed.Inc()
+}
+
+// StreamReconnected records reconnects made by an egress to its rtmp outputs
+func (m *Monitor) StreamReconnected(egressType string, count int) {
+	m.rtmpReconnects.With(prometheus.Labels{"type": egressType}).Add(float64(count))
+}
+
+// UploadsRetried records upload requests retried by an egress, and uploads which failed after all retries
+func (m *Monitor) UploadsRetried(egressType string, retries, failures int) {
+	m.uploadRetries.With(prometheus.Labels{"type": egressType}).Add(float64(retries))
+	m.uploadFailures.With(prometheus.Labels{"type": egressType}).Add(float64(failures))
+}
+
+// SegmentHooksFailed records segment hooks which failed or were skipped
+func (m *Monitor) SegmentHooksFailed(egressType string, failures int) {
+	m.hookFailures.With(prometheus.Labels{"type": egressType}).Add(float64(failures))
+}
+
+// PipelineRestarted records pipelines rebuilt after failing mid-egress
+func (m *Monitor) PipelineRestarted(egressType string, restarts int) {
+	m.restarts.With(prometheus.Labels{"type": egressType}).Add(float64(restarts))
+}
+
+// WebsocketDropped records audio dropped by an egress while its websocket consumer was disconnected or falling behind
+func (m *Monitor) WebsocketDropped(egressType string, bytes int64) {
+	m.wsDropped.With(prometheus.Labels{"type": egressType}).Add(float64(bytes))
+}
+
+// VideoLayerSwitched records changes in the dimensions of subscribed video, such as simulcast layer switches
+func (m *Monitor) VideoLayerSwitched(egressType string, count int) {
+	m.layerSwitches.With(prometheus.Labels{"type": egressType}).Add(float64(count))
+}
+
+// PacketsReceived records rtp packets lost by track and track composite egress, packets which arrived out of order,
+// and lost audio packets concealed by the decoder
+func (m *Monitor) PacketsReceived(egressType string, lost, reordered, concealed int64) {
+	m.packetsLost.With(prometheus.Labels{"type": egressType}).Add(float64(lost))
+	m.packetsReordered.With(prometheus.Labels{"type": egressType}).Add(float64(reordered))
+	m.packetsConcealed.With(prometheus.Labels{"type": egressType}).Add(float64(concealed))
+}
+
+// SetRetainedBytes records the size of local files kept after upload
+func (m *Monitor) SetRetainedBytes(bytes int64) {
+	m.retainedBytes.Set(float64(bytes))
+}
+
+// SetUploadThroughput records the bytes per second uploaded by all egresses on the node
+func (m *Monitor) SetUploadThroughput(bytesPerSecond int64) {
+	m.uploadRate.Set(float64(bytesPerSecond))
+}
+
+// GetEgressCounts returns the number of completed and failed egresses since the monitor started
+func (m *Monitor) GetEgressCounts() map[string]int64 {
+	return map[string]int64{
+		"completed": m.completed.Load(),
+		"failed":    m.failed.Load(),
+	}
+}
+
+// EgressType returns the metric label for the type of req
+func EgressType(req *livekit.StartEgressRequest) string {
 	switch req.Request.(type) {
 	case *livekit.StartEgressRequest_RoomComposite:
-		m.requestGauge.With(prometheus.Labels{"type": "room_composite"}).Sub(1)
+		return "room_composite"
 	case *livekit.StartEgressRequest_Web:
-		m.requestGauge.With(prometheus.Labels{"type": "web"}).Sub(1)
+		return "web"
 	case *livekit.StartEgressRequest_TrackComposite:
-		m.requestGauge.With(prometheus.Labels{"type": "track_composite"}).Sub(1)
+		return "track_composite"
 	case *livekit.StartEgressRequest_Track:
-		m.requestGauge.With(prometheus.Labels{"type": "track"}).Sub(1)
+		return "track"
+	default:
+		return "unknown"
 	}
 }
@@ -0,0 +1,377 @@
+package stats
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/livekit"
+)
+
+type testCPUStats struct {
+	idle float64
+}
+
+func (c *testCPUStats) GetCPUIdle() float64 {
+	return c.idle
+}
+
+func (c *testCPUStats) Stop() {}
+
+func newTestMonitor(numCPUs float64, holdDuration time.Duration) *Monitor {
+	m := NewMonitor()
+	m.numCPUs = numCPUs
+	m.cpuCostConfig = config.CPUCostConfig{
+		RoomCompositeCpuCost:    3,
+		WebCpuCost:              3,
+		TrackCompositeCpuCost:   2,
+		TrackCpuCost:            1,
+		AudioOnlyCpuCostRatio:   0.5,
+		PassthroughCpuCostRatio: 0.25,
+		HardwareCpuCostRatio:    0.5,
+		CPUHoldDuration:         holdDuration,
+	}
+	m.cpuStats = &testCPUStats{idle: numCPUs}
+
+	m.memoryStats = &MemoryStats{}
+	m.memoryStats.usedGB.Store(1)
+	m.memoryStats.totalGB.Store(64)
+
+	m.requestGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "requests"}, []string{"type"})
+	m.promEgressCPU = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "egress_cpu"}, []string{"egress_id", "egress_type"})
+	m.bytesWritten = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bytes_written"}, []string{"egress_id", "egress_type"})
+	m.framesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "frames_dropped"}, []string{"egress_id", "egress_type"})
+	m.recentDropped = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "frames_dropped_last_minute"}, []string{"egress_id", "egress_type"})
+	m.maxQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "max_queue_depth"}, []string{"egress_id", "egress_type"})
+	return m
+}
+
+func newRoomCompositeRequest(egressID string) *livekit.StartEgressRequest {
+	return &livekit.StartEgressRequest{
+		EgressId: egressID,
+		Request: &livekit.StartEgressRequest_RoomComposite{
+			RoomComposite: &livekit.RoomCompositeEgressRequest{},
+		},
+	}
+}
+
+func TestSimultaneousRequests(t *testing.T) {
+	m := newTestMonitor(8, time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 3; i++ {
+		req := newRoomCompositeRequest(string(rune('a' + i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := m.AcceptRequest(req); ok {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 8 idle cpus can only afford two requests costing 3
+	require.Equal(t, 2, accepted)
+	require.Equal(t, float64(6), m.pendingCPUs.Load())
+}
+
+func TestHoldReleasedOnStart(t *testing.T) {
+	m := newTestMonitor(8, time.Minute)
+
+	req := newRoomCompositeRequest("egress")
+	ok, _ := m.AcceptRequest(req)
+	require.True(t, ok)
+	require.Equal(t, float64(3), m.pendingCPUs.Load())
+
+	m.EgressStarted(req)
+	require.Equal(t, float64(0), m.pendingCPUs.Load())
+
+	// releasing again should not free more than was held
+	m.EgressEnded(req)
+	require.Equal(t, float64(0), m.pendingCPUs.Load())
+}
+
+func TestHoldReleasedAfterTimeout(t *testing.T) {
+	m := newTestMonitor(8, time.Millisecond*50)
+
+	ok, _ := m.AcceptRequest(newRoomCompositeRequest("egress"))
+	require.True(t, ok)
+	require.Equal(t, float64(3), m.pendingCPUs.Load())
+
+	require.Eventually(t, func() bool {
+		return m.pendingCPUs.Load() == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestHoldReleasedOnFailure(t *testing.T) {
+	m := newTestMonitor(8, time.Minute)
+
+	ok, release := m.AcceptRequest(newRoomCompositeRequest("egress"))
+	require.True(t, ok)
+
+	release()
+	require.Equal(t, float64(0), m.pendingCPUs.Load())
+	require.Empty(t, m.holds)
+}
+
+func TestAudioOnlyCPUCost(t *testing.T) {
+	m := newTestMonitor(8, time.Minute)
+
+	audioOnly := &livekit.StartEgressRequest{
+		EgressId: "audio",
+		Request: &livekit.StartEgressRequest_RoomComposite{
+			RoomComposite: &livekit.RoomCompositeEgressRequest{AudioOnly: true},
+		},
+	}
+	ok, _ := m.AcceptRequest(audioOnly)
+	require.True(t, ok)
+	require.Equal(t, 1.5, m.pendingCPUs.Load())
+
+	audioTrack := &livekit.StartEgressRequest{
+		EgressId: "audio_track",
+		Request: &livekit.StartEgressRequest_TrackComposite{
+			TrackComposite: &livekit.TrackCompositeEgressRequest{AudioTrackId: "TR_audio"},
+		},
+	}
+	require.Equal(t, float64(1), m.getCPUCost(audioTrack))
+	require.Equal(t, int64(0), m.getEncoderSessionCost(audioTrack))
+
+	// track egress does not transcode, so it is never discounted
+	require.Equal(t, float64(1), m.getCPUCost(newTrackRequest("track")))
+}
+
+func TestPassthroughCPUCost(t *testing.T) {
+	m := newTestMonitor(8, time.Minute)
+
+	newRequest := func(filepath string) *livekit.StartEgressRequest {
+		return &livekit.StartEgressRequest{
+			EgressId: "passthrough",
+			Request: &livekit.StartEgressRequest_TrackComposite{
+				TrackComposite: &livekit.TrackCompositeEgressRequest{
+					AudioTrackId: "TR_audio",
+					VideoTrackId: "TR_video",
+					Output: &livekit.TrackCompositeEgressRequest_File{
+						File: &livekit.EncodedFileOutput{Filepath: filepath},
+					},
+				},
+			},
+		}
+	}
+
+	require.Equal(t, 0.5, m.getCPUCost(newRequest("recording.mkv")))
+	require.Equal(t, int64(0), m.getEncoderSessionCost(newRequest("recording.mkv")))
+	require.Equal(t, float64(2), m.getCPUCost(newRequest("recording.mp4")))
+}
+
+func TestHardwareCPUCost(t *testing.T) {
+	m := newTestMonitor(8, time.Minute)
+	require.Equal(t, float64(3), m.getCPUCost(newRoomCompositeRequest("software")))
+
+	m.hardwareEncoder = true
+	require.Equal(t, 1.5, m.getCPUCost(newRoomCompositeRequest("hardware")))
+	require.Equal(t, float64(1), m.getCPUCost(newTrackRequest("track")))
+}
+
+func TestIsMP4File(t *testing.T) {
+	newRequest := func(fileType livekit.EncodedFileType, filepath string, audioOnly bool) *livekit.StartEgressRequest {
+		return &livekit.StartEgressRequest{
+			Request: &livekit.StartEgressRequest_RoomComposite{
+				RoomComposite: &livekit.RoomCompositeEgressRequest{
+					AudioOnly: audioOnly,
+					Output: &livekit.RoomCompositeEgressRequest_File{
+						File: &livekit.EncodedFileOutput{FileType: fileType, Filepath: filepath},
+					},
+				},
+			},
+		}
+	}
+
+	require.True(t, isMP4File(newRequest(livekit.EncodedFileType_MP4, "recording", false)))
+	require.True(t, isMP4File(newRequest(livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.mp4", false)))
+	require.True(t, isMP4File(newRequest(livekit.EncodedFileType_DEFAULT_FILETYPE, "recording", false)))
+	require.False(t, isMP4File(newRequest(livekit.EncodedFileType_DEFAULT_FILETYPE, "recording", true)))
+	require.False(t, isMP4File(newRequest(livekit.EncodedFileType_DEFAULT_FILETYPE, "recording.webm", false)))
+	require.False(t, isMP4File(newRoomCompositeRequest("stream")))
+}
+
+func newTrackRequest(egressID string) *livekit.StartEgressRequest {
+	return &livekit.StartEgressRequest{
+		EgressId: egressID,
+		Request: &livekit.StartEgressRequest_Track{
+			Track: &livekit.TrackEgressRequest{},
+		},
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	m := newTestMonitor(64, time.Minute)
+	m.limits = config.ConcurrencyLimits{MaxConcurrent: 3}
+
+	for i := 0; i < 3; i++ {
+		req := newTrackRequest(string(rune('a' + i)))
+		ok, _ := m.AcceptRequest(req)
+		require.True(t, ok)
+		m.EgressProcessStarted(req, 0)
+		m.EgressStarted(req)
+	}
+
+	ok, _ := m.AcceptRequest(newRoomCompositeRequest("d"))
+	require.False(t, ok)
+	require.Equal(t, 0, m.AvailableSlots()["track"])
+
+	// ending an egress frees its slot
+	m.EgressEnded(newTrackRequest("a"))
+	ok, _ = m.AcceptRequest(newRoomCompositeRequest("d"))
+	require.True(t, ok)
+}
+
+func TestMaxConcurrentPerType(t *testing.T) {
+	m := newTestMonitor(64, time.Minute)
+	m.limits = config.ConcurrencyLimits{MaxRoomComposite: 2}
+
+	for i := 0; i < 2; i++ {
+		ok, _ := m.AcceptRequest(newRoomCompositeRequest(string(rune('a' + i))))
+		require.True(t, ok)
+	}
+
+	ok, _ := m.AcceptRequest(newRoomCompositeRequest("c"))
+	require.False(t, ok)
+	require.Equal(t, 0, m.AvailableSlots()["room_composite"])
+
+	// other types are not limited
+	ok, _ = m.AcceptRequest(newTrackRequest("d"))
+	require.True(t, ok)
+}
+
+func TestConcurrencyReleasedOnLaunchFailure(t *testing.T) {
+	m := newTestMonitor(64, time.Minute)
+	m.limits = config.ConcurrencyLimits{MaxConcurrent: 1}
+
+	ok, release := m.AcceptRequest(newTrackRequest("a"))
+	require.True(t, ok)
+	release()
+
+	ok, release = m.AcceptRequest(newTrackRequest("b"))
+	require.True(t, ok)
+
+	// once launched, releasing the resource hold keeps the slot
+	m.EgressProcessStarted(newTrackRequest("b"), 0)
+	release()
+	ok, _ = m.AcceptRequest(newTrackRequest("c"))
+	require.False(t, ok)
+}
+
+func TestRecentDrops(t *testing.T) {
+	m := newTestMonitor(64, time.Minute)
+	now := time.Now()
+
+	require.Equal(t, int64(5), m.recordDrops("a", 5, now))
+	require.Equal(t, int64(8), m.recordDrops("a", 3, now.Add(time.Second*30)))
+	require.Equal(t, int64(0), m.recordDrops("b", 0, now))
+
+	// drops older than a minute are forgotten, even without new drops
+	require.Equal(t, int64(3), m.recordDrops("a", 0, now.Add(time.Second*61)))
+	require.Equal(t, int64(0), m.recordDrops("a", 0, now.Add(time.Second*91)))
+}
+
+func TestFormulaCPUCost(t *testing.T) {
+	// 6 are in use
+	m := newTestMonitor(8, time.Minute)
+	m.cpuStats = &testCPUStats{idle: 2}
+	m.cpuCostConfig.Formula = &config.CPUCostFormula{
+		BaseCpuCost:      0.25,
+		ChromeCpuCost:    1,
+		MegapixelCpuCost: 0.04,
+		AudioCpuCost:     0.1,
+	}
+
+	newRequest := func(egressID string, options *livekit.EncodingOptions) *livekit.StartEgressRequest {
+		req := newRoomCompositeRequest(egressID)
+		if options != nil {
+			req.GetRoomComposite().Options = &livekit.RoomCompositeEgressRequest_Advanced{Advanced: options}
+		}
+		return req
+	}
+
+	// without encoding options, the flat cost of 3 is charged
+	ok, _ := m.AcceptRequest(newRequest("flat", nil))
+	require.False(t, ok)
+
+	// 1080p30 costs 0.25 + 1 + 0.1 + 62.2 * 0.04
+	require.InDelta(t, 3.838, m.getCPUCost(newRequest("1080p30", &livekit.EncodingOptions{Width: 1920, Height: 1080, Framerate: 30})), 0.001)
+	ok, _ = m.AcceptRequest(newRequest("1080p30", &livekit.EncodingOptions{Width: 1920, Height: 1080, Framerate: 30}))
+	require.False(t, ok)
+
+	// 720p15 costs 0.25 + 1 + 0.1 + 13.8 * 0.04
+	req := newRequest("720p15", &livekit.EncodingOptions{Width: 1280, Height: 720, Framerate: 15})
+	require.InDelta(t, 1.903, m.getCPUCost(req), 0.001)
+	ok, _ = m.AcceptRequest(req)
+	require.True(t, ok)
+	require.InDelta(t, 1.903, m.pendingCPUs.Load(), 0.001)
+
+	// presets, and the default framerate for options which don't set one
+	preset := newRoomCompositeRequest("preset")
+	preset.GetRoomComposite().Options = &livekit.RoomCompositeEgressRequest_Preset{Preset: livekit.EncodingOptionsPreset_H264_720P_30}
+	require.InDelta(t, 2.456, m.getCPUCost(preset), 0.001)
+	m.defaultFramerate = 15
+	require.InDelta(t, 1.903, m.getCPUCost(newRequest("default", &livekit.EncodingOptions{Width: 1280, Height: 720})), 0.001)
+
+	// audio only room composite is charged for chrome and audio, and track composite isn't charged for chrome
+	audioOnly := newRequest("audio", &livekit.EncodingOptions{})
+	audioOnly.GetRoomComposite().AudioOnly = true
+	require.InDelta(t, 1.35, m.getCPUCost(audioOnly), 0.001)
+	trackComposite := &livekit.StartEgressRequest{
+		EgressId: "track_composite",
+		Request: &livekit.StartEgressRequest_TrackComposite{
+			TrackComposite: &livekit.TrackCompositeEgressRequest{
+				VideoTrackId: "TR_video",
+				Options:      &livekit.TrackCompositeEgressRequest_Advanced{Advanced: &livekit.EncodingOptions{Width: 1280, Height: 720, Framerate: 15}},
+			},
+		},
+	}
+	require.InDelta(t, 0.803, m.getCPUCost(trackComposite), 0.001)
+
+	// hardware encoding discounts the video
+	m.hardwareEncoder = true
+	require.InDelta(t, 1.6265, m.getCPUCost(req), 0.001)
+
+	// track egress is not transcoded
+	require.Equal(t, float64(1), m.getCPUCost(newTrackRequest("track")))
+}
+
+func TestSetCPUCostConfig(t *testing.T) {
+	m := newTestMonitor(4, time.Second)
+	m.disabledGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "types_disabled"}, []string{"type"})
+	require.Equal(t, 1, m.AvailableSlots()["room_composite"])
+
+	costs := m.cpuCostConfig
+	costs.RoomCompositeCpuCost = 2
+	costs.WebCpuCost = 5
+	require.NoError(t, m.SetCPUCostConfig(costs))
+	require.Equal(t, 2, m.AvailableSlots()["room_composite"])
+	accepted, _ := m.AcceptRequest(newRoomCompositeRequest("room_composite"))
+	require.True(t, accepted)
+
+	// types which cost more than the node has are disabled
+	accepted, _ = m.AcceptRequest(&livekit.StartEgressRequest{
+		EgressId: "web",
+		Request:  &livekit.StartEgressRequest_Web{Web: &livekit.WebEgressRequest{}},
+	})
+	require.False(t, accepted)
+
+	costs.TrackCpuCost = 8
+	costs.TrackCompositeCpuCost = 8
+	costs.RoomCompositeCpuCost = 8
+	costs.WebCpuCost = 8
+	require.Error(t, m.SetCPUCostConfig(costs))
+	require.Equal(t, float64(2), m.cpuCostConfig.RoomCompositeCpuCost)
+}
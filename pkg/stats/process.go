@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// USER_HZ, which is 100 on all supported platforms
+const clockTicksPerSecond = 100
+
+type processCPU struct {
+	egressType string
+	pid        int
+
+	lastTicks  uint64
+	lastSample time.Time
+	cpu        float64
+}
+
+type procStat struct {
+	ppid  int
+	ticks uint64
+}
+
+// update samples cpu usage (in cores) for the process and all of its descendants
+func (p *processCPU) update(stats map[int]*procStat, children map[int][]int, now time.Time) {
+	ticks := treeTicks(p.pid, stats, children)
+	if !p.lastSample.IsZero() && ticks >= p.lastTicks {
+		elapsed := now.Sub(p.lastSample).Seconds()
+		if elapsed > 0 {
+			p.cpu = float64(ticks-p.lastTicks) / clockTicksPerSecond / elapsed
+		}
+	}
+	p.lastTicks = ticks
+	p.lastSample = now
+}
+
+func treeTicks(pid int, stats map[int]*procStat, children map[int][]int) uint64 {
+	var ticks uint64
+	if s, ok := stats[pid]; ok {
+		ticks = s.ticks
+	}
+	for _, child := range children[pid] {
+		ticks += treeTicks(child, stats, children)
+	}
+	return ticks
+}
+
+// readProcStats reads /proc/<pid>/stat for every running process
+func readProcStats() (map[int]*procStat, map[int][]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := make(map[int]*procStat)
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		s, err := readProcStat(pid)
+		if err != nil {
+			// process exited
+			continue
+		}
+
+		stats[pid] = s
+		children[s.ppid] = append(children[s.ppid], pid)
+	}
+
+	return stats, children, nil
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	b, err := os.ReadFile(path.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the command name can contain spaces, so parse from the closing paren
+	line := string(b)
+	idx := strings.LastIndexByte(line, ')')
+	if idx < 0 {
+		return nil, os.ErrInvalid
+	}
+
+	// fields start at state (field 3)
+	fields := strings.Fields(line[idx+1:])
+	if len(fields) < 13 {
+		return nil, os.ErrInvalid
+	}
+
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &procStat{
+		ppid:  ppid,
+		ticks: utime + stime,
+	}, nil
+}
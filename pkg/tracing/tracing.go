@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/tracer"
+)
+
+const shutdownTimeout = time.Second * 5
+
+// the trace context is passed to handlers in the environment, as W3C traceparent and tracestate
+var environ = map[string]string{
+	"traceparent": "TRACEPARENT",
+	"tracestate":  "TRACESTATE",
+}
+
+// Init exports the spans started with the protocol tracer, which is a no-op until then. Spans are batched, and
+// the returned func flushes them before the process exits
+func Init(conf *config.Config, serviceName string) (func(), error) {
+	if conf.Tracing == nil {
+		return func() {}, nil
+	}
+
+	u, err := url.Parse(conf.Tracing.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	if len(conf.Tracing.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(conf.Tracing.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.Tracing.SampleRate))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.instance.id", conf.NodeID),
+		)),
+	)
+	tracer.SetTracer(&otelTracer{tracer: provider.Tracer("github.com/livekit/egress")})
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.Errorw("failed to flush traces", err)
+		}
+	}, nil
+}
+
+// SetEgress labels the span in ctx with the egress it belongs to
+func SetEgress(ctx context.Context, egressID, egressType string) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("egress.id", egressID),
+		attribute.String("egress.type", egressType),
+	)
+}
+
+// Environ returns the trace context of ctx as environment variables, for a handler to continue the trace.
+// It is empty when tracing is disabled
+func Environ(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	var env []string
+	for key, value := range carrier {
+		if name, ok := environ[key]; ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// FromEnviron returns ctx with the trace context passed in by the service, if any
+func FromEnviron(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, name := range environ {
+		if value := os.Getenv(name); value != "" {
+			carrier[key] = value
+		}
+	}
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t *otelTracer) Start(ctx context.Context, spanName string, opts ...interface{}) (context.Context, tracer.Span) {
+	var startOpts []trace.SpanStartOption
+	for _, opt := range opts {
+		if o, ok := opt.(trace.SpanStartOption); ok {
+			startOpts = append(startOpts, o)
+		}
+	}
+	ctx, span := t.tracer.Start(ctx, spanName, startOpts...)
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
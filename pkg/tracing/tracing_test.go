@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/livekit/protocol/tracer"
+)
+
+func TestEnviron(t *testing.T) {
+	// nothing to pass on without a trace
+	ctx, span := tracer.Start(context.Background(), "test")
+	defer span.End()
+	require.Empty(t, Environ(ctx))
+	require.Equal(t, ctx, FromEnviron(ctx))
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	env := Environ(trace.ContextWithSpanContext(context.Background(), parent))
+	require.Equal(t, []string{"TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, env)
+
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		t.Setenv(name, value)
+	}
+	extracted := trace.SpanContextFromContext(FromEnviron(context.Background()))
+	require.True(t, extracted.IsRemote())
+	require.Equal(t, traceID, extracted.TraceID())
+	require.Equal(t, spanID, extracted.SpanID())
+	require.True(t, extracted.IsSampled())
+
+}
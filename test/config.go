@@ -76,8 +76,8 @@ func NewTestContext(t *testing.T) *TestConfig {
 	if conf.ApiKey == "" || conf.ApiSecret == "" || conf.WsUrl == "" {
 		t.Fatal("api key, secret, and ws url required")
 	}
-	if conf.Redis == nil {
-		t.Fatal("redis required")
+	if conf.Redis == nil && conf.GRPC == nil {
+		t.Fatal("redis or grpc required")
 	}
 
 	tc.runRoomTests = !tc.ParticipantTestsOnly && !tc.TrackCompositeTestsOnly && !tc.TrackTestsOnly && !tc.WebTestsOnly
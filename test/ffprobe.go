@@ -5,7 +5,9 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -27,6 +29,9 @@ const (
 	maxRetries = 5
 	minDelay   = time.Millisecond * 100
 	maxDelay   = time.Second * 5
+
+	// maximum difference between the end of the audio and video streams
+	avSyncTolerance = 0.5
 )
 
 type FFProbeInfo struct {
@@ -34,6 +39,8 @@ type FFProbeInfo struct {
 		CodecName string `json:"codec_name"`
 		CodecType string `json:"codec_type"`
 		Profile   string `json:"profile"`
+		StartTime string `json:"start_time"`
+		Duration  string `json:"duration"`
 
 		// audio
 		SampleRate    string `json:"sample_rate"`
@@ -53,7 +60,10 @@ type FFProbeInfo struct {
 		Size       string `json:"size"`
 		ProbeScore int    `json:"probe_score"`
 		Tags       struct {
-			Encoder string `json:"encoder"`
+			Encoder      string `json:"encoder"`
+			Title        string `json:"title"`
+			Comment      string `json:"comment"`
+			CreationTime string `json:"creation_time"`
 		} `json:"tags"`
 	} `json:"format"`
 }
@@ -88,6 +98,22 @@ func ffprobe(input string) (*FFProbeInfo, error) {
 	return info, err
 }
 
+// maxVolume returns the peak volume of the audio in a file, in dB
+func maxVolume(t *testing.T, input string) float64 {
+	out, err := exec.Command("ffmpeg", "-i", input, "-af", "volumedetect", "-f", "null", "-").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	match := regexp.MustCompile(`max_volume: (\S+) dB`).FindStringSubmatch(string(out))
+	require.NotNil(t, match, "volume missing from ffmpeg output")
+	if match[1] == "-inf" {
+		return math.Inf(-1)
+	}
+
+	volume, err := strconv.ParseFloat(match[1], 64)
+	require.NoError(t, err)
+	return volume
+}
+
 func verifyFile(t *testing.T, conf *TestConfig, p *params.Params, res *livekit.EgressInfo) {
 	// egress info
 	require.Equal(t, res.Error == "", res.Status != livekit.EgressStatus_EGRESS_FAILED)
@@ -171,11 +197,25 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 		require.Equal(t, 100, info.Format.ProbeScore)
 	}
 
+	switch p.OutputType {
+	case params.OutputTypeWebM:
+		require.Contains(t, info.Format.FormatName, "webm")
+	case params.OutputTypeMKV:
+		require.Contains(t, info.Format.FormatName, "matroska")
+	}
+
 	switch resultType {
 	case ResultTypeFile:
 		// size
 		require.NotEqual(t, "0", info.Format.Size)
 
+		// metadata
+		if p.OutputType == params.OutputTypeMP4 {
+			require.Equal(t, res.EgressId, info.Format.Tags.Comment)
+			require.Equal(t, res.RoomName, info.Format.Tags.Title)
+			require.NotEmpty(t, info.Format.Tags.CreationTime)
+		}
+
 		// duration
 		expected := float64(res.GetFile().Duration) / 1e9
 		actual, err := strconv.ParseFloat(info.Format.Duration, 64)
@@ -186,6 +226,10 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 		case *livekit.EgressInfo_RoomComposite:
 			require.InDelta(t, expected, actual, 1.5)
 
+		case *livekit.EgressInfo_TrackComposite:
+			// muted periods are filled, so the file should cover the whole egress
+			require.InDelta(t, expected, actual, 1.5)
+
 		case *livekit.EgressInfo_Track:
 			if p.AudioEnabled {
 				delta := 3.0
@@ -202,10 +246,12 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 
 	// check stream info
 	var hasAudio, hasVideo bool
+	var audioEnd, videoEnd float64
 	for _, stream := range info.Streams {
 		switch stream.CodecType {
 		case "audio":
 			hasAudio = true
+			audioEnd = streamEnd(stream.StartTime, stream.Duration)
 
 			// codec
 			switch p.AudioCodec {
@@ -237,11 +283,16 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 
 		case "video":
 			hasVideo = true
+			videoEnd = streamEnd(stream.StartTime, stream.Duration)
 
 			// codec and profile
 			switch p.VideoCodec {
 			case params.MimeTypeH264:
 				require.Equal(t, "h264", stream.CodecName)
+				if p.Passthrough {
+					// profile is chosen by the publisher
+					break
+				}
 
 				switch p.VideoProfile {
 				case params.ProfileBaseline:
@@ -253,6 +304,8 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 				}
 			case params.MimeTypeVP8:
 				require.Equal(t, "vp8", stream.CodecName)
+			case params.MimeTypeVP9:
+				require.Equal(t, "vp9", stream.CodecName)
 			}
 
 			switch p.OutputType {
@@ -279,7 +332,14 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 				require.NoError(t, err)
 				d, err := strconv.ParseFloat(frac[1], 64)
 				require.NoError(t, err)
-				require.Greater(t, n/d, float64(p.Framerate)*0.95)
+				require.Greater(t, n/d, p.Framerate.Float()*0.95)
+
+			case params.OutputTypeWebM:
+				// vp8 is muxed without transcoding, so only vp9 is scaled to the requested dimensions
+				if p.VideoCodec == params.MimeTypeVP9 {
+					require.Equal(t, p.Width, stream.Width)
+					require.Equal(t, p.Height, stream.Height)
+				}
 			}
 
 		default:
@@ -296,4 +356,19 @@ func verify(t *testing.T, input string, p *params.Params, res *livekit.EgressInf
 		require.True(t, hasVideo)
 		require.NotEmpty(t, p.VideoCodec)
 	}
+
+	// a/v sync, including after mute cycles. Stream durations are only reported by some containers
+	if hasAudio && hasVideo && audioEnd > 0 && videoEnd > 0 {
+		require.InDelta(t, audioEnd, videoEnd, avSyncTolerance, "audio and video out of sync")
+	}
+}
+
+// streamEnd returns the end time of a stream in seconds, or 0 if unknown
+func streamEnd(startTime, duration string) float64 {
+	d, err := strconv.ParseFloat(duration, 64)
+	if err != nil {
+		return 0
+	}
+	start, _ := strconv.ParseFloat(startTime, 64)
+	return start + d
 }
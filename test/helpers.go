@@ -13,6 +13,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -119,6 +120,9 @@ func download(t *testing.T, uploadParams interface{}, localFilepath, storageFile
 
 	case *livekit.AzureBlobUpload:
 		downloadAzure(t, u, localFilepath, storageFilepath)
+
+	case *livekit.AliOSSUpload:
+		downloadAliOSS(t, u, localFilepath, storageFilepath)
 	}
 }
 
@@ -223,3 +227,19 @@ func downloadGCP(t *testing.T, conf *livekit.GCPUpload, localFilepath, storageFi
 	err = client.Bucket(conf.Bucket).Object(storageFilepath).Delete(context.Background())
 	require.NoError(t, err)
 }
+
+// downloadAliOSS also works with oss compatible emulators, using the endpoint from the test config
+func downloadAliOSS(t *testing.T, conf *livekit.AliOSSUpload, localFilepath, storageFilepath string) {
+	endpoint := conf.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://oss-%s.aliyuncs.com", conf.Region)
+	}
+	client, err := oss.New(endpoint, conf.AccessKey, conf.Secret)
+	require.NoError(t, err)
+
+	bucket, err := client.Bucket(conf.Bucket)
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.GetObjectToFile(storageFilepath, localFilepath))
+	require.NoError(t, bucket.DeleteObject(storageFilepath))
+}
@@ -6,7 +6,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -50,7 +53,7 @@ type testCase struct {
 	outputType params.OutputType
 }
 
-func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rpcServer egress.RPCServer) {
+func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rpcServer service.RPCServer) {
 	// connect to room
 	room, err := lksdk.ConnectToRoom(conf.WsUrl, lksdk.ConnectInfo{
 		APIKey:              conf.ApiKey,
@@ -63,7 +66,7 @@ func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rp
 	defer room.Disconnect()
 
 	// start service
-	svc := service.NewService(conf.Config, rpcServer)
+	svc := service.NewService(conf.Config, rpcServer, nil)
 	go func() {
 		err := svc.Run()
 		require.NoError(t, err)
@@ -85,8 +88,12 @@ func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rp
 	// check status
 	if conf.HealthPort != 0 {
 		status := getStatus(t, svc)
-		require.Len(t, status, 1)
-		require.Contains(t, status, "CpuLoad")
+		require.Empty(t, status.Egresses)
+		require.Contains(t, status.EgressCounts, "completed")
+		require.Contains(t, status.EgressCounts, "failed")
+		require.Len(t, status.AvailableSlots, 4)
+
+		checkMetrics(t, conf, svc)
 	}
 
 	// run tests
@@ -95,6 +102,9 @@ func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rp
 			t.Run("RoomComposite/File", func(t *testing.T) {
 				testRoomCompositeFile(t, conf)
 			})
+			t.Run("RoomComposite/Empty", func(t *testing.T) {
+				testRoomCompositeEmpty(t, conf)
+			})
 		}
 
 		if conf.runStreamTests {
@@ -115,6 +125,9 @@ func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rp
 			t.Run("TrackComposite/File", func(t *testing.T) {
 				testTrackCompositeFile(t, conf)
 			})
+			t.Run("TrackComposite/Republish", func(t *testing.T) {
+				testTrackCompositeRepublish(t, conf)
+			})
 		}
 
 		if conf.runStreamTests {
@@ -149,6 +162,9 @@ func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rp
 			t.Run("Web/File", func(t *testing.T) {
 				testWebFile(t, conf)
 			})
+			t.Run("Web/AudioIsolation", func(t *testing.T) {
+				testWebAudioIsolation(t, conf)
+			})
 		}
 
 		if conf.runStreamTests {
@@ -168,7 +184,7 @@ func RunTestSuite(t *testing.T, conf *TestConfig, rpcClient egress.RPCClient, rp
 func awaitIdle(t *testing.T, svc *service.Service) {
 	for i := 0; i < 30; i++ {
 		status := getStatus(t, svc)
-		if len(status) == 1 {
+		if len(status.Egresses) == 0 {
 			return
 		}
 		time.Sleep(time.Second)
@@ -257,9 +273,8 @@ func runMultipleStreamTest(t *testing.T, conf *TestConfig, req *livekit.StartEgr
 	})
 	require.NoError(t, err)
 
-	time.Sleep(time.Second * 5)
-
-	update := getUpdate(t, conf.updates, egressID)
+	// the bad url is only marked as failed once its reconnect attempts have been used up
+	update := awaitStreamStatus(t, conf.updates, egressID, badStreamUrl, livekit.StreamInfo_FAILED)
 	require.Equal(t, livekit.EgressStatus_EGRESS_ACTIVE.String(), update.Status.String())
 	require.Len(t, update.GetStream().Info, 3)
 	for _, info := range update.GetStream().Info {
@@ -373,7 +388,11 @@ func startEgress(t *testing.T, conf *TestConfig, req *livekit.StartEgressRequest
 	// check status
 	if conf.HealthPort != 0 {
 		status := getStatus(t, conf.svc)
-		require.Contains(t, status, info.EgressId)
+		require.Contains(t, status.Egresses, info.EgressId)
+		egressStatus := status.Egresses[info.EgressId]
+		require.Equal(t, info.EgressId, egressStatus.EgressId)
+		require.NotEmpty(t, egressStatus.Type)
+		require.NotEmpty(t, egressStatus.Status)
 	}
 
 	// wait
@@ -385,12 +404,52 @@ func startEgress(t *testing.T, conf *TestConfig, req *livekit.StartEgressRequest
 	return info.EgressId
 }
 
-func getStatus(t *testing.T, svc *service.Service) map[string]interface{} {
+// checkMetrics fetches /metrics from a server with the service's metrics handler, as served on the health port
+func checkMetrics(t *testing.T, conf *TestConfig, svc *service.Service) {
+	server := httptest.NewServer(svc.MetricsHandler())
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	require.NoError(t, err)
+	if auth := conf.MetricsAuth; auth != nil {
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+auth.BearerToken)
+		}
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	for _, name := range []string{
+		"livekit_egress_available",
+		"livekit_egress_cpu_load",
+		"livekit_egress_memory_load",
+		"livekit_egress_disk_free_bytes",
+		"livekit_egress_available_slots",
+		"livekit_egress_retained_bytes",
+		"livekit_egress_upload_throughput_bytes",
+	} {
+		require.Contains(t, string(b), name)
+	}
+}
+
+func getStatus(t *testing.T, svc *service.Service) *service.ServiceStatus {
 	b, err := svc.Status()
 	require.NoError(t, err)
 
-	status := make(map[string]interface{})
-	err = json.Unmarshal(b, &status)
+	status := &service.ServiceStatus{}
+	err = json.Unmarshal(b, status)
 	require.NoError(t, err)
 
 	return status
@@ -406,9 +465,29 @@ func checkUpdate(t *testing.T, sub utils.PubSub, egressID string, status livekit
 		require.Empty(t, info.Error, "status %s with error %s", info.Status.String(), info.Error)
 	}
 
+	if info.Status == livekit.EgressStatus_EGRESS_COMPLETE {
+		checkResult(t, info)
+	}
+
 	return info
 }
 
+// checkResult verifies the output details included in the final update
+func checkResult(t *testing.T, info *livekit.EgressInfo) {
+	switch res := info.Result.(type) {
+	case *livekit.EgressInfo_File:
+		require.NotEmpty(t, res.File.Location, "file location missing")
+		require.Greater(t, res.File.Size, int64(0), "file size missing")
+		require.Greater(t, res.File.Duration, int64(0), "file duration missing")
+
+	case *livekit.EgressInfo_Segments:
+		require.NotEmpty(t, res.Segments.PlaylistLocation, "playlist location missing")
+		require.Greater(t, res.Segments.SegmentCount, int64(0), "segment count missing")
+		require.Greater(t, res.Segments.Size, int64(0), "segments size missing")
+		require.Greater(t, res.Segments.Duration, int64(0), "segments duration missing")
+	}
+}
+
 func getUpdate(t *testing.T, sub utils.PubSub, egressID string) *livekit.EgressInfo {
 	for {
 		select {
@@ -427,6 +506,64 @@ func getUpdate(t *testing.T, sub utils.PubSub, egressID string) *livekit.EgressI
 	}
 }
 
+// awaitResults returns the final info of each egress, for egresses running at the same time
+func awaitResults(t *testing.T, sub utils.PubSub, egressIDs ...string) map[string]*livekit.EgressInfo {
+	results := make(map[string]*livekit.EgressInfo)
+	pending := make(map[string]bool)
+	for _, egressID := range egressIDs {
+		pending[egressID] = true
+	}
+
+	deadline := time.After(time.Second * 90)
+	for len(pending) > 0 {
+		select {
+		case msg := <-sub.Channel():
+			info := &livekit.EgressInfo{}
+			require.NoError(t, proto.Unmarshal(sub.Payload(msg), info))
+			if !pending[info.EgressId] {
+				continue
+			}
+			switch info.Status {
+			case livekit.EgressStatus_EGRESS_COMPLETE,
+				livekit.EgressStatus_EGRESS_FAILED,
+				livekit.EgressStatus_EGRESS_ABORTED,
+				livekit.EgressStatus_EGRESS_LIMIT_REACHED:
+				results[info.EgressId] = info
+				delete(pending, info.EgressId)
+			}
+
+		case <-deadline:
+			t.Fatalf("no final update for %d egresses", len(pending))
+			return nil
+		}
+	}
+	return results
+}
+
+// awaitStreamStatus returns the first update in which url has the given status
+func awaitStreamStatus(t *testing.T, sub utils.PubSub, egressID, url string, status livekit.StreamInfo_Status) *livekit.EgressInfo {
+	deadline := time.After(time.Second * 45)
+	for {
+		select {
+		case msg := <-sub.Channel():
+			info := &livekit.EgressInfo{}
+			require.NoError(t, proto.Unmarshal(sub.Payload(msg), info))
+			if info.EgressId != egressID {
+				continue
+			}
+			for _, streamInfo := range info.GetStream().GetInfo() {
+				if streamInfo.Url == url && streamInfo.Status == status {
+					return info
+				}
+			}
+
+		case <-deadline:
+			t.Fatalf("%s never reached status %s", url, status)
+			return nil
+		}
+	}
+}
+
 func stopEgress(t *testing.T, conf *TestConfig, egressID string) *livekit.EgressInfo {
 	// send stop request
 	info, err := conf.rpcClient.SendRequest(context.Background(), &livekit.EgressRequest{
@@ -458,7 +595,7 @@ func checkStoppedEgress(t *testing.T, conf *TestConfig, egressID string, expecte
 	// check status
 	if conf.HealthPort != 0 {
 		status := getStatus(t, conf.svc)
-		require.Len(t, status, 1)
+		require.NotContains(t, status.Egresses, egressID)
 	}
 
 	return info
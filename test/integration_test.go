@@ -6,19 +6,34 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
+	"github.com/livekit/egress/pkg/service"
 	"github.com/livekit/protocol/egress"
-	"github.com/livekit/protocol/redis"
 )
 
 func TestEgress(t *testing.T) {
 	conf := NewTestContext(t)
 
-	// rpc client and server
-	rc, err := redis.GetRedisClient(conf.Config.Redis)
+	// rpc server, over the transport selected by the config
+	transport, err := service.NewServiceTransport(conf.Config)
 	require.NoError(t, err)
-	rpcServer := egress.NewRedisRPCServer(rc)
-	rpcClient := egress.NewRedisRPCClient("egress_test", rc)
+	t.Cleanup(transport.Close)
 
-	RunTestSuite(t, conf, rpcClient, rpcServer)
+	// rpc client
+	var rpcClient egress.RPCClient
+	if conf.GRPC != nil {
+		require.Nil(t, conf.GRPC.TLS, "the tests connect to grpc without tls")
+		conn, err := grpc.Dial(conf.GRPC.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		rpcClient = service.NewGRPCClient(conn)
+	} else {
+		rc, err := service.NewRedisClient(conf.Config.Redis)
+		require.NoError(t, err)
+		rpcClient = egress.NewRedisRPCClient("egress_test", rc)
+	}
+
+	RunTestSuite(t, conf, rpcClient, transport.RPCServer)
 }
@@ -0,0 +1,82 @@
+//go:build integration
+
+package test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/service"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/redis"
+	"github.com/livekit/protocol/utils"
+)
+
+// TestRedisSentinelFailover runs against a sentinel setup such as build/test/redis-sentinel, with
+// REDIS_SENTINEL_ADDRESSES=localhost:26379 and REDIS_SENTINEL_MASTER=egress
+func TestRedisSentinelFailover(t *testing.T) {
+	addresses := os.Getenv("REDIS_SENTINEL_ADDRESSES")
+	if addresses == "" {
+		t.Skip("REDIS_SENTINEL_ADDRESSES not set")
+	}
+	masterName := os.Getenv("REDIS_SENTINEL_MASTER")
+	if masterName == "" {
+		masterName = "egress"
+	}
+
+	ctx := context.Background()
+	conf := &config.RedisConfig{
+		RedisConfig: redis.RedisConfig{
+			SentinelAddresses: strings.Split(addresses, ","),
+			MasterName:        masterName,
+		},
+		MaxRetries:      10,
+		MinRetryBackoff: time.Millisecond * 100,
+		MaxRetryBackoff: time.Second * 3,
+	}
+	rc, err := service.NewRedisClient(conf)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	bus := utils.NewRedisMessageBus(rc)
+	sub, err := bus.Subscribe(ctx, "egress_failover_test")
+	require.NoError(t, err)
+	defer sub.Close()
+
+	sentinel := goredis.NewSentinelClient(&goredis.Options{Addr: conf.SentinelAddresses[0]})
+	defer sentinel.Close()
+	master, err := sentinel.GetMasterAddrByName(ctx, masterName).Result()
+	require.NoError(t, err)
+	require.NoError(t, sentinel.Failover(ctx, masterName).Err())
+
+	// updates published during and after the failover still arrive once the new master is up
+	deadline := time.After(time.Minute)
+	for {
+		info := &livekit.EgressInfo{EgressId: "EG_failover", StartedAt: time.Now().UnixNano()}
+		if err = bus.Publish(ctx, "egress_failover_test", info); err != nil {
+			t.Logf("publish failed: %v", err)
+		}
+
+		select {
+		case msg := <-sub.Channel():
+			received := &livekit.EgressInfo{}
+			require.NoError(t, proto.Unmarshal(sub.Payload(msg), received))
+			current, err := sentinel.GetMasterAddrByName(ctx, masterName).Result()
+			require.NoError(t, err)
+			if received.StartedAt == info.StartedAt && (current[0] != master[0] || current[1] != master[1]) {
+				return
+			}
+		case <-time.After(time.Second):
+		case <-deadline:
+			t.Fatal("no updates received after failover")
+		}
+	}
+}
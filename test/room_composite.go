@@ -9,6 +9,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/pipeline/params"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/utils"
@@ -39,6 +40,21 @@ func testRoomCompositeFile(t *testing.T, conf *TestConfig) {
 			},
 			filename: "r_{room_name}_opus_{time}",
 		},
+		{
+			name:     "h264-portrait-mp4",
+			fileType: livekit.EncodedFileType_MP4,
+			options: &livekit.EncodingOptions{
+				AudioCodec: livekit.AudioCodec_AAC,
+				VideoCodec: livekit.VideoCodec_H264_MAIN,
+				Width:      1080,
+				Height:     1920,
+			},
+			filename: "r_{room_name}_portrait_{time}.mp4",
+		},
+		{
+			name:     "vp9-webm",
+			filename: "r_{room_name}_vp9_{time}.webm",
+		},
 		{
 			name:     "h264-high-mp4-limit",
 			fileType: livekit.EncodedFileType_MP4,
@@ -222,3 +238,36 @@ func testRoomCompositeSegments(t *testing.T, conf *TestConfig) {
 		return
 	}
 }
+
+func testRoomCompositeEmpty(t *testing.T, conf *TestConfig) {
+	conf.RoomEmpty = config.RoomEmptyConfig{Timeout: time.Second * 10, Tracks: true}
+	t.Cleanup(func() { conf.RoomEmpty = config.RoomEmptyConfig{} })
+
+	t.Run("room-empty", func(t *testing.T) {
+		awaitIdle(t, conf.svc)
+
+		// nothing is published, so the egress ends on its own
+		req := &livekit.StartEgressRequest{
+			EgressId: utils.NewGuid(utils.EgressPrefix),
+			Request: &livekit.StartEgressRequest_RoomComposite{
+				RoomComposite: &livekit.RoomCompositeEgressRequest{
+					RoomName: conf.room.Name(),
+					Layout:   "speaker-dark",
+					Output: &livekit.RoomCompositeEgressRequest_File{
+						File: &livekit.EncodedFileOutput{
+							FileType: livekit.EncodedFileType_MP4,
+							Filepath: getFilePath(conf.Config, "r_empty_{time}.mp4"),
+						},
+					},
+				},
+			},
+		}
+
+		egressID := startEgress(t, conf, req)
+		res := checkStoppedEgress(t, conf, egressID, livekit.EgressStatus_EGRESS_COMPLETE)
+
+		p, err := params.GetPipelineParams(context.Background(), conf.Config, req)
+		require.NoError(t, err)
+		verifyFile(t, conf, p, res)
+	})
+}
@@ -38,6 +38,13 @@ func testTrackFile(t *testing.T, conf *TestConfig) {
 			outputType: params.OutputTypeWebM,
 			filename:   "t_{track_type}_{time}.webm",
 		},
+		{
+			name:       "track-vp8-ivf",
+			videoOnly:  true,
+			videoCodec: params.MimeTypeVP8,
+			outputType: params.OutputTypeIVF,
+			filename:   "t_{track_type}_{time}.ivf",
+		},
 		{
 			name:       "track-h264",
 			videoOnly:  true,
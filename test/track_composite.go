@@ -3,9 +3,13 @@
 package test
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/pipeline/params"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/utils"
@@ -27,6 +31,36 @@ func testTrackCompositeFile(t *testing.T, conf *TestConfig) {
 			videoCodec: params.MimeTypeH264,
 			filename:   "tc_{room_name}_h264_{time}.mp4",
 		},
+		{
+			name:       "tc-aac-48k-mp4",
+			fileType:   livekit.EncodedFileType_MP4,
+			audioCodec: params.MimeTypeOpus,
+			videoCodec: params.MimeTypeH264,
+			options: &livekit.EncodingOptions{
+				AudioCodec:     livekit.AudioCodec_AAC,
+				AudioBitrate:   192,
+				AudioFrequency: 48000,
+			},
+			filename: "tc_{room_name}_aac_48k_{time}.mp4",
+		},
+		{
+			name:       "tc-opus-ogg",
+			audioOnly:  true,
+			audioCodec: params.MimeTypeOpus,
+			filename:   "tc_{room_name}_opus_{time}.ogg",
+		},
+		{
+			name:       "tc-vp9-webm",
+			audioCodec: params.MimeTypeOpus,
+			videoCodec: params.MimeTypeH264,
+			filename:   "tc_{room_name}_vp9_{time}.webm",
+		},
+		{
+			name:       "tc-passthrough-mkv",
+			audioCodec: params.MimeTypeOpus,
+			videoCodec: params.MimeTypeH264,
+			filename:   "tc_{room_name}_passthrough_{time}.mkv",
+		},
 		{
 			name:           "tc-limit",
 			fileType:       livekit.EncodedFileType_MP4,
@@ -175,3 +209,64 @@ func testTrackCompositeSegments(t *testing.T, conf *TestConfig) {
 		})
 	}
 }
+
+func testTrackCompositeRepublish(t *testing.T, conf *TestConfig) {
+	conf.Republish.Timeout = time.Second * 10
+	conf.Republish.Placeholder = true
+	t.Cleanup(func() { conf.Republish = config.RepublishConfig{} })
+
+	newRequest := func(audioTrackID, videoTrackID, filename string) *livekit.StartEgressRequest {
+		return &livekit.StartEgressRequest{
+			EgressId: utils.NewGuid(utils.EgressPrefix),
+			Request: &livekit.StartEgressRequest_TrackComposite{
+				TrackComposite: &livekit.TrackCompositeEgressRequest{
+					RoomName:     conf.room.Name(),
+					AudioTrackId: audioTrackID,
+					VideoTrackId: videoTrackID,
+					Output: &livekit.TrackCompositeEgressRequest_File{
+						File: &livekit.EncodedFileOutput{
+							FileType: livekit.EncodedFileType_MP4,
+							Filepath: getFilePath(conf.Config, filename),
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("tc-republish", func(t *testing.T) {
+		awaitIdle(t, conf.svc)
+		audioTrackID, videoTrackID := publishSamplesToRoom(t, conf.room, params.MimeTypeOpus, params.MimeTypeH264, false)
+
+		req := newRequest(audioTrackID, videoTrackID, "tc_republish_{time}.mp4")
+		egressID := startEgress(t, conf, req)
+
+		// the replacement is picked up and the egress completes
+		time.Sleep(time.Second * 5)
+		require.NoError(t, conf.room.LocalParticipant.UnpublishTrack(videoTrackID))
+		time.Sleep(time.Second * 3)
+		publishSampleToRoom(t, conf.room, params.MimeTypeH264, false)
+		time.Sleep(time.Second * 10)
+
+		res := stopEgress(t, conf, egressID)
+
+		p, err := params.GetPipelineParams(context.Background(), conf.Config, req)
+		require.NoError(t, err)
+		verifyFile(t, conf, p, res)
+	})
+
+	t.Run("tc-republish-timeout", func(t *testing.T) {
+		awaitIdle(t, conf.svc)
+		audioTrackID, videoTrackID := publishSamplesToRoom(t, conf.room, params.MimeTypeOpus, params.MimeTypeH264, false)
+
+		req := newRequest(audioTrackID, videoTrackID, "tc_republish_timeout_{time}.mp4")
+		egressID := startEgress(t, conf, req)
+
+		// without a replacement the egress fails once the timeout has passed
+		time.Sleep(time.Second * 5)
+		require.NoError(t, conf.room.LocalParticipant.UnpublishTrack(videoTrackID))
+
+		res := checkUpdate(t, conf.updates, egressID, livekit.EgressStatus_EGRESS_FAILED)
+		require.Contains(t, res.Error, "was not republished")
+	})
+}
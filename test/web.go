@@ -3,12 +3,20 @@
 package test
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/egress/pkg/pipeline/params"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/utils"
 )
 
+const silentPageUrl = "data:text/html,<html><body>silent</body></html>"
+
 func testWebFile(t *testing.T, conf *TestConfig) {
 	awaitIdle(t, conf.svc)
 
@@ -74,3 +82,68 @@ func testWebSegments(t *testing.T, conf *TestConfig) {
 
 	runSegmentsTest(t, conf, req, 0)
 }
+
+// testWebAudioIsolation records a page with audio and a silent page at the same time,
+// and checks that the silent recording doesn't pick up the other page's audio
+func testWebAudioIsolation(t *testing.T, conf *TestConfig) {
+	awaitIdle(t, conf.svc)
+
+	newRequest := func(url, filename string) *livekit.StartEgressRequest {
+		return &livekit.StartEgressRequest{
+			EgressId: utils.NewGuid(utils.EgressPrefix),
+			Request: &livekit.StartEgressRequest_Web{
+				Web: &livekit.WebEgressRequest{
+					Url:       url,
+					AudioOnly: true,
+					Output: &livekit.WebEgressRequest_File{
+						File: &livekit.EncodedFileOutput{
+							FileType: livekit.EncodedFileType_OGG,
+							Filepath: getFilePath(conf.Config, filename),
+						},
+					},
+				},
+			},
+		}
+	}
+	audible := newRequest(webUrl, "web_audible_{time}.ogg")
+	silent := newRequest(silentPageUrl, "web_silent_{time}.ogg")
+	requests := []*livekit.StartEgressRequest{audible, silent}
+
+	for _, req := range requests {
+		info, err := conf.rpcClient.SendRequest(context.Background(), req)
+		require.NoError(t, err)
+		require.Empty(t, info.Error)
+	}
+
+	time.Sleep(time.Second * 25)
+
+	for _, req := range requests {
+		_, err := conf.rpcClient.SendRequest(context.Background(), &livekit.EgressRequest{
+			EgressId: req.EgressId,
+			Request: &livekit.EgressRequest_Stop{
+				Stop: &livekit.StopEgressRequest{EgressId: req.EgressId},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	results := awaitResults(t, conf.updates, audible.EgressId, silent.EgressId)
+	volumes := make(map[string]float64)
+	for _, req := range requests {
+		res := results[req.EgressId]
+		require.Equal(t, livekit.EgressStatus_EGRESS_COMPLETE.String(), res.Status.String(), res.Error)
+
+		p, err := params.GetPipelineParams(context.Background(), conf.Config, req)
+		require.NoError(t, err)
+
+		localPath := res.GetFile().Filename
+		if p.UploadConfig != nil {
+			localPath = fmt.Sprintf("%s/%s", conf.LocalOutputDirectory, res.GetFile().Filename)
+			download(t, p.UploadConfig, localPath, res.GetFile().Filename)
+		}
+		volumes[req.EgressId] = maxVolume(t, localPath)
+	}
+
+	require.Greater(t, volumes[audible.EgressId], -40.0, "page audio not recorded")
+	require.Less(t, volumes[silent.EgressId], -60.0, "silent page recorded audio from another egress")
+}